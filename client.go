@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	slm "github.com/ztkent/sunlight-meter/internal/sunlightmeter"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	CLIENT_CONFIG_PATH  = ".config/sunlightmeter/client.yaml" // Under the home directory
+	CLIENT_TIMEOUT      = 30 * time.Second
+	CLIENT_GRAPH_WIDTH  = 60
+	CLIENT_EXPORT_LIMIT = slm.MAX_READINGS_LIMIT
+)
+
+const clientUsage = `Usage: sunlight-meter client [--host host] [--token token] [--json] <command>
+
+Commands:
+  status                             each sensor and the job it's running
+  start [--name name]                start a recording job
+  stop                               stop the running job
+  conditions                         the latest reading
+  export [--out file] [--since 24h]  the readings as CSV, or NDJSON with --json
+  graph [--since 24h] [--width 60]   a sparkline of the lux
+
+The host, token, and format (table or json) default to the host:, token: and format: lines of ~/` + CLIENT_CONFIG_PATH + `
+`
+
+// Talks to a meter's API
+type apiClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// An error response from the API
+type clientAPIError struct {
+	Status int
+	tools.APIError
+}
+
+func (e *clientAPIError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Code, e.Status, e.Message)
+}
+
+// Run the client subcommand, returning the exit code: 1 when the API returns an error, 2 for bad usage
+func runClient(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, clientUsage) }
+	host := flags.String("host", "localhost", "the meter's address, with a port if it isn't 80, e.g. pi.local:8080")
+	token := flags.String("token", "", "API token, for meters that require one")
+	asJSON := flags.Bool("json", false, "print the API's JSON rather than a table")
+	configPath := flags.String("config", "", "client config file, defaults to ~/"+CLIENT_CONFIG_PATH)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	// Flags win over the config file
+	config, err := loadClientConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to read the client config: %v\n", err)
+		return 2
+	}
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if value, ok := config["host"]; ok && !set["host"] {
+		*host = value
+	}
+	if value, ok := config["token"]; ok && !set["token"] {
+		*token = value
+	}
+	if value, ok := config["format"]; ok && !set["json"] {
+		*asJSON = value == "json"
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	base := strings.TrimSuffix(*host, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	c := &apiClient{base: base, token: *token, client: &http.Client{Timeout: CLIENT_TIMEOUT}}
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "status":
+		err = c.status(stdout, *asJSON)
+	case "start":
+		err = c.start(commandArgs, stdout, stderr, *asJSON)
+	case "stop":
+		err = c.stop(stdout, *asJSON)
+	case "conditions":
+		err = c.conditions(stdout, *asJSON)
+	case "export":
+		err = c.export(commandArgs, stdout, stderr, *asJSON)
+	case "graph":
+		err = c.graph(commandArgs, stdout, stderr, *asJSON)
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n\n", command)
+		flags.Usage()
+		return 2
+	}
+	if errors.Is(err, errClientUsage) {
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Returned by commands whose flags didn't parse, the flag package has already said why
+var errClientUsage = errors.New("invalid usage")
+
+// Read the flat key: value pairs of a client config, a missing file is an empty config
+func loadClientConfig(path string) (map[string]string, error) {
+	config := map[string]string{}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return config, nil
+		}
+		path = filepath.Join(home, CLIENT_CONFIG_PATH)
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key: value", path, line)
+		}
+		config[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return config, scanner.Err()
+}
+
+// Call the API, and return the body of a successful response. Error responses are returned as a clientAPIError.
+func (c *apiClient) do(method string, path string, query url.Values) ([]byte, error) {
+	target := c.base + "/api/v1" + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var envelope struct {
+			Error tools.APIError `json:"error"`
+		}
+		if json.Unmarshal(body, &envelope) != nil || envelope.Error.Code == "" {
+			envelope.Error = tools.APIError{Code: http.StatusText(resp.StatusCode), Message: strings.TrimSpace(string(body))}
+		}
+		return nil, &clientAPIError{Status: resp.StatusCode, APIError: envelope.Error}
+	}
+	return body, nil
+}
+
+// Call the API, and print its JSON as-is with --json, or decode it into v to be formatted
+func (c *apiClient) call(method string, path string, query url.Values, out io.Writer, asJSON bool, v interface{}) (bool, error) {
+	body, err := c.do(method, path, query)
+	if err != nil {
+		return false, err
+	} else if asJSON {
+		_, err = fmt.Fprintln(out, strings.TrimSpace(string(body)))
+		return false, err
+	}
+	return true, json.Unmarshal(body, v)
+}
+
+func (c *apiClient) status(out io.Writer, asJSON bool) error {
+	var sensors []slm.SensorStatus
+	if ok, err := c.call(http.MethodGet, "/sensors", nil, out, asJSON, &sensors); !ok {
+		return err
+	}
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "SENSOR\tCONNECTED\tJOB\tPAUSED")
+	for _, sensor := range sensors {
+		job := sensor.JobID
+		if job == "" {
+			job = "-"
+		}
+		fmt.Fprintf(table, "%s\t%t\t%s\t%t\n", sensor.SensorID, sensor.Connected, job, sensor.Paused)
+	}
+	return table.Flush()
+}
+
+func (c *apiClient) start(args []string, out io.Writer, stderr io.Writer, asJSON bool) error {
+	flags := flag.NewFlagSet("start", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	name := flags.String("name", "", "name the job")
+	if err := flags.Parse(args); err != nil {
+		return errClientUsage
+	}
+	query := url.Values{}
+	if *name != "" {
+		query.Set("name", *name)
+	}
+	return c.jobCommand("/start", query, out, asJSON)
+}
+
+func (c *apiClient) stop(out io.Writer, asJSON bool) error {
+	return c.jobCommand("/stop", nil, out, asJSON)
+}
+
+func (c *apiClient) jobCommand(path string, query url.Values, out io.Writer, asJSON bool) error {
+	var response slm.JobResponse
+	if ok, err := c.call(http.MethodPost, path, query, out, asJSON, &response); !ok {
+		return err
+	}
+	if response.JobID != "" {
+		_, err := fmt.Fprintf(out, "%s - Job: %s\n", response.Message, response.JobID)
+		return err
+	}
+	_, err := fmt.Fprintln(out, response.Message)
+	return err
+}
+
+func (c *apiClient) conditions(out io.Writer, asJSON bool) error {
+	var conditions slm.LiveConditions
+	if ok, err := c.call(http.MethodGet, "/current-conditions", nil, out, asJSON, &conditions); !ok {
+		return err
+	}
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Recorded At\t%s\n", localTime(conditions.RecordedAt))
+	fmt.Fprintf(table, "Recording\t%t\n", conditions.Live)
+	fmt.Fprintf(table, "Job\t%s\n", conditions.JobID)
+	fmt.Fprintf(table, "Lux\t%.2f\n", conditions.Lux)
+	fmt.Fprintf(table, "Full Spectrum\t%.5f\n", conditions.FullSpectrum)
+	fmt.Fprintf(table, "Visible\t%.5f\n", conditions.Visible)
+	fmt.Fprintf(table, "Infrared\t%.5f\n", conditions.Infrared)
+	fmt.Fprintf(table, "PPFD\t%.1f µmol/m²/s\n", conditions.PPFD)
+	return table.Flush()
+}
+
+// Write the readings as CSV, or NDJSON with --json, to --out or stdout
+func (c *apiClient) export(args []string, out io.Writer, stderr io.Writer, asJSON bool) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	outPath := flags.String("out", "", "file to write, stdout when empty")
+	since := flags.String("since", "", "only the readings from this long ago, like 1h, 24h or 7d. Every reading when empty")
+	if err := flags.Parse(args); err != nil {
+		return errClientUsage
+	}
+	if *outPath != "" {
+		file, err := os.Create(*outPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(out)
+		return c.eachReading(*since, func(reading slm.Reading) error {
+			return encoder.Encode(reading)
+		})
+	}
+	writer := csv.NewWriter(out)
+	writer.Write([]string{"created_at", "device_id", "job_id", "lux", "full_spectrum", "visible", "infrared", "saturated", "light_source"})
+	err := c.eachReading(*since, func(reading slm.Reading) error {
+		return writer.Write([]string{
+			reading.CreatedAt,
+			reading.DeviceID,
+			reading.JobID,
+			strconv.FormatFloat(reading.Lux, 'f', -1, 64),
+			strconv.FormatFloat(reading.FullSpectrum, 'f', -1, 64),
+			strconv.FormatFloat(reading.Visible, 'f', -1, 64),
+			strconv.FormatFloat(reading.Infrared, 'f', -1, 64),
+			strconv.FormatBool(reading.Saturated),
+			reading.LightSource,
+		})
+	})
+	writer.Flush()
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// Page through the readings oldest first, since the range when it's set
+func (c *apiClient) eachReading(since string, fn func(slm.Reading) error) error {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(CLIENT_EXPORT_LIMIT))
+	if since != "" {
+		query.Set("range", since)
+	}
+	for {
+		body, err := c.do(http.MethodGet, "/readings", query)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Readings   []slm.Reading `json:"readings"`
+			NextCursor *string       `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		for _, reading := range page.Readings {
+			if err := fn(reading); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == nil {
+			return nil
+		}
+		query.Set("cursor", *page.NextCursor)
+	}
+}
+
+// The average lux over one column of the graph
+type graphBucket struct {
+	Start      string  `json:"start"` // UTC
+	AverageLux float64 `json:"averageLux"`
+	Samples    int     `json:"samples"`
+}
+
+// Draw the lux over --since as a sparkline, --width characters wide
+func (c *apiClient) graph(args []string, out io.Writer, stderr io.Writer, asJSON bool) error {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	since := flags.String("since", "24h", "graph the readings from this long ago, like 1h, 24h or 7d")
+	width := flags.Int("width", CLIENT_GRAPH_WIDTH, "columns in the sparkline")
+	if err := flags.Parse(args); err != nil {
+		return errClientUsage
+	} else if *width < 1 {
+		fmt.Fprintln(stderr, "--width must be at least 1")
+		return errClientUsage
+	}
+
+	var times []time.Time
+	var luxes []float64
+	err := c.eachReading(*since, func(reading slm.Reading) error {
+		at, err := time.Parse("2006-01-02 15:04:05", reading.CreatedAt)
+		if err != nil || reading.Saturated {
+			return nil
+		}
+		times, luxes = append(times, at), append(luxes, reading.Lux)
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if len(times) == 0 {
+		return fmt.Errorf("no readings in the last %s", *since)
+	}
+
+	start, span := times[0], times[len(times)-1].Sub(times[0])
+	buckets := make([]graphBucket, *width)
+	for i := range buckets {
+		buckets[i].Start = start.Add(span * time.Duration(i) / time.Duration(*width)).Format("2006-01-02 15:04:05")
+	}
+	for i, at := range times {
+		column := *width - 1
+		if span > 0 {
+			column = min(int(int64(at.Sub(start))*int64(*width)/int64(span)), *width-1)
+		}
+		buckets[column].AverageLux += luxes[i]
+		buckets[column].Samples++
+	}
+	peak, peakAt := 0.0, 0
+	for i := range buckets {
+		if buckets[i].Samples > 0 {
+			buckets[i].AverageLux /= float64(buckets[i].Samples)
+		}
+		if buckets[i].AverageLux > peak {
+			peak, peakAt = buckets[i].AverageLux, i
+		}
+	}
+	if asJSON {
+		return json.NewEncoder(out).Encode(buckets)
+	}
+
+	levels := []rune("▁▂▃▄▅▆▇█")
+	var line strings.Builder
+	for _, bucket := range buckets {
+		switch {
+		case bucket.Samples == 0:
+			line.WriteRune(' ')
+		case peak == 0:
+			line.WriteRune(levels[0])
+		default:
+			line.WriteRune(levels[int(math.Round(bucket.AverageLux/peak*float64(len(levels)-1)))])
+		}
+	}
+	fmt.Fprintf(out, "Lux over the last %s, peak %.0f around %s\n", *since, peak, localTime(buckets[peakAt].Start))
+	fmt.Fprintln(out, line.String())
+	_, err = fmt.Fprintf(out, "%s  ->  %s\n", localTime(times[0].Format("2006-01-02 15:04:05")), localTime(times[len(times)-1].Format("2006-01-02 15:04:05")))
+	return err
+}
+
+// A UTC time from the API, in the local timezone
+func localTime(value string) string {
+	at, err := time.Parse("2006-01-02 15:04:05", value)
+	if err != nil {
+		return value
+	}
+	return at.Local().Format("2006-01-02 15:04")
+}
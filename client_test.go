@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	slm "github.com/ztkent/sunlight-meter/internal/sunlightmeter"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// A stand-in for a meter's API, recording the requests the client makes
+type fakeMeter struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newFakeMeter(t *testing.T, mux *http.ServeMux) *fakeMeter {
+	t.Helper()
+	f := &fakeMeter{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Clone(r.Context()))
+		f.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeMeter) lastRequest(t *testing.T) *http.Request {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		t.Fatal("the client made no requests")
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Run the client against the fake meter, without reading the user's config
+func runTestClient(t *testing.T, f *fakeMeter, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"--host", f.URL, "--config", filepath.Join(t.TempDir(), "missing.yaml")}, args...)
+	code := runClient(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestClientStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sensors", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, []slm.SensorStatus{
+			{SensorID: "main", Connected: true, JobID: "job-1"},
+			{SensorID: "shade", Connected: false},
+		})
+	})
+	f := newFakeMeter(t, mux)
+
+	code, stdout, stderr := runTestClient(t, f, "--token", "secret", "status")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	req := f.lastRequest(t)
+	if req.Method != http.MethodGet || req.URL.Path != "/api/v1/sensors" {
+		t.Errorf("got %s %s", req.Method, req.URL.Path)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("got Authorization %q", got)
+	}
+	if got := req.Header.Get("Accept"); got != "application/json" {
+		t.Errorf("got Accept %q", got)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "SENSOR") {
+		t.Fatalf("got table:\n%s", stdout)
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "main true job-1 false" {
+		t.Errorf("got row %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); strings.Join(fields, " ") != "shade false - false" {
+		t.Errorf("got row %q", lines[2])
+	}
+
+	// --json prints the API's response as-is
+	code, stdout, _ = runTestClient(t, f, "--json", "status")
+	if code != 0 {
+		t.Fatalf("exit %d", code)
+	}
+	var sensors []slm.SensorStatus
+	if err := json.Unmarshal([]byte(stdout), &sensors); err != nil || len(sensors) != 2 {
+		t.Errorf("got %q, want the API's JSON", stdout)
+	}
+}
+
+func TestClientStartAndStop(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/start", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, slm.JobResponse{Message: "Sunlight Reading Started", JobID: "job-1"})
+	})
+	mux.HandleFunc("/api/v1/stop", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusConflict, map[string]interface{}{
+			"error": map[string]string{"code": tools.ERR_JOB_NOT_RUNNING, "message": "The sensor is already stopped"},
+		})
+	})
+	f := newFakeMeter(t, mux)
+
+	code, stdout, stderr := runTestClient(t, f, "start", "--name", "south window")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	req := f.lastRequest(t)
+	if req.Method != http.MethodPost || req.URL.Query().Get("name") != "south window" {
+		t.Errorf("got %s %s", req.Method, req.URL)
+	}
+	if stdout != "Sunlight Reading Started - Job: job-1\n" {
+		t.Errorf("got %q", stdout)
+	}
+
+	// API errors exit 1, with the error's code
+	code, stdout, stderr = runTestClient(t, f, "stop")
+	if code != 1 {
+		t.Errorf("got exit %d, want 1", code)
+	}
+	if stdout != "" || !strings.Contains(stderr, tools.ERR_JOB_NOT_RUNNING) || !strings.Contains(stderr, "409") {
+		t.Errorf("got stdout %q stderr %q", stdout, stderr)
+	}
+}
+
+func TestClientErrorWithoutEnvelope(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sensors", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	})
+	f := newFakeMeter(t, mux)
+
+	code, _, stderr := runTestClient(t, f, "status")
+	if code != 1 {
+		t.Errorf("got exit %d, want 1", code)
+	}
+	if !strings.Contains(stderr, "Bad Gateway") || !strings.Contains(stderr, "upstream unavailable") {
+		t.Errorf("got stderr %q", stderr)
+	}
+}
+
+func TestClientConditions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/current-conditions", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, slm.LiveConditions{
+			Conditions: slm.Conditions{JobID: "job-1", Lux: 1234.5, PPFD: 22.8},
+			Live:       true,
+			RecordedAt: "2024-06-21 12:00:00",
+		})
+	})
+	f := newFakeMeter(t, mux)
+
+	code, stdout, stderr := runTestClient(t, f, "conditions")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	for _, want := range []string{"Recording      true", "job-1", "1234.50", "22.8 µmol/m²/s"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+}
+
+// Two pages of readings, joined by a cursor
+func readingsMux(t *testing.T) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/readings", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("range") != "24h" {
+			t.Errorf("got range %q", r.URL.Query().Get("range"))
+		}
+		cursor := "page-2"
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{
+				"readings": []slm.Reading{
+					{ID: 1, JobID: "job-1", Lux: 100, CreatedAt: "2024-06-21 12:00:00"},
+					{ID: 2, JobID: "job-1", Lux: 200, CreatedAt: "2024-06-21 13:00:00"},
+				},
+				"next_cursor": cursor,
+			})
+		case cursor:
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{
+				"readings": []slm.Reading{
+					{ID: 3, JobID: "job-1", Saturated: true, CreatedAt: "2024-06-21 14:00:00"},
+					{ID: 4, JobID: "job-1", Lux: 400, CreatedAt: "2024-06-21 15:00:00"},
+				},
+				"next_cursor": nil,
+			})
+		default:
+			t.Errorf("got cursor %q", r.URL.Query().Get("cursor"))
+		}
+	})
+	return mux
+}
+
+func TestClientExport(t *testing.T) {
+	f := newFakeMeter(t, readingsMux(t))
+
+	out := filepath.Join(t.TempDir(), "data.csv")
+	code, _, stderr := runTestClient(t, f, "export", "--since", "24h", "--out", out)
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	file, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header, and every reading across both pages
+	if len(rows) != 5 || rows[0][0] != "created_at" {
+		t.Fatalf("got %d rows: %v", len(rows), rows)
+	}
+	if rows[1][3] != "100" || rows[4][3] != "400" || rows[3][7] != "true" {
+		t.Errorf("got rows %v", rows)
+	}
+
+	// NDJSON with --json, to stdout
+	code, stdout, stderr := runTestClient(t, f, "--json", "export", "--since", "24h")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines:\n%s", len(lines), stdout)
+	}
+	var reading slm.Reading
+	if err := json.Unmarshal([]byte(lines[3]), &reading); err != nil || reading.ID != 4 {
+		t.Errorf("got %q", lines[3])
+	}
+}
+
+func TestClientGraph(t *testing.T) {
+	f := newFakeMeter(t, readingsMux(t))
+
+	code, stdout, stderr := runTestClient(t, f, "--json", "graph", "--since", "24h", "--width", "4")
+	if code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr)
+	}
+	var buckets []graphBucket
+	if err := json.Unmarshal([]byte(stdout), &buckets); err != nil {
+		t.Fatalf("got %q", stdout)
+	}
+	// The saturated reading is left out, so the third bucket is empty
+	want := []graphBucket{
+		{Start: "2024-06-21 12:00:00", AverageLux: 100, Samples: 1},
+		{Start: "2024-06-21 12:45:00", AverageLux: 200, Samples: 1},
+		{Start: "2024-06-21 13:30:00", AverageLux: 0, Samples: 0},
+		{Start: "2024-06-21 14:15:00", AverageLux: 400, Samples: 1},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %+v", buckets)
+	}
+	for i := range want {
+		if buckets[i] != want[i] {
+			t.Errorf("bucket %d: got %+v, want %+v", i, buckets[i], want[i])
+		}
+	}
+
+	code, stdout, _ = runTestClient(t, f, "graph", "--since", "24h", "--width", "4")
+	if code != 0 {
+		t.Fatalf("exit %d", code)
+	}
+	if lines := strings.Split(stdout, "\n"); len(lines) < 2 || lines[1] != "▃▅ █" {
+		t.Errorf("got sparkline:\n%s", stdout)
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/sensors", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, []slm.SensorStatus{})
+	})
+	f := newFakeMeter(t, mux)
+
+	config := filepath.Join(t.TempDir(), "client.yaml")
+	err := os.WriteFile(config, []byte("# The meter on the porch\nhost: "+f.URL+"\ntoken: \"from-config\"\nformat: json\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runClient([]string{"--config", config, "status"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if got := f.lastRequest(t).Header.Get("Authorization"); got != "Bearer from-config" {
+		t.Errorf("got Authorization %q", got)
+	}
+	if strings.TrimSpace(stdout.String()) != "[]" {
+		t.Errorf("format: json wasn't used, got %q", stdout.String())
+	}
+
+	// Flags win over the config
+	stdout.Reset()
+	if code := runClient([]string{"--config", config, "--token", "from-flag", "--json=false", "status"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if got := f.lastRequest(t).Header.Get("Authorization"); got != "Bearer from-flag" {
+		t.Errorf("got Authorization %q", got)
+	}
+	if !strings.HasPrefix(stdout.String(), "SENSOR") {
+		t.Errorf("--json=false didn't print a table, got %q", stdout.String())
+	}
+
+	// A line that isn't key: value is an error
+	if err := os.WriteFile(config, []byte("token secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code := runClient([]string{"--config", config, "status"}, &stdout, &stderr); code != 2 {
+		t.Errorf("got exit %d for a malformed config, want 2", code)
+	}
+}
+
+func TestClientUsage(t *testing.T) {
+	f := newFakeMeter(t, http.NewServeMux())
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"no command", nil},
+		{"unknown command", []string{"dance"}},
+		{"unknown flag", []string{"--colour", "status"}},
+		{"bad command flag", []string{"start", "--label", "x"}},
+		{"zero width graph", []string{"graph", "--width", "0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runTestClient(t, f, tt.args...)
+			if code != 2 {
+				t.Errorf("got exit %d, want 2", code)
+			}
+			if stderr == "" {
+				t.Error("nothing was written to stderr")
+			}
+		})
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) != 0 {
+		t.Errorf("bad usage still called the API %d times", len(f.requests))
+	}
+}
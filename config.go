@@ -0,0 +1,326 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/solar"
+	slm "github.com/ztkent/sunlight-meter/internal/sunlightmeter"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+// Config holds the runtime settings for the Sunlight Meter.
+// Each value can be set by flag, or by environment variable, and falls back to a sensible default.
+type Config struct {
+	DBPath        string
+	DBJournalMode string
+	DBSynchronous string
+	DBBusyTimeout time.Duration
+	ListenAddr    string
+	Port          string
+	I2CDev        string
+	I2CAddr       string
+	SensorID      string
+	Sensors       string
+	ReadRetries   int
+	Saturation    float64
+	AutoResume    bool
+	NetInterface  string
+	RecordTemp    bool
+	Simulate      bool
+	PPFDPerLux    float64
+	WattsPerLux   float64
+
+	MinFreeDiskMB     int
+	DiskCheckInterval time.Duration
+	BatchSize         int
+	BatchInterval     time.Duration
+	ResultsBuffer     int
+	DropPolicy        string
+	StaleAfter        time.Duration
+
+	WebhookURLs   string
+	WebhookEvents string
+	WebhookSecret string
+	LuxAbove      float64
+	LuxBelow      float64
+
+	RelayPin       int
+	RelayActiveLow bool
+	RelayOnBelow   float64
+	RelayOffAbove  float64
+	RelayHours     string
+
+	DeviceID        string
+	ForwardURL      string
+	ForwardToken    string
+	ForwardInterval time.Duration
+
+	InfluxURL      string
+	InfluxOrg      string
+	InfluxBucket   string
+	InfluxToken    string
+	InfluxInterval time.Duration
+	InfluxBuffer   int
+
+	SummaryTimezone string
+	Latitude        string
+	Longitude       string
+	WeatherProvider string
+	WeatherInterval time.Duration
+
+	MDNS bool
+
+	TrustedProxies string
+	AllowedCIDRs   string
+	CORSOrigins    string
+
+	AuthUser         string
+	AuthPasswordHash string
+
+	ControlRatePerMin float64
+	ControlBurst      int
+
+	GzipLevel   int
+	TemplateDir string
+	StaticDir   string
+	EChartsCDN  bool
+
+	TLS             bool
+	TLSCert         string
+	TLSKey          string
+	TLSKeyType      string
+	TLSHosts        string
+	TLSValidityDays int
+	TLSRenewDays    int
+}
+
+func loadConfig() Config {
+	cfg := Config{}
+	flag.StringVar(&cfg.DBPath, "db-path", envOrDefault("SLM_DB_PATH", slm.DB_PATH), "path to the sqlite results database")
+	flag.StringVar(&cfg.DBJournalMode, "db-journal-mode", envOrDefault("SLM_DB_JOURNAL_MODE", tools.DefaultSqliteOptions.JournalMode), "sqlite journal mode")
+	flag.StringVar(&cfg.DBSynchronous, "db-synchronous", envOrDefault("SLM_DB_SYNCHRONOUS", tools.DefaultSqliteOptions.Synchronous), "sqlite synchronous setting")
+	flag.DurationVar(&cfg.DBBusyTimeout, "db-busy-timeout", envDurationOrDefault("SLM_DB_BUSY_TIMEOUT", tools.DefaultSqliteOptions.BusyTimeout), "how long a query waits on a locked sqlite db")
+	flag.StringVar(&cfg.ListenAddr, "listen-addr", envOrDefault("SLM_LISTEN_ADDR", "0.0.0.0"), "address the HTTP server listens on")
+	flag.StringVar(&cfg.Port, "port", envOrDefault("SLM_PORT", "80"), "port the HTTP server listens on")
+	flag.StringVar(&cfg.I2CDev, "i2c-dev", envOrDefault("SLM_I2C_DEV", "/dev/i2c-1"), "I2C bus the TSL2591 is connected to")
+	flag.StringVar(&cfg.I2CAddr, "i2c-addr", envOrDefault("SLM_I2C_ADDR", fmt.Sprintf("%#x", tsl2591.TSL2591_ADDR)), "I2C address of the TSL2591, for boards with an address jumper")
+	flag.StringVar(&cfg.SensorID, "sensor-id", envOrDefault("SLM_SENSOR_ID", slm.DEFAULT_SENSOR_ID), "ID recorded with readings from the sensor on -i2c-dev")
+	flag.StringVar(&cfg.Sensors, "sensors", envOrDefault("SLM_SENSORS", ""), "comma-separated id=bus pairs of extra sensors, with an optional @address, e.g. shade=/dev/i2c-3,canopy=/dev/i2c-1@0x28")
+	flag.BoolVar(&cfg.Simulate, "simulate", envBoolOrDefault("SLM_SIMULATE", false), "read a simulated sensor with a synthetic day of sunlight, rather than the I2C bus")
+	flag.IntVar(&cfg.ReadRetries, "read-retries", envIntOrDefault("SLM_READ_RETRIES", tsl2591.DEFAULT_READ_RETRIES), "retries for a failed sensor read before the sample is skipped")
+	flag.Float64Var(&cfg.Saturation, "saturation", envFloatOrDefault("SLM_SATURATION", tsl2591.DEFAULT_SATURATION), "share of a channel's full scale that's treated as saturated, stepping the gain down before the sensor stops being linear")
+	flag.BoolVar(&cfg.AutoResume, "auto-resume", envBoolOrDefault("SLM_AUTO_RESUME", false), "resume logging on startup if a job was running when the process stopped")
+	flag.StringVar(&cfg.NetInterface, "net-interface", envOrDefault("SLM_NET_INTERFACE", ""), "network interface reported by signal-strength, picks the wifi or active interface when empty")
+	flag.BoolVar(&cfg.RecordTemp, "record-temp", envBoolOrDefault("SLM_RECORD_TEMP", false), "record the CPU temperature alongside each sample")
+	flag.Float64Var(&cfg.PPFDPerLux, "ppfd-per-lux", envFloatOrDefault("SLM_PPFD_PER_LUX", slm.SUNLIGHT_PPFD_PER_LUX), "µmol/m²/s of PPFD per lux for the light source being measured, used to estimate PPFD and DLI")
+	flag.Float64Var(&cfg.WattsPerLux, "wm2-per-lux", envFloatOrDefault("SLM_WM2_PER_LUX", slm.SUNLIGHT_WM2_PER_LUX), "W/m² of irradiance per lux, used to estimate W/m² when units=wm2")
+	flag.IntVar(&cfg.MinFreeDiskMB, "min-free-disk-mb", envIntOrDefault("SLM_MIN_FREE_DISK_MB", slm.DEFAULT_MIN_FREE_DISK>>20), "pause recording when the db's filesystem has less free space, 0 disables the check")
+	flag.DurationVar(&cfg.DiskCheckInterval, "disk-check-interval", envDurationOrDefault("SLM_DISK_CHECK_INTERVAL", slm.DEFAULT_DISK_CHECK_INTERVAL), "how often to check the free disk space")
+	flag.IntVar(&cfg.BatchSize, "batch-size", envIntOrDefault("SLM_BATCH_SIZE", 1), "readings buffered before they're written together, 1 writes each reading as it arrives")
+	flag.DurationVar(&cfg.BatchInterval, "batch-interval", envDurationOrDefault("SLM_BATCH_INTERVAL", 30*time.Second), "longest a buffered reading waits before it's written")
+	flag.IntVar(&cfg.ResultsBuffer, "results-buffer", envIntOrDefault("SLM_RESULTS_BUFFER", slm.RESULTS_BUFFER), "samples held while the db is busy, more are dropped so sampling keeps its interval")
+	flag.StringVar(&cfg.DropPolicy, "drop-policy", envOrDefault("SLM_DROP_POLICY", slm.DROP_NEWEST), "which sample to drop once -results-buffer is full, newest keeps the buffered samples, oldest keeps the latest")
+	flag.DurationVar(&cfg.StaleAfter, "stale-after", envDurationOrDefault("SLM_STALE_AFTER", slm.DEFAULT_STALE_AFTER), "restart a running job that hasn't recorded a reading in this long, 0 disables the watchdog")
+	flag.StringVar(&cfg.WebhookURLs, "webhook-urls", envOrDefault("SLM_WEBHOOK_URLS", ""), "comma-separated URLs that job events and lux threshold crossings are posted to")
+	flag.StringVar(&cfg.WebhookEvents, "webhook-events", envOrDefault("SLM_WEBHOOK_EVENTS", ""), "comma-separated events to send: job_started, job_stopped, job_error, job_stalled, lux_above, lux_below. Sends every event when empty")
+	flag.StringVar(&cfg.WebhookSecret, "webhook-secret", envOrDefault("SLM_WEBHOOK_SECRET", ""), "secret for the HMAC-SHA256 signature header on webhooks")
+	flag.Float64Var(&cfg.LuxAbove, "lux-above", envFloatOrDefault("SLM_LUX_ABOVE", slm.DEFAULT_LUX_ABOVE), "send a lux_above webhook when the lux rises past this")
+	flag.Float64Var(&cfg.LuxBelow, "lux-below", envFloatOrDefault("SLM_LUX_BELOW", slm.DEFAULT_LUX_BELOW), "send a lux_below webhook when the lux falls past this, must be below -lux-above")
+	flag.IntVar(&cfg.RelayPin, "relay-pin", envIntOrDefault("SLM_RELAY_PIN", -1), "BCM number of a GPIO pin driving a relay, e.g. for a grow light, switched by the lux. No relay when below 0")
+	flag.BoolVar(&cfg.RelayActiveLow, "relay-active-low", envBoolOrDefault("SLM_RELAY_ACTIVE_LOW", false), "the relay switches on when its pin is low")
+	flag.Float64Var(&cfg.RelayOnBelow, "relay-on-below", envFloatOrDefault("SLM_RELAY_ON_BELOW", slm.DEFAULT_RELAY_ON_BELOW), "switch the relay on when the lux falls past this")
+	flag.Float64Var(&cfg.RelayOffAbove, "relay-off-above", envFloatOrDefault("SLM_RELAY_OFF_ABOVE", slm.DEFAULT_RELAY_OFF_ABOVE), "switch the relay off when the lux rises past this, must be above -relay-on-below")
+	flag.StringVar(&cfg.RelayHours, "relay-hours", envOrDefault("SLM_RELAY_HOURS", ""), "hours the relay may be on in the summary timezone, e.g. 06:00-20:00. Any time when empty")
+	flag.StringVar(&cfg.DeviceID, "device-id", envOrDefault("SLM_DEVICE_ID", ""), "identifies this meter's readings, on this instance and any it forwards to. Generated once and kept in the db when empty")
+	flag.StringVar(&cfg.ForwardURL, "forward-url", envOrDefault("SLM_FORWARD_URL", ""), "ingest URL of a central instance that readings are also posted to, e.g. http://central/api/v1/ingest")
+	flag.StringVar(&cfg.ForwardToken, "forward-token", envOrDefault("SLM_FORWARD_TOKEN", ""), "API token for the central instance")
+	flag.DurationVar(&cfg.ForwardInterval, "forward-interval", envDurationOrDefault("SLM_FORWARD_INTERVAL", slm.DEFAULT_FORWARD_INTERVAL), "how often queued readings are forwarded")
+	flag.StringVar(&cfg.InfluxURL, "influx-url", envOrDefault("SLM_INFLUX_URL", ""), "InfluxDB v2 server that readings are also written to, e.g. http://influx:8086")
+	flag.StringVar(&cfg.InfluxOrg, "influx-org", envOrDefault("SLM_INFLUX_ORG", ""), "InfluxDB organization")
+	flag.StringVar(&cfg.InfluxBucket, "influx-bucket", envOrDefault("SLM_INFLUX_BUCKET", "sunlight"), "InfluxDB bucket")
+	flag.StringVar(&cfg.InfluxToken, "influx-token", envOrDefault("SLM_INFLUX_TOKEN", ""), "InfluxDB API token")
+	flag.DurationVar(&cfg.InfluxInterval, "influx-interval", envDurationOrDefault("SLM_INFLUX_INTERVAL", tools.DEFAULT_INFLUX_INTERVAL), "how often buffered readings are written to InfluxDB")
+	flag.IntVar(&cfg.InfluxBuffer, "influx-buffer", envIntOrDefault("SLM_INFLUX_BUFFER", tools.DEFAULT_INFLUX_BUFFER), "readings kept while InfluxDB is unreachable, the oldest are dropped beyond this")
+	flag.StringVar(&cfg.SummaryTimezone, "summary-timezone", envOrDefault("SLM_SUMMARY_TIMEZONE", "UTC"), "timezone that days are summarized in, e.g. America/New_York")
+	flag.StringVar(&cfg.Latitude, "latitude", envOrDefault("SLM_LATITUDE", ""), "latitude of the meter in degrees, north is positive, for the clear sky model")
+	flag.StringVar(&cfg.Longitude, "longitude", envOrDefault("SLM_LONGITUDE", ""), "longitude of the meter in degrees, east is positive, for the clear sky model")
+	flag.StringVar(&cfg.WeatherProvider, "weather-provider", envOrDefault("SLM_WEATHER_PROVIDER", ""), "fetch the weather at -latitude and -longitude from this provider, e.g. open-meteo. Not fetched when empty")
+	flag.DurationVar(&cfg.WeatherInterval, "weather-interval", envDurationOrDefault("SLM_WEATHER_INTERVAL", slm.DEFAULT_WEATHER_INTERVAL), "how often the weather is fetched, providers that update less often are asked less often")
+	flag.BoolVar(&cfg.MDNS, "mdns", envBoolOrDefault("SLM_MDNS", true), "advertise the meter on the LAN over mDNS, as _sunlightmeter._tcp and _http._tcp")
+	flag.StringVar(&cfg.TrustedProxies, "trusted-proxies", envOrDefault("SLM_TRUSTED_PROXIES", ""), "comma-separated proxy CIDRs whose X-Forwarded-For header is trusted")
+	flag.StringVar(&cfg.AllowedCIDRs, "allowed-cidrs", envOrDefault("SLM_ALLOWED_CIDRS", ""), "comma-separated CIDRs allowed to reach the dashboard, in addition to the local network")
+	flag.StringVar(&cfg.CORSOrigins, "cors-origins", envOrDefault("SLM_CORS_ORIGINS", ""), "comma-separated origins allowed to call the API from a browser, e.g. https://app.example.com, or * for any. The API is same-origin only when empty")
+	flag.StringVar(&cfg.AuthUser, "auth-user", envOrDefault("SLM_AUTH_USER", "admin"), "username for dashboard basic auth")
+	flag.StringVar(&cfg.AuthPasswordHash, "auth-password-hash", envOrDefault("SLM_AUTH_PASSWORD_HASH", ""), "bcrypt hash of the dashboard password, basic auth is disabled when empty")
+	flag.Float64Var(&cfg.ControlRatePerMin, "control-rate", envFloatOrDefault("SLM_CONTROL_RATE", 6), "start/stop/reset requests allowed per minute for each client, 0 disables the limit")
+	flag.IntVar(&cfg.ControlBurst, "control-burst", envIntOrDefault("SLM_CONTROL_BURST", 3), "start/stop/reset requests a client can make in a burst")
+	flag.IntVar(&cfg.GzipLevel, "gzip-level", envIntOrDefault("SLM_GZIP_LEVEL", 5), "gzip level for HTML, JSON and text responses, from 1 (fastest) to 9 (smallest), 0 disables compression")
+	flag.StringVar(&cfg.TemplateDir, "template-dir", envOrDefault("SLM_TEMPLATE_DIR", ""), "re-read the dashboard html from this directory on every request, for development, e.g. internal/sunlightmeter/html")
+	flag.StringVar(&cfg.StaticDir, "static-dir", envOrDefault("SLM_STATIC_DIR", ""), "serve the static assets from this directory instead of the binary, for development, e.g. internal/sunlightmeter/static")
+	flag.BoolVar(&cfg.EChartsCDN, "echarts-cdn", envBoolOrDefault("SLM_ECHARTS_CDN", false), "load the graph's JavaScript from the go-echarts CDN instead of the copy in the binary")
+	flag.BoolVar(&cfg.TLS, "tls", envBoolOrDefault("SLM_TLS", false), "serve HTTPS with a self-signed certificate")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", envOrDefault("SLM_TLS_CERT", "sunlightmeter.crt"), "path to the TLS certificate")
+	flag.StringVar(&cfg.TLSKey, "tls-key", envOrDefault("SLM_TLS_KEY", "sunlightmeter.key"), "path to the TLS private key")
+	flag.StringVar(&cfg.TLSKeyType, "tls-key-type", envOrDefault("SLM_TLS_KEY_TYPE", "rsa"), "key type for generated certificates, rsa or ecdsa")
+	flag.StringVar(&cfg.TLSHosts, "tls-hosts", envOrDefault("SLM_TLS_HOSTS", ""), "comma-separated DNS names and IPs for the certificate, defaults to the hostname and interface addresses")
+	flag.IntVar(&cfg.TLSValidityDays, "tls-validity-days", envIntOrDefault("SLM_TLS_VALIDITY_DAYS", 365), "validity period of generated certificates")
+	flag.IntVar(&cfg.TLSRenewDays, "tls-renew-days", envIntOrDefault("SLM_TLS_RENEW_DAYS", 30), "regenerate the certificate this many days before it expires")
+	flag.Parse()
+	return cfg
+}
+
+// Log the effective configuration at startup
+func (cfg Config) log() {
+	log.Printf("Config - DB Path: %s, Listen Addr: %s, Port: %s, I2C Dev: %s, I2C Addr: %s, Simulate: %t, Read Retries: %d, Saturation: %.3f, Auto Resume: %t, Record Temp: %t", cfg.DBPath, cfg.ListenAddr, cfg.Port, cfg.I2CDev, cfg.I2CAddr, cfg.Simulate, cfg.ReadRetries, cfg.Saturation, cfg.AutoResume, cfg.RecordTemp)
+	log.Printf("Config - DB Journal Mode: %s, DB Synchronous: %s, DB Busy Timeout: %s", cfg.DBJournalMode, cfg.DBSynchronous, cfg.DBBusyTimeout)
+	log.Printf("Config - Min Free Disk: %d MB, Disk Check Interval: %s, Batch Size: %d, Batch Interval: %s, Results Buffer: %d, Drop Policy: %s, Stale After: %s", cfg.MinFreeDiskMB, cfg.DiskCheckInterval, cfg.BatchSize, cfg.BatchInterval, cfg.ResultsBuffer, cfg.DropPolicy, cfg.StaleAfter)
+	log.Printf("Config - Control Rate: %.1f/min, Control Burst: %d, Summary Timezone: %s, Gzip Level: %d, mDNS: %t", cfg.ControlRatePerMin, cfg.ControlBurst, cfg.SummaryTimezone, cfg.GzipLevel, cfg.MDNS)
+	if cfg.Latitude != "" || cfg.Longitude != "" {
+		log.Printf("Config - Latitude: %s, Longitude: %s", cfg.Latitude, cfg.Longitude)
+	}
+	if cfg.WeatherProvider != "" {
+		log.Printf("Config - Weather Provider: %s, Weather Interval: %s", cfg.WeatherProvider, cfg.WeatherInterval)
+	}
+	if cfg.Sensors != "" {
+		log.Printf("Config - Sensor ID: %s, Extra Sensors: %s", cfg.SensorID, cfg.Sensors)
+	}
+	if cfg.TemplateDir != "" || cfg.StaticDir != "" {
+		log.Printf("Config - Template Dir: %s, Static Dir: %s, these are read from disk on every request", cfg.TemplateDir, cfg.StaticDir)
+	}
+	if cfg.TrustedProxies != "" || cfg.AllowedCIDRs != "" {
+		log.Printf("Config - Trusted Proxies: %s, Allowed CIDRs: %s", cfg.TrustedProxies, cfg.AllowedCIDRs)
+	}
+	if cfg.CORSOrigins != "" {
+		log.Printf("Config - CORS Origins: %s", cfg.CORSOrigins)
+	}
+	if cfg.AuthPasswordHash != "" {
+		log.Printf("Config - Dashboard basic auth enabled for user: %s", cfg.AuthUser)
+	}
+	if cfg.WebhookURLs != "" {
+		log.Printf("Config - Webhooks: %d URLs, Events: %s, Lux Above: %.0f, Lux Below: %.0f", len(splitList(cfg.WebhookURLs)), cfg.WebhookEvents, cfg.LuxAbove, cfg.LuxBelow)
+	}
+	if cfg.RelayPin >= 0 {
+		log.Printf("Config - Relay Pin: %d, Active Low: %t, On Below: %.0f, Off Above: %.0f, Hours: %s", cfg.RelayPin, cfg.RelayActiveLow, cfg.RelayOnBelow, cfg.RelayOffAbove, cfg.RelayHours)
+	}
+	if cfg.ForwardURL != "" {
+		log.Printf("Config - Forward URL: %s, Forward Interval: %s", cfg.ForwardURL, cfg.ForwardInterval)
+	}
+	if cfg.InfluxURL != "" {
+		log.Printf("Config - Influx URL: %s, Org: %s, Bucket: %s, Interval: %s, Buffer: %d", cfg.InfluxURL, cfg.InfluxOrg, cfg.InfluxBucket, cfg.InfluxInterval, cfg.InfluxBuffer)
+	}
+	if cfg.TLS {
+		log.Printf("Config - TLS Cert: %s, TLS Key: %s, Key Type: %s", cfg.TLSCert, cfg.TLSKey, cfg.TLSKeyType)
+	}
+}
+
+func envOrDefault(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envBoolOrDefault(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %s", key, value)
+	}
+	return fallback
+}
+
+func envIntOrDefault(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %s", key, value)
+	}
+	return fallback
+}
+
+func envFloatOrDefault(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %s", key, value)
+	}
+	return fallback
+}
+
+func envDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil {
+			return parsed
+		}
+		log.Printf("Invalid value for %s: %s", key, value)
+	}
+	return fallback
+}
+
+// Parse an I2C address, in hex (0x29) or decimal
+func parseI2CAddr(value string) (uint16, error) {
+	addr, err := strconv.ParseUint(strings.TrimSpace(value), 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid I2C address %q", value)
+	}
+	return uint16(addr), tsl2591.ValidateAddress(uint16(addr))
+}
+
+// Parse -latitude and -longitude, which are set together or not at all
+func parseLocation(latitude string, longitude string) (*solar.Location, error) {
+	if latitude == "" && longitude == "" {
+		return nil, nil
+	} else if latitude == "" || longitude == "" {
+		return nil, fmt.Errorf("-latitude and -longitude must be set together")
+	}
+	var location solar.Location
+	var err error
+	if location.Latitude, err = strconv.ParseFloat(strings.TrimSpace(latitude), 64); err != nil {
+		return nil, fmt.Errorf("Invalid latitude %q", latitude)
+	}
+	if location.Longitude, err = strconv.ParseFloat(strings.TrimSpace(longitude), 64); err != nil {
+		return nil, fmt.Errorf("Invalid longitude %q", longitude)
+	}
+	return &location, location.Validate()
+}
+
+// Parse the relay's hours, HH:MM-HH:MM, into offsets from midnight. Empty is the whole day.
+func parseRelayHours(value string) (time.Duration, time.Duration, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	fromValue, toValue, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("Invalid relay hours %q, expected HH:MM-HH:MM", value)
+	}
+	var hours [2]time.Duration
+	for i, clock := range []string{fromValue, toValue} {
+		at, err := time.Parse("15:04", strings.TrimSpace(clock))
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid relay hours %q, expected HH:MM-HH:MM", value)
+		}
+		hours[i] = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	}
+	return hours[0], hours[1], nil
+}
+
+// Split a comma-separated config value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,189 @@
+// Package solar estimates where the sun is for a place and time, and the illuminance a clear sky would give there.
+// The sun's position follows NOAA's solar calculator, good to within a fraction of a degree,
+// and the clear sky follows the IES daylight model, which is only ever a rough guide to a real sky.
+package solar
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	SOLAR_ILLUMINANCE  = 128000.0 // Lux of sunlight above the atmosphere, at the mean Earth-Sun distance
+	CLEAR_EXTINCTION   = 0.21     // Atmospheric extinction of a clear sky
+	CLEAR_DIFFUSE_BASE = 800.0    // The clear sky's diffuse light, 800 + 15500 × sin(elevation)^0.5 lux
+	CLEAR_DIFFUSE_SUN  = 15500.0
+	SUNRISE_ZENITH     = 90.833 // The sun's upper edge on the horizon, after refraction
+)
+
+// A place on Earth, in degrees. North and east are positive.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (l Location) Validate() error {
+	if l.Latitude < -90 || l.Latitude > 90 || math.IsNaN(l.Latitude) {
+		return errors.New("latitude must be between -90 and 90")
+	} else if l.Longitude < -180 || l.Longitude > 180 || math.IsNaN(l.Longitude) {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+// Where the sun is in the sky, in degrees. Elevation is above the horizon, azimuth is clockwise from north.
+type Position struct {
+	Elevation float64 `json:"elevation"`
+	Azimuth   float64 `json:"azimuth"`
+}
+
+// The sun's position at a time and place, without atmospheric refraction
+func SunPosition(t time.Time, loc Location) Position {
+	declination, equationOfTime := sunAt(t)
+	hourAngle := hourAngleAt(t, loc, equationOfTime)
+
+	cosZenith := sin(loc.Latitude)*sin(declination) + cos(loc.Latitude)*cos(declination)*cos(hourAngle)
+	zenith := degrees(math.Acos(math.Max(-1, math.Min(1, cosZenith))))
+
+	// At the poles, or with the sun overhead, any azimuth will do
+	azimuth := 180.0
+	if denominator := cos(loc.Latitude) * sin(zenith); math.Abs(denominator) > 1e-9 {
+		cosAzimuth := (sin(loc.Latitude)*cos(zenith) - sin(declination)) / denominator
+		azimuth = degrees(math.Acos(math.Max(-1, math.Min(1, cosAzimuth))))
+		if hourAngle > 0 {
+			azimuth = math.Mod(azimuth+180, 360)
+		} else {
+			azimuth = math.Mod(540-azimuth, 360)
+		}
+	}
+	return Position{Elevation: 90 - zenith, Azimuth: azimuth}
+}
+
+// When the sun rises, sets, and crosses the meridian on a day
+type Day struct {
+	SolarNoon time.Time
+	// Zero when the sun stays up, or down, all day
+	Sunrise time.Time
+	Sunset  time.Time
+	Length  time.Duration
+}
+
+// The sun's times on the day holding t, in t's location.
+// It's the solar noon nearest the day's midday, within a minute or so of NOAA's tables.
+func SunTimes(t time.Time, loc Location) Day {
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, t.Location())
+	for i := 0; i < 2; i++ {
+		_, equationOfTime := sunAt(noon)
+		noon = noon.Add(-time.Duration(hourAngleAt(noon, loc, equationOfTime) * 4 * float64(time.Minute)))
+	}
+	day := Day{SolarNoon: noon}
+
+	declination, _ := sunAt(noon)
+	cosHourAngle := (cos(SUNRISE_ZENITH) - sin(loc.Latitude)*sin(declination)) / (cos(loc.Latitude) * cos(declination))
+	if cosHourAngle >= 1 || math.IsNaN(cosHourAngle) {
+		return day
+	} else if cosHourAngle <= -1 {
+		day.Length = 24 * time.Hour
+		return day
+	}
+	half := time.Duration(degrees(math.Acos(cosHourAngle)) * 4 * float64(time.Minute))
+	day.Sunrise, day.Sunset, day.Length = noon.Add(-half), noon.Add(half), 2*half
+	return day
+}
+
+// How long the sun is up between from and to
+func DaylightBetween(from time.Time, to time.Time, loc Location) time.Duration {
+	var daylight time.Duration
+	// A day either side, since a day's daylight can spill past its date at far longitudes
+	for day := from.UTC().AddDate(0, 0, -1); !day.After(to.UTC().AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
+		sun := SunTimes(day, loc)
+		rise, set := sun.Sunrise, sun.Sunset
+		if rise.IsZero() {
+			if sun.Length == 0 {
+				continue
+			}
+			rise, set = sun.SolarNoon.Add(-12*time.Hour), sun.SolarNoon.Add(12*time.Hour)
+		}
+		if rise.Before(from) {
+			rise = from
+		}
+		if set.After(to) {
+			set = to
+		}
+		if set.After(rise) {
+			daylight += set.Sub(rise)
+		}
+	}
+	return daylight
+}
+
+// The sun's declination, in degrees, and the equation of time, in minutes, at a time
+func sunAt(t time.Time) (float64, float64) {
+	t = t.UTC()
+	julianDay := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	century := (julianDay - 2451545) / 36525
+
+	meanLongitude := math.Mod(280.46646+century*(36000.76983+century*0.0003032), 360)
+	meanAnomaly := 357.52911 + century*(35999.05029-0.0001537*century)
+	eccentricity := 0.016708634 - century*(0.000042037+0.0000001267*century)
+	center := sin(meanAnomaly)*(1.914602-century*(0.004817+0.000014*century)) +
+		sin(2*meanAnomaly)*(0.019993-0.000101*century) +
+		sin(3*meanAnomaly)*0.000289
+	omega := 125.04 - 1934.136*century
+	apparentLongitude := meanLongitude + center - 0.00569 - 0.00478*sin(omega)
+	meanObliquity := 23 + (26+(21.448-century*(46.815+century*(0.00059-century*0.001813)))/60)/60
+	obliquity := meanObliquity + 0.00256*cos(omega)
+	declination := degrees(math.Asin(sin(obliquity) * sin(apparentLongitude)))
+
+	// The equation of time is how far the sun runs ahead of the clock
+	y := math.Pow(math.Tan(radians(obliquity/2)), 2)
+	equationOfTime := 4 * degrees(y*sin(2*meanLongitude)-2*eccentricity*sin(meanAnomaly)+
+		4*eccentricity*y*sin(meanAnomaly)*cos(2*meanLongitude)-
+		0.5*y*y*sin(4*meanLongitude)-1.25*eccentricity*eccentricity*sin(2*meanAnomaly))
+	return declination, equationOfTime
+}
+
+// How far the sun is past solar noon, in degrees between -180 and 180
+func hourAngleAt(t time.Time, loc Location, equationOfTime float64) float64 {
+	t = t.UTC()
+	minutes := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+	trueSolarTime := math.Mod(minutes+equationOfTime+4*loc.Longitude, 1440)
+	if trueSolarTime < 0 {
+		trueSolarTime += 1440
+	}
+	return trueSolarTime/4 - 180
+}
+
+// The illuminance on a horizontal surface under a clear sky at a time and place, in lux.
+// It's 0 while the sun is below the horizon, twilight isn't modeled.
+func ClearSkyLux(t time.Time, loc Location) float64 {
+	return ClearSkyLuxAt(SunPosition(t, loc).Elevation, t)
+}
+
+// The clear sky illuminance for a sun at an elevation, on a date, in lux.
+// The direct sun is dimmed by the air it passes through, and the sky adds its diffuse light.
+func ClearSkyLuxAt(elevation float64, t time.Time) float64 {
+	if elevation <= 0 {
+		return 0
+	}
+	// The Earth is closest to the sun in early January
+	day := float64(t.UTC().YearDay())
+	extraterrestrial := SOLAR_ILLUMINANCE * (1 + 0.034*math.Cos(2*math.Pi*(day-2)/365))
+
+	direct := extraterrestrial * math.Exp(-CLEAR_EXTINCTION*AirMass(elevation)) * sin(elevation)
+	diffuse := CLEAR_DIFFUSE_BASE + CLEAR_DIFFUSE_SUN*math.Sqrt(sin(elevation))
+	return direct + diffuse
+}
+
+// How many atmospheres of air the sunlight passes through, from Kasten and Young (1989).
+// It's 1 with the sun overhead, and stays finite down at the horizon.
+func AirMass(elevation float64) float64 {
+	zenith := 90 - elevation
+	return 1 / (cos(zenith) + 0.50572*math.Pow(96.07995-zenith, -1.6364))
+}
+
+func radians(degrees float64) float64 { return degrees * math.Pi / 180 }
+func degrees(radians float64) float64 { return radians * 180 / math.Pi }
+func sin(degrees float64) float64     { return math.Sin(radians(degrees)) }
+func cos(degrees float64) float64     { return math.Cos(radians(degrees)) }
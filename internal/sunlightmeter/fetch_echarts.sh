@@ -0,0 +1,13 @@
+#!/bin/sh
+# Fetch the echarts assets the graph loads into static/echarts, to be embedded in the binary.
+# Run by go generate, the themes match the ones in graphThemes.
+set -e
+
+HOST="https://go-echarts.github.io/go-echarts-assets/assets"
+DIR="static/echarts"
+
+mkdir -p "$DIR/themes"
+curl -fsSL -o "$DIR/echarts.min.js" "$HOST/echarts.min.js"
+for theme in chalk essos infographic macarons purple-passion roma romantic shine vintage walden westeros wonderland; do
+    curl -fsSL -o "$DIR/themes/$theme.js" "$HOST/themes/$theme.js"
+done
@@ -0,0 +1,66 @@
+// select the correct tab, and hide the other tabs
+var tabs = {
+    resultsTab: 'resultsContent',
+    settingsTab: 'settingsContent',
+    jobsTab: 'jobsContent',
+};
+
+function switchTab(activeTabId) {
+    for (var tabId in tabs) {
+        var active = tabId === activeTabId;
+        document.getElementById(tabs[tabId]).classList.toggle('hidden', !active);
+        document.getElementById(tabId).classList.toggle('bg-gray-300', active);
+        document.getElementById(tabId).classList.toggle('bg-gray-200', !active);
+    }
+}
+
+window.onload = function () {
+    document.title = "Sunlight Meter";
+    for (var tabId in tabs) {
+        document.getElementById(tabId).addEventListener('click', switchTab.bind(null, tabId));
+    }
+    setDateInputs();
+}
+
+// graph a job from the job list, over its whole span
+function graphJob(jobID, start, end) {
+    var jobSelect = document.getElementById('job_id');
+    if (jobSelect) {
+        // a job that started after the page loaded isn't in the select yet
+        if (!jobSelect.querySelector('option[value="' + jobID + '"]')) {
+            jobSelect.add(new Option(jobID, jobID));
+        }
+        jobSelect.value = jobID;
+    }
+    document.getElementById('start').value = start;
+    document.getElementById('end').value = end;
+    switchTab('resultsTab');
+    htmx.trigger('#graphForm', 'submit');
+}
+
+// export only the readings between the start and end times in the settings
+function exportRangeURL() {
+    var params = new URLSearchParams({
+        start: document.getElementById('start').value,
+        end: document.getElementById('end').value,
+    });
+    return '/sunlightmeter/export?' + params.toString();
+}
+
+function setDateInputs() {
+    // set the start and end times to 8 hours ago and now
+    var now = new Date();
+    var eightHoursAgo = new Date(now.getTime() - 8 * 60 * 60 * 1000);
+    document.getElementById('start').value = formatDateTime(eightHoursAgo);
+    document.getElementById('end').value = formatDateTime(now);
+}
+
+// format a date as a string that can be used in an input[type=datetime-local]
+function formatDateTime(date) {
+    var year = date.getFullYear();
+    var month = (date.getMonth() + 1).toString().padStart(2, '0');
+    var day = date.getDate().toString().padStart(2, '0');
+    var hours = date.getHours().toString().padStart(2, '0');
+    var minutes = date.getMinutes().toString().padStart(2, '0');
+    return year + '-' + month + '-' + day + 'T' + hours + ':' + minutes;
+}
@@ -5,37 +5,138 @@ import (
 	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"log"
 	"math"
 	"net/http"
-	"os/exec"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/ztkent/sunlight-meter/internal/solar"
+	"github.com/ztkent/sunlight-meter/internal/tools"
 	"github.com/ztkent/sunlight-meter/tsl2591"
 )
 
 //go:embed html/*
 var templateFiles embed.FS
 
+//go:generate sh fetch_echarts.sh
+//go:embed static/*
+var staticFiles embed.FS
+
 type SLMeter struct {
 	*tsl2591.TSL2591
 	LuxResultsChan chan LuxResults
 	ResultsDB      *sql.DB
-	cancel         context.CancelFunc
+	DBPath         string
 	Pid            int
+	NetInterface   string // Reported by signal-strength, picked automatically when empty
+	RecordTemp     bool   // Record the CPU temperature alongside each sample
+	MinFreeDisk    uint64 // Pause recording when the db's filesystem has less free space, 0 disables the check
+	// Reports the usage of the filesystem holding a path, defaults to tools.ReadDiskUsage
+	DiskUsage func(path string) (tools.DiskUsage, error)
+	// Job events and lux threshold crossings are posted here, if set
+	Webhooks *tools.WebhookNotifier
+	LuxAbove float64
+	LuxBelow float64
+	// Buffer this many results before writing them, flushing at least every BatchInterval
+	BatchSize     int
+	BatchInterval time.Duration
+	// Which sample is dropped when LuxResultsChan is full, DROP_NEWEST by default
+	DropPolicy string
+	// Recorded with each sample, and sent with forwarded readings
+	DeviceID string
+	Version  string
+	Commit   string
+	// Samples are also queued on disk, and posted to ForwardURL with ForwardToken, if set
+	ForwardURL   string
+	ForwardToken string
+	// Recorded samples are also written to InfluxDB, if set
+	Influx *tools.InfluxWriter
+	// Days are summarized in this timezone, defaults to UTC
+	SummaryLocation *time.Location
+	// Recorded with each sample. Sensors on other buses each get their own meter, added with AddSensor.
+	SensorID string
+	Sensors  []*SLMeter
+	// µmol/m²/s of photosynthetic light per lux, for the light source being measured. Defaults to sunlight.
+	PPFDPerLux float64
+	// Load the graph's JavaScript from the go-echarts CDN, rather than the copy in the static files
+	EChartsCDN bool
+	// Where the meter is, for the clear sky model. Nil when it isn't set.
+	Location *solar.Location
+	// Where RunWeather fetches the weather at Location from, nil when it isn't fetched
+	Weather tools.WeatherProvider
+	// Switched by the readings, nil when there's no relay
+	Relay *Relay
+
+	jobMu           sync.Mutex
+	jobID           string
+	lastJobID       string
+	jobCtx          context.Context
+	jobStartedAt    time.Time
+	cancel          context.CancelFunc
+	interruptCancel context.CancelFunc
+	onDemand        bool
+	paused          bool          // The running job is still read, but the recorder discards its results
+	recordInterval  time.Duration // Between a job's samples, RECORD_INTERVAL when 0
+
+	dropped     atomic.Int64
+	produced    atomic.Int64
+	recorded    atomic.Int64
+	overflowed  atomic.Int64
+	lost        atomic.Int64
+	storageFull atomic.Bool
+	lightState  int
+	flush       chan chan struct{}
+	flushOnce   sync.Once
+	closing     atomic.Bool // Set on shutdown, new results are no longer accepted
+
+	events        chan SensorEvent
+	eventsOnce    sync.Once
+	droppedEvents atomic.Int64
+
+	jobChanges     chan struct{} // Signalled when any sensor's job starts or stops
+	jobChangesOnce sync.Once
+
+	lastWrite        atomic.Int64 // Unix nanoseconds of the last successful write
+	stalled          atomic.Bool
+	recoveries       atomic.Int64
+	recoveryFailures atomic.Int64
+
+	ppfdSetting      atomic.Pointer[PPFDSetting]      // Saved through the API, in place of PPFDPerLux
+	lightSourceBands atomic.Pointer[LightSourceBands] // Saved through the API, in place of the defaults
+
+	summary  summaryState
+	graphPNG graphPNGCache
+	primary  *SLMeter // Set on the meters of added sensors
 }
 
+// A single sample from the sensor.
+// Failed marks a sample where the sensor could not be read, it is never recorded.
+// This keeps failed reads out of the averages, while a genuine 0 lux reading is still saved.
 type LuxResults struct {
 	Lux          float64
 	Infrared     float64
 	Visible      float64
 	FullSpectrum float64
 	JobID        string
+	SensorID     string
+	Failed       bool
+	// The sensor's channels overflowed, so there's no lux to record. The sample is stored flagged, with 0 lux.
+	Saturated bool
+	// Why the read failed, when it did
+	Error string
+	// Only set when RecordTemp is enabled, and the temperature could be read
+	CPUTemperature *float64
+	// The probable source of the light, classified by its IR ratio when it's read. Empty when it couldn't be told.
+	LightSource string
+	// When the sensor was read, the recorder stamps results that arrive without one
+	CreatedAt time.Time
 }
 
 type Conditions struct {
@@ -46,15 +147,32 @@ type Conditions struct {
 	Infrared              float64 `json:"infrared"`
 	DateRange             string  `json:"dateRange"`
 	RecordedHoursInRange  float64 `json:"recordedHoursInRange"`
+	DaylightHoursInRange  float64 `json:"daylightHoursInRange,omitempty"` // While recording, when the location is set
 	FullSunlightInRange   float64 `json:"fullSunlightInRange"`
 	LightConditionInRange string  `json:"lightConditionInRange"`
 	AverageLuxInRange     float64 `json:"averageLuxInRange"`
+	PPFD                  float64 `json:"ppfd"` // Estimated from lux, in µmol/m²/s
+	PPFDSource            string  `json:"ppfdSource,omitempty"`
+	Units                 string  `json:"units,omitempty"`
 }
 
 const (
-	MAX_JOB_DURATION = 8 * time.Hour
-	RECORD_INTERVAL  = 30 * time.Second
-	DB_PATH          = "sunlightmeter.db"
+	MAX_JOB_DURATION    = 8 * time.Hour
+	RECORD_INTERVAL     = 30 * time.Second
+	OVERFLOW_RETRY      = 5 * time.Second // How long to wait after an overflow before reading again
+	RESULTS_BUFFER      = 100
+	DROP_NEWEST         = "newest" // Drop the sample being sent, the buffered samples are kept
+	DROP_OLDEST         = "oldest" // Drop the oldest buffered sample, to make room for the one being sent
+	SINGLE_READ_SAMPLES = 3
+	DB_PATH             = "sunlightmeter.db"
+)
+
+var (
+	ErrJobRunning = errors.New("The sensor is already started")
+	ErrJobStopped = errors.New("The sensor is already stopped")
+	ErrSensorBusy = errors.New("The sensor is busy taking a reading")
+	ErrJobPaused  = errors.New("The job is already paused")
+	ErrJobResumed = errors.New("The job is not paused")
 )
 
 // Start the sensor, and collect data in a loop
@@ -62,73 +180,35 @@ func (m *SLMeter) Start() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("It's going to be a bright day!")
 		if m.TSL2591 == nil {
-			ServeResponse(w, r, "The sensor is not connected", http.StatusBadRequest)
-			return
-		} else if m.Enabled {
-			ServeResponse(w, r, "The sensor is already started", http.StatusBadRequest)
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
 			return
 		}
 
-		go func() {
-			// Create a new context with a timeout to manage the sensor lifecycle
-			ctx, cancel := context.WithTimeout(context.Background(), MAX_JOB_DURATION)
-			m.cancel = cancel
-
-			// Enable the sensor
-			m.Enable()
-			defer m.Disable()
-
-			jobID := uuid.New().String()
-			ticker := time.NewTicker(RECORD_INTERVAL)
-			for {
-				// Check if we've cancelled this job.
-				select {
-				case <-ctx.Done():
-					log.Println("Job Cancelled, stopping sensor")
-					return
-				default:
-				}
-
-				// Read the sensor
-				ch0, ch1, err := m.GetFullLuminosity()
-				if err != nil {
-					log.Println(fmt.Sprintf("The sensor failed to get luminosity: %s", err.Error()))
-					m.LuxResultsChan <- LuxResults{
-						JobID: jobID,
-					}
-					<-ticker.C
-					continue
-				}
-
-				// Calculate the lux value from the sensor readings
-				lux, err := m.CalculateLux(ch0, ch1)
-				if err != nil {
-					log.Println(fmt.Sprintf("The sensor failed to calculate lux: %s", err.Error()))
-					log.Println("Attempting to set new optimal sensor gain")
-					err = m.SetOptimalGain()
-					if err != nil {
-						log.Println(fmt.Sprintf("The sensor failed to determine new optimal gain: %s", err.Error()))
-					} else {
-						log.Println("The sensor has been reconfigured with a new optimal gain")
-					}
-					time.Sleep(5 * time.Second)
-					continue
-				}
+		name := strings.TrimSpace(r.FormValue("name"))
+		if len(name) > MAX_JOB_FIELD_LENGTH {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "The name is too long", http.StatusBadRequest)
+			return
+		}
 
-				// Send the results to the LuxResultsChan
-				m.LuxResultsChan <- LuxResults{
-					Lux:          lux,
-					Visible:      tsl2591.GetNormalizedOutput(tsl2591.TSL2591_VISIBLE, ch0, ch1),
-					Infrared:     tsl2591.GetNormalizedOutput(tsl2591.TSL2591_INFRARED, ch0, ch1),
-					FullSpectrum: tsl2591.GetNormalizedOutput(tsl2591.TSL2591_FULLSPECTRUM, ch0, ch1),
-					JobID:        jobID,
-				}
-				<-ticker.C
+		jobID, err := m.startJob()
+		m.logSensorRequest(r, "start", jobID, err)
+		if errors.Is(err, ErrJobRunning) {
+			serveJobError(w, r, tools.ERR_JOB_RUNNING, err.Error(), jobID, http.StatusConflict)
+			return
+		} else if errors.Is(err, ErrSensorBusy) {
+			serveJobError(w, r, tools.ERR_SENSOR_BUSY, err.Error(), jobID, http.StatusConflict)
+			return
+		} else if err != nil {
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if name != "" {
+			// The job is already recording, so a failure here only loses the name
+			if err := m.labelJob(Job{JobID: jobID, Name: name}); err != nil {
+				tools.RequestLog(r).WithError(err).Error(fmt.Sprintf("Failed to name job %s", jobID))
 			}
-		}()
-		w.WriteHeader(http.StatusOK)
-		ServeResponse(w, r, "Sunlight Reading Started", http.StatusOK)
-		return
+		}
+		serveJobResponse(w, r, "Sunlight Reading Started", jobID, http.StatusOK)
 	}
 }
 
@@ -136,61 +216,518 @@ func (m *SLMeter) Start() http.HandlerFunc {
 func (m *SLMeter) Stop() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if m.TSL2591 == nil {
-			ServeResponse(w, r, "The sensor is not connected", http.StatusBadRequest)
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		}
+
+		jobID, err := m.stopJob()
+		m.logSensorRequest(r, "stop", jobID, err)
+		if errors.Is(err, ErrJobStopped) {
+			serveJobError(w, r, tools.ERR_JOB_NOT_RUNNING, err.Error(), jobID, http.StatusConflict)
 			return
-		} else if !m.Enabled {
-			ServeResponse(w, r, "The sensor is already stopped", http.StatusBadRequest)
+		} else if err != nil {
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		serveJobResponse(w, r, "Sunlight Reading Stopped", jobID, http.StatusOK)
+	}
+}
+
+// Pause recording the running job, the sensor keeps reading but nothing is written until it's resumed
+func (m *SLMeter) Pause() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID, err := m.pauseJob()
+		m.logSensorRequest(r, "pause", jobID, err)
+		if errors.Is(err, ErrJobStopped) {
+			serveJobError(w, r, tools.ERR_JOB_NOT_RUNNING, err.Error(), jobID, http.StatusConflict)
+			return
+		} else if errors.Is(err, ErrJobPaused) {
+			serveJobError(w, r, tools.ERR_CONFLICT, err.Error(), jobID, http.StatusConflict)
+			return
+		}
+		serveJobResponse(w, r, "Sunlight Recording Paused", jobID, http.StatusOK)
+	}
+}
+
+// Resume recording the running job
+func (m *SLMeter) Resume() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobID, err := m.resumeJob()
+		m.logSensorRequest(r, "resume", jobID, err)
+		if errors.Is(err, ErrJobStopped) {
+			serveJobError(w, r, tools.ERR_JOB_NOT_RUNNING, err.Error(), jobID, http.StatusConflict)
+			return
+		} else if errors.Is(err, ErrJobResumed) {
+			serveJobError(w, r, tools.ERR_CONFLICT, err.Error(), jobID, http.StatusConflict)
+			return
+		}
+		serveJobResponse(w, r, "Sunlight Recording Resumed", jobID, http.StatusOK)
+	}
+}
+
+// Log a request that used the sensor, with its request ID and the job it affected as structured fields.
+// The request ID matches the one on the access log line, to trace who started or stopped a job.
+func (m *SLMeter) logSensorRequest(r *http.Request, action string, jobID string, err error) {
+	entry := tools.RequestLog(r).WithFields(logrus.Fields{
+		"action": action,
+		"sensor": m.SensorID,
+		"job_id": jobID,
+	})
+	if err != nil {
+		entry.WithError(err).Warn("sensor request failed")
+		return
+	}
+	entry.Info("sensor request")
+}
+
+// The reply to starting, stopping, pausing or resuming a job
+type JobResponse struct {
+	Message string `json:"message"`
+	JobID   string `json:"jobID,omitempty"`
+}
+
+// Reply with the message and the affected job ID
+func serveJobResponse(w http.ResponseWriter, r *http.Request, message string, jobID string, status int) {
+	if tools.WantsJSON(r) {
+		serveJSON(w, status, JobResponse{
+			Message: message,
+			JobID:   jobID,
+		})
+		return
+	} else if jobID != "" {
+		message = fmt.Sprintf("%s - Job: %s", message, jobID)
+	}
+	ServeResponse(w, r, message, status)
+}
+
+// Reply with the error and the job it refers to
+func serveJobError(w http.ResponseWriter, r *http.Request, code string, message string, jobID string, status int) {
+	if !tools.WantsJSON(r) {
+		serveJobResponse(w, r, message, jobID, status)
+		return
+	}
+	type jobError struct {
+		tools.APIError
+		JobID string `json:"jobID,omitempty"`
+	}
+	serveJSON(w, status, struct {
+		Error jobError `json:"error"`
+	}{
+		Error: jobError{APIError: tools.APIError{Code: code, Message: message}, JobID: jobID},
+	})
+}
+
+// The ID of the running job, or an empty string if there isn't one
+func (m *SLMeter) JobID() string {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	return m.jobID
+}
 
-		// Stop the sensor, cancel the job context
-		defer m.Disable()
+// Start a new recording job, and return its ID
+func (m *SLMeter) startJob() (string, error) {
+	jobID := uuid.New().String()
+	if err := m.startJobWithID(jobID); err != nil {
+		return m.JobID(), err
+	}
+	return jobID, nil
+}
+
+func (m *SLMeter) startJobWithID(jobID string) error {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	if m.jobID != "" {
+		return ErrJobRunning
+	} else if m.onDemand {
+		return ErrSensorBusy
+	}
+
+	// Create a new context with a timeout to manage the sensor lifecycle
+	ctx, cancel := context.WithTimeout(context.Background(), MAX_JOB_DURATION)
+	m.jobID = jobID
+	m.jobCtx = ctx
+	m.jobStartedAt = time.Now()
+	m.cancel = cancel
+	m.interruptCancel = nil
+	m.paused = false
+	m.persistLoggingState(true, jobID)
+	m.jobStateChanged()
+	go m.runJob(ctx, jobID)
+	return nil
+}
+
+// Cancel the running job, and return its ID.
+// If there is no running job, the ID of the previous job is returned.
+func (m *SLMeter) stopJob() (string, error) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	if m.jobID == "" {
+		return m.lastJobID, ErrJobStopped
+	}
+	jobID := m.jobID
+	m.cancel()
+	m.jobID = ""
+	m.lastJobID = jobID
+	m.paused = false
+	m.persistLoggingState(false, jobID)
+	m.jobStateChanged()
+	return jobID, nil
+}
+
+// Stop recording the running job's results, without stopping the job. Its ID is returned.
+// Pausing only sets a flag the recorder checks, so pausing and resuming never starts or stops a goroutine.
+func (m *SLMeter) pauseJob() (string, error) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	if m.jobID == "" {
+		return m.lastJobID, ErrJobStopped
+	} else if m.paused {
+		return m.jobID, ErrJobPaused
+	}
+	m.paused = true
+	return m.jobID, nil
+}
+
+// Record the running job's results again, and return its ID
+func (m *SLMeter) resumeJob() (string, error) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	if m.jobID == "" {
+		return m.lastJobID, ErrJobStopped
+	} else if !m.paused {
+		return m.jobID, ErrJobResumed
+	}
+	m.paused = false
+	return m.jobID, nil
+}
+
+// Whether the running job is paused
+func (m *SLMeter) Paused() bool {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	return m.jobID != "" && m.paused
+}
+
+// Whether the recorder should discard the job's results, only the running job can be paused.
+// Results from a job that has since stopped are still recorded.
+func (m *SLMeter) recordingPaused(jobID string) bool {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	return m.paused && m.jobID == jobID
+}
+
+// Clear the job once its goroutine returns, unless it was already stopped
+func (m *SLMeter) endJob(jobID string) {
+	m.jobMu.Lock()
+	defer m.jobMu.Unlock()
+	if m.jobID == jobID {
 		m.cancel()
+		m.jobID = ""
+		m.lastJobID = jobID
+		m.paused = false
+		m.persistLoggingState(false, jobID)
+		m.jobStateChanged()
+	}
+	// Leave the sensor on if another job has already started
+	if m.jobID == "" {
+		m.Disable()
+	}
+}
 
-		w.WriteHeader(http.StatusOK)
-		ServeResponse(w, r, "Sunlight Reading Stopped", http.StatusOK)
+// Read the sensor every RECORD_INTERVAL until the job is cancelled or times out
+func (m *SLMeter) runJob(ctx context.Context, jobID string) {
+	// Enable the sensor, a job that can't read it never starts
+	if err := m.Enable(); err != nil {
+		log.Println(fmt.Sprintf("The sensor failed to enable: %s", err.Error()))
+		m.Webhooks.Notify(tools.EVENT_JOB_ERROR, jobID, nil, fmt.Sprintf("The sensor failed to enable: %s", err.Error()))
+		m.endJob(jobID)
 		return
 	}
+	m.Webhooks.Notify(tools.EVENT_JOB_STARTED, jobID, nil, "Sunlight Reading Started")
+	defer func() {
+		m.Webhooks.Notify(tools.EVENT_JOB_STOPPED, jobID, nil, "Sunlight Reading Stopped")
+		m.endJob(jobID)
+	}()
+
+	ticker := time.NewTicker(m.sampleInterval())
+	defer ticker.Stop()
+	failing := false
+	for {
+		// Read the sensor, transient errors are retried by the driver
+		reading, err := m.GetReading()
+		// Failed reads have no time from the driver, they're stamped as soon as it returns
+		readAt := reading.ReadAt
+		if readAt.IsZero() {
+			readAt = time.Now().UTC()
+		}
+		if errors.Is(err, tsl2591.ErrOverflow) {
+			log.Println(fmt.Sprintf("The sensor failed to calculate lux: %s", err.Error()))
+			m.logEvent(SENSOR_EVENT_OVERFLOW, jobID, err.Error())
+			m.sendResult(LuxResults{JobID: jobID, SensorID: m.SensorID, Saturated: true, CreatedAt: readAt})
+			log.Println("Attempting to set new optimal sensor gain")
+			gain, timing := m.Gain, m.Timing
+			err = m.SetOptimalGain()
+			if err != nil {
+				log.Println(fmt.Sprintf("The sensor failed to determine new optimal gain: %s", err.Error()))
+			} else {
+				log.Println("The sensor has been reconfigured with a new optimal gain")
+			}
+			if m.Gain != gain || m.Timing != timing {
+				m.logEvent(SENSOR_EVENT_GAIN_CHANGE, jobID, fmt.Sprintf("%s -> %s", gainLabel(gain, timing), gainLabel(m.Gain, m.Timing)))
+			}
+			// The job can still be stopped while the sensor stays saturated
+			select {
+			case <-ctx.Done():
+				log.Println("Job Cancelled, stopping sensor")
+				return
+			case <-time.After(OVERFLOW_RETRY):
+			}
+			continue
+		} else if err != nil {
+			log.Println(fmt.Sprintf("The sensor failed to get luminosity: %s", err.Error()))
+			m.logEvent(SENSOR_EVENT_I2C_ERROR, jobID, err.Error())
+			if !failing {
+				m.Webhooks.Notify(tools.EVENT_JOB_ERROR, jobID, nil, fmt.Sprintf("The sensor failed to get luminosity: %s", err.Error()))
+			}
+			failing = true
+			m.sendResult(LuxResults{
+				JobID:     jobID,
+				SensorID:  m.SensorID,
+				Failed:    true,
+				Error:     err.Error(),
+				CreatedAt: readAt,
+			})
+		} else {
+			failing = false
+			// Send the results to the LuxResultsChan
+			result := LuxResults{
+				Lux:          reading.Lux,
+				Visible:      reading.Visible,
+				Infrared:     reading.Infrared,
+				FullSpectrum: reading.FullSpectrum,
+				JobID:        jobID,
+				SensorID:     m.SensorID,
+				LightSource:  m.LightSourceBands().classify(reading.Infrared, reading.FullSpectrum),
+				CreatedAt:    readAt,
+			}
+			if m.RecordTemp {
+				if temp, err := tools.ReadCPUTemperature(); err == nil {
+					result.CPUTemperature = &temp
+				}
+			}
+			m.sendResult(result)
+		}
+
+		// Check if we've cancelled this job.
+		select {
+		case <-ctx.Done():
+			log.Println("Job Cancelled, stopping sensor")
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
-// Serve data about the most recent entry saved to the db
-func (m *SLMeter) CurrentConditions() http.HandlerFunc {
+// How long a job waits between samples
+func (m *SLMeter) sampleInterval() time.Duration {
+	if m.recordInterval > 0 {
+		return m.recordInterval
+	}
+	return RECORD_INTERVAL
+}
+
+// Run fn with the sensor powered on, without starting a job.
+// If a job is running the sensor is already on, otherwise it's only enabled while fn runs.
+func (m *SLMeter) withSensor(fn func() error) error {
+	m.jobMu.Lock()
+	if m.jobID != "" {
+		m.jobMu.Unlock()
+		return fn()
+	} else if m.onDemand {
+		m.jobMu.Unlock()
+		return ErrSensorBusy
+	}
+	m.onDemand = true
+	m.jobMu.Unlock()
+	defer func() {
+		m.jobMu.Lock()
+		m.onDemand = false
+		m.jobMu.Unlock()
+	}()
+
+	if err := m.Enable(); err != nil {
+		return err
+	}
+	defer m.Disable()
+	return fn()
+}
+
+// Take a single averaged reading, without starting a job
+func (m *SLMeter) ReadOnce() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if m.TSL2591 == nil {
-			ServeResponse(w, r, "The sensor is not connected", http.StatusBadRequest)
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		} else if m.JobID() != "" {
+			ServeError(w, r, tools.ERR_JOB_RUNNING, "A job is running, use current-conditions instead", http.StatusConflict)
+			return
+		}
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var conditions Conditions
+		err = m.withSensor(func() error {
+			var err error
+			conditions, err = m.averageReading(SINGLE_READ_SAMPLES)
+			return err
+		})
+		m.logSensorRequest(r, "read", "", err)
+		if errors.Is(err, ErrSensorBusy) {
+			ServeError(w, r, tools.ERR_SENSOR_BUSY, err.Error(), http.StatusConflict)
 			return
-		} else if !m.Enabled {
-			ServeResponse(w, r, "The sensor is not enabled", http.StatusBadRequest)
+		} else if err != nil {
+			tools.RequestLog(r).WithError(err).Error("The sensor failed to take a reading")
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		conditions, err := m.getCurrentConditions()
+
+		conditions = m.withPPFD(conditions)
+		serveData(w, r, conditions.inUnits(units), http.StatusOK)
+	}
+}
+
+// Average a number of readings from the sensor
+func (m *SLMeter) averageReading(samples int) (Conditions, error) {
+	conditions := Conditions{}
+	for i := 0; i < samples; i++ {
+		reading, err := m.GetReading()
 		if err != nil {
-			log.Println(err)
-			ServeResponse(w, r, err.Error(), http.StatusInternalServerError)
+			return Conditions{}, err
+		}
+		conditions.Lux += reading.Lux / float64(samples)
+		conditions.Visible += reading.Visible / float64(samples)
+		conditions.Infrared += reading.Infrared / float64(samples)
+		conditions.FullSpectrum += reading.FullSpectrum / float64(samples)
+	}
+	return conditions, nil
+}
+
+// Reset the sensor, and re-apply the current gain/timing
+func (m *SLMeter) ResetSensor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.TSL2591 == nil {
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
 			return
 		}
 
-		conditionsData, err := json.Marshal(conditions)
+		err := m.Reset()
+		m.logSensorRequest(r, "reset", m.JobID(), err)
 		if err != nil {
-			log.Println(err)
-			ServeResponse(w, r, err.Error(), http.StatusInternalServerError)
+			tools.RequestLog(r).WithError(err).Error("The sensor failed to reset")
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, fmt.Sprintf("The sensor failed to reset: %s", err.Error()), http.StatusInternalServerError)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		ServeResponse(w, r, string(conditionsData), http.StatusOK)
+		ServeResponse(w, r, "Sensor Reset", http.StatusOK)
 		return
 	}
 }
 
-// Return the most recent entry saved to the db
+// Take a single reading, and serve the raw channel values
+func (m *SLMeter) RawChannels() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.TSL2591 == nil {
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		}
+
+		// Power the sensor for this reading if no job is running, the gain/timing are left as-is
+		var ch0, ch1 uint16
+		var gain, timing byte
+		err := m.withSensor(func() error {
+			m.Lock()
+			defer m.Unlock()
+			var err error
+			ch0, ch1, err = m.GetFullLuminosity()
+			gain, timing = m.Gain, m.Timing
+			return err
+		})
+		if errors.Is(err, ErrSensorBusy) {
+			ServeError(w, r, tools.ERR_SENSOR_BUSY, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).WithError(err).Error("The sensor failed to get luminosity")
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serveJSON(w, http.StatusOK, struct {
+			Ch0             uint16 `json:"ch0"`
+			Ch1             uint16 `json:"ch1"`
+			Gain            string `json:"gain"`
+			IntegrationTime string `json:"integration_time"`
+		}{
+			Ch0:             ch0,
+			Ch1:             ch1,
+			Gain:            tsl2591.GainToString(gain),
+			IntegrationTime: tsl2591.IntegrationTimeToString(timing),
+		})
+	}
+}
+
+// Serve data about the most recent entry saved to the db.
+// When no job is running, the API serves the last recorded reading with live set to false, the dashboard reports an error.
+func (m *SLMeter) CurrentConditions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.TSL2591 == nil {
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		}
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		live := m.JobID() != ""
+		if !live && !tools.WantsJSON(r) {
+			ServeError(w, r, tools.ERR_JOB_NOT_RUNNING, "The sensor is not enabled", http.StatusConflict)
+			return
+		}
+		conditions, recordedAt, err := m.getLatestConditions()
+		if errors.Is(err, sql.ErrNoRows) && !live {
+			ServeError(w, r, tools.ERR_NOT_FOUND, "No readings have been recorded", http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		conditions = m.withPPFD(conditions)
+
+		serveData(w, r, LiveConditions{
+			Conditions: conditions.inUnits(units),
+			Live:       live,
+			RecordedAt: recordedAt,
+		}, http.StatusOK)
+	}
+}
+
+// The last recorded reading, and whether a job is still recording
+type LiveConditions struct {
+	Conditions
+	Live       bool   `json:"live"`
+	RecordedAt string `json:"recordedAt"`
+}
+
+// Return the most recent entry saved to the db, while a job is running
 func (m *SLMeter) getCurrentConditions() (Conditions, error) {
-	if m.TSL2591 == nil || !m.Enabled {
+	if m.TSL2591 == nil || m.JobID() == "" {
 		return Conditions{}, nil
 	}
-	conditions := Conditions{}
-	row := m.ResultsDB.QueryRow("SELECT job_id, lux, full_spectrum, visible, infrared FROM sunlight ORDER BY id DESC LIMIT 1")
-	err := row.Scan(&conditions.JobID, &conditions.Lux, &conditions.FullSpectrum, &conditions.Visible, &conditions.Infrared)
+	conditions, _, err := m.getLatestConditions()
 	if err != nil {
 		log.Println(err)
 		return Conditions{}, err
@@ -198,57 +735,67 @@ func (m *SLMeter) getCurrentConditions() (Conditions, error) {
 	return conditions, nil
 }
 
-// Check the signal strength of the wifi connection
+// Return the most recent entry saved to the db, and when it was recorded
+func (m *SLMeter) getLatestConditions() (Conditions, string, error) {
+	conditions := Conditions{}
+	var recordedAt string
+	// Skip over readings ingested from other devices, and recorded by other sensors
+	filter, filterArgs := m.readingFilter(readingScope{Device: m.DeviceID, Sensor: m.SensorID})
+	err := tools.RetryBusy(func() error {
+		row := m.ResultsDB.QueryRow("SELECT job_id, lux, full_spectrum, visible, infrared, CAST(created_at AS TEXT) FROM sunlight WHERE 1 = 1"+filter+" ORDER BY id DESC LIMIT 1", filterArgs...)
+		return row.Scan(&conditions.JobID, &conditions.Lux, &conditions.FullSpectrum, &conditions.Visible, &conditions.Infrared, &recordedAt)
+	})
+	if err != nil {
+		return Conditions{}, "", err
+	}
+	return conditions, recordedAt, nil
+}
+
+// Check the state of the network link, and the wifi signal strength
 func (m *SLMeter) SignalStrength() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cmd := exec.Command("sh", "-c", "iw dev wlan0 link | grep 'signal:' | awk '{print $2}'")
-		output, err := cmd.Output()
+		link, err := tools.ReadLinkInfo(m.NetInterface)
 		if err != nil {
-			log.Println(err)
-			ServeResponse(w, r, err.Error(), http.StatusInternalServerError)
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_NETWORK_UNAVAILABLE, err.Error(), http.StatusServiceUnavailable)
 			return
 		}
-		signalInt, err := strconv.Atoi(strings.TrimSpace(string(output)))
-		if err != nil {
-			ServeResponse(w, r, "Device is not connected to a network", http.StatusBadRequest)
+		if tools.WantsJSON(r) {
+			serveJSON(w, http.StatusOK, link)
 			return
 		}
 
-		// Convert the signal to a strength value
-		// https://git.openwrt.org/?p=project/iwinfo.git;a=blob;f=iwinfo_nl80211.c;hb=HEAD#l2885
-		if signalInt < -110 {
-			signalInt = -110
-		} else if signalInt > -40 {
-			signalInt = -40
+		if link.LinkType == tools.LINK_NONE {
+			ServeResponse(w, r, "Device is not connected to a network", http.StatusOK)
+		} else if link.LinkType == tools.LINK_ETHERNET {
+			ServeResponse(w, r, fmt.Sprintf("Ethernet (%s): %s", link.Interface, connectedString(link.Connected)), http.StatusOK)
+		} else if !link.Connected {
+			ServeResponse(w, r, fmt.Sprintf("Wifi (%s): %s", link.Interface, connectedString(link.Connected)), http.StatusOK)
+		} else {
+			log.Println("Signal: ", *link.SignalDBM, " dBm")
+			log.Println("Strength: ", *link.QualityPercent, "%")
+			ServeResponse(w, r, fmt.Sprintf("Signal Strength: %d dBm\nQuality: %d%%", *link.SignalDBM, *link.QualityPercent), http.StatusOK)
 		}
+	}
+}
 
-		// Scale the signal to a percentage
-		strength := (signalInt + 110) * 100 / 70
-		if strength < 0 {
-			strength = 0
-		} else if strength > 100 {
-			strength = 100
-		}
-
-		log.Println("Signal: ", fmt.Sprintf("%d", signalInt), " dBm")
-		log.Println("Strength: ", strength, "%")
-
-		w.WriteHeader(http.StatusOK)
-		ServeResponse(w, r, "Signal Strength: "+fmt.Sprintf("%d", signalInt)+" dBm\nQuality: "+fmt.Sprintf("%d", strength)+"%", http.StatusOK)
-		return
+func connectedString(connected bool) string {
+	if connected {
+		return "Connected"
 	}
+	return "Disconnected"
 }
 
 // Populate the response div with a message, or reply with a JSON message
 func ServeResponse(w http.ResponseWriter, r *http.Request, message string, status int) {
-	if strings.Contains(r.URL.Path, "/api/v1/") {
+	if tools.WantsJSON(r) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]string{"message": message})
 		return
 	}
 
-	tmpl, err := parseTemplateFile("html/response.gohtml")
+	tmpl, err := getTemplate("response.gohtml")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -260,41 +807,331 @@ func ServeResponse(w http.ResponseWriter, r *http.Request, message string, statu
 	}
 }
 
-func parseTemplateFile(path string) (*template.Template, error) {
-	content, err := templateFiles.ReadFile(path)
-	if err != nil {
-		log.Fatalf("failed to read embedded template: %v", err)
+// Populate the response div with the error, or reply with a JSON error
+func ServeError(w http.ResponseWriter, r *http.Request, code string, message string, status int) {
+	if tools.WantsJSON(r) {
+		tools.WriteAPIError(w, code, message, status)
+		return
 	}
+	ServeResponse(w, r, message, status)
+}
 
-	tmpl, err := template.New("results").Parse(string(content))
+// Reply with the data as the JSON body for API requests, or show it in the response div
+func serveData(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
+	if tools.WantsJSON(r) {
+		serveJSON(w, status, data)
+		return
+	}
+	encoded, err := json.Marshal(data)
 	if err != nil {
-		log.Fatalf("failed to parse template: %v", err)
+		tools.RequestLog(r).Error(err)
+		ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ServeResponse(w, r, string(encoded), status)
+}
+
+// Reply with a JSON body
+func serveJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Reject a reading that can't be stored as a number, NaN or infinite in either direction
+func validateResult(result LuxResults) error {
+	values := []struct {
+		name  string
+		value float64
+	}{
+		{"lux", result.Lux},
+		{"full spectrum", result.FullSpectrum},
+		{"visible", result.Visible},
+		{"infrared", result.Infrared},
 	}
-	return tmpl, nil
+	for _, v := range values {
+		if math.IsNaN(v.value) || math.IsInf(v.value, 0) {
+			return fmt.Errorf("invalid %s", v.name)
+		}
+	}
+	return nil
+}
+
+// The number of readings the recorder has rejected since startup
+func (m *SLMeter) DroppedReadings() int64 {
+	return m.dropped.Load()
 }
 
-// Read from LuxResultsChan, write the results to sqlite
+// Hand a sample to the recorder without waiting on it. If the buffer is full a sample is dropped and counted,
+// so a slow write can't hold up the sampling loop and stretch the interval.
+// The recorder's DropPolicy decides whether it's this sample, or the oldest one waiting.
+func (m *SLMeter) sendResult(result LuxResults) {
+	recorder := m.recorder()
+	if recorder.closing.Load() {
+		log.Println(fmt.Sprintf("- JobID: %s, Shutting down, not recording the sample", result.JobID))
+		return
+	}
+	recorder.produced.Add(1)
+	select {
+	case m.LuxResultsChan <- result:
+		return
+	default:
+	}
+
+	if recorder.DropPolicy == DROP_OLDEST {
+		select {
+		case oldest := <-m.LuxResultsChan:
+			dropped := recorder.overflowed.Add(1)
+			log.Println(fmt.Sprintf("- JobID: %s, The recorder is behind, dropping the oldest sample from %s (Dropped: %d)",
+				oldest.JobID, oldest.CreatedAt.Format("2006-01-02 15:04:05"), dropped))
+		default:
+		}
+		// Another sensor could have taken the space first
+		select {
+		case m.LuxResultsChan <- result:
+			return
+		default:
+		}
+	}
+	dropped := recorder.overflowed.Add(1)
+	log.Println(fmt.Sprintf("- JobID: %s, The recorder is behind, dropping the sample (Dropped: %d)", result.JobID, dropped))
+}
+
+// Whether the policy is DROP_NEWEST or DROP_OLDEST
+func ValidDropPolicy(policy string) bool {
+	return policy == DROP_NEWEST || policy == DROP_OLDEST
+}
+
+type RecorderStats struct {
+	Produced int64 `json:"samples_produced"`
+	Recorded int64 `json:"samples_recorded"`
+	Dropped  int64 `json:"samples_dropped"`
+	Lost     int64 `json:"samples_lost"`
+}
+
+// How many samples every sensor has taken since startup, how many were written,
+// how many were dropped because the recorder's buffer was full, and how many were lost to failed writes
+func (m *SLMeter) RecorderStats() RecorderStats {
+	recorder := m.recorder()
+	return RecorderStats{
+		Produced: recorder.produced.Load(),
+		Recorded: recorder.recorded.Load(),
+		Dropped:  recorder.overflowed.Load(),
+		Lost:     recorder.lost.Load(),
+	}
+}
+
+// Read from LuxResultsChan, write the results to sqlite.
+// With a BatchSize over 1, results are buffered and written together once the batch is full,
+// or every BatchInterval, whichever comes first. Results from a paused job are discarded.
 func (m *SLMeter) MonitorAndRecordResults() {
 	log.Println("Monitoring for new Sunlight Messages...")
+	batchSize := m.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	var flushTicker <-chan time.Time
+	if batchSize > 1 && m.BatchInterval > 0 {
+		ticker := time.NewTicker(m.BatchInterval)
+		defer ticker.Stop()
+		flushTicker = ticker.C
+	}
+
+	batch := make([]LuxResults, 0, batchSize)
 	for {
 		select {
 		case result := <-m.LuxResultsChan:
-			log.Println(fmt.Sprintf("- JobID: %s, Lux: %.5f", result.JobID, result.Lux))
-			if math.IsInf(result.Lux, 1) {
-				log.Println("Lux is invalid, skipping record")
+			result, ok := m.prepareResult(result)
+			if !ok {
 				continue
 			}
-			_, err := m.ResultsDB.Exec(
-				"INSERT INTO sunlight (job_id, lux, full_spectrum, visible, infrared) VALUES (?, ?, ?, ?, ?)",
-				result.JobID,
-				fmt.Sprintf("%.5f", result.Lux),
-				fmt.Sprintf("%.5e", result.FullSpectrum),
-				fmt.Sprintf("%.5e", result.Visible),
-				fmt.Sprintf("%.5e", result.Infrared),
-			)
+			batch = append(batch, result)
+			if len(batch) >= batchSize {
+				m.writeResults(batch)
+				batch = batch[:0]
+			}
+		case <-flushTicker:
+			m.writeResults(batch)
+			batch = batch[:0]
+		case done := <-m.flushRequests():
+			// Everything already sent is written too, not only the batch
+			for drained := false; !drained; {
+				select {
+				case result := <-m.LuxResultsChan:
+					if result, ok := m.prepareResult(result); ok {
+						batch = append(batch, result)
+					}
+				default:
+					drained = true
+				}
+			}
+			m.writeResults(batch)
+			batch = batch[:0]
+			close(done)
+		}
+	}
+}
+
+// Check a result from LuxResultsChan before it's recorded, and whether it should be written.
+// Failed reads are recorded as failures here, and invalid results are dropped.
+func (m *SLMeter) prepareResult(result LuxResults) (LuxResults, bool) {
+	if result.CreatedAt.IsZero() {
+		result.CreatedAt = time.Now().UTC()
+	}
+	// The channel is still drained while a job is paused, so its sampling loop never backs up
+	if sensor, ok := m.Sensor(result.SensorID); ok && sensor.recordingPaused(result.JobID) {
+		log.Println(fmt.Sprintf("- JobID: %s, Paused, skipping record", result.JobID))
+		return result, false
+	}
+	if result.Failed {
+		log.Println(fmt.Sprintf("- JobID: %s, Failed read, recording the failure", result.JobID))
+		m.recordReadFailure(result)
+		return result, false
+	}
+	if !result.Saturated {
+		if err := validateResult(result); err != nil {
+			dropped := m.dropped.Add(1)
+			log.Println(fmt.Sprintf("- JobID: %s, %s, skipping record (Lux: %v, Full Spectrum: %v, Visible: %v, Infrared: %v, Dropped: %d)",
+				result.JobID, err.Error(), result.Lux, result.FullSpectrum, result.Visible, result.Infrared, dropped))
+			m.logResultEvent(result, SENSOR_EVENT_INVALID_LUX, fmt.Sprintf("Dropped a reading with %s", err.Error()))
+			return result, false
+		}
+		if result.Lux < 0 {
+			// The infrared channel read higher than full spectrum, there's no visible light to measure
+			log.Println(fmt.Sprintf("- JobID: %s, Negative lux %.5f, recording 0", result.JobID, result.Lux))
+			m.logResultEvent(result, SENSOR_EVENT_INVALID_LUX, fmt.Sprintf("Recorded negative lux %.5f as 0", result.Lux))
+			result.Lux = 0
+		}
+		m.checkThresholds(result)
+		m.updateRelay(result)
+	}
+	if m.StorageFull() {
+		log.Println(fmt.Sprintf("- JobID: %s, Storage is full, skipping record", result.JobID))
+		return result, false
+	}
+	if result.Saturated {
+		log.Println(fmt.Sprintf("- JobID: %s, Saturated", result.JobID))
+	} else {
+		log.Println(fmt.Sprintf("- JobID: %s, Lux: %.5f", result.JobID, result.Lux))
+	}
+	return result, true
+}
+
+// Write the results to sqlite in a single transaction
+func (m *SLMeter) writeResults(results []LuxResults) {
+	if len(results) == 0 {
+		return
+	}
+	// The whole transaction is retried if a reader holds the db
+	err := tools.RetryBusy(func() error {
+		return m.insertResults(results)
+	})
+	if err != nil {
+		lost := m.lost.Add(int64(len(results)))
+		log.Println(fmt.Sprintf("Failed to write %d results, they're lost (Lost: %d): %s", len(results), lost, err.Error()))
+		return
+	}
+	m.lastWrite.Store(time.Now().UnixNano())
+	m.stalled.Store(false)
+	m.recorded.Add(int64(len(results)))
+	m.writeInflux(results)
+}
+
+func (m *SLMeter) insertResults(results []LuxResults) error {
+	tx, err := m.ResultsDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT INTO sunlight (device_id, sensor_id, job_id, lux, full_spectrum, visible, infrared, cpu_temp, saturated, light_source, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, result := range results {
+		_, err := stmt.Exec(
+			m.DeviceID,
+			sql.NullString{String: result.SensorID, Valid: result.SensorID != ""},
+			result.JobID,
+			fmt.Sprintf("%.5f", result.Lux),
+			fmt.Sprintf("%.5e", result.FullSpectrum),
+			fmt.Sprintf("%.5e", result.Visible),
+			fmt.Sprintf("%.5e", result.Infrared),
+			result.CPUTemperature,
+			result.Saturated,
+			sql.NullString{String: result.LightSource, Valid: result.LightSource != ""},
+			result.CreatedAt.Format("2006-01-02 15:04:05"),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Queue the results for the forwarder in the same transaction, so a crash can't record without forwarding
+	if m.ForwardURL != "" {
+		queueStmt, err := tx.Prepare("INSERT INTO forward_queue (sensor_id, job_id, lux, full_spectrum, visible, infrared, cpu_temp, light_source, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer queueStmt.Close()
+		for _, result := range results {
+			// The central instance has no use for a reading without a lux
+			if result.Saturated {
+				continue
+			}
+			_, err := queueStmt.Exec(sql.NullString{String: result.SensorID, Valid: result.SensorID != ""},
+				result.JobID, result.Lux, result.FullSpectrum, result.Visible, result.Infrared, result.CPUTemperature,
+				sql.NullString{String: result.LightSource, Valid: result.LightSource != ""}, result.CreatedAt.Format("2006-01-02 15:04:05"))
 			if err != nil {
-				log.Println(err)
+				return err
 			}
 		}
 	}
+	return tx.Commit()
+}
+
+// Stop accepting new results, then write every result that was already sent, waiting up to timeout for the recorder.
+// Running jobs keep running until the process exits, but nothing they send after this is recorded.
+func (m *SLMeter) Shutdown(timeout time.Duration) {
+	m.recorder().closing.Store(true)
+	m.FlushResults(timeout)
+}
+
+// Write any buffered results, and any waiting in LuxResultsChan, waiting up to timeout for the recorder.
+// The timeout covers both the recorder taking the request and finishing the write, so a stuck write can't hang shutdown.
+func (m *SLMeter) FlushResults(timeout time.Duration) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	done := make(chan struct{})
+	select {
+	case m.flushRequests() <- done:
+	case <-deadline.C:
+		log.Println("Timed out waiting for the recorder to flush")
+		return
+	}
+	select {
+	case <-done:
+	case <-deadline.C:
+		log.Println("Timed out waiting for the recorder to finish flushing")
+	}
+}
+
+// Extra sensors share the primary meter's recorder
+func (m *SLMeter) recorder() *SLMeter {
+	if m.primary != nil {
+		return m.primary
+	}
+	return m
+}
+
+func (m *SLMeter) flushRequests() chan chan struct{} {
+	// Extra sensors are recorded by the primary meter
+	if m.primary != nil {
+		return m.primary.flushRequests()
+	}
+	m.flushOnce.Do(func() {
+		m.flush = make(chan chan struct{})
+	})
+	return m.flush
 }
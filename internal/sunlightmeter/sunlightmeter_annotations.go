@@ -0,0 +1,209 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const MAX_NOTE_LENGTH = 1000
+
+type Annotation struct {
+	ID          int64    `json:"id"`
+	Note        string   `json:"note"`
+	Temperature *float64 `json:"temperature"`
+	JobID       *string  `json:"jobID"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+// The note, with the temperature when there is one
+func (a Annotation) Label() string {
+	if a.Temperature != nil {
+		return fmt.Sprintf("%s (%.1f°C)", a.Note, *a.Temperature)
+	}
+	return a.Note
+}
+
+// Attach a note, and optionally a temperature in °C, to the current time or the timestamp.
+// The note can be sent as note or text, and tied to a job with job_id.
+func (m *SLMeter) Annotate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		note := strings.TrimSpace(r.FormValue("note"))
+		if note == "" {
+			note = strings.TrimSpace(r.FormValue("text"))
+		}
+		if note == "" {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "A note is required", http.StatusBadRequest)
+			return
+		} else if len(note) > MAX_NOTE_LENGTH {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("The note is longer than %d characters", MAX_NOTE_LENGTH), http.StatusBadRequest)
+			return
+		}
+
+		temperature := sql.NullFloat64{}
+		if value := r.FormValue("temperature"); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid temperature", http.StatusBadRequest)
+				return
+			}
+			temperature = sql.NullFloat64{Float64: parsed, Valid: true}
+		}
+
+		// Timestamps use the same format as the date range, the current time is used when it's empty
+		createdAt := time.Now().UTC().Format("2006-01-02 15:04:05")
+		if value := r.FormValue("timestamp"); value != "" {
+			parsed, err := inputDateToDB(value)
+			if err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid timestamp, expected YYYY-MM-DDTHH:MM", http.StatusBadRequest)
+				return
+			}
+			createdAt = parsed
+		}
+
+		jobID := sql.NullString{}
+		if value := strings.TrimSpace(r.FormValue("job_id")); value != "" {
+			if _, err := m.getJob(value); errors.Is(err, ErrJobNotFound) {
+				ServeError(w, r, tools.ERR_NOT_FOUND, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				tools.RequestLog(r).Error(err)
+				ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jobID = sql.NullString{String: value, Valid: true}
+		}
+
+		annotation := Annotation{Note: note}
+		err := m.ResultsDB.QueryRow(
+			"INSERT INTO annotations (note, temperature, job_id, created_at) VALUES (?, ?, ?, ?) RETURNING id, CAST(created_at AS TEXT)",
+			note, temperature, jobID, createdAt,
+		).Scan(&annotation.ID, &annotation.CreatedAt)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if temperature.Valid {
+			annotation.Temperature = &temperature.Float64
+		}
+		if jobID.Valid {
+			annotation.JobID = &jobID.String
+		}
+
+		log.Println(fmt.Sprintf("Annotated %s: %s", annotation.CreatedAt, note))
+		serveJSON(w, http.StatusCreated, annotation)
+	}
+}
+
+// Serve the annotations in the date range, oldest first.
+// With a job_id, only the job's annotations and those without a job are included.
+func (m *SLMeter) ServeAnnotations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		annotations, err := m.getAnnotations(startDate, endDate, r.FormValue("job_id"))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, annotations)
+	}
+}
+
+// Remove an annotation, and its marker on the graph
+func (m *SLMeter) DeleteAnnotation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid annotation id", http.StatusBadRequest)
+			return
+		}
+		result, err := tools.ExecRetry(m.ResultsDB, "DELETE FROM annotations WHERE id = ?", id)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+			ServeError(w, r, tools.ERR_NOT_FOUND, "Annotation not found", http.StatusNotFound)
+			return
+		}
+		ServeResponse(w, r, "Annotation Deleted", http.StatusOK)
+	}
+}
+
+// Get the annotations recorded in the date range, both ends included.
+// With a job, only its annotations and those without a job are included.
+func (m *SLMeter) getAnnotations(startDate string, endDate string, jobID string) ([]Annotation, error) {
+	filter, args := "", []interface{}{startDate, endDate}
+	if jobID != "" {
+		filter = " AND (job_id IS NULL OR job_id = ?)"
+		args = append(args, jobID)
+	}
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT id, note, temperature, job_id, CAST(created_at AS TEXT)
+    FROM annotations
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    ORDER BY created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		var annotation Annotation
+		var temperature sql.NullFloat64
+		var jobID sql.NullString
+		if err := rows.Scan(&annotation.ID, &annotation.Note, &temperature, &jobID, &annotation.CreatedAt); err != nil {
+			return nil, err
+		}
+		if temperature.Valid {
+			annotation.Temperature = &temperature.Float64
+		}
+		if jobID.Valid {
+			annotation.JobID = &jobID.String
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations, rows.Err()
+}
+
+// Place each annotation on the first reading at or after it, the x axis only has reading times
+func annotationMarkers(annotations []Annotation, timeValues []string) []opts.MarkLineNameXAxisItem {
+	markers := []opts.MarkLineNameXAxisItem{}
+	for _, annotation := range annotations {
+		if marker, ok := markerAt(annotation.CreatedAt, annotation.Label(), timeValues); ok {
+			markers = append(markers, marker)
+		}
+	}
+	return markers
+}
+
+// A marker line on the first reading at or after the time, or the last reading when there's none after it
+func markerAt(at string, name string, timeValues []string) (opts.MarkLineNameXAxisItem, bool) {
+	if len(timeValues) == 0 {
+		return opts.MarkLineNameXAxisItem{}, false
+	}
+	i := sort.SearchStrings(timeValues, at)
+	if i == len(timeValues) {
+		i = len(timeValues) - 1
+	}
+	return opts.MarkLineNameXAxisItem{Name: name, XAxis: timeValues[i]}, true
+}
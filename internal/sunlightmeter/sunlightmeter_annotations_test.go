@@ -0,0 +1,187 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func postAnnotation(m *SLMeter, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/annotations", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	m.Annotate().ServeHTTP(w, req)
+	return w
+}
+
+// Annotate each note at its time, which has to land on a minute like the dashboard's inputs
+func annotateAt(t *testing.T, m *SLMeter, notes map[string]time.Time) {
+	t.Helper()
+	for note, at := range notes {
+		if w := postAnnotation(m, url.Values{"note": {note}, "timestamp": {timeToInputDate(at)}}); w.Code != http.StatusCreated {
+			t.Fatalf("annotating %q: got %d, %s", note, w.Code, w.Body.String())
+		}
+	}
+}
+
+func getAnnotationNotes(t *testing.T, m *SLMeter, start time.Time, end time.Time) []string {
+	t.Helper()
+	query := url.Values{"start": {timeToInputDate(start)}, "end": {timeToInputDate(end)}}
+	w := serveAPIRequest(m.ServeAnnotations(), http.MethodGet, "/annotations?"+query.Encode())
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, %s", w.Code, w.Body.String())
+	}
+	var annotations []Annotation
+	if err := json.Unmarshal(w.Body.Bytes(), &annotations); err != nil {
+		t.Fatal(err)
+	}
+	notes := []string{}
+	for _, annotation := range annotations {
+		notes = append(notes, annotation.Note)
+	}
+	return notes
+}
+
+func TestAnnotationsAtRangeBoundaries(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, inputLocation())
+	end := start.Add(10 * time.Minute)
+	annotateAt(t, m, map[string]time.Time{
+		"before the start": start.Add(-time.Minute),
+		"at the start":     start,
+		"in the middle":    start.Add(5 * time.Minute),
+		"at the end":       end,
+		"after the end":    end.Add(time.Minute),
+	})
+	// The range ends at the start of its last minute, so later in that minute is outside it, as it is for readings
+	if _, err := m.ResultsDB.Exec("INSERT INTO annotations (note, created_at) VALUES (?, ?)", "during the last minute", end.Add(30*time.Second).UTC().Format("2006-01-02 15:04:05")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []string
+	}{
+		{"both ends are included", start, end, []string{"at the start", "in the middle", "at the end"}},
+		{"a range of one minute", start, start, []string{"at the start"}},
+		{"ending just before one", start.Add(time.Minute), end.Add(-time.Minute), []string{"in the middle"}},
+		{"a range with none", start.Add(time.Minute), start.Add(4 * time.Minute), []string{}},
+		{"everything", start.Add(-time.Hour), end.Add(time.Hour), []string{"before the start", "at the start", "in the middle", "at the end", "during the last minute", "after the end"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getAnnotationNotes(t, m, tt.start, tt.end); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnnotationsOnTheGraph(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, inputLocation())
+	end := start.Add(10 * time.Minute)
+	seedReadings(t, m, start.UTC(), 11)
+	annotateAt(t, m, map[string]time.Time{
+		"Pruned the maple":    start,
+		"Moved sensor 2m W":   end,
+		"Before the range":    start.Add(-time.Minute),
+		"After the range":     end.Add(time.Minute),
+		"Cloud bank rolls in": start.Add(5 * time.Minute),
+	})
+
+	query := url.Values{"start": {timeToInputDate(start)}, "end": {timeToInputDate(end)}}
+	req := httptest.NewRequest(http.MethodGet, "/sunlightmeter/graph?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	m.ServeResultsGraph().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, %s", w.Code, w.Body.String())
+	}
+	for _, label := range []string{"Pruned the maple", "Moved sensor 2m W", "Cloud bank rolls in"} {
+		if !strings.Contains(w.Body.String(), label) {
+			t.Errorf("%q isn't marked on the graph", label)
+		}
+	}
+	for _, label := range []string{"Before the range", "After the range"} {
+		if strings.Contains(w.Body.String(), label) {
+			t.Errorf("%q is marked on the graph, but it's outside the range", label)
+		}
+	}
+}
+
+func TestMarkerAt(t *testing.T) {
+	timeValues := []string{"2024-06-21 16:00:00", "2024-06-21 16:05:00", "2024-06-21 16:10:00"}
+	tests := []struct {
+		name string
+		at   string
+		want string
+	}{
+		{"on the first reading", "2024-06-21 16:00:00", "2024-06-21 16:00:00"},
+		{"on the last reading", "2024-06-21 16:10:00", "2024-06-21 16:10:00"},
+		{"between readings", "2024-06-21 16:00:01", "2024-06-21 16:05:00"},
+		{"before the first reading", "2024-06-21 15:59:00", "2024-06-21 16:00:00"},
+		{"after the last reading", "2024-06-21 16:10:01", "2024-06-21 16:10:00"},
+	}
+	for _, tt := range tests {
+		marker, ok := markerAt(tt.at, "note", timeValues)
+		if !ok || marker.XAxis != tt.want || marker.Name != "note" {
+			t.Errorf("%s: got %+v, want a marker at %s", tt.name, marker, tt.want)
+		}
+	}
+	if _, ok := markerAt("2024-06-21 16:00:00", "note", nil); ok {
+		t.Error("got a marker on a graph without readings")
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+
+	w := postAnnotation(m, url.Values{"text": {"  Moved sensor 2m west "}, "temperature": {"21.5"}, "timestamp": {"2024-06-21T12:00"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d, %s", w.Code, w.Body.String())
+	}
+	var annotation Annotation
+	if err := json.Unmarshal(w.Body.Bytes(), &annotation); err != nil {
+		t.Fatal(err)
+	}
+	// Noon in Indianapolis is 16:00 UTC in the summer
+	if annotation.Note != "Moved sensor 2m west" || annotation.CreatedAt != "2024-06-21 16:00:00" || annotation.Label() != "Moved sensor 2m west (21.5°C)" {
+		t.Errorf("got %+v", annotation)
+	}
+
+	for _, form := range []url.Values{
+		{},
+		{"note": {"   "}},
+		{"note": {strings.Repeat("a", MAX_NOTE_LENGTH+1)}},
+		{"note": {"n"}, "temperature": {"NaN"}},
+		{"note": {"n"}, "timestamp": {"2024-06-21 12:00"}},
+	} {
+		if w := postAnnotation(m, form); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", form.Encode(), w.Code, http.StatusBadRequest)
+		}
+	}
+	if w := postAnnotation(m, url.Values{"note": {"n"}, "job_id": {"no-such-job"}}); w.Code != http.StatusNotFound {
+		t.Errorf("an unknown job: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// Deleting removes it once
+	r := chi.NewRouter()
+	r.Delete("/api/v1/annotations/{id}", m.DeleteAnnotation())
+	deleteAnnotation := func(id int64) int {
+		return serveAPIRequest(r, http.MethodDelete, fmt.Sprintf("/annotations/%d", id)).Code
+	}
+	if code := deleteAnnotation(annotation.ID); code != http.StatusOK {
+		t.Errorf("got %d deleting the annotation", code)
+	}
+	if code := deleteAnnotation(annotation.ID); code != http.StatusNotFound {
+		t.Errorf("got %d deleting it again, want %d", code, http.StatusNotFound)
+	}
+}
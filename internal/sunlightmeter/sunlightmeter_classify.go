@@ -0,0 +1,91 @@
+package sunlightmeter
+
+import (
+	"net/http"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	LIGHT_FULL_SUN      = "Full Sun"
+	LIGHT_PARTIAL_SUN   = "Partial Sun"
+	LIGHT_PARTIAL_SHADE = "Partial Shade"
+	LIGHT_SHADE         = "Shade"
+	LIGHT_NO_DATA       = "No Data in Range"
+)
+
+// The share of recorded, or daylight, time in full sun needed for each light condition.
+// Anything at or below PartialShade is Shade.
+type Bands struct {
+	FullSun      float64
+	PartialSun   float64
+	PartialShade float64
+}
+
+var DefaultBands = Bands{
+	FullSun:      0.5,
+	PartialSun:   0.25,
+	PartialShade: 0.1,
+}
+
+// Classify a spot by the share of the recorded, or daylight, hours it spent in full sun
+func ClassifyLight(fullSunHours, recordedHours float64, bands Bands) string {
+	if recordedHours <= 0 {
+		return LIGHT_NO_DATA
+	}
+	ratio := fullSunHours / recordedHours
+	if ratio > bands.FullSun {
+		return LIGHT_FULL_SUN
+	} else if ratio > bands.PartialSun {
+		return LIGHT_PARTIAL_SUN
+	} else if ratio > bands.PartialShade {
+		return LIGHT_PARTIAL_SHADE
+	}
+	return LIGHT_SHADE
+}
+
+// The hours the full sun is a share of: the daylight hours, when the location is set and the sun was up.
+// A job that runs through the night isn't counted as shadier for it.
+func (c Conditions) classifiedHours() float64 {
+	if c.DaylightHoursInRange > 0 {
+		return c.DaylightHoursInRange
+	}
+	return c.RecordedHoursInRange
+}
+
+// Serve the light condition for the date range, and the share of it spent in full sun
+func (m *SLMeter) Classify() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conditions, err := m.getHistoricalConditions(Conditions{}, startDate, endDate, scopeFromRequest(r))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var ratio float64
+		if hours := conditions.classifiedHours(); hours > 0 {
+			ratio = conditions.FullSunlightInRange / hours
+		}
+		serveJSON(w, http.StatusOK, struct {
+			Label         string  `json:"label"`
+			FullSunRatio  float64 `json:"fullSunRatio"`
+			FullSunHours  float64 `json:"fullSunHours"`
+			RecordedHours float64 `json:"recordedHours"`
+			DaylightHours float64 `json:"daylightHours,omitempty"`
+			DateRange     string  `json:"dateRange"`
+		}{
+			Label:         conditions.LightConditionInRange,
+			FullSunRatio:  ratio,
+			FullSunHours:  conditions.FullSunlightInRange,
+			RecordedHours: conditions.RecordedHoursInRange,
+			DaylightHours: conditions.DaylightHoursInRange,
+			DateRange:     conditions.DateRange,
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package sunlightmeter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/ztkent/sunlight-meter/internal/solar"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	CLEAR_SKY_SERIES      = "Clear Sky"
+	MAX_CLOUDINESS_POINTS = 10000
+	// Closer to the horizon the clear sky model is too rough, and a tree's shadow is most of the light
+	CLOUDINESS_MIN_ELEVATION = 5.0
+)
+
+var (
+	ErrLocationNotSet = errors.New("The location is not set, start with -latitude and -longitude")
+	ErrTooManyPoints  = fmt.Errorf("The range has more than %d points, pick a larger bucket", MAX_CLOUDINESS_POINTS)
+)
+
+// A reading, or a bucket of them, against the lux a clear sky would give at the same time
+type CloudinessPoint struct {
+	Time        string  `json:"time"`
+	Lux         float64 `json:"lux"`
+	ClearSkyLux float64 `json:"clearSkyLux"`
+	Elevation   float64 `json:"elevation"` // Of the sun, in degrees, at the middle of a bucket
+	// Measured over modeled lux. Near 1 is a clear, unshaded sky, lower is cloud or shade.
+	// Null while the sun is below CLOUDINESS_MIN_ELEVATION.
+	Ratio   *float64 `json:"ratio"`
+	Samples int      `json:"samples"`
+}
+
+type Cloudiness struct {
+	DateRange string         `json:"dateRange"`
+	Location  solar.Location `json:"location"`
+	Bucket    string         `json:"bucket,omitempty"`
+	// The daytime lux over the daytime clear sky lux, across the whole range
+	AverageRatio *float64          `json:"averageRatio"`
+	Points       []CloudinessPoint `json:"points"`
+}
+
+// Serve each reading's lux against a clear sky model for the configured location, or the buckets' with bucket=15m.
+// Shade from a fixed object dips at the same sun elevation every day, while clouds come and go.
+func (m *SLMeter) ServeCloudiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.Location == nil {
+			ServeError(w, r, tools.ERR_LOCATION_NOT_SET, ErrLocationNotSet.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bucket, err := parseCloudinessBucket(r.FormValue("bucket"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cloudiness, err := m.getCloudiness(startDate, endDate, scopeFromRequest(r), bucket)
+		if errors.Is(err, ErrTooManyPoints) {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, cloudiness)
+	}
+}
+
+// A bucket of 0 keeps every reading
+func parseCloudinessBucket(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	bucket, err := time.ParseDuration(value)
+	if err != nil || bucket < time.Minute {
+		return 0, fmt.Errorf("Invalid bucket, expected a duration of at least 1m, like 15m")
+	}
+	return bucket, nil
+}
+
+func (m *SLMeter) getCloudiness(startDate string, endDate string, scope readingScope, bucket time.Duration) (Cloudiness, error) {
+	location := *m.Location
+	cloudiness := Cloudiness{
+		DateRange: fmt.Sprintf("%s - %s UTC", startDate, endDate),
+		Location:  location,
+		Points:    []CloudinessPoint{},
+	}
+	if bucket > 0 {
+		cloudiness.Bucket = bucket.String()
+	}
+
+	filter, filterArgs := m.readingFilter(scope)
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT lux, created_at
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    ORDER BY created_at`, append([]interface{}{startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return cloudiness, err
+	}
+	defer rows.Close()
+
+	// Only the daytime readings count toward a ratio
+	var point *CloudinessPoint
+	var pointStart time.Time
+	var dayLux, dayClearSky, totalDayLux, totalDayClearSky float64
+	finish := func() {
+		if point == nil {
+			return
+		}
+		point.Lux /= float64(point.Samples)
+		point.ClearSkyLux /= float64(point.Samples)
+		if bucket > 0 {
+			point.Elevation = solar.SunPosition(pointStart.Add(bucket/2), location).Elevation
+		}
+		if dayClearSky > 0 {
+			ratio := dayLux / dayClearSky
+			point.Ratio = &ratio
+		}
+		cloudiness.Points = append(cloudiness.Points, *point)
+		totalDayLux, totalDayClearSky = totalDayLux+dayLux, totalDayClearSky+dayClearSky
+		point, dayLux, dayClearSky = nil, 0, 0
+	}
+	for rows.Next() {
+		var lux string
+		var createdAt time.Time
+		if err := rows.Scan(&lux, &createdAt); err != nil {
+			return cloudiness, err
+		}
+		luxFloat, err := strconv.ParseFloat(lux, 64)
+		if err != nil {
+			return cloudiness, err
+		}
+		createdAt = createdAt.UTC()
+
+		start := createdAt
+		if bucket > 0 {
+			start = createdAt.Truncate(bucket)
+		}
+		if point == nil || !start.Equal(pointStart) {
+			finish()
+			if len(cloudiness.Points) == MAX_CLOUDINESS_POINTS {
+				return cloudiness, ErrTooManyPoints
+			}
+			point, pointStart = &CloudinessPoint{Time: start.Format("2006-01-02 15:04:05")}, start
+		}
+		elevation := solar.SunPosition(createdAt, location).Elevation
+		clearSky := solar.ClearSkyLuxAt(elevation, createdAt)
+		point.Lux += luxFloat
+		point.ClearSkyLux += clearSky
+		point.Elevation = elevation
+		point.Samples++
+		if elevation >= CLOUDINESS_MIN_ELEVATION {
+			dayLux, dayClearSky = dayLux+luxFloat, dayClearSky+clearSky
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return cloudiness, err
+	}
+	finish()
+	if totalDayClearSky > 0 {
+		ratio := totalDayLux / totalDayClearSky
+		cloudiness.AverageRatio = &ratio
+	}
+	return cloudiness, nil
+}
+
+// The clear sky model's lux at each of the graph's times, in the units, and its largest value
+func clearSkyData(times []string, location solar.Location, units string) ([]opts.LineData, float64) {
+	data := make([]opts.LineData, len(times))
+	peak := 0.0
+	for i, value := range times {
+		at, err := parseGraphTime(value)
+		if err != nil {
+			data[i] = opts.LineData{Value: "-"}
+			continue
+		}
+		lux := convertLux(solar.ClearSkyLux(at, location), units)
+		peak = max(peak, lux)
+		data[i] = opts.LineData{Value: math.Round(lux*10) / 10}
+	}
+	return data, peak
+}
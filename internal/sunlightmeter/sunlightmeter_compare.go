@@ -0,0 +1,140 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+// The colors of the two ranges on a comparison, distinct on both the dark and light themes
+var compareColors = [2]string{"#fc97af", "#3a8fb7"}
+
+// The second date range picked with compare_start and compare_end, in the DB's format.
+// It takes the same device and sensor as the first range, but not its job, since a job only covers its own span.
+func compareRangeFromRequest(r *http.Request) (string, string, bool, error) {
+	compareStart, compareEnd := r.FormValue("compare_start"), r.FormValue("compare_end")
+	if compareStart == "" && compareEnd == "" {
+		return "", "", false, nil
+	} else if compareStart == "" || compareEnd == "" {
+		return "", "", false, fmt.Errorf("Both compare_start and compare_end are required")
+	}
+	startDate, err := inputDateToDB(compareStart)
+	if err != nil {
+		return "", "", false, fmt.Errorf("Invalid compare_start date, expected YYYY-MM-DDTHH:MM")
+	}
+	endDate, err := inputDateToDB(compareEnd)
+	if err != nil {
+		return "", "", false, fmt.Errorf("Invalid compare_end date, expected YYYY-MM-DDTHH:MM")
+	}
+	if endDate < startDate {
+		return "", "", false, fmt.Errorf("The compare_end date is before the compare_start date")
+	}
+	return startDate, endDate, true, nil
+}
+
+// Chart the readings of both ranges over the hours since each one started, so they line up at the start.
+// A shorter range, or one that runs out of readings, just ends early.
+func (m *SLMeter) renderComparison(w http.ResponseWriter, ranges [2][2]string, scope readingScope, theme string, units string) error {
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithInitializationOpts(graphInitialization(theme)),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "Hours",
+			Type: "value",
+			Min:  0,
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name: unitsLabel(units),
+			Min:  "0",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:        true,
+			Trigger:     "axis",
+			Formatter:   graphTooltipFormatter(),
+			AxisPointer: &opts.AxisPointer{Type: "line", Snap: true},
+		}),
+		charts.WithDataZoomOpts(graphDataZoom()...),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+
+	for i, dates := range ranges {
+		start, _, err := startAndEndDateToTime(dates[0], dates[1])
+		if err != nil {
+			return err
+		}
+		series, err := m.getGraphSeries(dates[0], dates[1], scope)
+		if err != nil {
+			return err
+		}
+		series = series.inUnits(units)
+		line.AddSeries(compareRangeLabel(dates[0], dates[1]), elapsedLuxData(series, start),
+			charts.WithLineChartOpts(opts.LineChart{Color: compareColors[i]}),
+		)
+		// Only the first range is scoped to the job
+		scope.JobID = ""
+	}
+
+	page := m.echartsPage(line)
+	w.Header().Set("Content-Type", "text/html")
+	return page.Render(w)
+}
+
+// The series' readings as points of hours since start and lux
+func elapsedLuxData(series graphSeries, start time.Time) []opts.LineData {
+	data := make([]opts.LineData, 0, len(series.lux))
+	for i, lux := range series.lux {
+		at, err := parseGraphTime(series.times[i])
+		if err != nil {
+			continue
+		}
+		hours := math.Round(at.Sub(start).Hours()*1000) / 1000
+		data = append(data, opts.LineData{Value: []interface{}{hours, lux.Value}})
+	}
+	return data
+}
+
+// A short label for a range in the legend, in the dashboard's timezone
+func compareRangeLabel(startDate string, endDate string) string {
+	start, end, err := startAndEndDateToTime(startDate, endDate)
+	if err != nil {
+		return fmt.Sprintf("%s - %s", startDate, endDate)
+	}
+	loc := inputLocation()
+	return fmt.Sprintf("%s - %s", start.In(loc).Format("Jan 2 15:04"), end.In(loc).Format("Jan 2 15:04"))
+}
+
+// The summary stats of a range, for the results tab
+type rangeStats struct {
+	Label          string `json:"label"`
+	RecordedHours  string `json:"recordedHours"`
+	FullSunlight   string `json:"fullSunlight"`
+	LightCondition string `json:"lightCondition"`
+	AverageLux     string `json:"averageLux"`
+	MaxLux         string `json:"maxLux"`
+}
+
+func (m *SLMeter) getRangeStats(startDate string, endDate string, scope readingScope, units string) (rangeStats, error) {
+	conditions, err := m.getHistoricalConditions(Conditions{}, startDate, endDate, scope)
+	if err != nil {
+		return rangeStats{}, err
+	}
+	maxLux, _, err := m.getPeak(startDate, endDate, scope)
+	if err != nil {
+		return rangeStats{}, err
+	}
+	conditions = conditions.inUnits(units)
+	return rangeStats{
+		Label:          compareRangeLabel(startDate, endDate),
+		RecordedHours:  fmt.Sprintf("%.2f", conditions.RecordedHoursInRange),
+		FullSunlight:   fmt.Sprintf("%.2f", conditions.FullSunlightInRange),
+		LightCondition: conditions.LightConditionInRange,
+		AverageLux:     fmt.Sprintf("%.1f", conditions.AverageLuxInRange),
+		MaxLux:         fmt.Sprintf("%.1f", convertLux(maxLux, units)),
+	}, nil
+}
@@ -0,0 +1,92 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/google/uuid"
+)
+
+// Draw a bar for each summarized day in the range, with its hours of full sun and its DLI on a second axis.
+// Days are the daily summaries, so they're in the summary timezone, and today isn't included until it's over.
+// Clicking a bar charts every reading of that day.
+func (m *SLMeter) renderDailyChart(w http.ResponseWriter, startDate string, endDate string, device string, theme string) error {
+	days, err := m.getDaySummaries(startDate, endDate, device)
+	if err != nil {
+		return err
+	}
+
+	// Each device has its own summary, the best of them is charted
+	var dates []string
+	var sunHours, dli []opts.BarData
+	for _, day := range days {
+		if n := len(dates); n > 0 && dates[n-1] == day.Date {
+			sunHours[n-1].Value = max(sunHours[n-1].Value.(float64), day.SunHours)
+			dli[n-1].Value = max(dli[n-1].Value.(float64), day.DLI)
+			continue
+		}
+		dates = append(dates, day.Date)
+		sunHours = append(sunHours, opts.BarData{Value: day.SunHours})
+		dli = append(dli, opts.BarData{Value: day.DLI})
+	}
+
+	// The date range of each day, in the format of the dashboard's inputs
+	loc := m.summaryLocation()
+	ranges := map[string][2]string{}
+	for _, date := range dates {
+		start, err := time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			continue
+		}
+		ranges[date] = [2]string{timeToInputDate(start), timeToInputDate(start.AddDate(0, 0, 1))}
+	}
+	rangesJSON, err := json.Marshal(ranges)
+	if err != nil {
+		return err
+	}
+
+	init := graphInitialization(theme)
+	// Every render gets its own ID, the chart's variable is declared again each time the graph is swapped in
+	init.ChartID = "daily_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	bar := charts.NewBar()
+	bar.SetGlobalOptions(
+		charts.WithInitializationOpts(init),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: fmt.Sprintf("Date (%s)", loc.String()),
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name: "Full Sun Hrs",
+			Min:  "0",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show:    true,
+			Trigger: "axis",
+		}),
+		charts.WithLegendOpts(opts.Legend{
+			Show: true,
+		}),
+	)
+	bar.ExtendYAxis(opts.YAxis{Name: "DLI (mol/m²/day)", Type: "value", Min: "0"})
+	bar.SetXAxis(dates).
+		AddSeries("Full Sun Hours", sunHours).
+		AddSeries("DLI", dli, charts.WithBarChartOpts(opts.BarChart{YAxisIndex: 1}))
+
+	// Load the day's readings into the line chart, through the settings form so the rest of the settings stick
+	bar.AddJSFuncs(fmt.Sprintf(`goecharts_%s.on('click', function (params) {
+        var range = %s[params.name];
+        if (!range) return;
+        document.getElementById('start').value = range[0];
+        document.getElementById('end').value = range[1];
+        document.getElementById('chart').value = 'line';
+        htmx.trigger('#graphForm', 'submit');
+    });`, init.ChartID, rangesJSON))
+
+	page := m.echartsPage(bar)
+	w.Header().Set("Content-Type", "text/html")
+	return page.Render(w)
+}
@@ -2,46 +2,67 @@ package sunlightmeter
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"math"
 	"net/http"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-echarts/go-echarts/v2/charts"
-	"github.com/go-echarts/go-echarts/v2/components"
 	"github.com/go-echarts/go-echarts/v2/opts"
 	"github.com/go-echarts/go-echarts/v2/types"
+	"github.com/ztkent/sunlight-meter/internal/tools"
 )
 
 // Serve the sqlite db for download
 func (m *SLMeter) ServeResultsDB() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", "sunlightmeter.db"))
-		w.Header().Set("Content-Type", "application/octet-stream")
-		http.ServeFile(w, r, DB_PATH)
+		// With a date range, only a copy of the readings in it is served
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		} else if hasDateRange(r) {
+			m.serveFilteredDB(w, r, startDate, endDate)
+			return
+		}
+
+		// The live file can be mid-write, so a snapshot of it is served
+		m.serveSnapshot(w, r, filepath.Base(m.DBPath))
 	}
 }
 
 // Serve the homepage
 func (m *SLMeter) ServeDashboard() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl, err := getTemplate("dashboard.gohtml")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
-		fileContent, err := parseHTMLFile("html/dashboard.html")
+		err = tmpl.Execute(w, struct {
+			StaticVersion string
+			ECharts       []string
+		}{
+			StaticVersion: StaticVersion(),
+			ECharts:       []string{m.echartsScript("echarts.min.js"), m.echartsScript("themes/chalk.js")},
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		w.Write(fileContent)
 	}
 }
 
-func parseHTMLFile(path string) ([]byte, error) {
-	content, err := templateFiles.ReadFile(path)
+func parseHTMLFile(name string) ([]byte, error) {
+	content, err := readHTMLFile(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read embedded html file: %v", err)
+		return nil, fmt.Errorf("failed to read html file: %v", err)
 	}
 	return content, nil
 }
@@ -49,12 +70,19 @@ func parseHTMLFile(path string) ([]byte, error) {
 // Serve the controls for the sensor, start/stop/export/current-conditions/signal-strength
 func (m *SLMeter) ServeSunlightControls() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl, err := parseTemplateFile("html/controls.gohtml")
+		tmpl, err := getTemplate("controls.gohtml")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = tmpl.Execute(w, nil)
+		// The sensor picker is only shown when there's more than one
+		var sensors []string
+		if len(m.Sensors) > 0 {
+			for _, sensor := range append([]*SLMeter{m}, m.Sensors...) {
+				sensors = append(sensors, sensor.SensorID)
+			}
+		}
+		err = tmpl.Execute(w, sensors)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -62,25 +90,41 @@ func (m *SLMeter) ServeSunlightControls() http.HandlerFunc {
 	}
 }
 
-// Status of the sensor
+// Status of the sensor, as badges or as JSON
 func (m *SLMeter) ServeSensorStatus() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl, err := parseTemplateFile("html/status.gohtml")
+		tmpl, err := getTemplate("status.gohtml")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		type Status struct {
-			Connected bool
-			Enabled   bool
+			Connected   bool   `json:"connected"`
+			Enabled     bool   `json:"enabled"`
+			Paused      bool   `json:"paused"`
+			JobID       string `json:"jobID,omitempty"`
+			StorageFull bool   `json:"storageFull"`
+			Stalled     bool   `json:"stalled"`
+			RecorderStats
+			Relay *RelayStatus `json:"relay,omitempty"`
+		}
+		status := Status{StorageFull: m.StorageFull(), Stalled: m.WatchdogStats().Stalled, RecorderStats: m.RecorderStats()}
+		if relay := m.relay(); relay != nil {
+			relayStatus := relay.Status()
+			status.Relay = &relayStatus
 		}
-		status := Status{}
 		if m.TSL2591 == nil {
 			status.Connected = false
 		} else {
 			status.Connected = true
-			status.Enabled = m.Enabled
+			status.JobID = m.JobID()
+			status.Enabled = status.JobID != ""
+			status.Paused = m.Paused()
+		}
+		if tools.WantsJSON(r) {
+			serveJSON(w, http.StatusOK, status)
+			return
 		}
 
 		err = tmpl.Execute(w, status)
@@ -91,79 +135,127 @@ func (m *SLMeter) ServeSensorStatus() http.HandlerFunc {
 	}
 }
 
-// Serve the results graph
+// Serve the results graph.
+// A job picked with job_id or job_name is charted on its own, over its whole span unless a date range is set.
+// With compare_start and compare_end, the line chart overlays the readings of both ranges from their starts.
+// Ranges longer than SUMMARY_GRAPH_AFTER_DAYS chart the daily summaries instead of every reading.
 func (m *SLMeter) ServeResultsGraph() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		startDate, endDate := parseStartAndEndDate(r)
-		rows, err := m.ResultsDB.Query("SELECT lux, created_at FROM sunlight WHERE created_at BETWEEN ? AND ? ORDER BY created_at", startDate, endDate)
+		theme, err := parseGraphTheme(r.FormValue("theme"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		chart, err := parseGraphChart(r.FormValue("chart"))
 		if err != nil {
-			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		compareStart, compareEnd, compare, err := compareRangeFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateDateRange(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		startDate, endDate, scope, err := m.rangeFromRequest(r)
+		if errors.Is(err, ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
-
-		var luxValues []opts.LineData
-		var timeValues []string
-		var maxLux int
-		for rows.Next() {
-			var lux string
-			var createdAt time.Time
-			if err := rows.Scan(&lux, &createdAt); err != nil {
-				log.Println(err)
+		if compare && chart == GRAPH_CHART_LINE {
+			if err := m.renderComparison(w, [2][2]string{{startDate, endDate}, {compareStart, compareEnd}}, scope, theme, units); err != nil {
+				tools.RequestLog(r).Error(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-
-			luxFloat, err := strconv.ParseFloat(lux, 64)
-			if err != nil {
-				log.Println(err)
+			writeResultsTrigger(w)
+			return
+		}
+		if chart == GRAPH_CHART_HEATMAP {
+			if err := m.renderHeatmap(w, startDate, endDate, scope, theme, units); err != nil {
+				tools.RequestLog(r).Error(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			timeString := createdAt.Format("2006-01-02 15:04:05")
-			if luxFloat > float64(maxLux) {
-				maxLux = int(math.Ceil(luxFloat/5000) * 5000)
+			writeResultsTrigger(w)
+			return
+		}
+		if chart == GRAPH_CHART_DAILY {
+			if err := m.renderDailyChart(w, startDate, endDate, scope.Device, theme); err != nil {
+				tools.RequestLog(r).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
+			writeResultsTrigger(w)
+			return
+		}
 
-			luxValues = append(luxValues, opts.LineData{Value: luxFloat})
-			timeValues = append(timeValues, timeString)
+		series, summarize, err := m.getResultsGraphSeries(startDate, endDate, scope)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		series = series.inUnits(units)
+		luxValues, tempValues, timeValues, maxLux, hasTemp := series.lux, series.temp, series.times, series.maxLux, series.hasTemp
 
-		line := charts.NewLine()
-		levels := map[int]string{
-			500:   "DarkGrey",
-			1000:  "WhiteSmoke",
-			10000: "SkyBlue",
-			25000: "Yellow",
+		ymax, err := parseGraphYMax(r.FormValue("ymax"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		titles := map[int]string{
-			500:   "Shade",
-			1000:  "Partial Shade",
-			10000: "Partial Sun",
-			25000: "Full Sun",
+
+		// Chart the clear sky model alongside the readings, when asked to, with room on the axis for it
+		showClearSky, _ := strconv.ParseBool(r.FormValue("show_clear_sky"))
+		showClearSky = showClearSky && !summarize
+		var clearSkyValues []opts.LineData
+		if showClearSky {
+			if m.Location == nil {
+				http.Error(w, ErrLocationNotSet.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			var peak float64
+			clearSkyValues, peak = clearSkyData(timeValues, *m.Location, units)
+			maxLux = max(maxLux, roundAxisMax(peak))
+		}
+		yAxisMax := fmt.Sprintf("%d", maxLux)
+		if ymax > 0 {
+			maxLux, yAxisMax = int(math.Ceil(ymax)), strconv.FormatFloat(ymax, 'f', -1, 64)
 		}
 
-		for level, color := range levels {
+		init := graphInitialization(theme)
+		init.ChartID = liveGraphChartID()
+		line := charts.NewLine()
+		for _, level := range graphLevels {
 			line.AddSeries(
-				fmt.Sprintf("%s", titles[level]),
-				func(level int, length int) []opts.LineData {
+				level.Title,
+				func(lux int, length int) []opts.LineData {
 					data := make([]opts.LineData, length)
 					for i := range data {
-						data[i] = opts.LineData{Value: level}
+						data[i] = opts.LineData{Value: convertLux(float64(lux), units)}
 					}
 					return data
-				}(level, len(timeValues)),
+				}(level.Lux, len(timeValues)),
 				charts.WithLineChartOpts(opts.LineChart{
-					Color: color,
+					Color: level.color(theme),
 				}),
 			)
 		}
 
 		line.SetGlobalOptions(
-			charts.WithInitializationOpts(opts.Initialization{
-				Theme: types.ThemeChalk,
-			}),
+			charts.WithInitializationOpts(init),
 			charts.WithTitleOpts(opts.Title{
 				// Title: "Lux over time",
 			}),
@@ -171,16 +263,18 @@ func (m *SLMeter) ServeResultsGraph() http.HandlerFunc {
 				Name: "Time",
 			}),
 			charts.WithYAxisOpts(opts.YAxis{
-				Name: "Lux",
+				Name: unitsLabel(units),
 				Min:  "0",
-				Max:  fmt.Sprintf("%d", maxLux),
+				Max:  yAxisMax,
 			}),
 			charts.WithTooltipOpts(opts.Tooltip{
-				Show:      true,
-				Trigger:   "axis",
-				TriggerOn: "mousemove",
-				Formatter: "{a4}: {c4}<br> Time: {b0}",
+				Show:        true,
+				Trigger:     "axis",
+				TriggerOn:   "mousemove",
+				Formatter:   graphTooltipFormatter(),
+				AxisPointer: &opts.AxisPointer{Type: "line", Snap: true},
 			}),
+			charts.WithDataZoomOpts(graphDataZoom()...),
 			charts.WithToolboxOpts(opts.Toolbox{
 				Show: true,
 				Feature: &opts.ToolBoxFeature{
@@ -192,65 +286,520 @@ func (m *SLMeter) ServeResultsGraph() http.HandlerFunc {
 				},
 			}),
 		)
-		line.SetXAxis(timeValues).AddSeries("Lux", luxValues)
+		// Mark any annotations in the range on the lux series
+		annotations, err := m.getAnnotations(startDate, endDate, scope.JobID)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		markers := annotationMarkers(annotations, timeValues)
+
+		// Mark the gain changes too, when asked to
+		if showGain, _ := strconv.ParseBool(r.FormValue("show_gain_changes")); showGain && !summarize {
+			events, err := m.getEvents(startDate, endDate, SENSOR_EVENT_GAIN_CHANGE, scope)
+			if err != nil {
+				tools.RequestLog(r).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			markers = append(markers, gainChangeMarkers(events, timeValues)...)
+		}
+		// And each sunrise and sunset, when asked to
+		if showSun, _ := strconv.ParseBool(r.FormValue("show_sun")); showSun && !summarize {
+			if m.Location == nil {
+				http.Error(w, ErrLocationNotSet.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			markers = append(markers, m.sunMarkers(startDate, endDate, timeValues)...)
+		}
+		seriesOpts := []charts.SeriesOpts{
+			charts.WithMarkLineNameXAxisItemOpts(markers...),
+			charts.WithMarkLineStyleOpts(opts.MarkLineStyle{
+				Symbol: []string{"none", "none"},
+				Label:  &opts.Label{Show: true, Formatter: "{b}"},
+			}),
+		}
+
+		// Shade the stretches without readings, when asked to
+		if showGaps, _ := strconv.ParseBool(r.FormValue("show_gaps")); showGaps && !summarize && len(timeValues) > 0 {
+			var gapAreas []opts.MarkAreaNameCoordItem
+			first, last := timeValues[0], timeValues[len(timeValues)-1]
+			_, _, err := m.findGaps(startDate, endDate, scope, DEFAULT_MIN_GAP, func(gap Gap) {
+				// The x axis only spans the readings, so gaps at either end of the range can't be drawn
+				if gap.Start >= first && gap.End <= last {
+					gapAreas = append(gapAreas, gapArea(gap, maxLux))
+				}
+			})
+			if err != nil {
+				tools.RequestLog(r).Error(err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			seriesOpts = append(seriesOpts,
+				charts.WithMarkAreaNameCoordItemOpts(gapAreas...),
+				charts.WithMarkAreaStyleOpts(opts.MarkAreaStyle{
+					ItemStyle: &opts.ItemStyle{Color: "rgba(255, 99, 71, 0.2)"},
+				}),
+			)
+		}
+		// Readings above a pinned axis are clipped, the highest is marked at the top of the axis
+		if peak, ok := clippedPeak(luxValues, ymax); ok {
+			seriesOpts = append(seriesOpts, charts.WithMarkPointNameCoordItemOpts(opts.MarkPointNameCoordItem{
+				Name:       "Above the axis",
+				Coordinate: []interface{}{timeValues[peak], ymax},
+				Value:      strconv.FormatFloat(luxValues[peak].Value.(float64), 'f', 0, 64),
+				Symbol:     "pin",
+			}))
+		}
+		line.SetXAxis(timeValues).AddSeries(series.name, luxValues, seriesOpts...)
+
+		// Chart the CPU temperature on its own axis, when it was recorded
+		if hasTemp {
+			line.ExtendYAxis(opts.YAxis{Name: "CPU °C", Type: "value"})
+			line.AddSeries("CPU Temp", tempValues, charts.WithLineChartOpts(opts.LineChart{YAxisIndex: 1}))
+		}
+
+		if showClearSky {
+			line.AddSeries(CLEAR_SKY_SERIES, clearSkyValues,
+				charts.WithLineChartOpts(opts.LineChart{Color: "#e67e22"}),
+				charts.WithLineStyleOpts(opts.LineStyle{Type: "dashed"}),
+			)
+		}
+
+		// Color the readings by their light source, when asked to
+		colorSources, _ := strconv.ParseBool(r.FormValue("color_light_source"))
+		colorSources = colorSources && !summarize
+		if colorSources {
+			line.Overlap(lightSourceScatter(series))
+		}
+
+		// Keep appending readings while the range runs up to now
+		if _, end, err := startAndEndDateToTime(startDate, endDate); err == nil && !summarize && end.After(time.Now().UTC().Add(-LIVE_GRAPH_WITHIN)) {
+			since := endDate
+			if len(timeValues) > 0 {
+				since = timeValues[len(timeValues)-1]
+			}
+			// The series after the lux series, in the order they were added
+			var following []string
+			if hasTemp {
+				following = append(following, LIVE_SERIES_TEMP)
+			}
+			if showClearSky {
+				following = append(following, LIVE_SERIES_CLEAR_SKY)
+			}
+			if colorSources {
+				following = append(following, lightSourceClasses...)
+			}
+			line.AddJSFuncs(liveGraphScript(init.ChartID, since, scope, units, following, ymax > 0))
+		}
 
 		// Create a new page and add the line chart to it
-		page := components.NewPage()
-		page.AddCharts(line)
+		page := m.echartsPage(line)
 		w.Header().Set("Content-Type", "text/html")
 		page.Render(w)
+		writeResultsTrigger(w)
+	}
+}
 
-		// Trigger an update for the results tab
-		w.Write([]byte(`<div id='resultUpdateTrigger' hx-post='/sunlightmeter/results' hx-target='#resultsContent' hx-trigger='load'></div>`))
-		w.Write([]byte(`<script>document.title = "Sunlight Meter";</script>`))
+// Trigger an update for the results tab, after the graph
+func writeResultsTrigger(w http.ResponseWriter) {
+	w.Write([]byte(`<div id='resultUpdateTrigger' hx-post='/sunlightmeter/results' hx-target='#resultsContent' hx-trigger='load'></div>`))
+	w.Write([]byte(`<script>document.title = "Sunlight Meter";</script>`))
+}
+
+// The reference bands drawn across the results graph
+type graphLevel struct {
+	Lux   int
+	Title string
+	Color string
+}
+
+var graphLevels = []graphLevel{
+	{500, "Shade", "DarkGrey"},
+	{1000, "Partial Shade", "WhiteSmoke"},
+	{10000, "Partial Sun", "SkyBlue"},
+	{25000, "Full Sun", "Yellow"},
+}
+
+func (l graphLevel) color(theme string) string {
+	if theme == GRAPH_THEME_LIGHT && l.Color == "WhiteSmoke" {
+		// WhiteSmoke disappears on a white background
+		return "Silver"
 	}
+	return l.Color
+}
+
+// The series for the results graph, and whether it's the daily summaries.
+// Days are summarized across every job and sensor, so ranges picked by either always chart every reading.
+func (m *SLMeter) getResultsGraphSeries(startDate string, endDate string, scope readingScope) (graphSeries, bool, error) {
+	summarize := false
+	if start, end, err := startAndEndDateToTime(startDate, endDate); err == nil && scope.JobID == "" && scope.Sensor == "" {
+		summarize = end.Sub(start) > SUMMARY_GRAPH_AFTER_DAYS*24*time.Hour
+	}
+	if summarize {
+		series, err := m.getSummaryGraphSeries(startDate, endDate, scope.Device)
+		return series, true, err
+	}
+	series, err := m.getGraphSeries(startDate, endDate, scope)
+	return series, false, err
+}
+
+const GRAPH_THEME_LIGHT = "light"
+
+// The themes the graph can be drawn with, chalk by default. Light and dark are built into ECharts.
+var graphThemes = map[string]string{
+	types.ThemeChalk:         types.ThemeChalk,
+	GRAPH_THEME_LIGHT:        "white",
+	"dark":                   "dark",
+	types.ThemeEssos:         types.ThemeEssos,
+	types.ThemeInfographic:   types.ThemeInfographic,
+	types.ThemeMacarons:      types.ThemeMacarons,
+	types.ThemePurplePassion: types.ThemePurplePassion,
+	types.ThemeRoma:          types.ThemeRoma,
+	types.ThemeRomantic:      types.ThemeRomantic,
+	types.ThemeShine:         types.ThemeShine,
+	types.ThemeVintage:       types.ThemeVintage,
+	types.ThemeWalden:        types.ThemeWalden,
+	types.ThemeWesteros:      types.ThemeWesteros,
+	types.ThemeWonderland:    types.ThemeWonderland,
+}
+
+func parseGraphTheme(value string) (string, error) {
+	if value == "" {
+		return types.ThemeChalk, nil
+	} else if _, ok := graphThemes[value]; !ok {
+		names := make([]string, 0, len(graphThemes))
+		for name := range graphThemes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("Invalid theme, expected one of %s", strings.Join(names, ", "))
+	}
+	return value, nil
+}
+
+// The y axis max picked with ymax, in the graph's units. 0 keeps the axis scaled to the readings.
+func parseGraphYMax(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ymax, err := strconv.ParseFloat(value, 64)
+	if err != nil || ymax <= 0 || math.IsInf(ymax, 1) {
+		return 0, fmt.Errorf("Invalid ymax, expected a number above 0")
+	}
+	return ymax, nil
+}
+
+// The index of the highest value above ymax, if any are
+func clippedPeak(values []opts.LineData, ymax float64) (int, bool) {
+	peak, found := 0, false
+	if ymax <= 0 {
+		return peak, found
+	}
+	for i, data := range values {
+		if value, ok := data.Value.(float64); ok && value > ymax && (!found || value > values[peak].Value.(float64)) {
+			peak, found = i, true
+		}
+	}
+	return peak, found
+}
+
+// Zoom the x axis with the slider under the graph, or by scrolling and dragging on it.
+// Save as Image exports the zoomed view, since it's what's on the canvas.
+func graphDataZoom() []opts.DataZoom {
+	return []opts.DataZoom{
+		{Type: "inside", XAxisIndex: 0},
+		{Type: "slider", XAxisIndex: 0},
+	}
+}
+
+// List the time, then the name and value of each series under the pointer, leaving out the reference bands.
+// Series are found by name, so it doesn't matter how many are charted.
+func graphTooltipFormatter() string {
+	// The function is embedded in the options' JSON, so it can't have double quotes
+	bands := make([]string, len(graphLevels))
+	for i, level := range graphLevels {
+		bands[i] = fmt.Sprintf("'%s'", level.Title)
+	}
+	return opts.FuncOpts(fmt.Sprintf(`function (params) {
+        var bands = [%s];
+        var lines = [params[0].axisValueLabel];
+        params.forEach(function (param) {
+            var value = Array.isArray(param.value) ? param.value[1] : param.value;
+            if (bands.indexOf(param.seriesName) >= 0 || value === '-' || value === undefined || value === null) {
+                return;
+            }
+            lines.push(param.marker + param.seriesName + ': ' + value);
+        });
+        return lines.join('<br>');
+    }`, strings.Join(bands, ", ")))
+}
+
+// The light theme has no background of its own, so it's given a white one
+func graphInitialization(theme string) opts.Initialization {
+	init := opts.Initialization{Theme: graphThemes[theme]}
+	if theme == GRAPH_THEME_LIGHT {
+		init.BackgroundColor = "#ffffff"
+	}
+	return init
+}
+
+// A shaded area over a gap on the results graph
+func gapArea(gap Gap, maxLux int) opts.MarkAreaNameCoordItem {
+	return opts.MarkAreaNameCoordItem{
+		Name:        fmt.Sprintf("Gap: %s", gap.Duration),
+		Coordinate0: []interface{}{gap.Start, 0},
+		Coordinate1: []interface{}{gap.End, maxLux},
+	}
+}
+
+// The lux and CPU temperature series charted on the results graph
+type graphSeries struct {
+	name    string
+	lux     []opts.LineData
+	temp    []opts.LineData
+	times   []string
+	sources []string // The light source of each reading, "" when it wasn't classified
+	maxLux  int
+	hasTemp bool
+}
+
+// Every reading in the date range and scope
+func (m *SLMeter) getGraphSeries(startDate string, endDate string, scope readingScope) (graphSeries, error) {
+	series := graphSeries{name: "Lux"}
+	filter, filterArgs := m.readingFilter(scope)
+	rows, err := tools.QueryRetry(m.ResultsDB, "SELECT lux, cpu_temp, COALESCE(light_source, ''), created_at FROM sunlight WHERE created_at BETWEEN ? AND ?"+filter+" ORDER BY created_at",
+		append([]interface{}{startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return series, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lux string
+		var cpuTemp sql.NullFloat64
+		var source string
+		var createdAt time.Time
+		if err := rows.Scan(&lux, &cpuTemp, &source, &createdAt); err != nil {
+			return series, err
+		}
+
+		luxFloat, err := strconv.ParseFloat(lux, 64)
+		if err != nil {
+			return series, err
+		}
+		series.addLux(luxFloat, createdAt.Format("2006-01-02 15:04:05"))
+		series.sources = append(series.sources, source)
+
+		// Samples without a temperature are left as gaps
+		if cpuTemp.Valid {
+			series.hasTemp = true
+			series.temp = append(series.temp, opts.LineData{Value: cpuTemp.Float64})
+		} else {
+			series.temp = append(series.temp, opts.LineData{Value: "-"})
+		}
+	}
+	return series, rows.Err()
+}
+
+// The max lux of each summarized day in the date range, across devices unless one is picked
+func (m *SLMeter) getSummaryGraphSeries(startDate string, endDate string, device string) (graphSeries, error) {
+	series := graphSeries{name: "Daily Max Lux"}
+	days, err := m.getDaySummaries(startDate, endDate, device)
+	if err != nil {
+		return series, err
+	}
+	for _, day := range days {
+		// Days are ordered by date, then device
+		if n := len(series.times); n > 0 && series.times[n-1] == day.Date {
+			if day.MaxLux > series.lux[n-1].Value.(float64) {
+				series.lux[n-1] = opts.LineData{Value: day.MaxLux}
+				series.maxLux = max(series.maxLux, int(math.Ceil(day.MaxLux/5000)*5000))
+			}
+			continue
+		}
+		series.addLux(day.MaxLux, day.Date)
+	}
+	return series, nil
+}
+
+// The series with lux converted to the units, and the axis maximum rounded again
+func (s graphSeries) inUnits(units string) graphSeries {
+	if units == UNITS_LUX {
+		return s
+	}
+	s.name = strings.Replace(s.name, "Lux", unitsLabel(units), 1)
+	values := make([]opts.LineData, len(s.lux))
+	for i, data := range s.lux {
+		values[i] = opts.LineData{Value: convertLux(data.Value.(float64), units)}
+	}
+	s.lux = values
+	s.maxLux = roundAxisMax(convertLux(float64(s.maxLux), units))
+	return s
+}
+
+func (s *graphSeries) addLux(lux float64, at string) {
+	if lux > float64(s.maxLux) {
+		s.maxLux = int(math.Ceil(lux/5000) * 5000)
+	}
+	s.lux = append(s.lux, opts.LineData{Value: lux})
+	s.times = append(s.times, at)
 }
 
 // Update the info in the results tab
 func (m *SLMeter) ServeResultsTab() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateDateRange(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		conditions, err := m.getCurrentConditions()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		startDate, endDate := parseStartAndEndDate(r)
-		conditions, err = m.getHistoricalConditions(conditions, startDate, endDate)
+		startDate, endDate, scope, err := m.rangeFromRequest(r)
+		if errors.Is(err, ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		conditions, err = m.getHistoricalConditions(conditions, startDate, endDate, scope)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		tmpl, err := parseTemplateFile("html/results.gohtml")
+		maxLux, peakAt, err := m.getPeak(startDate, endDate, scope)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		annotations, err := m.getAnnotations(startDate, endDate, scope.JobID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		compareStart, compareEnd, compare, err := compareRangeFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Both ranges' stats side by side, only the first is scoped to the job
+		var comparison []rangeStats
+		if compare {
+			rangeStats, err := m.getRangeStats(startDate, endDate, scope, units)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			compareScope := scope
+			compareScope.JobID = ""
+			compareStats, err := m.getRangeStats(compareStart, compareEnd, compareScope, units)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			comparison = append(comparison, rangeStats, compareStats)
+		}
+		// How the range measures up to the plant picked in the results tab
+		plants, err := m.getPlants()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var adequacy *Adequacy
+		if value := r.FormValue("plant"); value != "" {
+			plant, err := m.getPlant(value)
+			if err != nil && !errors.Is(err, ErrPlantNotFound) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if err == nil {
+				result, err := m.getAdequacy(plant, startDate, endDate, scope.Device)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				adequacy = &result
+			}
+		}
+		jobName, rangeJobName := "", ""
+		if conditions.JobID != "" {
+			if jobName, err = m.getJobName(conditions.JobID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if scope.JobID != "" {
+			if rangeJobName, err = m.getJobName(scope.JobID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			} else if rangeJobName == "" {
+				rangeJobName = scope.JobID
+			}
+		}
+		tmpl, err := getTemplate("results.gohtml")
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		type ConditionsForDisplay struct {
-			JobID                 string `json:"jobID"`
-			Lux                   string `json:"lux"`
-			FullSpectrum          string `json:"fullSpectrum"`
-			Visible               string `json:"visible"`
-			Infrared              string `json:"infrared"`
-			DateRange             string `json:"dateRange"`
-			RecordedHoursInRange  string `json:"recordedHoursInRange"`
-			FullSunlightInRange   string `json:"fullSunlightInRange"`
-			LightConditionInRange string `json:"lightConditionInRange"`
-			AverageLuxInRange     string `json:"averageLuxInRange"`
-			StartDate             string `json:"startDate"`
-			EndDate               string `json:"endDate"`
+			JobID                 string       `json:"jobID"`
+			JobName               string       `json:"jobName"`
+			Lux                   string       `json:"lux"`
+			Units                 string       `json:"units"`
+			PPFD                  string       `json:"ppfd"`
+			FullSpectrum          string       `json:"fullSpectrum"`
+			Visible               string       `json:"visible"`
+			Infrared              string       `json:"infrared"`
+			DateRange             string       `json:"dateRange"`
+			RangeJobID            string       `json:"rangeJobID"`
+			RangeJobName          string       `json:"rangeJobName"`
+			RecordedHoursInRange  string       `json:"recordedHoursInRange"`
+			FullSunlightInRange   string       `json:"fullSunlightInRange"`
+			LightConditionInRange string       `json:"lightConditionInRange"`
+			AverageLuxInRange     string       `json:"averageLuxInRange"`
+			MaxLuxInRange         string       `json:"maxLuxInRange"`
+			PeakAt                string       `json:"peakAt"`
+			Annotations           []Annotation `json:"annotations"`
+			Comparison            []rangeStats `json:"comparison,omitempty"`
+			Plants                []Plant      `json:"plants"`
+			Adequacy              *Adequacy    `json:"adequacy,omitempty"`
+			StartDate             string       `json:"startDate"`
+			EndDate               string       `json:"endDate"`
 		}
+		conditions = m.withPPFD(conditions)
+		conditions = conditions.inUnits(units)
 		err = tmpl.Execute(w, ConditionsForDisplay{
 			JobID:                 conditions.JobID,
+			JobName:               jobName,
 			Lux:                   fmt.Sprintf("%.4f", conditions.Lux),
+			Units:                 unitsLabel(units),
+			PPFD:                  fmt.Sprintf("%.2f", conditions.PPFD),
 			FullSpectrum:          fmt.Sprintf("%.4f", conditions.FullSpectrum),
 			Visible:               fmt.Sprintf("%.4f", conditions.Visible),
 			Infrared:              fmt.Sprintf("%.4f", conditions.Infrared),
 			DateRange:             conditions.DateRange,
+			RangeJobID:            scope.JobID,
+			RangeJobName:          rangeJobName,
 			RecordedHoursInRange:  fmt.Sprintf("%.4f", conditions.RecordedHoursInRange),
 			FullSunlightInRange:   fmt.Sprintf("%.4f", conditions.FullSunlightInRange),
 			LightConditionInRange: conditions.LightConditionInRange,
 			AverageLuxInRange:     fmt.Sprintf("%.4f", conditions.AverageLuxInRange),
+			MaxLuxInRange:         fmt.Sprintf("%.4f", convertLux(maxLux, units)),
+			PeakAt:                peakAt,
+			Annotations:           annotations,
+			Comparison:            comparison,
+			Plants:                plants,
+			Adequacy:              adequacy,
 			StartDate:             startDate,
 			EndDate:               endDate,
 		})
@@ -262,39 +811,44 @@ func (m *SLMeter) ServeResultsTab() http.HandlerFunc {
 }
 
 // Return the most recent entry saved to the db
-func (m *SLMeter) getHistoricalConditions(conditions Conditions, startDate string, endDate string) (Conditions, error) {
+// Only readings from device are included, when it's set.
+func (m *SLMeter) getHistoricalConditions(conditions Conditions, startDate string, endDate string, scope readingScope) (Conditions, error) {
 	if m.ResultsDB == nil {
 		return conditions, nil
 	}
 
 	conditions.DateRange = fmt.Sprintf("%s - %s UTC", startDate, endDate)
-	row := m.ResultsDB.QueryRow(`
+	filter, filterArgs := m.readingFilter(scope)
+	args := append([]interface{}{startDate, endDate}, filterArgs...)
+	var oldest, mostRecent sql.NullString
+	err := tools.RetryBusy(func() error {
+		row := m.ResultsDB.QueryRow(`
     SELECT 
         COALESCE(AVG(lux), 0), 
         COALESCE(MIN(created_at), '0001-01-01 00:00:00'), 
         COALESCE(MAX(created_at), '0001-01-01 00:00:00') 
     FROM sunlight 
-    WHERE created_at BETWEEN ? AND ?`, startDate, endDate)
-	var oldest, mostRecent sql.NullString
-	err := row.Scan(&conditions.AverageLuxInRange, &oldest, &mostRecent)
+    WHERE created_at BETWEEN ? AND ?`+filter, args...)
+		return row.Scan(&conditions.AverageLuxInRange, &oldest, &mostRecent)
+	})
 	if err != nil {
 		return conditions, err
 	}
 	if conditions.AverageLuxInRange == 0 {
-		conditions.LightConditionInRange = "No Data in Range"
+		conditions.LightConditionInRange = LIGHT_NO_DATA
 		return conditions, nil
 	}
 
-	// Get the number of hours where the average lux was above 10k
-	rows, err := m.ResultsDB.Query(`
+	// Get the number of hours where the average lux was above FULL_SUN_LUX
+	rows, err := tools.QueryRetry(m.ResultsDB, `
     SELECT COUNT(*) 
     FROM (
         SELECT AVG(lux) as avg_lux 
         FROM sunlight 
-        WHERE created_at BETWEEN ? AND ? 
+        WHERE created_at BETWEEN ? AND ?`+filter+`
         GROUP BY strftime('%H:%M', created_at)
     ) 
-    WHERE avg_lux > 10000`, startDate, endDate)
+    WHERE avg_lux > ?`, append(args, FULL_SUN_LUX)...)
 	if err != nil {
 		return conditions, err
 	}
@@ -318,60 +872,274 @@ func (m *SLMeter) getHistoricalConditions(conditions Conditions, startDate strin
 			return conditions, err
 		}
 		conditions.RecordedHoursInRange = oldest.Sub(mostRecent).Hours()
-		if conditions.FullSunlightInRange/conditions.RecordedHoursInRange > 0.5 {
-			conditions.LightConditionInRange = "Full Sun"
-		} else if conditions.FullSunlightInRange/conditions.RecordedHoursInRange > 0.25 {
-			conditions.LightConditionInRange = "Partial Sun"
-		} else if conditions.FullSunlightInRange/conditions.RecordedHoursInRange > 0.1 {
-			conditions.LightConditionInRange = "Partial Shade"
-		} else {
-			conditions.LightConditionInRange = "Shade"
-		}
+		conditions.DaylightHoursInRange = m.daylightHours(mostRecent, oldest)
+		conditions.LightConditionInRange = ClassifyLight(conditions.FullSunlightInRange, conditions.classifiedHours(), DefaultBands)
 	}
 
 	return conditions, nil
 }
 
+// Limit a query to the readings from one device, as an extra WHERE condition.
+// Readings recorded before device IDs existed belong to this device.
+func (m *SLMeter) deviceFilter(device string) (string, []interface{}) {
+	if device == "" {
+		return "", nil
+	} else if device == m.DeviceID {
+		return " AND (device_id = ? OR device_id IS NULL)", []interface{}{device}
+	}
+	return " AND device_id = ?", []interface{}{device}
+}
+
+// Every device with recorded readings
+func (m *SLMeter) getDevices() ([]string, error) {
+	rows, err := tools.QueryRetry(m.ResultsDB, "SELECT DISTINCT COALESCE(device_id, ?) FROM sunlight ORDER BY 1", m.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []string
+	for rows.Next() {
+		var device string
+		if err := rows.Scan(&device); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// Serve the device filter for the graph settings, once readings from more than one device exist
+func (m *SLMeter) ServeDeviceSelect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		devices, err := m.getDevices()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if len(devices) < 2 {
+			return
+		}
+
+		tmpl, err := getTemplate("devices.gohtml")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = tmpl.Execute(w, devices)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
 // Used to clear a div with htmx
 func (m *SLMeter) Clear() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Get the start and end dates from the request, format them for comparison with the DB
-func parseStartAndEndDate(r *http.Request) (string, string) {
-	r.ParseForm()
-	startDate := r.FormValue("start")
-	endDate := r.FormValue("end")
-	layoutInput := "2006-01-02T15:04"
-	layoutDB := "2006-01-02 15:04:05"
-	if startDate == "" || endDate == "" {
-		startDate = time.Now().UTC().Add(-8 * time.Hour).Format(layoutDB)
-		endDate = time.Now().UTC().Format(layoutDB)
-	} else {
-		var err error
-		var t time.Time
+// The date range and readings picked in the graph settings.
+// Both the job and date range apply when they're set, with only a job the range is the job's span.
+func (m *SLMeter) rangeFromRequest(r *http.Request) (string, string, readingScope, error) {
+	scope := scopeFromRequest(r)
+	startDate, endDate, err := parseStartAndEndDate(r)
+	if err != nil {
+		return startDate, endDate, scope, err
+	}
+	jobID, err := m.jobFromRequest(r)
+	if err != nil || jobID == "" {
+		return startDate, endDate, scope, err
+	}
+	scope.JobID = jobID
+	if (r.FormValue("start") == "" || r.FormValue("end") == "") && r.FormValue("range") == "" {
+		job, err := m.getJob(jobID)
+		if err != nil {
+			return startDate, endDate, scope, err
+		}
+		if job.StartedAt != nil && job.EndedAt != nil {
+			startDate, endDate = *job.StartedAt, *job.EndedAt
+		}
+	}
+	return startDate, endDate, scope, nil
+}
 
-		// Assume they are in EST, who has users? Not me.
-		loc, _ := time.LoadLocation("America/Indiana/Indianapolis")
+// Serve the job filter for the graph settings, once any job exists
+func (m *SLMeter) ServeJobSelect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := m.getJobs()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if len(jobs) == 0 {
+			return
+		}
 
-		t, err = time.Parse(layoutInput, startDate)
+		tmpl, err := getTemplate("jobs.gohtml")
 		if err != nil {
-			log.Println("Error parsing start date:", err)
-		} else {
-			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
-			startDate = t.UTC().Format(layoutDB)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = tmpl.Execute(w, jobs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// A job in the dashboard's job list, with its span in the format of the date inputs
+type jobListItem struct {
+	Job
+	Label      string
+	StartInput string
+	EndInput   string
+}
+
+// Serve the list of past logging sessions, newest first
+func (m *SLMeter) ServeJobList() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := m.getJobs()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		items := make([]jobListItem, 0, len(jobs))
+		for _, job := range jobs {
+			item := jobListItem{Job: job, Label: job.JobID}
+			if job.Name != "" {
+				item.Label = job.Name
+			}
+			// A running job's span is up to now
+			end := time.Now()
+			if job.EndedAt != nil && !job.Running {
+				if endedAt, err := time.Parse("2006-01-02 15:04:05", *job.EndedAt); err == nil {
+					end = endedAt
+				}
+			}
+			if job.StartedAt != nil {
+				if startedAt, err := time.Parse("2006-01-02 15:04:05", *job.StartedAt); err == nil {
+					item.StartInput = timeToInputDate(startedAt)
+					item.EndInput = timeToInputDate(end.Add(time.Minute))
+				}
+			}
+			items = append(items, item)
 		}
 
-		t, err = time.Parse(layoutInput, endDate)
+		tmpl, err := getTemplate("joblist.gohtml")
 		if err != nil {
-			log.Println("Error parsing end date:", err)
-		} else {
-			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
-			endDate = t.UTC().Format(layoutDB)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = tmpl.Execute(w, items)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Get the start and end dates from the request, format them for comparison with the DB
+func parseStartAndEndDate(r *http.Request) (string, string, error) {
+	r.ParseForm()
+	return resolveDateRange(r.FormValue("start"), r.FormValue("end"), r.FormValue("range"), time.Now().UTC())
+}
+
+const DEFAULT_DATE_RANGE = 8 * time.Hour
+
+// Resolve a request's dates to the DB's format. Explicit dates win over a range like 24h,
+// which covers the time up to now. Without either, the range is the last DEFAULT_DATE_RANGE.
+func resolveDateRange(startDate string, endDate string, rangeValue string, now time.Time) (string, string, error) {
+	layoutDB := "2006-01-02 15:04:05"
+	if startDate == "" && endDate == "" {
+		window := DEFAULT_DATE_RANGE
+		if rangeValue != "" {
+			var err error
+			if window, err = parseRange(rangeValue); err != nil {
+				return "", "", err
+			}
 		}
+		return now.Add(-window).Format(layoutDB), now.Format(layoutDB), nil
+	} else if startDate == "" || endDate == "" {
+		return "", "", fmt.Errorf("Both start and end are required")
+	}
+	start, err := inputDateToDB(startDate)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid start date, expected YYYY-MM-DDTHH:MM")
+	}
+	end, err := inputDateToDB(endDate)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid end date, expected YYYY-MM-DDTHH:MM")
+	}
+	if end < start {
+		return "", "", fmt.Errorf("The end date is before the start date")
+	}
+	return start, end, nil
+}
+
+// Convert a date from the dashboard's inputs to the DB's format
+func inputDateToDB(value string) (string, error) {
+	t, err := time.Parse("2006-01-02T15:04", value)
+	if err != nil {
+		return "", err
+	}
+	loc := inputLocation()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+	return t.UTC().Format("2006-01-02 15:04:05"), nil
+}
+
+// Convert a time to the format of the dashboard's inputs
+func timeToInputDate(t time.Time) string {
+	return t.In(inputLocation()).Format("2006-01-02T15:04")
+}
+
+func inputLocation() *time.Location {
+	// Assume they are in EST, who has users? Not me.
+	loc, _ := time.LoadLocation("America/Indiana/Indianapolis")
+	return loc
+}
+
+// Parse a range like 90m, 24h or 7d. Go durations are accepted, along with whole days and weeks.
+func parseRange(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, fmt.Errorf("No range")
+	}
+	var window time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		window, err = wholeUnits(days, 24*time.Hour)
+	} else if weeks, ok := strings.CutSuffix(value, "w"); ok {
+		window, err = wholeUnits(weeks, 7*24*time.Hour)
+	} else {
+		window, err = time.ParseDuration(value)
+	}
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("Invalid range, expected a positive duration like 1h, 24h or 7d")
+	}
+	return window, nil
+}
+
+func wholeUnits(value string, unit time.Duration) (time.Duration, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
 	}
-	return startDate, endDate
+	return time.Duration(n) * unit, nil
+}
+
+// Whether the request picks a date range, with start and end or with range
+func hasDateRange(r *http.Request) bool {
+	return r.FormValue("start") != "" || r.FormValue("end") != "" || r.FormValue("range") != ""
+}
+
+// Check that the start and end dates, or the range, are valid, for handlers that check before they need the dates
+func validateDateRange(r *http.Request) error {
+	_, _, err := parseStartAndEndDate(r)
+	return err
 }
 
 func startAndEndDateToTime(startDate string, endDate string) (time.Time, time.Time, error) {
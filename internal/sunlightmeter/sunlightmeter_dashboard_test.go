@@ -0,0 +1,93 @@
+package sunlightmeter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+func TestResolveDateRange(t *testing.T) {
+	now := time.Date(2024, 6, 21, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name                  string
+		start, end, rangeText string
+		wantStart, wantEnd    string
+	}{
+		{"the default range", "", "", "", "2024-06-21 08:00:00", "2024-06-21 16:00:00"},
+		{"minutes", "", "", "90m", "2024-06-21 14:30:00", "2024-06-21 16:00:00"},
+		{"hours", "", "", "24h", "2024-06-20 16:00:00", "2024-06-21 16:00:00"},
+		{"days", "", "", "7d", "2024-06-14 16:00:00", "2024-06-21 16:00:00"},
+		{"weeks", "", "", "2w", "2024-06-07 16:00:00", "2024-06-21 16:00:00"},
+		// The dates are in Indianapolis, four hours behind UTC in the summer
+		{"dates", "2024-06-21T08:00", "2024-06-21T09:00", "", "2024-06-21 12:00:00", "2024-06-21 13:00:00"},
+		{"dates beat a range", "2024-06-21T08:00", "2024-06-21T09:00", "7d", "2024-06-21 12:00:00", "2024-06-21 13:00:00"},
+		{"dates beat a range that isn't one", "2024-06-21T08:00", "2024-06-21T09:00", "bogus", "2024-06-21 12:00:00", "2024-06-21 13:00:00"},
+		{"a range of one minute", "2024-06-21T08:00", "2024-06-21T08:00", "", "2024-06-21 12:00:00", "2024-06-21 12:00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := resolveDateRange(tt.start, tt.end, tt.rangeText, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("got %s to %s, want %s to %s", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name                  string
+		start, end, rangeText string
+	}{
+		{"zero hours", "", "", "0h"},
+		{"zero days", "", "", "0d"},
+		{"zero weeks", "", "", "0w"},
+		{"negative hours", "", "", "-1h"},
+		{"negative days", "", "", "-2d"},
+		{"part of a day", "", "", "1.5d"},
+		{"no unit", "", "", "24"},
+		{"only a unit", "", "", "d"},
+		{"years", "", "", "1y"},
+		{"garbage", "", "", "bogus"},
+		{"only a start", "2024-06-21T08:00", "", ""},
+		{"only an end", "", "2024-06-21T08:00", "24h"},
+		{"a start that isn't a date", "yesterday", "2024-06-21T08:00", ""},
+		{"an end that isn't a date", "2024-06-21T08:00", "2024-06-21 09:00", ""},
+		{"the end before the start", "2024-06-21T09:00", "2024-06-21T08:00", ""},
+	} {
+		if start, end, err := resolveDateRange(tt.start, tt.end, tt.rangeText, now); err == nil {
+			t.Errorf("%s: got %s to %s, want an error", tt.name, start, end)
+		}
+	}
+}
+
+// Every handler rejects a range that isn't one, rather than using the default
+func TestInvalidRangeIsRejected(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+
+	// The graph settings are posted as a form, and answered with HTML
+	for name, handler := range map[string]http.HandlerFunc{"graph": m.ServeResultsGraph(), "results tab": m.ServeResultsTab()} {
+		req := httptest.NewRequest(http.MethodPost, "/sunlightmeter/graph", strings.NewReader(url.Values{"range": {"bogus"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	for path, handler := range map[string]http.HandlerFunc{
+		"/readings": m.ServeReadings(),
+		"/stats":    m.ServeStats(),
+		"/events":   m.ServeEvents(),
+		"/db":       m.ServeResultsDB(),
+	} {
+		assertErrorCode(t, serveAPIRequest(handler, http.MethodGet, path+"?range=bogus"), http.StatusBadRequest, tools.ERR_BAD_DATE_RANGE)
+	}
+}
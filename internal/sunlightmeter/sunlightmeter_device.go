@@ -0,0 +1,146 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+const (
+	SETTING_DEVICE_ID       = "device_id"
+	SETTING_DEVICE_NAME     = "device_name"
+	SETTING_DEVICE_LOCATION = "device_location"
+	SENSOR_MODEL            = "TSL2591"
+	MAX_DEVICE_FIELD_LENGTH = 255
+)
+
+type DeviceInfo struct {
+	ServiceName string     `json:"service_name"`
+	DeviceID    string     `json:"device_id"`
+	Hostname    string     `json:"hostname"`
+	Version     string     `json:"version"`
+	Commit      string     `json:"commit,omitempty"`
+	Name        string     `json:"name"`
+	Location    string     `json:"location"`
+	Sensor      SensorInfo `json:"sensor"`
+}
+
+type SensorInfo struct {
+	Model           string `json:"model"`
+	Connected       bool   `json:"connected"`
+	Gain            string `json:"gain,omitempty"`
+	IntegrationTime string `json:"integration_time,omitempty"`
+	RecordInterval  string `json:"record_interval"`
+}
+
+// Use the configured DeviceID, or the one generated on first start and kept in the db.
+// Readings recorded before device IDs existed are stamped with it, so they stay distinguishable once exported.
+func (m *SLMeter) LoadDeviceID() error {
+	if m.DeviceID == "" {
+		deviceID, ok, err := m.getSetting(SETTING_DEVICE_ID)
+		if err != nil {
+			return err
+		} else if !ok || deviceID == "" {
+			deviceID = uuid.New().String()
+			if err := m.setSetting(SETTING_DEVICE_ID, deviceID); err != nil {
+				return err
+			}
+			log.Println(fmt.Sprintf("Generated device ID %s", deviceID))
+		}
+		m.DeviceID = deviceID
+	}
+
+	_, err := tools.ExecRetry(m.ResultsDB, "UPDATE sunlight SET device_id = ? WHERE device_id IS NULL", m.DeviceID)
+	return err
+}
+
+// Serve the device ID, software version, sensor settings, and the user-set name and location
+func (m *SLMeter) ServeDeviceInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info, err := m.getDeviceInfo()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, info)
+	}
+}
+
+// Set the device's friendly name and location, fields that aren't sent are left as-is
+func (m *SLMeter) UpdateDeviceInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields := []struct {
+			form    string
+			setting string
+		}{
+			{"name", SETTING_DEVICE_NAME},
+			{"location", SETTING_DEVICE_LOCATION},
+		}
+		for _, field := range fields {
+			if _, ok := r.PostForm[field.form]; !ok {
+				continue
+			}
+			value := strings.TrimSpace(r.PostForm.Get(field.form))
+			if len(value) > MAX_DEVICE_FIELD_LENGTH {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("The %s is too long", field.form), http.StatusBadRequest)
+				return
+			}
+			if err := m.setSetting(field.setting, value); err != nil {
+				tools.RequestLog(r).Error(err)
+				ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		info, err := m.getDeviceInfo()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Println(fmt.Sprintf("Updated device info, Name: %s, Location: %s", info.Name, info.Location))
+		serveJSON(w, http.StatusOK, info)
+	}
+}
+
+func (m *SLMeter) getDeviceInfo() (DeviceInfo, error) {
+	hostname, _ := os.Hostname()
+	info := DeviceInfo{
+		ServiceName: "Sunlight Meter",
+		DeviceID:    m.DeviceID,
+		Hostname:    hostname,
+		Version:     m.Version,
+		Commit:      m.Commit,
+		Sensor: SensorInfo{
+			Model:          SENSOR_MODEL,
+			Connected:      m.TSL2591 != nil,
+			RecordInterval: RECORD_INTERVAL.String(),
+		},
+	}
+	if m.TSL2591 != nil {
+		m.Lock()
+		info.Sensor.Gain = tsl2591.GainToString(m.Gain)
+		info.Sensor.IntegrationTime = tsl2591.IntegrationTimeToString(m.Timing)
+		m.Unlock()
+	}
+
+	var err error
+	if info.Name, _, err = m.getSetting(SETTING_DEVICE_NAME); err != nil {
+		return DeviceInfo{}, err
+	}
+	if info.Location, _, err = m.getSetting(SETTING_DEVICE_LOCATION); err != nil {
+		return DeviceInfo{}, err
+	}
+	return info, nil
+}
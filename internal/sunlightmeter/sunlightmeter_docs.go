@@ -0,0 +1,34 @@
+package sunlightmeter
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+// Serve the OpenAPI document describing the /api/v1 routes
+func (m *SLMeter) ServeOpenAPISpec() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(openAPISpec)
+	}
+}
+
+// Serve a Swagger UI page for the OpenAPI document
+func (m *SLMeter) ServeAPIDocs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fileContent, err := parseHTMLFile("docs.html")
+		if err != nil {
+			ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		w.Write(fileContent)
+	}
+}
@@ -0,0 +1,49 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-echarts/go-echarts/v2/components"
+)
+
+// The graph's JavaScript is served from the static files, so it draws without internet access.
+// The copy is fetched into static/echarts by go generate, from the same host go-echarts uses by default.
+const (
+	ECHARTS_CDN_HOST   = "https://go-echarts.github.io/go-echarts-assets/assets/"
+	ECHARTS_LOCAL_HOST = "/static/echarts/"
+)
+
+// Whether the static files hold the echarts assets
+func EChartsAssetsAvailable(root http.FileSystem) bool {
+	file, err := root.Open("echarts/echarts.min.js")
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
+
+// The URL of an echarts asset, like echarts.min.js or themes/chalk.js.
+// Local assets carry the static version, so they're cached until the binary changes.
+func (m *SLMeter) echartsScript(name string) string {
+	if m.EChartsCDN {
+		return ECHARTS_CDN_HOST + name
+	}
+	return fmt.Sprintf("%s%s?v=%s", ECHARTS_LOCAL_HOST, name, StaticVersion())
+}
+
+// A page with the charts, loading their JavaScript from the local copy unless EChartsCDN is set
+func (m *SLMeter) echartsPage(charts ...components.Charter) *components.Page {
+	page := components.NewPage()
+	page.AddCharts(charts...)
+	// go-echarts prefixes each asset with the host, unless it already starts with it
+	page.AssetsHost = ECHARTS_LOCAL_HOST
+	if m.EChartsCDN {
+		page.AssetsHost = ECHARTS_CDN_HOST
+	}
+	for i, asset := range page.JSAssets.Values {
+		page.JSAssets.Values[i] = m.echartsScript(asset)
+	}
+	return page
+}
@@ -0,0 +1,66 @@
+package sunlightmeter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var scriptSrc = regexp.MustCompile(`<script src="([^"]*)"`)
+
+func graphScripts(t *testing.T, m *SLMeter, query url.Values) []string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/sunlightmeter/graph?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+	m.ServeResultsGraph().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, %s", w.Code, w.Body.String())
+	}
+	var scripts []string
+	for _, match := range scriptSrc.FindAllStringSubmatch(w.Body.String(), -1) {
+		scripts = append(scripts, match[1])
+	}
+	if len(scripts) == 0 {
+		t.Fatalf("the graph loads no scripts: %s", w.Body.String())
+	}
+	return scripts
+}
+
+func TestGraphScriptsAreServedLocally(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, inputLocation())
+	seedReadings(t, m, start.UTC(), 11)
+	query := url.Values{"start": {timeToInputDate(start)}, "end": {timeToInputDate(start.Add(10 * time.Minute))}, "theme": {"chalk"}}
+
+	// From the static files, with the version so they're cached until the binary changes
+	want := []string{
+		ECHARTS_LOCAL_HOST + "echarts.min.js?v=" + StaticVersion(),
+		ECHARTS_LOCAL_HOST + "themes/chalk.js?v=" + StaticVersion(),
+	}
+	if scripts := graphScripts(t, m, query); strings.Join(scripts, " ") != strings.Join(want, " ") {
+		t.Errorf("got scripts %q, want %q", scripts, want)
+	}
+
+	// Only from the CDN when it's asked for
+	m.EChartsCDN = true
+	for _, src := range graphScripts(t, m, query) {
+		if !strings.HasPrefix(src, ECHARTS_CDN_HOST) {
+			t.Errorf("got script %q with EChartsCDN set", src)
+		}
+	}
+}
+
+func TestEChartsAssetsAvailable(t *testing.T) {
+	assets := fstest.MapFS{"echarts/echarts.min.js": {Data: []byte("// echarts")}}
+	if !EChartsAssetsAvailable(http.FS(assets)) {
+		t.Error("the assets weren't found")
+	}
+	if EChartsAssetsAvailable(http.FS(fstest.MapFS{"dashboard.js": {Data: []byte("// dashboard")}})) {
+		t.Error("got assets from static files without them")
+	}
+}
@@ -0,0 +1,195 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+// Sensor trouble worth keeping next to the readings, to tell whether noisy data lines up with it
+const (
+	SENSOR_EVENT_OVERFLOW    = "overflow"
+	SENSOR_EVENT_GAIN_CHANGE = "gain_change"
+	SENSOR_EVENT_I2C_ERROR   = "i2c_error"
+	SENSOR_EVENT_RECONNECT   = "reconnect"
+	SENSOR_EVENT_INVALID_LUX = "invalid_lux"
+	SENSOR_EVENT_RELAY       = "relay"
+)
+
+var sensorEventTypes = []string{SENSOR_EVENT_OVERFLOW, SENSOR_EVENT_GAIN_CHANGE, SENSOR_EVENT_I2C_ERROR, SENSOR_EVENT_RECONNECT, SENSOR_EVENT_INVALID_LUX, SENSOR_EVENT_RELAY}
+
+// Events waiting to be written, any more are dropped rather than holding up the sampling loop
+const SENSOR_EVENT_BUFFER = 256
+
+type SensorEvent struct {
+	ID        int64   `json:"id"`
+	Type      string  `json:"type"`
+	Detail    string  `json:"detail"`
+	JobID     *string `json:"jobID"`
+	SensorID  string  `json:"sensorID,omitempty"`
+	CreatedAt string  `json:"createdAt"`
+}
+
+// Queue an event to be recorded. This never blocks, when the recorder is behind the event is only logged.
+func (m *SLMeter) logEvent(eventType string, jobID string, detail string) {
+	event := SensorEvent{
+		Type:      eventType,
+		Detail:    detail,
+		SensorID:  m.SensorID,
+		CreatedAt: time.Now().UTC().Format("2006-01-02 15:04:05"),
+	}
+	if jobID != "" {
+		event.JobID = &jobID
+	}
+	select {
+	case m.eventQueue() <- event:
+	default:
+		dropped := m.recorder().droppedEvents.Add(1)
+		log.Println(fmt.Sprintf("The event queue is full, skipping %s event: %s (Dropped: %d)", eventType, detail, dropped))
+	}
+}
+
+// Queue an event for the sensor that took the result
+func (m *SLMeter) logResultEvent(result LuxResults, eventType string, detail string) {
+	sensor, ok := m.Sensor(result.SensorID)
+	if !ok {
+		sensor = m
+	}
+	sensor.logEvent(eventType, result.JobID, detail)
+}
+
+func (m *SLMeter) eventQueue() chan SensorEvent {
+	recorder := m.recorder()
+	recorder.eventsOnce.Do(func() {
+		recorder.events = make(chan SensorEvent, SENSOR_EVENT_BUFFER)
+	})
+	return recorder.events
+}
+
+// Write queued events to sqlite, and record each sensor's reconnects
+func (m *SLMeter) RecordEvents() {
+	for _, sensor := range append([]*SLMeter{m}, m.Sensors...) {
+		if sensor.TSL2591 != nil {
+			sensor.OnReconnect = sensor.reconnected
+		}
+	}
+	for event := range m.eventQueue() {
+		if m.StorageFull() {
+			continue
+		}
+		_, err := tools.ExecRetry(m.ResultsDB,
+			"INSERT INTO events (device_id, sensor_id, job_id, type, detail, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+			m.DeviceID,
+			sql.NullString{String: event.SensorID, Valid: event.SensorID != ""},
+			event.JobID,
+			event.Type,
+			event.Detail,
+			event.CreatedAt,
+		)
+		if err != nil {
+			log.Println(fmt.Sprintf("Failed to record the %s event: %s", event.Type, err.Error()))
+		}
+	}
+}
+
+// Called by the driver after it reopens the I2C device
+func (m *SLMeter) reconnected(err error) {
+	if err != nil {
+		m.logEvent(SENSOR_EVENT_RECONNECT, m.JobID(), fmt.Sprintf("Failed to reconnect: %s", err.Error()))
+		return
+	}
+	m.logEvent(SENSOR_EVENT_RECONNECT, m.JobID(), "Reconnected")
+}
+
+func gainLabel(gain byte, timing byte) string {
+	return fmt.Sprintf("%s gain, %s", tsl2591.GainToString(gain), tsl2591.IntegrationTimeToString(timing))
+}
+
+// Serve the events in the date range, optionally only those of one type
+func (m *SLMeter) ServeEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		eventType := r.FormValue("type")
+		if eventType != "" && !validEventType(eventType) {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("Invalid type, expected one of %s", strings.Join(sensorEventTypes, ", ")), http.StatusBadRequest)
+			return
+		}
+		scope := scopeFromRequest(r)
+		scope.JobID = r.FormValue("job_id")
+
+		events, err := m.getEvents(startDate, endDate, eventType, scope)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, events)
+	}
+}
+
+func validEventType(eventType string) bool {
+	for _, t := range sensorEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the events in the date range and scope, oldest first. An empty type matches every event.
+func (m *SLMeter) getEvents(startDate string, endDate string, eventType string, scope readingScope) ([]SensorEvent, error) {
+	filter, filterArgs := m.scopeFilter(scope)
+	if eventType != "" {
+		filter += " AND type = ?"
+		filterArgs = append(filterArgs, eventType)
+	}
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT id, type, COALESCE(detail, ''), job_id, COALESCE(sensor_id, ''), CAST(created_at AS TEXT)
+    FROM events
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    ORDER BY created_at, id`, append([]interface{}{startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SensorEvent{}
+	for rows.Next() {
+		var event SensorEvent
+		var jobID sql.NullString
+		if err := rows.Scan(&event.ID, &event.Type, &event.Detail, &jobID, &event.SensorID, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if jobID.Valid {
+			event.JobID = &jobID.String
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Mark each gain change on the graph with the new gain, at the first reading after it
+func gainChangeMarkers(events []SensorEvent, timeValues []string) []opts.MarkLineNameXAxisItem {
+	markers := []opts.MarkLineNameXAxisItem{}
+	for _, event := range events {
+		_, to, _ := strings.Cut(event.Detail, " -> ")
+		if to == "" {
+			to = event.Detail
+		}
+		if marker, ok := markerAt(event.CreatedAt, to, timeValues); ok {
+			markers = append(markers, marker)
+		}
+	}
+	return markers
+}
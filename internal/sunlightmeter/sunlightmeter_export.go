@@ -0,0 +1,244 @@
+package sunlightmeter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const NDJSON_FLUSH_ROWS = 1000
+
+// Copy the rows in the date range into the attached export db. Tokens and settings are left out.
+var filteredExportQueries = []string{
+	`INSERT INTO export.sunlight (job_id, lux, full_spectrum, visible, infrared, cpu_temp, device_id, sensor_id, saturated, light_source, created_at)
+    SELECT job_id, lux, full_spectrum, visible, infrared, cpu_temp, COALESCE(device_id, :device), sensor_id, saturated, light_source, created_at
+    FROM main.sunlight WHERE created_at BETWEEN :start AND :end ORDER BY created_at, id`,
+	`INSERT INTO export.jobs (job_id, name, location, tags, created_at, updated_at)
+    SELECT job_id, name, location, tags, created_at, updated_at
+    FROM main.jobs WHERE job_id IN (SELECT job_id FROM export.sunlight)`,
+	`INSERT INTO export.annotations (note, temperature, job_id, created_at)
+    SELECT note, temperature, job_id, created_at
+    FROM main.annotations WHERE created_at BETWEEN :start AND :end ORDER BY created_at, id`,
+	`INSERT INTO export.interrupt_events (job_id, ch0, ch1, lux, created_at)
+    SELECT job_id, ch0, ch1, lux, created_at
+    FROM main.interrupt_events WHERE created_at BETWEEN :start AND :end ORDER BY created_at, id`,
+	`INSERT INTO export.read_failures (device_id, sensor_id, job_id, error, created_at)
+    SELECT COALESCE(device_id, :device), sensor_id, job_id, error, created_at
+    FROM main.read_failures WHERE created_at BETWEEN :start AND :end ORDER BY created_at, id`,
+	`INSERT INTO export.events (device_id, sensor_id, job_id, type, detail, created_at)
+    SELECT COALESCE(device_id, :device), sensor_id, job_id, type, detail, created_at
+    FROM main.events WHERE created_at BETWEEN :start AND :end ORDER BY created_at, id`,
+	`INSERT INTO export.weather (observed_at, provider, cloud_cover, precipitation, created_at)
+    SELECT observed_at, provider, cloud_cover, precipitation, created_at
+    FROM main.weather WHERE observed_at BETWEEN :start AND :end ORDER BY observed_at`,
+}
+
+// Serve a db with only the readings in the date range, and the jobs, annotations, events and weather that go with them.
+// It's built in a temp file, which is removed once it's been served.
+func (m *SLMeter) serveFilteredDB(w http.ResponseWriter, r *http.Request, startDate string, endDate string) {
+	tmpFile, err := os.CreateTemp("", "slm-export-*.db")
+	if err != nil {
+		tools.RequestLog(r).Error(err)
+		ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	if err := m.buildFilteredDB(r, tmpFile.Name(), startDate, endDate); err != nil {
+		tools.RequestLog(r).WithError(err).Error("Failed to build the export db")
+		ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exported, err := os.Open(tmpFile.Name())
+	if err != nil {
+		tools.RequestLog(r).Error(err)
+		ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer exported.Close()
+	name := fmt.Sprintf("sunlightmeter_%s_%s.db", startDate[:10], endDate[:10])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", name))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, time.Now(), exported)
+}
+
+func (m *SLMeter) buildFilteredDB(r *http.Request, path string, startDate string, endDate string) error {
+	// Run the migrations on the new db, so it has the same schema and can be imported again
+	exportDB, err := tools.ConnectSqlite(path, tools.SqliteOptions{JournalMode: "DELETE"})
+	if err != nil {
+		return err
+	}
+	exportDB.Close()
+
+	// Attaching only applies to one connection, so the copy runs on a dedicated one
+	conn, err := m.ResultsDB.Conn(r.Context())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(r.Context(), "ATTACH DATABASE ? AS export", path); err != nil {
+		return err
+	}
+	defer conn.ExecContext(r.Context(), "DETACH DATABASE export")
+
+	for _, query := range filteredExportQueries {
+		err := tools.RetryBusy(func() error {
+			_, err := conn.ExecContext(r.Context(), query,
+				sql.Named("device", m.DeviceID), sql.Named("start", startDate), sql.Named("end", endDate))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serve a backup of the whole db, safe to take while a job is recording
+func (m *SLMeter) ServeBackup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.serveSnapshot(w, r, fmt.Sprintf("sunlightmeter_backup_%s.db", time.Now().UTC().Format("20060102T150405Z")))
+	}
+}
+
+// Serve a consistent snapshot of the db, rather than the live file, which the recorder may be writing to.
+// VACUUM INTO writes it next to the db, so it doesn't fill a RAM-backed /tmp, and it's removed once it's been served.
+func (m *SLMeter) serveSnapshot(w http.ResponseWriter, r *http.Request, name string) {
+	snapshot, err := os.CreateTemp(filepath.Dir(m.DBPath), ".slm-snapshot-*.db")
+	if err != nil {
+		tools.RequestLog(r).Error(err)
+		ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	snapshot.Close()
+	defer os.Remove(snapshot.Name())
+
+	err = tools.RetryBusy(func() error {
+		// VACUUM INTO only writes to an empty file, a failed attempt may have left part of one
+		if err := os.Truncate(snapshot.Name(), 0); err != nil {
+			return err
+		}
+		_, err := m.ResultsDB.ExecContext(r.Context(), "VACUUM INTO ?", snapshot.Name())
+		return err
+	})
+	if err != nil {
+		tools.RequestLog(r).WithError(err).Error("Failed to snapshot the db")
+		ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(snapshot.Name())
+	if err != nil {
+		tools.RequestLog(r).Error(err)
+		ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", name))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, time.Now(), file)
+}
+
+// Stream the readings in the date range as newline-delimited JSON, oldest first.
+// Rows are written as they're read, and flushed every NDJSON_FLUSH_ROWS so memory stays flat and clients see progress.
+func (m *SLMeter) ServeNDJSON() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT id, COALESCE(device_id, ?), job_id, lux, full_spectrum, visible, infrared, cpu_temp, saturated, COALESCE(light_source, ''), CAST(created_at AS TEXT),`+weatherForReading+`
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?
+    ORDER BY created_at, id`, m.DeviceID, startDate, endDate)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=sunlightmeter.ndjson")
+		w.Header().Add("Vary", "Accept-Encoding")
+		var body io.Writer = w
+		var gz *gzip.Writer
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz = gzip.NewWriter(w)
+			defer gz.Close()
+			body = gz
+		}
+		w.WriteHeader(http.StatusOK)
+
+		out := bufio.NewWriter(body)
+		defer out.Flush()
+		flush := func() {
+			out.Flush()
+			if gz != nil {
+				gz.Flush()
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+
+		count := 0
+		for rows.Next() {
+			var reading Reading
+			err := rows.Scan(&reading.ID, &reading.DeviceID, &reading.JobID, &reading.Lux, &reading.FullSpectrum, &reading.Visible, &reading.Infrared, &reading.CPUTemp, &reading.Saturated, &reading.LightSource, &reading.CreatedAt, &reading.CloudCover, &reading.Precipitation)
+			if err != nil {
+				// The response has started, so the error can only be logged
+				tools.RequestLog(r).Error(err)
+				return
+			}
+			data, err := json.Marshal(reading)
+			if err != nil {
+				tools.RequestLog(r).Error(err)
+				return
+			}
+			out.Write(data)
+			out.WriteByte('\n')
+
+			count++
+			if count%NDJSON_FLUSH_ROWS == 0 {
+				select {
+				case <-r.Context().Done():
+					log.Printf("NDJSON export cancelled by the client after %d rows", count)
+					return
+				default:
+				}
+				flush()
+			}
+		}
+		if err := rows.Err(); err != nil {
+			tools.RequestLog(r).Error(err)
+		}
+	}
+}
+
+// Whether the client accepts a gzip encoded response
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package sunlightmeter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const NDJSON_TEST_ROWS = 100000
+
+// Counts what's written without keeping it, and records how much had been written at each flush
+type streamRecorder struct {
+	header  http.Header
+	status  int
+	written int
+	lines   int
+	last    []byte // The last complete line
+	partial []byte
+	writes  int
+	largest int
+	flushes []int
+	onFlush func()
+}
+
+func newStreamRecorder() *streamRecorder {
+	return &streamRecorder{header: http.Header{}}
+}
+
+func (s *streamRecorder) Header() http.Header { return s.header }
+
+func (s *streamRecorder) WriteHeader(status int) { s.status = status }
+
+func (s *streamRecorder) Write(p []byte) (int, error) {
+	s.writes++
+	s.written += len(p)
+	s.largest = max(s.largest, len(p))
+	for _, b := range p {
+		if b == '\n' {
+			s.lines++
+			s.last, s.partial = s.partial, s.partial[:0:0]
+			continue
+		}
+		s.partial = append(s.partial, b)
+	}
+	return len(p), nil
+}
+
+func (s *streamRecorder) Flush() {
+	s.flushes = append(s.flushes, s.written)
+	if s.onFlush != nil {
+		s.onFlush()
+	}
+}
+
+// Record n readings a second apart from start, with lux counting up from 1
+func seedManyReadings(t testing.TB, m *SLMeter, start time.Time, n int) {
+	t.Helper()
+	results := make([]LuxResults, n)
+	for i := range results {
+		results[i] = LuxResults{Lux: float64(i + 1), JobID: "job-1", CreatedAt: start.Add(time.Duration(i) * time.Second)}
+	}
+	if err := m.insertResults(results); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ndjsonRequest(start time.Time, end time.Time) *http.Request {
+	query := url.Values{"start": {timeToInputDate(start)}, "end": {timeToInputDate(end)}}
+	return httptest.NewRequest(http.MethodGet, "/api/v1/export.ndjson?"+query.Encode(), nil)
+}
+
+func TestNDJSONExportStreams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("seeds 100k rows")
+	}
+	m := &SLMeter{ResultsDB: newTestDB(t), DeviceID: "test-device"}
+	start := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	seedManyReadings(t, m, start, NDJSON_TEST_ROWS)
+
+	w := newStreamRecorder()
+	m.ServeNDJSON().ServeHTTP(w, ndjsonRequest(start, start.Add(48*time.Hour)))
+
+	if w.status != http.StatusOK {
+		t.Fatalf("got %d", w.status)
+	}
+	if w.lines != NDJSON_TEST_ROWS {
+		t.Fatalf("got %d lines, want %d", w.lines, NDJSON_TEST_ROWS)
+	}
+	var last Reading
+	if err := json.Unmarshal(w.last, &last); err != nil || last.Lux != NDJSON_TEST_ROWS || last.DeviceID != "test-device" {
+		t.Errorf("got last line %s", w.last)
+	}
+
+	// Flushed every NDJSON_FLUSH_ROWS as it goes, rather than once the whole result is ready
+	if want := NDJSON_TEST_ROWS/NDJSON_FLUSH_ROWS - 1; len(w.flushes) < want {
+		t.Fatalf("got %d flushes, want at least %d", len(w.flushes), want)
+	}
+	if first := w.flushes[0]; first > w.written/50 {
+		t.Errorf("%d of %d bytes were written before the first flush", first, w.written)
+	}
+	// Each write to the response is a buffer's worth, never the result or a large part of it
+	if w.largest > 64<<10 {
+		t.Errorf("the largest write was %d bytes of %d", w.largest, w.written)
+	}
+	if w.writes < w.written/(64<<10) {
+		t.Errorf("got %d writes for %d bytes", w.writes, w.written)
+	}
+}
+
+func TestNDJSONExportStopsWhenTheClientLeaves(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	seedManyReadings(t, m, start, 5*NDJSON_FLUSH_ROWS)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := newStreamRecorder()
+	w.onFlush = cancel
+	m.ServeNDJSON().ServeHTTP(w, ndjsonRequest(start, start.Add(24*time.Hour)).WithContext(ctx))
+
+	// The first batch is flushed, then the cancelled context is noticed at the next one
+	if w.lines > 2*NDJSON_FLUSH_ROWS {
+		t.Errorf("got %d lines after the client left after the first %d", w.lines, NDJSON_FLUSH_ROWS)
+	}
+}
+
+func TestNDJSONExportRanges(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	seedManyReadings(t, m, start, 180)
+
+	// Both ends are included, the readings are a second apart so 00:01 to 00:02 holds 61
+	w := httptest.NewRecorder()
+	m.ServeNDJSON().ServeHTTP(w, ndjsonRequest(start.Add(time.Minute), start.Add(2*time.Minute)))
+	if lines := bytes.Count(w.Body.Bytes(), []byte("\n")); lines != 61 {
+		t.Errorf("got %d lines, want 61", lines)
+	}
+
+	// An empty range is an empty body, not an error
+	w = httptest.NewRecorder()
+	m.ServeNDJSON().ServeHTTP(w, ndjsonRequest(start.Add(-48*time.Hour), start.Add(-24*time.Hour)))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("got %d with %q, want an empty 200", w.Code, w.Body.String())
+	}
+
+	// Gzip when it's accepted
+	req := ndjsonRequest(start, start.Add(time.Hour))
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	m.ServeNDJSON().ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(body, []byte("\n")); lines != 180 {
+		t.Errorf("got %d lines, want 180", lines)
+	}
+}
@@ -0,0 +1,79 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// Record a read that failed, so the gap it leaves in the readings can be explained.
+// Failures are kept apart from the readings, rather than as sunlight rows without a lux, so nothing that
+// averages or graphs the lux has to skip them. Failures are rare, so they're written straight away rather than batched.
+func (m *SLMeter) recordReadFailure(result LuxResults) {
+	if m.ResultsDB == nil || m.StorageFull() {
+		return
+	}
+	_, err := tools.ExecRetry(m.ResultsDB,
+		"INSERT INTO read_failures (device_id, sensor_id, job_id, error, created_at) VALUES (?, ?, ?, ?, ?)",
+		m.DeviceID,
+		sql.NullString{String: result.SensorID, Valid: result.SensorID != ""},
+		result.JobID,
+		result.Error,
+		result.CreatedAt.Format("2006-01-02 15:04:05"),
+	)
+	if err != nil {
+		log.Println(fmt.Sprintf("Failed to record the failed read: %s", err.Error()))
+	}
+}
+
+type readFailure struct {
+	createdAt string
+	error     string
+}
+
+// The failed reads in the date range, oldest first
+func (m *SLMeter) getReadFailures(startDate string, endDate string, scope readingScope) ([]readFailure, error) {
+	filter, filterArgs := m.scopeFilter(scope)
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT CAST(created_at AS TEXT), error
+    FROM read_failures
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    ORDER BY created_at`, append([]interface{}{startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []readFailure{}
+	for rows.Next() {
+		var failure readFailure
+		if err := rows.Scan(&failure.createdAt, &failure.error); err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
+
+// The sorted failures inside the gap
+func failuresBetween(failures []readFailure, from string, to string) []readFailure {
+	first := sort.Search(len(failures), func(i int) bool { return failures[i].createdAt >= from })
+	last := sort.Search(len(failures), func(i int) bool { return failures[i].createdAt >= to })
+	return failures[first:last]
+}
+
+// Each error once, in the order they first happened
+func distinctErrors(failures []readFailure) []string {
+	var distinct []string
+	seen := map[string]bool{}
+	for _, failure := range failures {
+		if !seen[failure.error] {
+			seen[failure.error] = true
+			distinct = append(distinct, failure.error)
+		}
+	}
+	return distinct
+}
@@ -0,0 +1,235 @@
+package sunlightmeter
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	DEFAULT_FORWARD_INTERVAL = 30 * time.Second
+	FORWARD_BATCH_SIZE       = 500
+	FORWARD_MAX_BACKOFF      = 5 * time.Minute
+	FORWARD_TIMEOUT          = 30 * time.Second
+	MAX_INGEST_SIZE          = 8 << 20 // 8MB
+	MAX_INGEST_READINGS      = 5000
+)
+
+// A batch of readings posted by a forwarding meter to /api/v1/ingest
+type IngestBatch struct {
+	DeviceID string    `json:"deviceID"`
+	Readings []Reading `json:"readings"`
+}
+
+// Post queued readings to ForwardURL every interval.
+// Readings stay in the on-disk queue until the remote accepts them, so nothing is lost while offline.
+func (m *SLMeter) RunForwarder(interval time.Duration) {
+	if m.ForwardURL == "" {
+		return
+	} else if interval <= 0 {
+		interval = DEFAULT_FORWARD_INTERVAL
+	}
+	client := &http.Client{Timeout: FORWARD_TIMEOUT}
+	backoff := interval
+	for {
+		sent, err := m.forwardQueued(client)
+		if err != nil {
+			log.Println(fmt.Sprintf("Failed to forward readings to %s, retrying in %s: %s", m.ForwardURL, backoff, err.Error()))
+			time.Sleep(backoff)
+			backoff = min(backoff*2, FORWARD_MAX_BACKOFF)
+			continue
+		}
+		backoff = interval
+
+		// Catch up on a backlog without waiting
+		if sent < FORWARD_BATCH_SIZE {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// Post the oldest queued readings, and remove them from the queue once they're accepted
+func (m *SLMeter) forwardQueued(client *http.Client) (int, error) {
+	rows, err := m.ResultsDB.Query(`
+    SELECT id, COALESCE(sensor_id, ''), job_id, lux, full_spectrum, visible, infrared, cpu_temp, COALESCE(light_source, ''), CAST(created_at AS TEXT)
+    FROM forward_queue
+    ORDER BY id
+    LIMIT ?`, FORWARD_BATCH_SIZE)
+	if err != nil {
+		return 0, err
+	}
+	batch := IngestBatch{DeviceID: m.DeviceID, Readings: []Reading{}}
+	var lastID int64
+	for rows.Next() {
+		var reading Reading
+		var cpuTemp sql.NullFloat64
+		if err := rows.Scan(&lastID, &reading.SensorID, &reading.JobID, &reading.Lux, &reading.FullSpectrum, &reading.Visible, &reading.Infrared,
+			&cpuTemp, &reading.LightSource, &reading.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if cpuTemp.Valid {
+			reading.CPUTemp = &cpuTemp.Float64
+		}
+		batch.Readings = append(batch.Readings, reading)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	} else if len(batch.Readings) == 0 {
+		return 0, nil
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, m.ForwardURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.ForwardToken != "" {
+		req.Header.Set("Authorization", "Bearer "+m.ForwardToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	if _, err := m.ResultsDB.Exec("DELETE FROM forward_queue WHERE id <= ?", lastID); err != nil {
+		return 0, err
+	}
+	return len(batch.Readings), nil
+}
+
+// Accept a batch of readings from a forwarding meter, and record them under its device ID
+func (m *SLMeter) Ingest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_INGEST_SIZE)
+		var batch IngestBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("Invalid ingest batch: %s", err.Error()), http.StatusBadRequest)
+			return
+		} else if err := validateIngestBatch(batch); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("Invalid ingest batch: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		ingested, skipped, err := m.ingestReadings(batch)
+		if err != nil {
+			tools.RequestLog(r).WithError(err).Error(fmt.Sprintf("Failed to ingest readings from %s", batch.DeviceID))
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Println(fmt.Sprintf("Ingested %d readings from %s, skipped %d duplicates", ingested, batch.DeviceID, skipped))
+		serveJSON(w, http.StatusOK, struct {
+			DeviceID string `json:"deviceID"`
+			Ingested int    `json:"ingested"`
+			Skipped  int    `json:"skipped"`
+		}{
+			DeviceID: batch.DeviceID,
+			Ingested: ingested,
+			Skipped:  skipped,
+		})
+	}
+}
+
+func validateIngestBatch(batch IngestBatch) error {
+	if batch.DeviceID == "" {
+		return errors.New("missing deviceID")
+	} else if len(batch.Readings) > MAX_INGEST_READINGS {
+		return fmt.Errorf("more than %d readings", MAX_INGEST_READINGS)
+	}
+	for i, reading := range batch.Readings {
+		if reading.JobID == "" {
+			return fmt.Errorf("reading %d: missing jobID", i)
+		} else if _, err := time.Parse("2006-01-02 15:04:05", reading.CreatedAt); err != nil {
+			return fmt.Errorf("reading %d: invalid createdAt", i)
+		} else if reading.LightSource != "" && !slices.Contains(lightSourceClasses, reading.LightSource) {
+			return fmt.Errorf("reading %d: invalid lightSource", i)
+		}
+		err := validateResult(LuxResults{
+			Lux:          reading.Lux,
+			FullSpectrum: reading.FullSpectrum,
+			Visible:      reading.Visible,
+			Infrared:     reading.Infrared,
+		})
+		if err != nil {
+			return fmt.Errorf("reading %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Record every reading that isn't already recorded, keyed on device_id + sensor_id + job_id + created_at.
+// A retried batch is skipped rather than duplicated.
+func (m *SLMeter) ingestReadings(batch IngestBatch) (int, int, error) {
+	tx, err := m.ResultsDB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+    INSERT INTO sunlight (device_id, sensor_id, job_id, lux, full_spectrum, visible, infrared, cpu_temp, light_source, created_at)
+    SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+    WHERE NOT EXISTS (SELECT 1 FROM sunlight WHERE device_id = ? AND sensor_id IS ? AND job_id = ? AND created_at = ?)`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+
+	ingested, skipped := 0, 0
+	oldest := ""
+	for _, reading := range batch.Readings {
+		sensorID := sql.NullString{String: reading.SensorID, Valid: reading.SensorID != ""}
+		result, err := stmt.Exec(
+			batch.DeviceID,
+			sensorID,
+			reading.JobID,
+			fmt.Sprintf("%.5f", reading.Lux),
+			fmt.Sprintf("%.5e", reading.FullSpectrum),
+			fmt.Sprintf("%.5e", reading.Visible),
+			fmt.Sprintf("%.5e", reading.Infrared),
+			reading.CPUTemp,
+			sql.NullString{String: reading.LightSource, Valid: reading.LightSource != ""},
+			reading.CreatedAt,
+			batch.DeviceID,
+			sensorID,
+			reading.JobID,
+			reading.CreatedAt,
+		)
+		if err != nil {
+			return 0, 0, err
+		}
+		if count, _ := result.RowsAffected(); count > 0 {
+			ingested++
+			if oldest == "" || reading.CreatedAt < oldest {
+				oldest = reading.CreatedAt
+			}
+		} else {
+			skipped++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	// Late readings land in days that may already be summarized
+	if oldest != "" {
+		m.markSummaryStale(oldest)
+	}
+	return ingested, skipped, nil
+}
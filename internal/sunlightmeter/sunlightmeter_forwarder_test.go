@@ -0,0 +1,136 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type ingestResult struct {
+	DeviceID string `json:"deviceID"`
+	Ingested int    `json:"ingested"`
+	Skipped  int    `json:"skipped"`
+}
+
+func TestForwardRoundTrip(t *testing.T) {
+	central := &SLMeter{ResultsDB: newNamedTestDB(t, t.Name()+"_central")}
+	// The first batch is ingested, but its response is lost, as if the connection dropped
+	var posts atomic.Int32
+	results := make(chan ingestResult, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer slm_token" {
+			t.Errorf("got Authorization %q", r.Header.Get("Authorization"))
+		}
+		recorded := httptest.NewRecorder()
+		central.Ingest().ServeHTTP(recorded, r)
+		var result ingestResult
+		if err := json.Unmarshal(recorded.Body.Bytes(), &result); err != nil {
+			t.Errorf("got %d %s: %v", recorded.Code, recorded.Body.String(), err)
+		}
+		results <- result
+		if posts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(recorded.Code)
+		w.Write(recorded.Body.Bytes())
+	}))
+	defer srv.Close()
+
+	meter := &SLMeter{ResultsDB: newTestDB(t), DeviceID: "greenhouse", ForwardURL: srv.URL, ForwardToken: "slm_token"}
+	readAt := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	cpuTemp := 48.5
+	err := meter.insertResults([]LuxResults{
+		// Two sensors read at the same moment
+		{SensorID: "east", JobID: "job-1", Lux: 1200, FullSpectrum: 0.5, Visible: 0.4, Infrared: 0.1, CPUTemperature: &cpuTemp, LightSource: LIGHT_SOURCE_NATURAL, CreatedAt: readAt},
+		{SensorID: "west", JobID: "job-1", Lux: 800, FullSpectrum: 0.3, Visible: 0.25, Infrared: 0.05, LightSource: LIGHT_SOURCE_ARTIFICIAL, CreatedAt: readAt},
+		{JobID: "job-1", Lux: 500, FullSpectrum: 0.2, Visible: 0.15, Infrared: 0.05, CreatedAt: readAt.Add(time.Minute)},
+		// A saturated reading has no lux to forward
+		{SensorID: "east", JobID: "job-1", Saturated: true, CreatedAt: readAt.Add(2 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	if _, err := meter.forwardQueued(client); err == nil {
+		t.Fatal("want an error when the response is lost")
+	}
+	if result := <-results; result != (ingestResult{"greenhouse", 3, 0}) {
+		t.Errorf("got %+v, want all 3 ingested", result)
+	}
+	if queued := countRows(t, meter.ResultsDB, "SELECT COUNT(*) FROM forward_queue"); queued != 3 {
+		t.Fatalf("got %d queued, want the batch kept to retry", queued)
+	}
+
+	// The retry is skipped by the central instance, and clears the queue
+	if sent, err := meter.forwardQueued(client); err != nil || sent != 3 {
+		t.Fatalf("got %d sent, %v", sent, err)
+	}
+	if result := <-results; result != (ingestResult{"greenhouse", 0, 3}) {
+		t.Errorf("got %+v, want all 3 skipped", result)
+	}
+	if queued := countRows(t, meter.ResultsDB, "SELECT COUNT(*) FROM forward_queue"); queued != 0 {
+		t.Errorf("got %d queued, want none", queued)
+	}
+	if sent, err := meter.forwardQueued(client); err != nil || sent != 0 || posts.Load() != 2 {
+		t.Errorf("got %d sent, %v, %d posts, want nothing posted for an empty queue", sent, err, posts.Load())
+	}
+
+	// Each reading keeps its sensor, light source and temperature
+	rows, err := central.ResultsDB.Query("SELECT device_id, sensor_id, CAST(lux AS REAL), cpu_temp, light_source FROM sunlight ORDER BY created_at, sensor_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type row struct {
+		deviceID    string
+		sensorID    sql.NullString
+		lux         float64
+		cpuTemp     sql.NullFloat64
+		lightSource sql.NullString
+	}
+	want := []row{
+		{"greenhouse", sql.NullString{String: "east", Valid: true}, 1200, sql.NullFloat64{Float64: 48.5, Valid: true}, sql.NullString{String: LIGHT_SOURCE_NATURAL, Valid: true}},
+		{"greenhouse", sql.NullString{String: "west", Valid: true}, 800, sql.NullFloat64{}, sql.NullString{String: LIGHT_SOURCE_ARTIFICIAL, Valid: true}},
+		{"greenhouse", sql.NullString{}, 500, sql.NullFloat64{}, sql.NullString{}},
+	}
+	var got []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.deviceID, &r.sensorID, &r.lux, &r.cpuTemp, &r.lightSource); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("row %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestIngestRejectsInvalidBatches(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	reading := `{"jobID": "job-1", "lux": 100, "fullSpectrum": 0.1, "visible": 0.1, "infrared": 0, "createdAt": "2024-06-21 12:00:00"`
+	for name, body := range map[string]string{
+		"no device":               `{"readings": [` + reading + `}]}`,
+		"a light source":          `{"deviceID": "greenhouse", "readings": [` + reading + `, "lightSource": "candle"}]}`,
+		"a local time":            `{"deviceID": "greenhouse", "readings": [{"jobID": "job-1", "lux": 100, "createdAt": "2024-06-21T12:00:00"}]}`,
+		"a reading without a job": `{"deviceID": "greenhouse", "readings": [{"lux": 100, "createdAt": "2024-06-21 12:00:00"}]}`,
+	} {
+		w := httptest.NewRecorder()
+		m.Ingest().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package sunlightmeter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+// The sensor's gain and integration time
+type SensorConfig struct {
+	SensorID        string `json:"sensorID"`
+	Gain            string `json:"gain"`
+	IntegrationTime string `json:"integrationTime"`
+	JobID           string `json:"jobID,omitempty"` // The running job, its readings from now on use these settings
+}
+
+// Set the sensor's gain, integration time, or both, like gain=med&integration=300ms.
+// A running job keeps recording, and the change is logged as a gain_change event, so its readings before and after can be told apart.
+// Auto-gain still steps away from these settings when a reading saturates.
+func (m *SLMeter) SetSensorConfig() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.TSL2591 == nil {
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		}
+		gainValue, timingValue := r.FormValue("gain"), r.FormValue("integration")
+		if gainValue == "" && timingValue == "" {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Set gain, integration, or both", http.StatusBadRequest)
+			return
+		}
+		m.Lock()
+		gain, timing := m.Gain, m.Timing
+		m.Unlock()
+		newGain, newTiming := gain, timing
+		var err error
+		if gainValue != "" {
+			if newGain, err = tsl2591.ParseGain(gainValue); err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if timingValue != "" {
+			if newTiming, err = tsl2591.ParseIntegrationTime(timingValue); err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Both are written at once, under the driver's lock, so a running job's next read never sees a mix of old and new
+		err = m.withSensor(func() error {
+			return m.SetGainAndTiming(newGain, newTiming)
+		})
+		jobID := m.JobID()
+		m.logSensorRequest(r, "config", jobID, err)
+		if errors.Is(err, ErrSensorBusy) {
+			ServeError(w, r, tools.ERR_SENSOR_BUSY, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).WithError(err).Error("The sensor failed to set its gain and integration time")
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if newGain != gain || newTiming != timing {
+			m.logEvent(SENSOR_EVENT_GAIN_CHANGE, jobID, fmt.Sprintf("%s -> %s, set through the API", gainLabel(gain, timing), gainLabel(newGain, newTiming)))
+		}
+
+		serveJSON(w, http.StatusOK, SensorConfig{
+			SensorID:        m.SensorID,
+			Gain:            tsl2591.GainToString(newGain),
+			IntegrationTime: tsl2591.IntegrationTimeToString(newTiming),
+			JobID:           jobID,
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const DEFAULT_MIN_GAP = 10 * time.Minute
+
+// A stretch of time without any readings, how many reads failed during it, and why
+type Gap struct {
+	Start       string   `json:"start"`
+	End         string   `json:"end"`
+	Duration    string   `json:"duration"`
+	Seconds     float64  `json:"seconds"`
+	FailedReads int      `json:"failedReads"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+type GapReport struct {
+	DateRange        string  `json:"dateRange"`
+	MinGap           string  `json:"minGap"`
+	Gaps             []Gap   `json:"gaps"`
+	CoveredSeconds   float64 `json:"coveredSeconds"`
+	UncoveredSeconds float64 `json:"uncoveredSeconds"`
+}
+
+// Serve each gap in the recording longer than min_gap, and how much of the date range was covered
+func (m *SLMeter) ServeGaps() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		minGap, err := parseMinGap(r.FormValue("min_gap"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		report := GapReport{
+			DateRange: fmt.Sprintf("%s - %s UTC", startDate, endDate),
+			MinGap:    minGap.String(),
+			Gaps:      []Gap{},
+		}
+		covered, uncovered, err := m.findGaps(startDate, endDate, scopeFromRequest(r), minGap, func(gap Gap) {
+			report.Gaps = append(report.Gaps, gap)
+		})
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report.CoveredSeconds = covered.Seconds()
+		report.UncoveredSeconds = uncovered.Seconds()
+		serveJSON(w, http.StatusOK, report)
+	}
+}
+
+func parseMinGap(value string) (time.Duration, error) {
+	if value == "" {
+		return DEFAULT_MIN_GAP, nil
+	}
+	minGap, err := time.ParseDuration(value)
+	if err != nil || minGap <= 0 {
+		return 0, fmt.Errorf("Invalid min_gap, expected a positive duration like 10m")
+	}
+	return minGap, nil
+}
+
+// Walk the readings in the date range in order, calling found with each gap longer than minGap.
+// Readings are streamed, so the range can be any size. Job boundaries are ignored, a gap between two jobs is still a gap.
+// The stretches before the first reading and after the last count too.
+func (m *SLMeter) findGaps(startDate string, endDate string, scope readingScope, minGap time.Duration, found func(Gap)) (time.Duration, time.Duration, error) {
+	start, end, err := startAndEndDateToTime(startDate, endDate)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Failed reads are loaded up front, so each gap can say whether the sensor was failing or not running
+	failures, err := m.getReadFailures(startDate, endDate, scope)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	filter, filterArgs := m.scopeFilter(scope)
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT CAST(created_at AS TEXT)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    ORDER BY created_at`, append([]interface{}{startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var uncovered time.Duration
+	check := func(from time.Time, to time.Time) {
+		if gap := to.Sub(from); gap > minGap {
+			uncovered += gap
+			start, end := from.Format("2006-01-02 15:04:05"), to.Format("2006-01-02 15:04:05")
+			failed := failuresBetween(failures, start, end)
+			found(Gap{
+				Start:       start,
+				End:         end,
+				Duration:    gap.String(),
+				Seconds:     gap.Seconds(),
+				FailedReads: len(failed),
+				Errors:      distinctErrors(failed),
+			})
+		}
+	}
+
+	last := start
+	for rows.Next() {
+		var createdAt string
+		if err := rows.Scan(&createdAt); err != nil {
+			return 0, 0, err
+		}
+		at, err := time.Parse("2006-01-02 15:04:05", createdAt)
+		if err != nil {
+			log.Println(fmt.Sprintf("Skipping reading with invalid created_at %q: %s", createdAt, err.Error()))
+			continue
+		}
+		check(last, at)
+		last = at
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	check(last, end)
+
+	covered := end.Sub(start) - uncovered
+	if covered < 0 {
+		covered = 0
+	}
+	return covered, uncovered, nil
+}
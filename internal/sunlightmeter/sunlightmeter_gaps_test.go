@@ -0,0 +1,54 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// A gap while the sensor was failing says how often and why, a gap while nothing was recording has no failures
+func TestServeGapsAttributesFailedReads(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t), DeviceID: "test-device"}
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, inputLocation())
+	at := func(minutes int) time.Time { return start.UTC().Add(time.Duration(minutes) * time.Minute) }
+
+	var results []LuxResults
+	for _, minute := range []int{0, 1, 2, 30, 31, 32} {
+		results = append(results, LuxResults{Lux: 100, JobID: "job-1", CreatedAt: at(minute)})
+	}
+	if err := m.insertResults(results); err != nil {
+		t.Fatal(err)
+	}
+	for _, failure := range []struct {
+		minute int
+		error  string
+	}{{3, "remote I/O error"}, {4, "remote I/O error"}, {10, "no such device"}, {11, "remote I/O error"}} {
+		m.recordReadFailure(LuxResults{JobID: "job-1", Error: failure.error, CreatedAt: at(failure.minute)})
+	}
+
+	query := url.Values{"start": {timeToInputDate(start)}, "end": {timeToInputDate(start.Add(time.Hour))}, "min_gap": {"10m"}}
+	w := serveAPIRequest(m.ServeGaps(), http.MethodGet, "/gaps?"+query.Encode())
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+	var report GapReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Gaps) != 2 {
+		t.Fatalf("got %+v, want the failing stretch and the end of the range", report.Gaps)
+	}
+	failing, idle := report.Gaps[0], report.Gaps[1]
+	if failing.Start != at(2).Format("2006-01-02 15:04:05") || failing.End != at(30).Format("2006-01-02 15:04:05") || failing.FailedReads != 4 {
+		t.Errorf("got %+v, want 4 failed reads from 12:02 to 12:30", failing)
+	}
+	if want := []string{"remote I/O error", "no such device"}; !slices.Equal(failing.Errors, want) {
+		t.Errorf("got errors %q, want %q", failing.Errors, want)
+	}
+	if idle.FailedReads != 0 || idle.Errors != nil {
+		t.Errorf("got %+v, want no failures after the last reading", idle)
+	}
+}
@@ -0,0 +1,359 @@
+package sunlightmeter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	GRAPH_PNG_WIDTH     = 800
+	GRAPH_PNG_HEIGHT    = 400
+	GRAPH_PNG_MIN_SIZE  = 200
+	GRAPH_PNG_MAX_SIZE  = 4000
+	GRAPH_PNG_RANGE     = 24 * time.Hour
+	GRAPH_PNG_CACHE_TTL = RECORD_INTERVAL // A new reading could have arrived after this
+)
+
+// The most recent graph.png or graph.svg render, reused while the same URL is asked for again within GRAPH_PNG_CACHE_TTL
+type graphPNGCache struct {
+	mu         sync.Mutex
+	key        string
+	renderedAt time.Time
+	png        []byte
+}
+
+// Serve the results graph as a PNG, for embedding in emails and dashboards.
+// It charts the same series as the HTML graph, with the reference bands, over the last GRAPH_PNG_RANGE by default.
+func (m *SLMeter) ServeResultsGraphPNG() http.HandlerFunc {
+	return m.serveResultsGraphImage(func(w io.Writer, graph graphImage, theme string) error {
+		return png.Encode(w, renderGraphPNG(graph, theme))
+	}, "image/png")
+}
+
+// Serve the results graph as an SVG, which stays sharp at any size and keeps its labels as text
+func (m *SLMeter) ServeResultsGraphSVG() http.HandlerFunc {
+	return m.serveResultsGraphImage(renderGraphSVG, "image/svg+xml")
+}
+
+func (m *SLMeter) serveResultsGraphImage(render func(w io.Writer, graph graphImage, theme string) error, contentType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		width, err := parseGraphSize(r.FormValue("width"), GRAPH_PNG_WIDTH)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid width: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		height, err := parseGraphSize(r.FormValue("height"), GRAPH_PNG_HEIGHT)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid height: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		theme, err := parseGraphTheme(r.FormValue("theme"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ymax, err := parseGraphYMax(r.FormValue("ymax"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateDateRange(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The PNG and SVG of the same range are cached apart
+		key := r.URL.Path + "?" + r.URL.Query().Encode()
+		if cached, ok := m.graphPNG.get(key); ok {
+			w.Header().Set("Content-Type", contentType)
+			w.Write(cached)
+			return
+		}
+
+		startDate, endDate, scope, err := m.rangeFromRequest(r)
+		if errors.Is(err, ErrJobNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if scope.JobID == "" && !hasDateRange(r) {
+			now := time.Now().UTC()
+			startDate, endDate = now.Add(-GRAPH_PNG_RANGE).Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05")
+		}
+		start, end, err := startAndEndDateToTime(startDate, endDate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		series, _, err := m.getResultsGraphSeries(startDate, endDate, scope)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		series = series.inUnits(units)
+		if ymax > 0 {
+			series.maxLux = int(math.Ceil(ymax))
+		}
+
+		var buf bytes.Buffer
+		if err := render(&buf, newGraphImage(series, start, end, width, height, units), theme); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.graphPNG.put(key, buf.Bytes())
+		w.Header().Set("Content-Type", contentType)
+		w.Write(buf.Bytes())
+	}
+}
+
+func parseGraphSize(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < GRAPH_PNG_MIN_SIZE || size > GRAPH_PNG_MAX_SIZE {
+		return 0, fmt.Errorf("expected a number of pixels from %d to %d", GRAPH_PNG_MIN_SIZE, GRAPH_PNG_MAX_SIZE)
+	}
+	return size, nil
+}
+
+func (c *graphPNGCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.key != key || time.Since(c.renderedAt) > GRAPH_PNG_CACHE_TTL {
+		return nil, false
+	}
+	return c.png, true
+}
+
+func (c *graphPNGCache) put(key string, png []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key, c.renderedAt, c.png = key, time.Now(), png
+}
+
+// The colors of the bands in the HTML graph, by their CSS names
+var graphPNGColors = map[string]color.RGBA{
+	"DarkGrey":   {169, 169, 169, 255},
+	"WhiteSmoke": {245, 245, 245, 255},
+	"Silver":     {192, 192, 192, 255},
+	"SkyBlue":    {135, 206, 235, 255},
+	"Yellow":     {255, 255, 0, 255},
+}
+
+// The colors of a graph image. Every theme but light is drawn on the chalk background.
+type graphPalette struct {
+	background color.RGBA
+	foreground color.RGBA
+	grid       color.RGBA
+	line       color.RGBA
+}
+
+func graphPaletteFor(theme string) graphPalette {
+	if theme == GRAPH_THEME_LIGHT {
+		return graphPalette{
+			background: color.RGBA{255, 255, 255, 255},
+			foreground: color.RGBA{51, 51, 51, 255},
+			grid:       color.RGBA{224, 230, 241, 255},
+			line:       color.RGBA{84, 112, 198, 255},
+		}
+	}
+	return graphPalette{
+		background: color.RGBA{41, 52, 65, 255},
+		foreground: color.RGBA{238, 238, 238, 255},
+		grid:       color.RGBA{72, 84, 98, 255},
+		line:       color.RGBA{252, 151, 175, 255},
+	}
+}
+
+// Where everything on a graph image goes, shared by the PNG and SVG renderers
+type graphImage struct {
+	series graphSeries
+	start  time.Time
+	end    time.Time
+	width  int
+	height int
+	units  string
+	// The plot area, leaving room for the labels
+	left, right, top, bottom int
+	maxY                     float64
+}
+
+const (
+	GRAPH_IMAGE_Y_TICKS = 5
+	GRAPH_IMAGE_X_TICKS = 4
+)
+
+func newGraphImage(series graphSeries, start time.Time, end time.Time, width int, height int, units string) graphImage {
+	graph := graphImage{
+		series: series, start: start, end: end, width: width, height: height, units: units,
+		left: 70, right: width - 20, top: 30, bottom: height - 40,
+		maxY: float64(series.maxLux),
+	}
+	if graph.maxY <= 0 {
+		graph.maxY = convertLux(1000, units)
+	}
+	return graph
+}
+
+func (g graphImage) x(at time.Time) int {
+	span := g.end.Sub(g.start).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+	return g.left + int(float64(g.right-g.left)*at.Sub(g.start).Seconds()/span)
+}
+
+// Values above the axis are clipped to its top
+func (g graphImage) y(value float64) int {
+	return g.bottom - int(float64(g.bottom-g.top)*math.Min(value, g.maxY)/g.maxY)
+}
+
+// The values of the horizontal grid lines
+func (g graphImage) yTicks() []float64 {
+	ticks := make([]float64, 0, GRAPH_IMAGE_Y_TICKS+1)
+	for i := 0; i <= GRAPH_IMAGE_Y_TICKS; i++ {
+		ticks = append(ticks, g.maxY*float64(i)/GRAPH_IMAGE_Y_TICKS)
+	}
+	return ticks
+}
+
+// The times along the bottom, with their labels, as dates once the range is over a couple of days
+func (g graphImage) xTicks() ([]time.Time, []string) {
+	layout := "15:04"
+	if g.end.Sub(g.start) > 48*time.Hour {
+		layout = "01-02"
+	}
+	var times []time.Time
+	var labels []string
+	for i := 0; i <= GRAPH_IMAGE_X_TICKS; i++ {
+		at := g.start.Add(time.Duration(float64(g.end.Sub(g.start)) * float64(i) / GRAPH_IMAGE_X_TICKS))
+		times, labels = append(times, at), append(labels, at.Format(layout))
+	}
+	return times, labels
+}
+
+// The reference bands that fall inside the axis, with their colors
+func (g graphImage) levels(theme string) ([]float64, []color.RGBA) {
+	var values []float64
+	var colors []color.RGBA
+	for _, level := range graphLevels {
+		value := convertLux(float64(level.Lux), g.units)
+		if value > g.maxY {
+			continue
+		}
+		values, colors = append(values, value), append(colors, graphPNGColors[level.color(theme)])
+	}
+	return values, colors
+}
+
+// The series as points on the image, points that can't be placed are skipped
+func (g graphImage) points() []image.Point {
+	points := make([]image.Point, 0, len(g.series.lux))
+	for i, data := range g.series.lux {
+		value, ok := data.Value.(float64)
+		if !ok || i >= len(g.series.times) {
+			continue
+		}
+		at, err := parseGraphTime(g.series.times[i])
+		if err != nil {
+			continue
+		}
+		points = append(points, image.Pt(g.x(at), g.y(value)))
+	}
+	return points
+}
+
+// The series times are readings, or days when it's the daily summaries
+func parseGraphTime(value string) (time.Time, error) {
+	if at, err := time.Parse("2006-01-02 15:04:05", value); err == nil {
+		return at, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Where a label sits against its point, like SVG's text-anchor and dominant-baseline
+type textAnchor int
+
+const (
+	anchorStart textAnchor = iota
+	anchorMiddle
+	anchorEnd
+)
+
+type textBaseline int
+
+const (
+	baselineTop textBaseline = iota
+	baselineMiddle
+	baselineBottom
+)
+
+// What a graph image is drawn on. graphImage.draw lays the whole graph out once, in pixels,
+// so the PNG and SVG only differ in how they put down lines and text.
+// That's too little to need a plotting library, gonum/plot would add itself, its fonts,
+// and its PDF, EPS and TeX backends to the binary on the Pi.
+type graphCanvas interface {
+	fill(c color.RGBA)
+	line(x0 int, y0 int, x1 int, y1 int, c color.RGBA)
+	polyline(points []image.Point, c color.RGBA)
+	text(x int, y int, text string, anchor textAnchor, baseline textBaseline, c color.RGBA)
+}
+
+// Draw the series between start and end, with the reference bands and labelled axes
+func (g graphImage) draw(canvas graphCanvas, theme string) {
+	palette := graphPaletteFor(theme)
+	canvas.fill(palette.background)
+
+	// Horizontal grid lines, with their values
+	for _, value := range g.yTicks() {
+		canvas.line(g.left, g.y(value), g.right, g.y(value), palette.grid)
+		canvas.text(g.left-8, g.y(value), strconv.FormatFloat(value, 'f', -1, 64), anchorEnd, baselineMiddle, palette.foreground)
+	}
+	canvas.text(g.left, g.top-8, unitsLabel(g.units), anchorStart, baselineBottom, palette.foreground)
+
+	times, labels := g.xTicks()
+	for i, at := range times {
+		// The first and last labels are kept inside the image
+		anchor := anchorMiddle
+		if i == 0 {
+			anchor = anchorStart
+		} else if i == len(times)-1 {
+			anchor = anchorEnd
+		}
+		canvas.text(g.x(at), g.bottom+10, labels[i], anchor, baselineTop, palette.foreground)
+	}
+	canvas.line(g.left, g.bottom, g.right, g.bottom, palette.foreground)
+	canvas.line(g.left, g.top, g.left, g.bottom, palette.foreground)
+
+	values, colors := g.levels(theme)
+	for i, value := range values {
+		canvas.line(g.left, g.y(value), g.right, g.y(value), colors[i])
+	}
+
+	if points := g.points(); len(points) > 0 {
+		canvas.polyline(points, palette.line)
+	}
+}
@@ -0,0 +1,185 @@
+package sunlightmeter
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden graph images in testdata with the current output")
+
+// A clear day from start, a reading every 15 minutes, rising from 06:00 to 30,000 lux at 13:00 and setting at 20:00
+func clearDaySeries(start time.Time, days int) graphSeries {
+	var series graphSeries
+	for i := 0; i <= days*96; i++ {
+		at := start.Add(time.Duration(i) * 15 * time.Minute)
+		hour := float64(i%96) / 4
+		lux := 0.0
+		if hour > 6 && hour < 20 {
+			lux = 30000 * (1 - (hour-13)*(hour-13)/49)
+		}
+		series.addLux(lux, at.Format("2006-01-02 15:04:05"))
+	}
+	return series
+}
+
+func TestGraphImageGolden(t *testing.T) {
+	start := time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC)
+	clipped := clearDaySeries(start, 1)
+	clipped.maxLux = 12000
+
+	tests := []struct {
+		name   string
+		graph  graphImage
+		theme  string
+		golden string
+	}{
+		{"a day", newGraphImage(clearDaySeries(start, 1), start, start.Add(24*time.Hour), 800, 400, UNITS_LUX), GRAPH_THEME_LIGHT, "day_light"},
+		{"a dark theme", newGraphImage(clearDaySeries(start, 1), start, start.Add(24*time.Hour), 800, 400, UNITS_LUX), "chalk", "day_chalk"},
+		{"foot-candles", newGraphImage(clearDaySeries(start, 1).inUnits(UNITS_FOOT_CANDLES), start, start.Add(24*time.Hour), 600, 300, UNITS_FOOT_CANDLES), GRAPH_THEME_LIGHT, "day_fc"},
+		{"a week, labelled by date", newGraphImage(clearDaySeries(start, 7).inUnits(UNITS_WATTS), start, start.Add(7*24*time.Hour), 800, 300, UNITS_WATTS), "chalk", "week_watts"},
+		{"clipped at ymax", newGraphImage(clipped, start, start.Add(24*time.Hour), 400, 200, UNITS_LUX), GRAPH_THEME_LIGHT, "day_clipped"},
+		{"no readings", newGraphImage(graphSeries{}, start, start.Add(24*time.Hour), 400, 200, UNITS_LUX), GRAPH_THEME_LIGHT, "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var encoded bytes.Buffer
+			if err := png.Encode(&encoded, renderGraphPNG(tt.graph, tt.theme)); err != nil {
+				t.Fatal(err)
+			}
+			assertGoldenPNG(t, filepath.Join("testdata", "graph", tt.golden+".png"), encoded.Bytes())
+
+			var svg bytes.Buffer
+			if err := renderGraphSVG(&svg, tt.graph, tt.theme); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("testdata", "graph", tt.golden+".svg"), svg.Bytes())
+		})
+	}
+}
+
+// Compare an image with its golden copy pixel by pixel, so a change in how the PNG is compressed doesn't fail it
+func assertGoldenPNG(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		writeGolden(t, path, got)
+		return
+	}
+	want := readGoldenPNG(t, path)
+	img, err := png.Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != want.Bounds() {
+		t.Fatalf("got a %v image, want %v, run go test -update to accept it", img.Bounds(), want.Bounds())
+	}
+	differ, first := 0, image.Point{-1, -1}
+	for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+		for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+			if img.At(x, y) != want.At(x, y) {
+				if differ == 0 {
+					first = image.Pt(x, y)
+				}
+				differ++
+			}
+		}
+	}
+	if differ > 0 {
+		t.Errorf("%d pixels differ from %s, the first at %v, run go test -update to accept them", differ, path, first)
+	}
+}
+
+func readGoldenPNG(t *testing.T, path string) image.Image {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func assertGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		writeGolden(t, path, got)
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n%s\nwant the contents of %s, run go test -update to accept it", got, path)
+	}
+}
+
+func writeGolden(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServeResultsGraphImage(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, inputLocation())
+	seedReadings(t, m, start.UTC(), 11)
+	query := url.Values{"start": {timeToInputDate(start)}, "end": {timeToInputDate(start.Add(10 * time.Minute))}}
+
+	serve := func(h http.HandlerFunc, path string, query url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil))
+		return w
+	}
+
+	sized := url.Values{"width": {"300"}, "height": {"200"}}
+	for key, values := range query {
+		sized[key] = values
+	}
+	w := serve(m.ServeResultsGraphPNG(), "/sunlightmeter/graph.png", sized)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("got %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 300, 200) {
+		t.Errorf("got a %v image, want 300x200", img.Bounds())
+	}
+
+	// The SVG of the same range is its own entry in the cache
+	w = serve(m.ServeResultsGraphSVG(), "/sunlightmeter/graph.svg", sized)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" || !strings.HasPrefix(w.Body.String(), `<svg xmlns="http://www.w3.org/2000/svg" width="300" height="200"`) {
+		t.Errorf("got %d, %s, %.80s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	for _, bad := range []url.Values{
+		{"width": {"10"}},
+		{"height": {"100000"}},
+		{"width": {"wide"}},
+		{"ymax": {"0"}},
+		{"theme": {"neon"}},
+	} {
+		if w := serve(m.ServeResultsGraphPNG(), "/sunlightmeter/graph.png", bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", bad.Encode(), w.Code, http.StatusBadRequest)
+		}
+	}
+}
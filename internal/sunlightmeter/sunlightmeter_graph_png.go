@@ -0,0 +1,138 @@
+package sunlightmeter
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode/utf8"
+)
+
+// Rasterize the graph, with its labels in graphFont
+func renderGraphPNG(graph graphImage, theme string) *image.RGBA {
+	canvas := pngCanvas{image.NewRGBA(image.Rect(0, 0, graph.width, graph.height))}
+	graph.draw(canvas, theme)
+	return canvas.img
+}
+
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (c pngCanvas) fill(color color.RGBA) {
+	draw.Draw(c.img, c.img.Bounds(), &image.Uniform{color}, image.Point{}, draw.Src)
+}
+
+func (c pngCanvas) line(x0 int, y0 int, x1 int, y1 int, color color.RGBA) {
+	drawLine(c.img, x0, y0, x1, y1, color)
+}
+
+func (c pngCanvas) polyline(points []image.Point, color color.RGBA) {
+	for i := 1; i < len(points); i++ {
+		drawLine(c.img, points[i-1].X, points[i-1].Y, points[i].X, points[i].Y, color)
+	}
+}
+
+func (c pngCanvas) text(x int, y int, text string, anchor textAnchor, baseline textBaseline, color color.RGBA) {
+	switch anchor {
+	case anchorMiddle:
+		x -= textWidth(text) / 2
+	case anchorEnd:
+		x -= textWidth(text)
+	}
+	switch baseline {
+	case baselineMiddle:
+		y -= textHeight / 2
+	case baselineBottom:
+		y -= textHeight
+	}
+	drawText(c.img, x, y, text, color)
+}
+
+// Draw a line between two points, with Bresenham's algorithm
+func drawLine(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		img.SetRGBA(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * err; e2 >= dy {
+			err += dy
+			x0 += sx
+		} else {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// A 3x5 pixel font, with only the characters the axis labels need. Each glyph is drawn at textScale.
+var graphFont = map[rune][5]string{
+	'0': {"111", "101", "101", "101", "111"},
+	'1': {"010", "110", "010", "010", "111"},
+	'2': {"111", "001", "111", "100", "111"},
+	'3': {"111", "001", "111", "001", "111"},
+	'4': {"101", "101", "111", "001", "001"},
+	'5': {"111", "100", "111", "001", "111"},
+	'6': {"111", "100", "111", "101", "111"},
+	'7': {"111", "001", "001", "001", "001"},
+	'8': {"111", "101", "111", "101", "111"},
+	'9': {"111", "101", "111", "001", "111"},
+	':': {"000", "010", "000", "010", "000"},
+	'-': {"000", "000", "111", "000", "000"},
+	'.': {"000", "000", "000", "000", "010"},
+	'/': {"001", "001", "010", "100", "100"},
+	'²': {"110", "001", "010", "111", "000"},
+	'F': {"111", "100", "110", "100", "100"},
+	'L': {"100", "100", "100", "100", "111"},
+	'W': {"101", "101", "101", "111", "101"},
+	'a': {"000", "110", "011", "101", "111"},
+	'c': {"000", "111", "100", "100", "111"},
+	'd': {"001", "001", "111", "101", "111"},
+	'e': {"000", "111", "111", "100", "111"},
+	'l': {"110", "010", "010", "010", "111"},
+	'm': {"000", "110", "111", "101", "101"},
+	'n': {"000", "110", "101", "101", "101"},
+	'o': {"000", "111", "101", "101", "111"},
+	's': {"000", "011", "110", "001", "110"},
+	't': {"010", "111", "010", "010", "011"},
+	'u': {"000", "101", "101", "101", "111"},
+	'x': {"000", "101", "010", "101", "101"},
+}
+
+const (
+	textScale  = 2
+	textHeight = 5 * textScale
+)
+
+func textWidth(text string) int {
+	return utf8.RuneCountInString(text) * 4 * textScale
+}
+
+func drawText(img *image.RGBA, x int, y int, text string, c color.RGBA) {
+	for _, char := range text {
+		for row, bits := range graphFont[char] {
+			for col, bit := range bits {
+				if bit == '1' {
+					draw.Draw(img, image.Rect(x+col*textScale, y+row*textScale, x+(col+1)*textScale, y+(row+1)*textScale), &image.Uniform{c}, image.Point{}, draw.Src)
+				}
+			}
+		}
+		x += 4 * textScale
+	}
+}
@@ -0,0 +1,57 @@
+package sunlightmeter
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Write the graph as an SVG, which stays sharp at any size and keeps its labels as text
+func renderGraphSVG(w io.Writer, graph graphImage, theme string) error {
+	canvas := svgCanvas{bufio.NewWriter(w)}
+	fmt.Fprintf(canvas.out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		graph.width, graph.height, graph.width, graph.height)
+	graph.draw(canvas, theme)
+	canvas.out.WriteString("</svg>\n")
+	return canvas.out.Flush()
+}
+
+type svgCanvas struct {
+	out *bufio.Writer
+}
+
+var (
+	svgTextAnchors   = map[textAnchor]string{anchorStart: "start", anchorMiddle: "middle", anchorEnd: "end"}
+	svgTextBaselines = map[textBaseline]string{baselineTop: "hanging", baselineMiddle: "middle", baselineBottom: "auto"}
+)
+
+func (c svgCanvas) fill(color color.RGBA) {
+	fmt.Fprintf(c.out, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", svgColor(color))
+}
+
+func (c svgCanvas) line(x0 int, y0 int, x1 int, y1 int, color color.RGBA) {
+	fmt.Fprintf(c.out, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s"/>`+"\n", x0, y0, x1, y1, svgColor(color))
+}
+
+func (c svgCanvas) polyline(points []image.Point, color color.RGBA) {
+	fmt.Fprintf(c.out, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="`, svgColor(color))
+	for i, point := range points {
+		if i > 0 {
+			c.out.WriteByte(' ')
+		}
+		fmt.Fprintf(c.out, "%d,%d", point.X, point.Y)
+	}
+	c.out.WriteString("\"/>\n")
+}
+
+func (c svgCanvas) text(x int, y int, text string, anchor textAnchor, baseline textBaseline, color color.RGBA) {
+	fmt.Fprintf(c.out, `<text x="%d" y="%d" text-anchor="%s" dominant-baseline="%s" fill="%s">%s</text>`+"\n",
+		x, y, svgTextAnchors[anchor], svgTextBaselines[baseline], svgColor(color), html.EscapeString(text))
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,157 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// The charts the results graph can be drawn as
+const (
+	GRAPH_CHART_LINE    = "line"
+	GRAPH_CHART_HEATMAP = "heatmap"
+	GRAPH_CHART_DAILY   = "daily"
+)
+
+func parseGraphChart(value string) (string, error) {
+	switch value {
+	case "", GRAPH_CHART_LINE:
+		return GRAPH_CHART_LINE, nil
+	case GRAPH_CHART_HEATMAP, GRAPH_CHART_DAILY:
+		return value, nil
+	}
+	return "", fmt.Errorf("Invalid chart, expected one of %s, %s, %s", GRAPH_CHART_LINE, GRAPH_CHART_HEATMAP, GRAPH_CHART_DAILY)
+}
+
+// From dark to full sun, anything brighter gets the last color
+var heatmapColors = []string{"#0b1d3a", "#1f4e79", "#3a8fb7", "#9ccc65", "#ffeb3b"}
+
+// The average lux of each hour of each day in the range, in the summary timezone
+type heatmapBuckets struct {
+	dates []string
+	cells []opts.HeatMapData
+}
+
+// Average the readings in the date range into hour by day buckets.
+// Readings are grouped by UTC hour in sqlite first, so a timezone offset by half an hour lands each bucket half an hour early.
+func (m *SLMeter) getHeatmapBuckets(startDate string, endDate string, scope readingScope, units string) (heatmapBuckets, error) {
+	buckets := heatmapBuckets{}
+	start, end, err := startAndEndDateToTime(startDate, endDate)
+	if err != nil {
+		return buckets, err
+	}
+	loc := m.summaryLocation()
+
+	// Every day in the range gets a column, so days without readings show up as gaps
+	columns := map[string]int{}
+	for day := start.In(loc); !day.After(end.In(loc)); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		columns[date] = len(buckets.dates)
+		buckets.dates = append(buckets.dates, date)
+	}
+	if last := end.In(loc).Format("2006-01-02"); len(buckets.dates) == 0 || buckets.dates[len(buckets.dates)-1] != last {
+		columns[last] = len(buckets.dates)
+		buckets.dates = append(buckets.dates, last)
+	}
+
+	filter, filterArgs := m.readingFilter(scope)
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT strftime('%Y-%m-%d %H:00:00', created_at), SUM(CAST(lux AS REAL)), COUNT(*)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    GROUP BY 1`, append([]interface{}{startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return buckets, err
+	}
+	defer rows.Close()
+
+	type total struct {
+		lux   float64
+		count int
+	}
+	totals := map[[2]int]*total{}
+	for rows.Next() {
+		var hour string
+		var lux float64
+		var count int
+		if err := rows.Scan(&hour, &lux, &count); err != nil {
+			return buckets, err
+		}
+		at, err := time.Parse("2006-01-02 15:04:05", hour)
+		if err != nil {
+			continue
+		}
+		at = at.In(loc)
+		column, ok := columns[at.Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		// Two UTC hours can land on the same local hour when the clocks go back
+		key := [2]int{column, at.Hour()}
+		if totals[key] == nil {
+			totals[key] = &total{}
+		}
+		totals[key].lux += lux
+		totals[key].count += count
+	}
+	if err := rows.Err(); err != nil {
+		return buckets, err
+	}
+	for key, total := range totals {
+		average := convertLux(total.lux/float64(total.count), units)
+		buckets.cells = append(buckets.cells, opts.HeatMapData{Value: [3]interface{}{key[0], key[1], math.Round(average*10) / 10}})
+	}
+	return buckets, nil
+}
+
+// Draw the average lux of each hour of each day in the range, with full sun at the top of the color ramp
+func (m *SLMeter) renderHeatmap(w http.ResponseWriter, startDate string, endDate string, scope readingScope, theme string, units string) error {
+	buckets, err := m.getHeatmapBuckets(startDate, endDate, scope, units)
+	if err != nil {
+		return err
+	}
+	hours := make([]string, 24)
+	for hour := range hours {
+		hours[hour] = fmt.Sprintf("%02d:00", hour)
+	}
+
+	heatmap := charts.NewHeatMap()
+	heatmap.SetGlobalOptions(
+		charts.WithInitializationOpts(graphInitialization(theme)),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name:      "Date",
+			Type:      "category",
+			Data:      buckets.dates, // HeatMap.SetXAxis is never applied to the axis
+			SplitArea: &opts.SplitArea{Show: true},
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name:      fmt.Sprintf("Hour (%s)", m.summaryLocation().String()),
+			Type:      "category",
+			Data:      hours,
+			SplitArea: &opts.SplitArea{Show: true},
+		}),
+		charts.WithVisualMapOpts(opts.VisualMap{
+			Calculable: true,
+			Min:        0,
+			Max:        float32(convertLux(FULL_SUN_LUX, units)),
+			Text:       []string{fmt.Sprintf("Full Sun (%.0f %s)", convertLux(FULL_SUN_LUX, units), unitsAbbreviation(units)), "0"},
+			InRange:    &opts.VisualMapInRange{Color: heatmapColors},
+			Orient:     "horizontal",
+			Left:       "center",
+			Bottom:     "0",
+		}),
+		charts.WithTooltipOpts(opts.Tooltip{
+			Show: true,
+		}),
+	)
+	heatmap.AddSeries(fmt.Sprintf("Average %s", unitsLabel(units)), buckets.cells)
+
+	page := m.echartsPage(heatmap)
+	w.Header().Set("Content-Type", "text/html")
+	return page.Render(w)
+}
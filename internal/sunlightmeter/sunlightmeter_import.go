@@ -0,0 +1,227 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	MAX_IMPORT_SIZE   = 256 << 20 // 256MB
+	IMPORT_BATCH_SIZE = 1000
+)
+
+var importColumns = []string{"job_id", "lux", "full_spectrum", "visible", "infrared", "created_at"}
+
+// Copied when the uploaded db has them, dbs from before they were added import them as NULL
+var optionalImportColumns = []string{"device_id", "sensor_id", "cpu_temp", "light_source"}
+
+// Merge the readings from a previously exported db into the live db
+func (m *SLMeter) ImportResultsDB() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_IMPORT_SIZE)
+		file, _, err := r.FormFile("db")
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("Failed to read the uploaded db: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		// Sqlite needs a file on disk to open
+		tmpFile, err := os.CreateTemp("", "slm-import-*.db")
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmpFile.Name())
+		_, err = io.Copy(tmpFile, file)
+		tmpFile.Close()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		imported, skipped, err := m.importResults(tmpFile.Name())
+		if err != nil {
+			// The batches before the failure are kept, importing the db again skips them
+			tools.RequestLog(r).WithError(err).Error(fmt.Sprintf("Failed to import db after importing %d rows", imported))
+			ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("Failed to import db after importing %d rows: %s", imported, err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		log.Println(fmt.Sprintf("Imported %d rows, skipped %d duplicates", imported, skipped))
+		ServeResponse(w, r, fmt.Sprintf("Imported %d rows, skipped %d duplicates", imported, skipped), http.StatusOK)
+	}
+}
+
+// Copy every sunlight row that isn't already recorded, keyed on job_id + created_at, and the device and sensor when the
+// uploaded db has them. Rows are committed IMPORT_BATCH_SIZE at a time, so a large import doesn't hold up the recorder.
+func (m *SLMeter) importResults(path string) (int, int, error) {
+	importDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer importDB.Close()
+
+	columns, err := validateImportSchema(importDB)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Select created_at as text, so the stored format is preserved.
+	// Columns older dbs don't have are imported as NULL.
+	query := "SELECT job_id, lux, full_spectrum, visible, infrared, CAST(created_at AS TEXT)"
+	for _, column := range optionalImportColumns {
+		if columns[column] {
+			query += ", " + column
+		} else {
+			query += ", NULL"
+		}
+	}
+	query += " FROM sunlight"
+	// Saturated readings have no lux to import, older dbs don't flag them
+	if columns["saturated"] {
+		query += " WHERE saturated = 0"
+	}
+	rows, err := importDB.Query(query + " ORDER BY id")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	imported, skipped := 0, 0
+	oldest := ""
+	// Imported days need summarizing again, including those of batches committed before a failure
+	defer func() {
+		if oldest != "" {
+			m.markSummaryStale(oldest)
+		}
+	}()
+	batch := make([]importedRow, 0, IMPORT_BATCH_SIZE)
+	importBatch := func() error {
+		var added []bool
+		// The whole batch is retried if a reader holds the db
+		err := tools.RetryBusy(func() error {
+			var err error
+			added, err = m.insertImportedRows(batch)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for i, row := range batch {
+			if !added[i] {
+				skipped++
+				continue
+			}
+			imported++
+			if oldest == "" || row.createdAt < oldest {
+				oldest = row.createdAt
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for rows.Next() {
+		var row importedRow
+		var createdAt sql.NullString
+		if err := rows.Scan(&row.jobID, &row.lux, &row.fullSpectrum, &row.visible, &row.infrared, &createdAt,
+			&row.deviceID, &row.sensorID, &row.cpuTemp, &row.lightSource); err != nil {
+			return imported, skipped, err
+		}
+		if !createdAt.Valid {
+			skipped++
+			continue
+		}
+		row.createdAt = createdAt.String
+		if batch = append(batch, row); len(batch) == IMPORT_BATCH_SIZE {
+			if err := importBatch(); err != nil {
+				return imported, skipped, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return imported, skipped, err
+	}
+	if err := importBatch(); err != nil {
+		return imported, skipped, err
+	}
+	return imported, skipped, nil
+}
+
+type importedRow struct {
+	jobID, lux, fullSpectrum, visible, infrared, createdAt string
+	deviceID, sensorID, lightSource                        sql.NullString
+	cpuTemp                                                sql.NullFloat64
+}
+
+// Insert the rows in one transaction, and report which of them weren't already recorded
+func (m *SLMeter) insertImportedRows(batch []importedRow) ([]bool, error) {
+	tx, err := m.ResultsDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// A row without a device or sensor matches one recorded with them, as it's the same reading from before they were
+	stmt, err := tx.Prepare(`
+    INSERT INTO sunlight (job_id, lux, full_spectrum, visible, infrared, created_at, device_id, sensor_id, cpu_temp, light_source)
+    SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+    WHERE NOT EXISTS (
+        SELECT 1 FROM sunlight WHERE job_id = ? AND created_at = ?
+        AND (? IS NULL OR device_id IS ?) AND (? IS NULL OR sensor_id IS ?))`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	added := make([]bool, len(batch))
+	for i, row := range batch {
+		result, err := stmt.Exec(row.jobID, row.lux, row.fullSpectrum, row.visible, row.infrared, row.createdAt,
+			row.deviceID, row.sensorID, row.cpuTemp, row.lightSource,
+			row.jobID, row.createdAt, row.deviceID, row.deviceID, row.sensorID, row.sensorID)
+		if err != nil {
+			return nil, err
+		}
+		count, _ := result.RowsAffected()
+		added[i] = count > 0
+	}
+	return added, tx.Commit()
+}
+
+// Make sure the uploaded db has a sunlight table with the columns we import, and return the columns it has
+func validateImportSchema(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("PRAGMA table_info(sunlight)")
+	if err != nil {
+		return nil, fmt.Errorf("not a valid sqlite db: %w", err)
+	}
+	defer rows.Close()
+
+	found := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		found[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("not a valid sqlite db: %w", err)
+	}
+
+	for _, column := range importColumns {
+		if !found[column] {
+			return nil, fmt.Errorf("missing sunlight column: %s", column)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,113 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// An uploaded db at a path, with the schema it was created with
+func newImportDB(t *testing.T, schema ...string) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "import.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, path
+}
+
+func TestImportResults(t *testing.T) {
+	source, path := newImportDB(t)
+	if err := tools.RunMigrations(source); err != nil {
+		t.Fatal(err)
+	}
+	// Two sensors read at the same moments, across more than one batch
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	rows := 0
+	for i := 0; i < IMPORT_BATCH_SIZE; i++ {
+		for _, sensor := range []string{"a", "b"} {
+			_, err := source.Exec("INSERT INTO sunlight (device_id, sensor_id, job_id, lux, full_spectrum, visible, infrared, cpu_temp, light_source, created_at) VALUES ('pi-1', ?, 'job-1', '100.00000', '1', '1', '1', 41.5, 'sun', ?)",
+				sensor, start.Add(time.Duration(i)*time.Second).Format("2006-01-02 15:04:05"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows++
+		}
+	}
+
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	imported, skipped, err := m.importResults(path)
+	if err != nil || imported != rows || skipped != 0 {
+		t.Fatalf("got %d imported, %d skipped, %v, want %d imported", imported, skipped, err, rows)
+	}
+	var deviceID, sensorID, lightSource string
+	var cpuTemp float64
+	err = m.ResultsDB.QueryRow("SELECT device_id, sensor_id, cpu_temp, light_source FROM sunlight WHERE sensor_id = 'b' LIMIT 1").Scan(&deviceID, &sensorID, &cpuTemp, &lightSource)
+	if err != nil || deviceID != "pi-1" || sensorID != "b" || cpuTemp != 41.5 || lightSource != "sun" {
+		t.Errorf("got %s, %s, %.1f, %s, %v", deviceID, sensorID, cpuTemp, lightSource, err)
+	}
+
+	// Importing it again adds nothing
+	imported, skipped, err = m.importResults(path)
+	if err != nil || imported != 0 || skipped != rows {
+		t.Errorf("got %d imported, %d skipped, %v, want %d skipped", imported, skipped, err, rows)
+	}
+
+	// A db from before the device and sensor were recorded matches the readings it already has, and imports the rest
+	old, oldPath := newImportDB(t, "CREATE TABLE sunlight (id INTEGER PRIMARY KEY, job_id TEXT, lux TEXT, full_spectrum TEXT, visible TEXT, infrared TEXT, created_at TEXT)")
+	for _, at := range []time.Time{start, start.Add(-time.Hour)} {
+		if _, err := old.Exec("INSERT INTO sunlight (job_id, lux, full_spectrum, visible, infrared, created_at) VALUES ('job-1', '100.00000', '1', '1', '1', ?)", at.Format("2006-01-02 15:04:05")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	imported, skipped, err = m.importResults(oldPath)
+	if err != nil || imported != 1 || skipped != 1 {
+		t.Errorf("got %d imported, %d skipped, %v, want 1 of each", imported, skipped, err)
+	}
+	var missing sql.NullString
+	if err := m.ResultsDB.QueryRow("SELECT device_id FROM sunlight WHERE created_at = ?", start.Add(-time.Hour).Format("2006-01-02 15:04:05")).Scan(&missing); err != nil || missing.Valid {
+		t.Errorf("got device %v, %v, want NULL", missing, err)
+	}
+}
+
+// The batches before a failure are committed, and importing again picks up after them
+func TestImportResultsInBatches(t *testing.T) {
+	source, path := newImportDB(t, "CREATE TABLE sunlight (id INTEGER PRIMARY KEY, job_id TEXT, lux TEXT, full_spectrum TEXT, visible TEXT, infrared TEXT, created_at TEXT)")
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < IMPORT_BATCH_SIZE+10; i++ {
+		lux := sql.NullString{String: fmt.Sprintf("%d.00000", i), Valid: i != IMPORT_BATCH_SIZE+5}
+		if _, err := source.Exec("INSERT INTO sunlight (job_id, lux, full_spectrum, visible, infrared, created_at) VALUES ('job-1', ?, '1', '1', '1', ?)",
+			lux, start.Add(time.Duration(i)*time.Second).Format("2006-01-02 15:04:05")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	imported, _, err := m.importResults(path)
+	if err == nil || imported != IMPORT_BATCH_SIZE {
+		t.Fatalf("got %d imported, %v, want %d and an error", imported, err, IMPORT_BATCH_SIZE)
+	}
+	var count int
+	if err := m.ResultsDB.QueryRow("SELECT COUNT(*) FROM sunlight").Scan(&count); err != nil || count != IMPORT_BATCH_SIZE {
+		t.Errorf("got %d rows, %v, want %d", count, err, IMPORT_BATCH_SIZE)
+	}
+
+	if _, err := source.Exec("UPDATE sunlight SET lux = '0.00000' WHERE lux IS NULL"); err != nil {
+		t.Fatal(err)
+	}
+	imported, skipped, err := m.importResults(path)
+	if err != nil || imported != 10 || skipped != IMPORT_BATCH_SIZE {
+		t.Errorf("got %d imported, %d skipped, %v, want 10 imported and %d skipped", imported, skipped, err, IMPORT_BATCH_SIZE)
+	}
+}
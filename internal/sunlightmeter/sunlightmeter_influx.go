@@ -0,0 +1,116 @@
+package sunlightmeter
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const INFLUX_MEASUREMENT = "sunlight"
+
+// Convert a reading to an Influx point, timestamped with when it was recorded
+func influxPoint(deviceID string, reading Reading) (tools.InfluxPoint, error) {
+	createdAt, err := time.Parse("2006-01-02 15:04:05", reading.CreatedAt)
+	if err != nil {
+		return tools.InfluxPoint{}, err
+	}
+	point := tools.InfluxPoint{
+		Measurement: INFLUX_MEASUREMENT,
+		Tags:        map[string]string{"device": deviceID, "job": reading.JobID},
+		Fields: map[string]float64{
+			"lux":           reading.Lux,
+			"full_spectrum": reading.FullSpectrum,
+			"visible":       reading.Visible,
+			"infrared":      reading.Infrared,
+		},
+		Time: createdAt,
+	}
+	if reading.CPUTemp != nil {
+		point.Fields["cpu_temp"] = *reading.CPUTemp
+	}
+	return point, nil
+}
+
+// Queue recorded results for the Influx writer, if one is configured
+func (m *SLMeter) writeInflux(results []LuxResults) {
+	if m.Influx == nil {
+		return
+	}
+	points := make([]tools.InfluxPoint, 0, len(results))
+	for _, result := range results {
+		if result.Saturated {
+			continue
+		}
+		// Use the stored created_at, which is truncated to the second
+		point, err := influxPoint(m.DeviceID, Reading{
+			JobID:        result.JobID,
+			Lux:          result.Lux,
+			FullSpectrum: result.FullSpectrum,
+			Visible:      result.Visible,
+			Infrared:     result.Infrared,
+			CPUTemp:      result.CPUTemperature,
+			CreatedAt:    result.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		points = append(points, point)
+	}
+	m.Influx.Write(points...)
+}
+
+// Serve the readings in the date range as InfluxDB line protocol, to backfill a bucket
+func (m *SLMeter) ServeLineProtocol() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT COALESCE(device_id, ?), job_id, lux, full_spectrum, visible, infrared, cpu_temp, CAST(created_at AS TEXT)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ? AND saturated = 0
+    ORDER BY created_at, id`, m.DeviceID, startDate, endDate)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=sunlightmeter.lp")
+		out := bufio.NewWriter(w)
+		defer out.Flush()
+		for rows.Next() {
+			var deviceID string
+			var reading Reading
+			var cpuTemp sql.NullFloat64
+			err := rows.Scan(&deviceID, &reading.JobID, &reading.Lux, &reading.FullSpectrum, &reading.Visible, &reading.Infrared, &cpuTemp, &reading.CreatedAt)
+			if err != nil {
+				// The response has started, so the error can only be logged
+				log.Println(err)
+				return
+			}
+			if cpuTemp.Valid {
+				reading.CPUTemp = &cpuTemp.Float64
+			}
+			point, err := influxPoint(deviceID, reading)
+			if err != nil {
+				log.Println(fmt.Sprintf("Skipping reading with invalid created_at %q: %s", reading.CreatedAt, err.Error()))
+				continue
+			}
+			out.WriteString(point.String() + "\n")
+		}
+		if err := rows.Err(); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package sunlightmeter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+const INTERRUPT_POLL_INTERVAL = 250 * time.Millisecond
+
+type InterruptEvent struct {
+	ID        int64    `json:"id"`
+	JobID     string   `json:"jobID"`
+	Ch0       uint16   `json:"ch0"`
+	Ch1       uint16   `json:"ch1"`
+	Lux       *float64 `json:"lux"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+// Record an event whenever the full spectrum channel leaves the [low, high] range, while the job runs
+func (m *SLMeter) EnableInterrupts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.TSL2591 == nil {
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		}
+		low, errLow := strconv.ParseUint(r.FormValue("low"), 10, 16)
+		high, errHigh := strconv.ParseUint(r.FormValue("high"), 10, 16)
+		if errLow != nil || errHigh != nil || low > high {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid thresholds, expected 0 <= low <= high <= 65535", http.StatusBadRequest)
+			return
+		}
+		persist := uint64(tsl2591.TSL2591_PERSIST_ANY)
+		if value := r.FormValue("persist"); value != "" {
+			var err error
+			persist, err = strconv.ParseUint(value, 10, 8)
+			if err != nil || persist > uint64(tsl2591.TSL2591_PERSIST_60) {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid persist filter, expected 0-15", http.StatusBadRequest)
+				return
+			}
+		}
+
+		m.jobMu.Lock()
+		defer m.jobMu.Unlock()
+		if m.jobID == "" {
+			ServeError(w, r, tools.ERR_JOB_NOT_RUNNING, "Start a job before enabling interrupts", http.StatusConflict)
+			return
+		}
+		err := m.EnableInterruptMode(uint16(low), uint16(high), byte(persist))
+		if err != nil {
+			tools.RequestLog(r).WithError(err).Error("The sensor failed to enable interrupts")
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// Replace any monitor that's already running, it stops with the job
+		if m.interruptCancel != nil {
+			m.interruptCancel()
+		}
+		ctx, cancel := context.WithCancel(m.jobCtx)
+		m.interruptCancel = cancel
+		go m.monitorInterrupts(ctx, m.jobID)
+
+		log.Println(fmt.Sprintf("Interrupts enabled - Low: %d, High: %d, Persist: %d", low, high, persist))
+		ServeResponse(w, r, "Interrupts Enabled", http.StatusOK)
+	}
+}
+
+// Stop recording interrupt events
+func (m *SLMeter) DisableInterrupts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.TSL2591 == nil {
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		}
+
+		m.jobMu.Lock()
+		defer m.jobMu.Unlock()
+		if m.interruptCancel == nil {
+			ServeError(w, r, tools.ERR_CONFLICT, "Interrupts are not enabled", http.StatusConflict)
+			return
+		}
+		m.interruptCancel()
+		m.interruptCancel = nil
+		if err := m.DisableInterruptMode(); err != nil {
+			tools.RequestLog(r).WithError(err).Error("The sensor failed to disable interrupts")
+			ServeError(w, r, tools.ERR_SENSOR_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ServeResponse(w, r, "Interrupts Disabled", http.StatusOK)
+	}
+}
+
+// Poll the status register, and record an event each time the interrupt fires
+func (m *SLMeter) monitorInterrupts(ctx context.Context, jobID string) {
+	ticker := time.NewTicker(INTERRUPT_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fired, err := m.InterruptFired()
+		if err != nil {
+			log.Println(fmt.Sprintf("The sensor failed to read the interrupt status: %s", err.Error()))
+			continue
+		} else if !fired {
+			continue
+		}
+
+		m.Lock()
+		ch0, ch1, err := m.GetFullLuminosity()
+		m.Unlock()
+		if err != nil {
+			log.Println(fmt.Sprintf("The sensor failed to get luminosity: %s", err.Error()))
+		} else {
+			lux := sql.NullFloat64{}
+			if value, err := m.CalculateLux(ch0, ch1); err == nil {
+				lux = sql.NullFloat64{Float64: value, Valid: true}
+			}
+			_, err = m.ResultsDB.Exec("INSERT INTO interrupt_events (job_id, ch0, ch1, lux) VALUES (?, ?, ?, ?)", jobID, ch0, ch1, lux)
+			if err != nil {
+				log.Println(err)
+			}
+			log.Println(fmt.Sprintf("- JobID: %s, Interrupt - Channel 0: %d, Channel 1: %d", jobID, ch0, ch1))
+		}
+
+		if err := m.ClearInterrupt(); err != nil {
+			log.Println(fmt.Sprintf("The sensor failed to clear the interrupt: %s", err.Error()))
+		}
+	}
+}
+
+// Serve the interrupt events captured in the date range
+func (m *SLMeter) ServeInterruptEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := m.ResultsDB.Query(`
+    SELECT id, job_id, ch0, ch1, lux, CAST(created_at AS TEXT)
+    FROM interrupt_events
+    WHERE created_at BETWEEN ? AND ?
+    ORDER BY created_at`, startDate, endDate)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		events := []InterruptEvent{}
+		for rows.Next() {
+			var event InterruptEvent
+			var lux sql.NullFloat64
+			if err := rows.Scan(&event.ID, &event.JobID, &event.Ch0, &event.Ch1, &lux, &event.CreatedAt); err != nil {
+				tools.RequestLog(r).Error(err)
+				ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if lux.Valid {
+				event.Lux = &lux.Float64
+			}
+			events = append(events, event)
+		}
+		serveJSON(w, http.StatusOK, events)
+	}
+}
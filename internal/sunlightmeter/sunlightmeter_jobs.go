@@ -0,0 +1,359 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	MAX_JOB_FIELD_LENGTH = 255
+	MAX_JOB_TAGS         = 20
+)
+
+var ErrJobNotFound = errors.New("Job not found")
+
+// A recording job, with the name, location and tags it was labeled with
+type Job struct {
+	JobID      string   `json:"jobID"`
+	Name       string   `json:"name"`
+	Location   string   `json:"location"`
+	Tags       []string `json:"tags"`
+	StartedAt  *string  `json:"startedAt"`
+	EndedAt    *string  `json:"endedAt"`
+	Samples    int      `json:"samples"`
+	AverageLux float64  `json:"averageLux"`
+	Running    bool     `json:"running"`
+}
+
+// Serve every job, newest first. Jobs without readings are included if they were labeled.
+func (m *SLMeter) ServeJobs() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := m.getJobs()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, jobs)
+	}
+}
+
+// Set a job's name, location note, and comma-separated tags, fields that aren't sent are left as-is
+func (m *SLMeter) UpdateJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jobID := chi.URLParam(r, "id")
+		job, err := m.getJob(jobID)
+		if errors.Is(err, ErrJobNotFound) {
+			ServeError(w, r, tools.ERR_NOT_FOUND, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, ok := r.PostForm["name"]; ok {
+			job.Name = strings.TrimSpace(r.PostForm.Get("name"))
+		}
+		if _, ok := r.PostForm["location"]; ok {
+			job.Location = strings.TrimSpace(r.PostForm.Get("location"))
+		}
+		if _, ok := r.PostForm["tags"]; ok {
+			job.Tags = parseTags(r.PostForm.Get("tags"))
+		}
+		if err := validateJobLabels(job); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.labelJob(job); err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Println(fmt.Sprintf("Updated job %s, Name: %s, Location: %s, Tags: %v", job.JobID, job.Name, job.Location, job.Tags))
+		serveJSON(w, http.StatusOK, job)
+	}
+}
+
+// The readings removed with a job, or that would be on a dry run
+type JobDeletion struct {
+	JobID  string `json:"jobID"`
+	Rows   int64  `json:"rows"`
+	DryRun bool   `json:"dryRun"`
+}
+
+// Delete a job's readings, failed reads, interrupt events, annotations and labels. The running job can't be deleted.
+// With dry_run=true, only the number of readings that would be removed is reported.
+func (m *SLMeter) DeleteJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dryRun := false
+		if value := r.FormValue("dry_run"); value != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(value); err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid dry_run, expected true or false", http.StatusBadRequest)
+				return
+			}
+		}
+		jobID := chi.URLParam(r, "id")
+		if m.jobRunning(jobID) {
+			ServeError(w, r, tools.ERR_JOB_RUNNING, "Stop the job before deleting it", http.StatusConflict)
+			return
+		}
+		job, err := m.getJob(jobID)
+		if errors.Is(err, ErrJobNotFound) {
+			ServeError(w, r, tools.ERR_NOT_FOUND, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		deletion := JobDeletion{JobID: jobID, DryRun: dryRun}
+		if dryRun {
+			deletion.Rows = int64(job.Samples)
+		} else if deletion.Rows, err = m.deleteJob(jobID); err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			log.Println(fmt.Sprintf("Deleted job %s, and %d readings", jobID, deletion.Rows))
+			// The days the job covered need to be summarized again
+			if job.StartedAt != nil {
+				m.markSummaryStale(*job.StartedAt)
+			}
+		}
+
+		if tools.WantsJSON(r) {
+			serveJSON(w, http.StatusOK, deletion)
+			return
+		} else if !dryRun {
+			ServeResponse(w, r, fmt.Sprintf("Deleted job %s, and %d readings", jobLabel(job), deletion.Rows), http.StatusOK)
+			return
+		}
+		// Ask the dashboard to confirm, before anything is removed
+		tmpl, err := getTemplate("deletejob.gohtml")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = tmpl.Execute(w, struct {
+			JobID string
+			Label string
+			Rows  int64
+		}{jobID, jobLabel(job), deletion.Rows})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// Remove the job's rows from every table in one transaction, returning the number of readings removed
+func (m *SLMeter) deleteJob(jobID string) (int64, error) {
+	var deleted int64
+	err := tools.RetryBusy(func() error {
+		tx, err := m.ResultsDB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		result, err := tx.Exec("DELETE FROM sunlight WHERE job_id = ?", jobID)
+		if err != nil {
+			return err
+		}
+		if deleted, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		// Readings still waiting to be forwarded are dropped too
+		for _, table := range []string{"interrupt_events", "read_failures", "events", "forward_queue", "annotations", "jobs"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE job_id = ?", jobID); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	return deleted, err
+}
+
+// Whether the job is running on any sensor
+func (m *SLMeter) jobRunning(jobID string) bool {
+	if jobID == "" {
+		return false
+	}
+	for _, sensor := range append([]*SLMeter{m}, m.Sensors...) {
+		if sensor.JobID() == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// The job's name, or its ID when it isn't named
+func jobLabel(job Job) string {
+	if job.Name != "" {
+		return job.Name
+	}
+	return job.JobID
+}
+
+// Split comma-separated tags, dropping empty and repeated ones
+func parseTags(value string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func validateJobLabels(job Job) error {
+	if len(job.Name) > MAX_JOB_FIELD_LENGTH {
+		return fmt.Errorf("The name is too long")
+	} else if len(job.Location) > MAX_JOB_FIELD_LENGTH {
+		return fmt.Errorf("The location is too long")
+	} else if len(job.Tags) > MAX_JOB_TAGS {
+		return fmt.Errorf("A job can have at most %d tags", MAX_JOB_TAGS)
+	}
+	for _, tag := range job.Tags {
+		if len(tag) > MAX_JOB_FIELD_LENGTH {
+			return fmt.Errorf("The tag %q is too long", tag)
+		}
+	}
+	return nil
+}
+
+// Save the job's name, location and tags
+func (m *SLMeter) labelJob(job Job) error {
+	if job.Tags == nil {
+		job.Tags = []string{}
+	}
+	tags, err := json.Marshal(job.Tags)
+	if err != nil {
+		return err
+	}
+	_, err = tools.ExecRetry(m.ResultsDB, `
+    INSERT INTO jobs (job_id, name, location, tags) VALUES (?, ?, ?, ?)
+    ON CONFLICT(job_id) DO UPDATE SET name = excluded.name, location = excluded.location, tags = excluded.tags, updated_at = CURRENT_TIMESTAMP`,
+		job.JobID, job.Name, job.Location, string(tags))
+	return err
+}
+
+// Jobs are the union of those with readings and those that were labeled
+const jobsQuery = `
+    SELECT ids.job_id, COALESCE(j.name, ''), COALESCE(j.location, ''), COALESCE(j.tags, '[]'),
+        readings.started_at, readings.ended_at, COALESCE(readings.samples, 0), COALESCE(readings.avg_lux, 0)
+    FROM (SELECT job_id FROM sunlight UNION SELECT job_id FROM jobs) ids
+    LEFT JOIN jobs j ON j.job_id = ids.job_id
+    LEFT JOIN (
+        SELECT job_id, CAST(MIN(created_at) AS TEXT) AS started_at, CAST(MAX(created_at) AS TEXT) AS ended_at,
+            COUNT(*) AS samples, AVG(CASE WHEN saturated = 0 THEN CAST(lux AS REAL) END) AS avg_lux
+        FROM sunlight
+        GROUP BY job_id
+    ) readings ON readings.job_id = ids.job_id`
+
+// Every job, newest first
+func (m *SLMeter) getJobs() ([]Job, error) {
+	rows, err := tools.QueryRetry(m.ResultsDB, jobsQuery+`
+    ORDER BY COALESCE(readings.started_at, j.created_at) DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := m.scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// A job with readings or labels, or the one that's running
+func (m *SLMeter) getJob(jobID string) (Job, error) {
+	rows, err := tools.QueryRetry(m.ResultsDB, jobsQuery+`
+    WHERE ids.job_id = ?`, jobID)
+	if err != nil {
+		return Job{}, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return m.scanJob(rows)
+	} else if err := rows.Err(); err != nil {
+		return Job{}, err
+	}
+	// A job that just started may not have recorded anything yet
+	if m.jobRunning(jobID) {
+		return Job{JobID: jobID, Tags: []string{}, Running: true}, nil
+	}
+	return Job{}, ErrJobNotFound
+}
+
+// The ID of the most recent job with the name
+func (m *SLMeter) getJobIDByName(name string) (string, error) {
+	var jobID string
+	err := tools.RetryBusy(func() error {
+		return m.ResultsDB.QueryRow("SELECT job_id FROM jobs WHERE name = ? ORDER BY created_at DESC LIMIT 1", name).Scan(&jobID)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrJobNotFound
+	}
+	return jobID, err
+}
+
+// The name of the job, or an empty string if it isn't named
+func (m *SLMeter) getJobName(jobID string) (string, error) {
+	var name sql.NullString
+	err := tools.RetryBusy(func() error {
+		return m.ResultsDB.QueryRow("SELECT name FROM jobs WHERE job_id = ?", jobID).Scan(&name)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return name.String, err
+}
+
+// The job picked with the job_id or job_name form value, or an empty string if neither is set
+func (m *SLMeter) jobFromRequest(r *http.Request) (string, error) {
+	if jobID := r.FormValue("job_id"); jobID != "" {
+		return jobID, nil
+	} else if name := strings.TrimSpace(r.FormValue("job_name")); name != "" {
+		return m.getJobIDByName(name)
+	}
+	return "", nil
+}
+
+func (m *SLMeter) scanJob(rows *sql.Rows) (Job, error) {
+	var job Job
+	var tags string
+	err := rows.Scan(&job.JobID, &job.Name, &job.Location, &tags, &job.StartedAt, &job.EndedAt, &job.Samples, &job.AverageLux)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := json.Unmarshal([]byte(tags), &job.Tags); err != nil || job.Tags == nil {
+		job.Tags = []string{}
+	}
+	job.Running = m.jobRunning(job.JobID)
+	return job, nil
+}
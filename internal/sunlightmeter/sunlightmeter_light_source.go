@@ -0,0 +1,204 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// The probable source of a reading's light, from the share of its full spectrum counts that are infrared.
+// Sunlight carries plenty of infrared, LEDs and fluorescent tubes hardly any, and incandescent bulbs more than the sun.
+// It's a heuristic: glass that blocks infrared makes sunlight look artificial, a heat lamp makes anything look
+// incandescent, and a grow light sharing a window with the sun reads as mixed.
+const (
+	LIGHT_SOURCE_ARTIFICIAL   = "artificial"
+	LIGHT_SOURCE_MIXED        = "mixed"
+	LIGHT_SOURCE_NATURAL      = "natural"
+	LIGHT_SOURCE_INCANDESCENT = "incandescent"
+	LIGHT_SOURCE_UNCLASSIFIED = "unclassified" // Too dim or saturated to tell, or recorded before readings were classified
+
+	SETTING_LIGHT_SOURCE_BANDS = "light_source_bands"
+	// Below this many full spectrum counts the ratio is mostly noise
+	LIGHT_SOURCE_MIN_COUNTS = 50
+)
+
+var lightSourceClasses = []string{LIGHT_SOURCE_ARTIFICIAL, LIGHT_SOURCE_MIXED, LIGHT_SOURCE_NATURAL, LIGHT_SOURCE_INCANDESCENT}
+
+// How each class is named and colored on the graph, apart from the reference bands
+var lightSourceLegend = map[string][2]string{
+	LIGHT_SOURCE_ARTIFICIAL:   {"Artificial Light", "#b388ff"},
+	LIGHT_SOURCE_MIXED:        {"Mixed Light", "#ff9f43"},
+	LIGHT_SOURCE_NATURAL:      {"Natural Light", "#2ecc71"},
+	LIGHT_SOURCE_INCANDESCENT: {"Incandescent Light", "#ff5252"},
+}
+
+// The IR ratio bands readings are classified with, the infrared channel over the full spectrum channel.
+// Under ArtificialMax is artificial, under NaturalMin is mixed, up to NaturalMax is natural, and above it is incandescent.
+type LightSourceBands struct {
+	ArtificialMax float64 `json:"artificialMax"`
+	NaturalMin    float64 `json:"naturalMin"`
+	NaturalMax    float64 `json:"naturalMax"`
+}
+
+// White LEDs read around 0.05, fluorescents a little higher, sunlight 0.25 to 0.4, and incandescent bulbs over 0.5
+var defaultLightSourceBands = LightSourceBands{ArtificialMax: 0.12, NaturalMin: 0.2, NaturalMax: 0.5}
+
+func (b LightSourceBands) validate() error {
+	if b.ArtificialMax <= 0 || b.NaturalMax > 1 {
+		return fmt.Errorf("The bands must be between 0 and 1")
+	} else if b.ArtificialMax > b.NaturalMin || b.NaturalMin >= b.NaturalMax {
+		return fmt.Errorf("Expected artificial_max <= natural_min < natural_max")
+	}
+	return nil
+}
+
+// The class of a reading from its normalized channels, or "" when there's too little light to tell
+func (b LightSourceBands) classify(infrared float64, fullSpectrum float64) string {
+	if fullSpectrum*0xFFFF < LIGHT_SOURCE_MIN_COUNTS {
+		return ""
+	}
+	switch ratio := infrared / fullSpectrum; {
+	case ratio < b.ArtificialMax:
+		return LIGHT_SOURCE_ARTIFICIAL
+	case ratio < b.NaturalMin:
+		return LIGHT_SOURCE_MIXED
+	case ratio <= b.NaturalMax:
+		return LIGHT_SOURCE_NATURAL
+	default:
+		return LIGHT_SOURCE_INCANDESCENT
+	}
+}
+
+// The bands every sensor shares, the ones saved through the API or else the defaults
+func (m *SLMeter) LightSourceBands() LightSourceBands {
+	if m.primary != nil {
+		return m.primary.LightSourceBands()
+	}
+	if bands := m.lightSourceBands.Load(); bands != nil {
+		return *bands
+	}
+	return defaultLightSourceBands
+}
+
+// Use the bands saved through the API, if there are any
+func (m *SLMeter) LoadLightSourceBands() error {
+	value, ok, err := m.getSetting(SETTING_LIGHT_SOURCE_BANDS)
+	if err != nil || !ok {
+		return err
+	}
+	var bands LightSourceBands
+	if err := json.Unmarshal([]byte(value), &bands); err != nil {
+		return fmt.Errorf("Invalid saved light source bands %q", value)
+	} else if err := bands.validate(); err != nil {
+		return fmt.Errorf("Invalid saved light source bands %q: %w", value, err)
+	}
+	m.lightSourceBands.Store(&bands)
+	return nil
+}
+
+// Serve the IR ratio bands new readings are classified with
+func (m *SLMeter) ServeLightSourceBands() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, http.StatusOK, m.LightSourceBands())
+	}
+}
+
+// Tune the IR ratio bands with artificial_max, natural_min, and natural_max, any left out keep their value.
+// The bands are saved, so they outlast a restart. Readings already recorded keep their class.
+func (m *SLMeter) UpdateLightSourceBands() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bands := m.LightSourceBands()
+		for _, field := range []struct {
+			name  string
+			value *float64
+		}{
+			{"artificial_max", &bands.ArtificialMax},
+			{"natural_min", &bands.NaturalMin},
+			{"natural_max", &bands.NaturalMax},
+		} {
+			value := r.PostForm.Get(field.name)
+			if value == "" {
+				continue
+			}
+			ratio, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, fmt.Sprintf("Invalid %s, expected a ratio", field.name), http.StatusBadRequest)
+				return
+			}
+			*field.value = ratio
+		}
+		if err := bands.validate(); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		value, err := json.Marshal(bands)
+		if err == nil {
+			err = m.setSetting(SETTING_LIGHT_SOURCE_BANDS, string(value))
+		}
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.lightSourceBands.Store(&bands)
+		log.Println(fmt.Sprintf("Updated the light source bands, Artificial: < %g, Natural: %g - %g", bands.ArtificialMax, bands.NaturalMin, bands.NaturalMax))
+		serveJSON(w, http.StatusOK, bands)
+	}
+}
+
+// How many readings in the range were recorded under each class, every class is included
+func (m *SLMeter) getLightSourceCounts(startDate string, endDate string, scope readingScope) (map[string]int, error) {
+	counts := map[string]int{LIGHT_SOURCE_UNCLASSIFIED: 0}
+	for _, class := range lightSourceClasses {
+		counts[class] = 0
+	}
+	filter, filterArgs := m.readingFilter(scope)
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT COALESCE(light_source, ?), COUNT(*)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    GROUP BY 1`, append([]interface{}{LIGHT_SOURCE_UNCLASSIFIED, startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var class string
+		var count int
+		if err := rows.Scan(&class, &count); err != nil {
+			return nil, err
+		}
+		counts[class] += count
+	}
+	return counts, rows.Err()
+}
+
+// A scatter series for each class, of the series' readings with that class, to overlap the lux line with.
+// Every class is charted, even without readings, so the live graph has somewhere to put new ones.
+func lightSourceScatter(series graphSeries) *charts.Scatter {
+	scatter := charts.NewScatter()
+	for _, class := range lightSourceClasses {
+		data := make([]opts.ScatterData, len(series.lux))
+		for i, lux := range series.lux {
+			if i < len(series.sources) && series.sources[i] == class {
+				data[i] = opts.ScatterData{Value: lux.Value, SymbolSize: 5}
+			} else {
+				data[i] = opts.ScatterData{Value: "-"}
+			}
+		}
+		legend := lightSourceLegend[class]
+		scatter.AddSeries(legend[0], data, charts.WithItemStyleOpts(opts.ItemStyle{Color: legend[1]}))
+	}
+	return scatter
+}
@@ -0,0 +1,238 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ztkent/sunlight-meter/internal/solar"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	GRAPH_DATA_MAX_ROWS = 500              // Readings per request, the client asks again from the newest one for the rest
+	LIVE_GRAPH_WITHIN   = 10 * time.Minute // The graph keeps updating when its range ends this close to now
+
+	// The series that can follow the lux series on the live graph, along with a light source scatter for each class
+	LIVE_SERIES_TEMP      = "temp"
+	LIVE_SERIES_CLEAR_SKY = "clearSky"
+)
+
+// A reading appended to the live graph
+type GraphPoint struct {
+	Time        string   `json:"time"`
+	Lux         float64  `json:"lux"`
+	Temp        *float64 `json:"temp,omitempty"`
+	LightSource string   `json:"lightSource,omitempty"`
+	ClearSky    *float64 `json:"clearSky,omitempty"` // Only with clear_sky=true, in the units
+}
+
+type GraphData struct {
+	Points []GraphPoint `json:"points"`
+	// The time of the newest point, or since when there are none. The next request asks for readings after it.
+	Newest string `json:"newest"`
+	// More readings are waiting after Newest
+	More bool `json:"more"`
+}
+
+// Serve the readings recorded after since, oldest first, for the live graph to append.
+// At most GRAPH_DATA_MAX_ROWS are returned at a time, with the same scope and units params as the graph.
+func (m *SLMeter) ServeGraphData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since := r.FormValue("since")
+		if _, err := time.Parse("2006-01-02 15:04:05", since); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid since, expected YYYY-MM-DD HH:MM:SS", http.StatusBadRequest)
+			return
+		}
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var location *solar.Location
+		if clearSky, _ := strconv.ParseBool(r.FormValue("clear_sky")); clearSky {
+			if m.Location == nil {
+				ServeError(w, r, tools.ERR_LOCATION_NOT_SET, ErrLocationNotSet.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			location = m.Location
+		}
+		scope := scopeFromRequest(r)
+		scope.JobID, err = m.jobFromRequest(r)
+		if errors.Is(err, ErrJobNotFound) {
+			ServeError(w, r, tools.ERR_NOT_FOUND, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := m.getGraphData(since, scope, units, location)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, data)
+	}
+}
+
+// With a location, each point has the clear sky model's lux too
+func (m *SLMeter) getGraphData(since string, scope readingScope, units string, location *solar.Location) (GraphData, error) {
+	data := GraphData{Points: []GraphPoint{}, Newest: since}
+	filter, filterArgs := m.readingFilter(scope)
+	// One extra row tells whether there's more
+	rows, err := tools.QueryRetry(m.ResultsDB, "SELECT lux, cpu_temp, COALESCE(light_source, ''), created_at FROM sunlight WHERE created_at > ?"+filter+" ORDER BY created_at LIMIT ?",
+		append(append([]interface{}{since}, filterArgs...), GRAPH_DATA_MAX_ROWS+1)...)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if len(data.Points) == GRAPH_DATA_MAX_ROWS {
+			data.More = true
+			break
+		}
+		var lux string
+		var point GraphPoint
+		var createdAt time.Time
+		if err := rows.Scan(&lux, &point.Temp, &point.LightSource, &createdAt); err != nil {
+			return data, err
+		}
+		luxFloat, err := strconv.ParseFloat(lux, 64)
+		if err != nil {
+			return data, err
+		}
+		point.Lux = convertLux(luxFloat, units)
+		point.Time = createdAt.Format("2006-01-02 15:04:05")
+		if location != nil {
+			clearSky := math.Round(convertLux(solar.ClearSkyLux(createdAt, *location), units)*10) / 10
+			point.ClearSky = &clearSky
+		}
+		data.Points = append(data.Points, point)
+		data.Newest = point.Time
+	}
+	return data, rows.Err()
+}
+
+// A unique ID for a line chart that's swapped in, so the live graph script only ever finds its own chart
+func liveGraphChartID() string {
+	return "line_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+}
+
+// Append new readings to the line chart every RECORD_INTERVAL, while the job being charted is running.
+// The sensor status decides whether to ask for readings, and the polling stops once the chart is swapped out.
+// Following lists the series after the lux series: LIVE_SERIES_TEMP, LIVE_SERIES_CLEAR_SKY, or a light source class.
+// A pinned axis keeps its max, rather than growing to fit new readings.
+// go-echarts joins the script onto one line, so it can only have block comments.
+func liveGraphScript(chartID string, since string, scope readingScope, units string, following []string, pinned bool) string {
+	params := url.Values{"units": {units}}
+	for _, series := range following {
+		if series == LIVE_SERIES_CLEAR_SKY {
+			params.Set("clear_sky", "true")
+		}
+	}
+	status := url.Values{}
+	if scope.Device != "" {
+		params.Set("device", scope.Device)
+	}
+	if scope.Sensor != "" {
+		params.Set("sensor", scope.Sensor)
+		status.Set("sensor", scope.Sensor)
+	}
+	if scope.JobID != "" {
+		params.Set("job_id", scope.JobID)
+	}
+	config, _ := json.Marshal(struct {
+		DataURL   string   `json:"dataURL"`
+		StatusURL string   `json:"statusURL"`
+		Since     string   `json:"since"`
+		JobID     string   `json:"jobID"`
+		Following []string `json:"following"`
+		Pinned    bool     `json:"pinned"`
+	}{
+		DataURL:   "/sunlightmeter/graph/data?" + params.Encode(),
+		StatusURL: "/sunlightmeter/status?" + status.Encode(),
+		Since:     since,
+		JobID:     scope.JobID,
+		Following: following,
+		Pinned:    pinned,
+	})
+	return fmt.Sprintf(`(function (chart, config) {
+        var headers = { headers: { Accept: 'application/json' } };
+        var luxSeries = %d;
+        var polling = false;
+        function append(points) {
+            var option = chart.getOption();
+            points.forEach(function (point) {
+                option.xAxis[0].data.push(point.time);
+                option.series.forEach(function (series, i) {
+                    if (i < luxSeries) {
+                        series.data.push(series.data.length ? series.data[0] : null);
+                    } else if (i === luxSeries) {
+                        series.data.push(point.lux);
+                    } else {
+                        var following = (config.following || [])[i - luxSeries - 1];
+                        if (following === '%s') {
+                            series.data.push(point.temp === undefined ? '-' : point.temp);
+                        } else if (following === '%s') {
+                            series.data.push(point.clearSky === undefined ? '-' : point.clearSky);
+                        } else if (following) {
+                            series.data.push(point.lightSource === following ? point.lux : '-');
+                        }
+                    }
+                });
+                /* Let the axis grow past its rounded max, rather than clip the new readings */
+                if (!config.pinned && option.yAxis[0].max !== undefined && point.lux > option.yAxis[0].max) {
+                    option.yAxis[0].max = null;
+                }
+            });
+            chart.setOption(option);
+        }
+        function poll() {
+            if (!document.getElementById('%s')) {
+                clearInterval(timer);
+                return;
+            } else if (polling) {
+                return;
+            }
+            polling = true;
+            fetch(config.statusURL, headers).then(function (response) {
+                return response.json();
+            }).then(function (status) {
+                /* Nothing new is recorded unless the charted job is running */
+                if (!status.enabled || status.paused || (config.jobID && status.jobID !== config.jobID)) {
+                    return;
+                }
+                function next() {
+                    return fetch(config.dataURL + '&since=' + encodeURIComponent(config.since), headers).then(function (response) {
+                        return response.json();
+                    }).then(function (data) {
+                        if (data.points && data.points.length) {
+                            append(data.points);
+                            config.since = data.newest;
+                        }
+                        if (data.more) {
+                            return next();
+                        }
+                    });
+                }
+                return next();
+            }).catch(function (err) {
+                console.log(err);
+            }).finally(function () {
+                polling = false;
+            });
+        }
+        var timer = setInterval(poll, %d);
+    })(goecharts_%s, %s);`, len(graphLevels), LIVE_SERIES_TEMP, LIVE_SERIES_CLEAR_SKY, chartID, RECORD_INTERVAL.Milliseconds(), chartID, config)
+}
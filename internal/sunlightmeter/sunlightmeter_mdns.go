@@ -0,0 +1,66 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// Advertise the meter on the LAN as a sunlight meter and a web server, so it can be found after DHCP hands it a new address
+func (m *SLMeter) Advertise(port int) (*tools.MDNSResponder, error) {
+	instance := "Sunlight Meter"
+	if hostname, err := os.Hostname(); err == nil {
+		// Name the host the way .local does, "pi" rather than "pi.lan"
+		instance = fmt.Sprintf("Sunlight Meter on %s", strings.SplitN(hostname, ".", 2)[0])
+	}
+	responder, err := tools.NewMDNSResponder(instance, port, []string{tools.MDNS_SERVICE_SUNLIGHTMETER, tools.MDNS_SERVICE_HTTP}, m.advertisedTXT())
+	if err != nil {
+		return nil, err
+	}
+	go m.updateAdvertisement(responder)
+	return responder, nil
+}
+
+// The TXT records of the advertisement: the device ID, the version, and whether any sensor is recording
+func (m *SLMeter) advertisedTXT() []string {
+	job := "idle"
+	for _, sensor := range append([]*SLMeter{m}, m.Sensors...) {
+		if sensor.JobID() != "" {
+			job = "running"
+		}
+	}
+	return []string{"path=/", "device=" + m.DeviceID, "version=" + m.Version, "job=" + job}
+}
+
+// Keep the TXT records in step with the jobs, until the responder is closed
+func (m *SLMeter) updateAdvertisement(responder *tools.MDNSResponder) {
+	for {
+		select {
+		case <-m.jobChangesChan():
+			responder.SetTXT(m.advertisedTXT())
+		case <-responder.Done():
+			return
+		}
+	}
+}
+
+// Note a job starting or stopping. This never blocks, so it's safe under jobMu, and changes close together are sent once.
+func (m *SLMeter) jobStateChanged() {
+	select {
+	case m.jobChangesChan() <- struct{}{}:
+	default:
+	}
+}
+
+// Extra sensors share the primary meter's channel
+func (m *SLMeter) jobChangesChan() chan struct{} {
+	if m.primary != nil {
+		return m.primary.jobChangesChan()
+	}
+	m.jobChangesOnce.Do(func() {
+		m.jobChanges = make(chan struct{}, 1)
+	})
+	return m.jobChanges
+}
@@ -0,0 +1,329 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	SETTING_PLANTS_SEEDED    = "plants_seeded"
+	MAX_PLANT_NAME_LENGTH    = 255
+	MAX_PLANT_SUN_HOURS      = 24
+	MAX_PLANT_DLI            = 100 // Beyond the brightest summer day, in mol/m²/day
+	PLANT_CLASSIFICATION_ANY = ""
+)
+
+var ErrPlantNotFound = errors.New("Plant not found")
+
+// The light a plant needs to do well. A day qualifies when it meets both minimums.
+type Plant struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	MinSunHours float64 `json:"minSunHours"`
+	MinDLI      float64 `json:"minDLI"`
+	// The light condition the plant prefers, empty for any
+	Classification string `json:"classification"`
+}
+
+// Seeded on first start, they can be edited or deleted like any other
+var defaultPlants = []Plant{
+	{Name: "Tomato", MinSunHours: 6, MinDLI: 20, Classification: LIGHT_FULL_SUN},
+	{Name: "Pepper", MinSunHours: 8, MinDLI: 25, Classification: LIGHT_FULL_SUN},
+	{Name: "Basil", MinSunHours: 6, MinDLI: 16, Classification: LIGHT_FULL_SUN},
+	{Name: "Lettuce", MinSunHours: 4, MinDLI: 12, Classification: LIGHT_PARTIAL_SUN},
+	{Name: "Hosta", MinSunHours: 0, MinDLI: 4, Classification: LIGHT_SHADE},
+}
+
+// Add the default plants, once. They aren't added again after they've been deleted.
+func (m *SLMeter) SeedPlants() error {
+	_, seeded, err := m.getSetting(SETTING_PLANTS_SEEDED)
+	if err != nil || seeded {
+		return err
+	}
+	for _, plant := range defaultPlants {
+		_, err := tools.ExecRetry(m.ResultsDB,
+			"INSERT OR IGNORE INTO plants (name, min_sun_hours, min_dli, classification) VALUES (?, ?, ?, ?)",
+			plant.Name, plant.MinSunHours, plant.MinDLI, plant.Classification)
+		if err != nil {
+			return err
+		}
+	}
+	return m.setSetting(SETTING_PLANTS_SEEDED, "true")
+}
+
+// Serve every plant, by name
+func (m *SLMeter) ServePlants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plants, err := m.getPlants()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, plants)
+	}
+}
+
+// Add a plant with a name, and its min_sun_hours, min_dli and classification
+func (m *SLMeter) CreatePlant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		plant, err := plantFromForm(Plant{}, r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = m.ResultsDB.QueryRow(
+			"INSERT INTO plants (name, min_sun_hours, min_dli, classification) VALUES (?, ?, ?, ?) RETURNING id",
+			plant.Name, plant.MinSunHours, plant.MinDLI, plant.Classification,
+		).Scan(&plant.ID)
+		if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			ServeError(w, r, tools.ERR_CONFLICT, fmt.Sprintf("Plant %s already exists", plant.Name), http.StatusConflict)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Println(fmt.Sprintf("Added plant %s", plant.Name))
+		serveJSON(w, http.StatusCreated, plant)
+	}
+}
+
+// Change a plant's requirements, fields that aren't sent are left as-is
+func (m *SLMeter) UpdatePlant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		plant, err := m.getPlant(chi.URLParam(r, "id"))
+		if errors.Is(err, ErrPlantNotFound) {
+			ServeError(w, r, tools.ERR_NOT_FOUND, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plant, err = plantFromForm(plant, r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, err = tools.ExecRetry(m.ResultsDB,
+			"UPDATE plants SET name = ?, min_sun_hours = ?, min_dli = ?, classification = ? WHERE id = ?",
+			plant.Name, plant.MinSunHours, plant.MinDLI, plant.Classification, plant.ID)
+		if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			ServeError(w, r, tools.ERR_CONFLICT, fmt.Sprintf("Plant %s already exists", plant.Name), http.StatusConflict)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Println(fmt.Sprintf("Updated plant %s", plant.Name))
+		serveJSON(w, http.StatusOK, plant)
+	}
+}
+
+func (m *SLMeter) DeletePlant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid plant id", http.StatusBadRequest)
+			return
+		}
+		result, err := tools.ExecRetry(m.ResultsDB, "DELETE FROM plants WHERE id = ?", id)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+			ServeError(w, r, tools.ERR_NOT_FOUND, ErrPlantNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		ServeResponse(w, r, "Plant Deleted", http.StatusOK)
+	}
+}
+
+// The plant with the form's fields applied over it, and checked
+func plantFromForm(plant Plant, r *http.Request) (Plant, error) {
+	if _, ok := r.PostForm["name"]; ok || plant.ID == 0 {
+		plant.Name = strings.TrimSpace(r.PostForm.Get("name"))
+	}
+	if plant.Name == "" {
+		return plant, fmt.Errorf("A name is required")
+	} else if len(plant.Name) > MAX_PLANT_NAME_LENGTH {
+		return plant, fmt.Errorf("The name is too long")
+	}
+	limits := []struct {
+		form  string
+		value *float64
+		max   float64
+	}{
+		{"min_sun_hours", &plant.MinSunHours, MAX_PLANT_SUN_HOURS},
+		{"min_dli", &plant.MinDLI, MAX_PLANT_DLI},
+	}
+	for _, limit := range limits {
+		value := r.PostForm.Get(limit.form)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(parsed) || parsed < 0 || parsed > limit.max {
+			return plant, fmt.Errorf("Invalid %s, expected 0-%g", limit.form, limit.max)
+		}
+		*limit.value = parsed
+	}
+	if _, ok := r.PostForm["classification"]; ok {
+		plant.Classification = strings.TrimSpace(r.PostForm.Get("classification"))
+	}
+	switch plant.Classification {
+	case PLANT_CLASSIFICATION_ANY, LIGHT_FULL_SUN, LIGHT_PARTIAL_SUN, LIGHT_PARTIAL_SHADE, LIGHT_SHADE:
+	default:
+		return plant, fmt.Errorf("Invalid classification, expected one of %s, %s, %s, %s, or empty for any",
+			LIGHT_FULL_SUN, LIGHT_PARTIAL_SUN, LIGHT_PARTIAL_SHADE, LIGHT_SHADE)
+	}
+	return plant, nil
+}
+
+func (m *SLMeter) getPlants() ([]Plant, error) {
+	rows, err := tools.QueryRetry(m.ResultsDB, "SELECT id, name, min_sun_hours, min_dli, classification FROM plants ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	plants := []Plant{}
+	for rows.Next() {
+		var plant Plant
+		if err := rows.Scan(&plant.ID, &plant.Name, &plant.MinSunHours, &plant.MinDLI, &plant.Classification); err != nil {
+			return nil, err
+		}
+		plants = append(plants, plant)
+	}
+	return plants, rows.Err()
+}
+
+// The plant with the ID, or the name, ignoring case
+func (m *SLMeter) getPlant(plant string) (Plant, error) {
+	var found Plant
+	err := tools.RetryBusy(func() error {
+		return m.ResultsDB.QueryRow("SELECT id, name, min_sun_hours, min_dli, classification FROM plants WHERE CAST(id AS TEXT) = ? OR name = ?", plant, plant).
+			Scan(&found.ID, &found.Name, &found.MinSunHours, &found.MinDLI, &found.Classification)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return Plant{}, ErrPlantNotFound
+	}
+	return found, err
+}
+
+// How the summarized days in a range measure up to a plant's needs.
+// It passes when the average day meets both minimums, the margins are how far above (or below) them it is.
+type Adequacy struct {
+	Plant               Plant   `json:"plant"`
+	DateRange           string  `json:"dateRange"`
+	Days                int     `json:"days"`
+	QualifyingDays      int     `json:"qualifyingDays"`
+	AverageSunHours     float64 `json:"averageSunHours"`
+	AverageDLI          float64 `json:"averageDLI"`
+	SunHoursMargin      float64 `json:"sunHoursMargin"`
+	DLIMargin           float64 `json:"dliMargin"`
+	LightCondition      string  `json:"lightCondition"`
+	ClassificationMatch bool    `json:"classificationMatch"`
+	Pass                bool    `json:"pass"`
+	Verdict             string  `json:"verdict"`
+}
+
+// Serve whether the spot got enough light for the plant over the date range, from the daily summaries.
+// Days are summarized once they're over, so today isn't counted.
+func (m *SLMeter) ServeAdequacy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("plant") == "" {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "A plant is required", http.StatusBadRequest)
+			return
+		}
+		plant, err := m.getPlant(r.FormValue("plant"))
+		if errors.Is(err, ErrPlantNotFound) {
+			ServeError(w, r, tools.ERR_NOT_FOUND, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		adequacy, err := m.getAdequacy(plant, startDate, endDate, r.FormValue("device"))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, adequacy)
+	}
+}
+
+func (m *SLMeter) getAdequacy(plant Plant, startDate string, endDate string, device string) (Adequacy, error) {
+	adequacy := Adequacy{Plant: plant, DateRange: fmt.Sprintf("%s - %s UTC", startDate, endDate)}
+	days, err := m.getDaySummaries(startDate, endDate, device)
+	if err != nil {
+		return adequacy, err
+	}
+	conditions, err := m.getHistoricalConditions(Conditions{}, startDate, endDate, readingScope{Device: device})
+	if err != nil {
+		return adequacy, err
+	}
+	adequacy.LightCondition = conditions.LightConditionInRange
+	adequacy.ClassificationMatch = plant.Classification == PLANT_CLASSIFICATION_ANY || plant.Classification == conditions.LightConditionInRange
+
+	// Each device has its own summary, the best of them counts for the day
+	best := map[string][2]float64{}
+	for _, day := range days {
+		sunHours, dli := day.SunHours, day.DLI
+		if previous, ok := best[day.Date]; ok {
+			sunHours, dli = max(sunHours, previous[0]), max(dli, previous[1])
+		}
+		best[day.Date] = [2]float64{sunHours, dli}
+	}
+	for _, day := range best {
+		adequacy.AverageSunHours += day[0]
+		adequacy.AverageDLI += day[1]
+		if day[0] >= plant.MinSunHours && day[1] >= plant.MinDLI {
+			adequacy.QualifyingDays++
+		}
+	}
+	adequacy.Days = len(best)
+	if adequacy.Days == 0 {
+		adequacy.Verdict = "No summarized days in range"
+		return adequacy, nil
+	}
+
+	adequacy.AverageSunHours = math.Round(adequacy.AverageSunHours/float64(adequacy.Days)*100) / 100
+	adequacy.AverageDLI = math.Round(adequacy.AverageDLI/float64(adequacy.Days)*100) / 100
+	adequacy.SunHoursMargin = math.Round((adequacy.AverageSunHours-plant.MinSunHours)*100) / 100
+	adequacy.DLIMargin = math.Round((adequacy.AverageDLI-plant.MinDLI)*100) / 100
+	adequacy.Pass = adequacy.SunHoursMargin >= 0 && adequacy.DLIMargin >= 0
+	if adequacy.Pass {
+		adequacy.Verdict = fmt.Sprintf("Enough light for %s", plant.Name)
+	} else {
+		adequacy.Verdict = fmt.Sprintf("Not enough light for %s", plant.Name)
+	}
+	return adequacy, nil
+}
@@ -0,0 +1,171 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// The light sources PPFD can be estimated for. Each source's spectrum puts a different amount of photosynthetic light in a lux.
+const (
+	PPFD_SOURCE_SUNLIGHT    = "sunlight"
+	PPFD_SOURCE_OVERCAST    = "overcast"
+	PPFD_SOURCE_LED         = "led"
+	PPFD_SOURCE_FLUORESCENT = "fluorescent"
+	PPFD_SOURCE_CUSTOM      = "custom"
+
+	SETTING_PPFD_SOURCE      = "ppfd_source"
+	SETTING_PPFD_PER_LUX     = "ppfd_per_lux"
+	OVERCAST_PPFD_PER_LUX    = 0.0190 // Diffuse skylight is a little bluer than direct sun
+	LED_PPFD_PER_LUX         = 0.0150 // A white full-spectrum LED grow light, red and blue fixtures run much higher
+	FLUORESCENT_PPFD_PER_LUX = 0.0135 // A cool white fluorescent tube
+)
+
+// A light source, and its µmol/m²/s of PPFD per lux.
+// The factors are from Thimijan & Heins (1983), apart from LEDs, which vary by fixture.
+type PPFDSource struct {
+	Name       string  `json:"name"`
+	Label      string  `json:"label"`
+	PPFDPerLux float64 `json:"ppfdPerLux"`
+}
+
+var ppfdSources = []PPFDSource{
+	{PPFD_SOURCE_SUNLIGHT, "Direct sunlight", SUNLIGHT_PPFD_PER_LUX},
+	{PPFD_SOURCE_OVERCAST, "Overcast sky", OVERCAST_PPFD_PER_LUX},
+	{PPFD_SOURCE_LED, "White LED grow light", LED_PPFD_PER_LUX},
+	{PPFD_SOURCE_FLUORESCENT, "Cool white fluorescent", FLUORESCENT_PPFD_PER_LUX},
+}
+
+// The µmol/m²/s of PPFD per lux of a light source, custom has no factor of its own
+func PPFDPerLuxFor(source string) (float64, error) {
+	for _, profile := range ppfdSources {
+		if profile.Name == source {
+			return profile.PPFDPerLux, nil
+		}
+	}
+	names := make([]string, 0, len(ppfdSources))
+	for _, profile := range ppfdSources {
+		names = append(names, profile.Name)
+	}
+	return 0, fmt.Errorf("Invalid source, expected one of %s, %s", strings.Join(names, ", "), PPFD_SOURCE_CUSTOM)
+}
+
+// The light source PPFD is estimated for, and its factor
+type PPFDSetting struct {
+	Source     string  `json:"source"`
+	PPFDPerLux float64 `json:"ppfdPerLux"`
+}
+
+// The source a factor belongs to, or custom if it isn't one of the profiles
+func ppfdSettingFor(ppfdPerLux float64) PPFDSetting {
+	for _, profile := range ppfdSources {
+		if profile.PPFDPerLux == ppfdPerLux {
+			return PPFDSetting{Source: profile.Name, PPFDPerLux: ppfdPerLux}
+		}
+	}
+	return PPFDSetting{Source: PPFD_SOURCE_CUSTOM, PPFDPerLux: ppfdPerLux}
+}
+
+// The PPFD setting every sensor shares, the one saved through the API or else PPFDPerLux
+func (m *SLMeter) PPFDSetting() PPFDSetting {
+	if m.primary != nil {
+		return m.primary.PPFDSetting()
+	}
+	if setting := m.ppfdSetting.Load(); setting != nil {
+		return *setting
+	}
+	if m.PPFDPerLux > 0 {
+		return ppfdSettingFor(m.PPFDPerLux)
+	}
+	return ppfdSettingFor(SUNLIGHT_PPFD_PER_LUX)
+}
+
+// Use the PPFD setting saved through the API, if there is one, in place of PPFDPerLux
+func (m *SLMeter) LoadPPFDSetting() error {
+	source, ok, err := m.getSetting(SETTING_PPFD_SOURCE)
+	if err != nil || !ok {
+		return err
+	}
+	value, _, err := m.getSetting(SETTING_PPFD_PER_LUX)
+	if err != nil {
+		return err
+	}
+	ppfdPerLux, err := strconv.ParseFloat(value, 64)
+	if err != nil || ppfdPerLux <= 0 {
+		return fmt.Errorf("Invalid saved PPFD factor %q", value)
+	}
+	m.ppfdSetting.Store(&PPFDSetting{Source: source, PPFDPerLux: ppfdPerLux})
+	return nil
+}
+
+type PPFDSettings struct {
+	PPFDSetting
+	Sources []PPFDSource `json:"sources"`
+}
+
+// Serve the light source PPFD is estimated for, along with every source that can be picked
+func (m *SLMeter) ServePPFDSetting() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, http.StatusOK, PPFDSettings{PPFDSetting: m.PPFDSetting(), Sources: ppfdSources})
+	}
+}
+
+// Pick the light source PPFD is estimated for, or set a custom factor with source=custom and ppfd_per_lux.
+// The setting is saved, so it outlasts a restart, and the days already summarized get their DLI again.
+func (m *SLMeter) UpdatePPFDSetting() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setting := PPFDSetting{Source: r.PostForm.Get("source")}
+		value := r.PostForm.Get("ppfd_per_lux")
+		if setting.Source == "" && value != "" {
+			setting.Source = PPFD_SOURCE_CUSTOM
+		}
+		if setting.Source == PPFD_SOURCE_CUSTOM {
+			ppfdPerLux, err := strconv.ParseFloat(value, 64)
+			if err != nil || ppfdPerLux <= 0 {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "A custom source needs a ppfd_per_lux above 0", http.StatusBadRequest)
+				return
+			}
+			setting.PPFDPerLux = ppfdPerLux
+		} else {
+			ppfdPerLux, err := PPFDPerLuxFor(setting.Source)
+			if err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+				return
+			}
+			setting.PPFDPerLux = ppfdPerLux
+		}
+
+		err := m.setSetting(SETTING_PPFD_PER_LUX, strconv.FormatFloat(setting.PPFDPerLux, 'g', -1, 64))
+		if err == nil {
+			err = m.setSetting(SETTING_PPFD_SOURCE, setting.Source)
+		}
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.ppfdSetting.Store(&setting)
+		log.Println(fmt.Sprintf("Updated the PPFD source, Source: %s, PPFD per lux: %g", setting.Source, setting.PPFDPerLux))
+
+		// Every summarized day's DLI was worked out with the old factor
+		var first sql.NullString
+		err = tools.RetryBusy(func() error {
+			return m.ResultsDB.QueryRow("SELECT CAST(MIN(created_at) AS TEXT) FROM sunlight").Scan(&first)
+		})
+		if err != nil {
+			log.Println(fmt.Sprintf("Failed to find the first reading to summarize again: %s", err.Error()))
+		} else if first.Valid {
+			m.markSummaryStale(first.String)
+		}
+		serveJSON(w, http.StatusOK, PPFDSettings{PPFDSetting: setting, Sources: ppfdSources})
+	}
+}
@@ -0,0 +1,187 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postPPFDSetting(m *SLMeter, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/settings/ppfd", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	m.UpdatePPFDSetting().ServeHTTP(w, req)
+	return w
+}
+
+func closeTo(got float64, want float64) bool {
+	return math.Abs(got-want) < 1e-9
+}
+
+// 10,000 lux, a bright overcast day or a strong grow light, in µmol/m²/s for each source
+var ppfdAt10000Lux = map[string]float64{
+	PPFD_SOURCE_SUNLIGHT:    185,
+	PPFD_SOURCE_OVERCAST:    190,
+	PPFD_SOURCE_LED:         150,
+	PPFD_SOURCE_FLUORESCENT: 135,
+}
+
+func TestPPFDProfiles(t *testing.T) {
+	if len(ppfdSources) != len(ppfdAt10000Lux) {
+		t.Fatalf("got %d sources, pin the conversion of every one", len(ppfdSources))
+	}
+	for source, want := range ppfdAt10000Lux {
+		ppfdPerLux, err := PPFDPerLuxFor(source)
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if got := 10000 * ppfdPerLux; !closeTo(got, want) {
+			t.Errorf("%s: 10000 lux is %v µmol/m²/s, want %v", source, got, want)
+		}
+
+		// The conditions are converted with the meter's setting, before lux changes units
+		m := &SLMeter{}
+		m.ppfdSetting.Store(&PPFDSetting{Source: source, PPFDPerLux: ppfdPerLux})
+		conditions := m.withPPFD(Conditions{Lux: 10000}).inUnits(UNITS_FOOT_CANDLES)
+		if !closeTo(conditions.PPFD, want) || conditions.PPFDSource != source {
+			t.Errorf("%s: got %v µmol/m²/s from %q, want %v", source, conditions.PPFD, conditions.PPFDSource, want)
+		}
+		if setting := ppfdSettingFor(ppfdPerLux); setting.Source != source {
+			t.Errorf("%s: its factor was taken for %s", source, setting.Source)
+		}
+	}
+
+	// Custom has no factor of its own, and anything else isn't a source
+	for _, source := range []string{PPFD_SOURCE_CUSTOM, "", "Sunlight", "hps"} {
+		if _, err := PPFDPerLuxFor(source); err == nil {
+			t.Errorf("got a factor for %q", source)
+		}
+	}
+	if setting := ppfdSettingFor(0.02); setting.Source != PPFD_SOURCE_CUSTOM || setting.PPFDPerLux != 0.02 {
+		t.Errorf("got %+v, want a custom setting", setting)
+	}
+}
+
+func TestPPFDSettingDefaults(t *testing.T) {
+	if setting := (&SLMeter{}).PPFDSetting(); setting.Source != PPFD_SOURCE_SUNLIGHT || setting.PPFDPerLux != SUNLIGHT_PPFD_PER_LUX {
+		t.Errorf("got %+v, want sunlight by default", setting)
+	}
+	if setting := (&SLMeter{PPFDPerLux: LED_PPFD_PER_LUX}).PPFDSetting(); setting.Source != PPFD_SOURCE_LED {
+		t.Errorf("got %+v, want the flag's factor matched to its source", setting)
+	}
+
+	// Added sensors use the primary's setting
+	primary := &SLMeter{}
+	primary.ppfdSetting.Store(&PPFDSetting{Source: PPFD_SOURCE_CUSTOM, PPFDPerLux: 0.03})
+	sensor := &SLMeter{PPFDPerLux: LED_PPFD_PER_LUX, primary: primary}
+	if setting := sensor.PPFDSetting(); setting.Source != PPFD_SOURCE_CUSTOM || setting.PPFDPerLux != 0.03 {
+		t.Errorf("got %+v, want the primary's setting", setting)
+	}
+}
+
+func TestUpdatePPFDSetting(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+
+	tests := []struct {
+		name string
+		form url.Values
+		want PPFDSetting
+	}{
+		{"a source", url.Values{"source": {PPFD_SOURCE_FLUORESCENT}}, PPFDSetting{PPFD_SOURCE_FLUORESCENT, FLUORESCENT_PPFD_PER_LUX}},
+		{"a source ignores a factor", url.Values{"source": {PPFD_SOURCE_LED}, "ppfd_per_lux": {"0.5"}}, PPFDSetting{PPFD_SOURCE_LED, LED_PPFD_PER_LUX}},
+		{"custom", url.Values{"source": {PPFD_SOURCE_CUSTOM}, "ppfd_per_lux": {"0.021"}}, PPFDSetting{PPFD_SOURCE_CUSTOM, 0.021}},
+		{"only a factor is custom", url.Values{"ppfd_per_lux": {"0.017"}}, PPFDSetting{PPFD_SOURCE_CUSTOM, 0.017}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postPPFDSetting(m, tt.form)
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d, %s", w.Code, w.Body.String())
+			}
+			var settings PPFDSettings
+			if err := json.Unmarshal(w.Body.Bytes(), &settings); err != nil {
+				t.Fatal(err)
+			}
+			if settings.PPFDSetting != tt.want || m.PPFDSetting() != tt.want || len(settings.Sources) != len(ppfdSources) {
+				t.Errorf("got %+v, the meter has %+v, want %+v", settings, m.PPFDSetting(), tt.want)
+			}
+
+			// Saved, so a restarted meter picks it up
+			restarted := &SLMeter{ResultsDB: m.ResultsDB}
+			if err := restarted.LoadPPFDSetting(); err != nil {
+				t.Fatal(err)
+			}
+			if restarted.PPFDSetting() != tt.want {
+				t.Errorf("got %+v after a restart, want %+v", restarted.PPFDSetting(), tt.want)
+			}
+		})
+	}
+
+	for _, form := range []url.Values{
+		{},
+		{"source": {"hps"}},
+		{"source": {PPFD_SOURCE_CUSTOM}},
+		{"source": {PPFD_SOURCE_CUSTOM}, "ppfd_per_lux": {"0"}},
+		{"source": {PPFD_SOURCE_CUSTOM}, "ppfd_per_lux": {"-0.01"}},
+		{"ppfd_per_lux": {"lots"}},
+	} {
+		if w := postPPFDSetting(m, form); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", form.Encode(), w.Code, http.StatusBadRequest)
+		}
+	}
+	// A rejected update leaves the setting alone
+	if want := (PPFDSetting{PPFD_SOURCE_CUSTOM, 0.017}); m.PPFDSetting() != want {
+		t.Errorf("got %+v, want %+v", m.PPFDSetting(), want)
+	}
+}
+
+func TestPPFDInReadingsAndStats(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	m.ppfdSetting.Store(&PPFDSetting{Source: PPFD_SOURCE_LED, PPFDPerLux: LED_PPFD_PER_LUX})
+	readAt := time.Date(2024, 6, 21, 16, 0, 0, 0, time.UTC)
+	err := m.insertResults([]LuxResults{
+		{Lux: 10000, JobID: "job-1", CreatedAt: readAt},
+		{Lux: 20000, JobID: "job-1", CreatedAt: readAt.Add(time.Minute)},
+		{Saturated: true, JobID: "job-1", CreatedAt: readAt.Add(2 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var page ReadingsPage
+	if code := getReadings(t, m, url.Values{"include": {"ppfd"}, "order": {"asc"}, "units": {UNITS_FOOT_CANDLES}}, &page); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	if len(page.Readings) != 3 {
+		t.Fatalf("got %d readings", len(page.Readings))
+	}
+	for i, want := range []float64{150, 300} {
+		if ppfd := page.Readings[i].PPFD; ppfd == nil || !closeTo(*ppfd, want) {
+			t.Errorf("reading %d: got PPFD %v, want %v", i, ppfd, want)
+		}
+	}
+	if page.Readings[2].PPFD != nil {
+		t.Errorf("a saturated reading got PPFD %v", *page.Readings[2].PPFD)
+	}
+	// Left out unless it's asked for
+	var withoutPPFD ReadingsPage
+	if code := getReadings(t, m, url.Values{}, &withoutPPFD); code != http.StatusOK || withoutPPFD.Readings[0].PPFD != nil {
+		t.Errorf("got %d with PPFD without include=ppfd", code)
+	}
+	if code := getReadings(t, m, url.Values{"include": {"dli"}}, &ReadingsPage{}); code != http.StatusBadRequest {
+		t.Errorf("an unknown include: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	stats, err := m.getStats("2024-06-21 00:00:00", "2024-06-22 00:00:00", readingScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !closeTo(stats.PPFD.Min, 150) || !closeTo(stats.PPFD.Max, 300) || !closeTo(stats.PPFD.Mean, 225) || stats.PPFDSource != PPFD_SOURCE_LED {
+		t.Errorf("got PPFD %+v from %q, want 150 to 300 from LEDs", stats.PPFD, stats.PPFDSource)
+	}
+}
@@ -0,0 +1,313 @@
+package sunlightmeter
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	DEFAULT_PAGE_SIZE      = 100
+	MAX_PAGE_SIZE          = 1000
+	DEFAULT_READINGS_LIMIT = 1000
+	MAX_READINGS_LIMIT     = 10000
+)
+
+type Reading struct {
+	ID           int64    `json:"id"`
+	DeviceID     string   `json:"deviceID,omitempty"`
+	SensorID     string   `json:"sensorID,omitempty"` // Only when forwarded
+	JobID        string   `json:"jobID"`
+	Lux          float64  `json:"lux"`
+	FullSpectrum float64  `json:"fullSpectrum"`
+	Visible      float64  `json:"visible"`
+	Infrared     float64  `json:"infrared"`
+	CPUTemp      *float64 `json:"cpuTemp,omitempty"`
+	Saturated    bool     `json:"saturated"`
+	LightSource  string   `json:"lightSource,omitempty"`
+	CreatedAt    string   `json:"createdAt"`
+	PPFD         *float64 `json:"ppfd,omitempty"` // Only with include=ppfd
+	// The weather observed at the time, only in export.ndjson
+	CloudCover    *float64 `json:"cloudCover,omitempty"`
+	Precipitation *float64 `json:"precipitation,omitempty"`
+}
+
+// How readings are presented: lux in the units, and with or without an estimated PPFD
+type readingsFormat struct {
+	units      string
+	ppfd       bool
+	ppfdPerLux float64
+}
+
+func (m *SLMeter) readingsFormatFromRequest(r *http.Request) (readingsFormat, error) {
+	units, err := parseUnits(r.FormValue("units"))
+	if err != nil {
+		return readingsFormat{}, err
+	}
+	format := readingsFormat{units: units, ppfdPerLux: m.ppfdPerLux()}
+	for _, field := range strings.Split(r.FormValue("include"), ",") {
+		switch strings.TrimSpace(field) {
+		case "":
+		case "ppfd":
+			format.ppfd = true
+		default:
+			return readingsFormat{}, fmt.Errorf("Invalid include %q, expected ppfd", field)
+		}
+	}
+	return format, nil
+}
+
+// The PPFD is estimated from lux, so it's filled in before lux is converted
+func (f readingsFormat) apply(reading Reading) Reading {
+	if f.ppfd && !reading.Saturated {
+		ppfd := reading.Lux * f.ppfdPerLux
+		reading.PPFD = &ppfd
+	}
+	reading.Lux = convertLux(reading.Lux, f.units)
+	return reading
+}
+
+type ReadingsPage struct {
+	Readings   []Reading `json:"readings"`
+	Page       int       `json:"page"`
+	PageSize   int       `json:"pageSize"`
+	Sort       string    `json:"sort"`
+	Order      string    `json:"order"`
+	Total      int       `json:"total"`
+	TotalPages int       `json:"totalPages"`
+	Units      string    `json:"units,omitempty"`
+}
+
+type ReadingsCursorPage struct {
+	Readings   []Reading `json:"readings"`
+	Units      string    `json:"units"`
+	NextCursor *string   `json:"next_cursor"` // Null on the last page
+}
+
+// Only these columns can be sorted on, lux is stored as text so it's cast for ordering
+var readingSortColumns = map[string]string{
+	"created_at": "created_at",
+	"lux":        "CAST(lux AS REAL)",
+}
+
+// Serve a page of recorded readings, with sorting, lux in the units param, and PPFD with include=ppfd.
+// Filtering by date range or job, or passing a limit or cursor, pages through the readings by cursor instead,
+// and can't be combined with the page, page_size, sort and order of the numbered pages.
+func (m *SLMeter) ServeReadings() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := m.readingsFormatFromRequest(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The numbered pages cover every reading, so they can't be mixed with the cursor's filters
+		byPage := r.FormValue("page") != "" || r.FormValue("page_size") != "" || r.FormValue("sort") != "" || r.FormValue("order") != ""
+		if hasDateRange(r) || r.FormValue("job_id") != "" || r.FormValue("limit") != "" || r.FormValue("cursor") != "" {
+			if byPage {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "page, page_size, sort and order can't be combined with start, end, range, job_id, limit or cursor", http.StatusBadRequest)
+				return
+			}
+			m.serveReadingsAfterCursor(w, r, format)
+			return
+		}
+
+		page, err := parsePositiveInt(r.FormValue("page"), 1)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid page", http.StatusBadRequest)
+			return
+		}
+		pageSize, err := parsePositiveInt(r.FormValue("page_size"), DEFAULT_PAGE_SIZE)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid page_size", http.StatusBadRequest)
+			return
+		} else if pageSize > MAX_PAGE_SIZE {
+			pageSize = MAX_PAGE_SIZE
+		}
+
+		sort := r.FormValue("sort")
+		if sort == "" {
+			sort = "created_at"
+		}
+		sortColumn, ok := readingSortColumns[sort]
+		if !ok {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid sort, expected created_at or lux", http.StatusBadRequest)
+			return
+		}
+		order := r.FormValue("order")
+		if order == "" {
+			order = "desc"
+		} else if order != "asc" && order != "desc" {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid order, expected asc or desc", http.StatusBadRequest)
+			return
+		}
+
+		result := ReadingsPage{
+			Readings: []Reading{},
+			Page:     page,
+			PageSize: pageSize,
+			Sort:     sort,
+			Order:    order,
+			Units:    format.units,
+		}
+		err = m.ResultsDB.QueryRow("SELECT COUNT(*) FROM sunlight").Scan(&result.Total)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.TotalPages = (result.Total + pageSize - 1) / pageSize
+
+		// The sort column and order are from a fixed set, everything else is a parameter
+		rows, err := m.ResultsDB.Query(fmt.Sprintf(`
+    SELECT id, COALESCE(device_id, ?), job_id, lux, full_spectrum, visible, infrared, cpu_temp, saturated, COALESCE(light_source, ''), CAST(created_at AS TEXT)
+    FROM sunlight
+    ORDER BY %s %s, id %s
+    LIMIT ? OFFSET ?`, sortColumn, order, order), m.DeviceID, pageSize, (page-1)*pageSize)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var reading Reading
+			err := rows.Scan(&reading.ID, &reading.DeviceID, &reading.JobID, &reading.Lux, &reading.FullSpectrum, &reading.Visible, &reading.Infrared, &reading.CPUTemp, &reading.Saturated, &reading.LightSource, &reading.CreatedAt)
+			if err != nil {
+				tools.RequestLog(r).Error(err)
+				ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result.Readings = append(result.Readings, format.apply(reading))
+		}
+		if err := rows.Err(); err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serveJSON(w, http.StatusOK, result)
+	}
+}
+
+func parsePositiveInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	} else if parsed < 1 {
+		return 0, fmt.Errorf("must be positive: %d", parsed)
+	}
+	return parsed, nil
+}
+
+// Where a page of readings ended, the next page starts after it
+type readingsCursor struct {
+	CreatedAt string
+	ID        int64
+}
+
+func (c readingsCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d", c.CreatedAt, c.ID)))
+}
+
+func parseReadingsCursor(value string) (readingsCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return readingsCursor{}, err
+	}
+	createdAt, id, ok := strings.Cut(string(decoded), "|")
+	if !ok {
+		return readingsCursor{}, errors.New("missing id")
+	}
+	cursor := readingsCursor{CreatedAt: createdAt}
+	if cursor.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return readingsCursor{}, err
+	}
+	return cursor, nil
+}
+
+// Serve up to limit readings, oldest first, ordered by (created_at, id) so pages never skip or repeat a row.
+// next_cursor is null on the last page.
+func (m *SLMeter) serveReadingsAfterCursor(w http.ResponseWriter, r *http.Request, format readingsFormat) {
+	limit, err := parsePositiveInt(r.FormValue("limit"), DEFAULT_READINGS_LIMIT)
+	if err != nil {
+		ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid limit", http.StatusBadRequest)
+		return
+	} else if limit > MAX_READINGS_LIMIT {
+		limit = MAX_READINGS_LIMIT
+	}
+	startDate, endDate, err := parseStartAndEndDate(r)
+	if err != nil {
+		ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Without a date range, every reading is included
+	var where []string
+	var args []interface{}
+	if hasDateRange(r) {
+		where = append(where, "created_at BETWEEN ? AND ?")
+		args = append(args, startDate, endDate)
+	}
+	if jobID := r.FormValue("job_id"); jobID != "" {
+		where = append(where, "job_id = ?")
+		args = append(args, jobID)
+	}
+	if value := r.FormValue("cursor"); value != "" {
+		cursor, err := parseReadingsCursor(value)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "(created_at > ? OR (created_at = ? AND id > ?))")
+		args = append(args, cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+	query := "SELECT id, COALESCE(device_id, ?), job_id, lux, full_spectrum, visible, infrared, cpu_temp, saturated, COALESCE(light_source, ''), CAST(created_at AS TEXT) FROM sunlight"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at, id LIMIT ?"
+
+	// Fetch one extra row, to know if there's another page
+	rows, err := tools.QueryRetry(m.ResultsDB, query, append(append([]interface{}{m.DeviceID}, args...), limit+1)...)
+	if err != nil {
+		tools.RequestLog(r).Error(err)
+		ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	// The page is built before anything is written, so a failed read is an error rather than a truncated success
+	result := ReadingsCursorPage{Readings: []Reading{}, Units: format.units}
+	var last readingsCursor
+	for rows.Next() {
+		if len(result.Readings) == limit {
+			next := last.String()
+			result.NextCursor = &next
+			break
+		}
+		var reading Reading
+		err := rows.Scan(&reading.ID, &reading.DeviceID, &reading.JobID, &reading.Lux, &reading.FullSpectrum, &reading.Visible, &reading.Infrared, &reading.CPUTemp, &reading.Saturated, &reading.LightSource, &reading.CreatedAt)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Readings = append(result.Readings, format.apply(reading))
+		last = readingsCursor{CreatedAt: reading.CreatedAt, ID: reading.ID}
+	}
+	if err := rows.Err(); err != nil {
+		tools.RequestLog(r).Error(err)
+		ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,261 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// Record n readings a minute apart from start, with lux counting up from 1.
+// Every third reading shares the previous one's time, so pages have to break ties by id.
+func seedReadings(t testing.TB, m *SLMeter, start time.Time, n int) {
+	t.Helper()
+	results := make([]LuxResults, 0, n)
+	at := start
+	for i := 0; i < n; i++ {
+		if i%3 != 2 {
+			at = start.Add(time.Duration(i) * time.Minute)
+		}
+		results = append(results, LuxResults{Lux: float64(i + 1), JobID: "job-1", CreatedAt: at})
+	}
+	if err := m.insertResults(results); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getReadings(t *testing.T, m *SLMeter, query url.Values, v interface{}) int {
+	t.Helper()
+	w := serveAPIRequest(m.ServeReadings(), http.MethodGet, "/readings?"+query.Encode())
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("the response isn't JSON: %s", w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func TestReadingsPages(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t), DeviceID: "test-device"}
+	seedReadings(t, m, time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC), 25)
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantLen    int
+		wantFirst  float64
+		wantSize   int
+		totalPages int
+	}{
+		{"first page, newest first", url.Values{"page_size": {"10"}}, 10, 25, 10, 3},
+		{"last full page", url.Values{"page": {"2"}, "page_size": {"10"}}, 10, 15, 10, 3},
+		{"partial last page", url.Values{"page": {"3"}, "page_size": {"10"}}, 5, 5, 10, 3},
+		{"past the last page", url.Values{"page": {"4"}, "page_size": {"10"}}, 0, 0, 10, 3},
+		{"exactly one page", url.Values{"page_size": {"25"}}, 25, 25, 25, 1},
+		{"oldest first", url.Values{"page_size": {"10"}, "order": {"asc"}}, 10, 1, 10, 3},
+		{"by lux", url.Values{"page_size": {"3"}, "sort": {"lux"}, "order": {"asc"}}, 3, 1, 3, 9},
+		{"page size is capped", url.Values{"page_size": {"100000"}}, 25, 25, MAX_PAGE_SIZE, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var page ReadingsPage
+			if code := getReadings(t, m, tt.query, &page); code != http.StatusOK {
+				t.Fatalf("got %d", code)
+			}
+			if page.Readings == nil {
+				t.Fatal("readings should be an empty list, not null")
+			}
+			if len(page.Readings) != tt.wantLen {
+				t.Fatalf("got %d readings, want %d", len(page.Readings), tt.wantLen)
+			}
+			if tt.wantLen > 0 && page.Readings[0].Lux != tt.wantFirst {
+				t.Errorf("the page starts at lux %v, want %v", page.Readings[0].Lux, tt.wantFirst)
+			}
+			if page.Total != 25 || page.TotalPages != tt.totalPages || page.PageSize != tt.wantSize {
+				t.Errorf("got total %d, %d pages of %d, want 25, %d of %d", page.Total, page.TotalPages, page.PageSize, tt.totalPages, tt.wantSize)
+			}
+		})
+	}
+
+	for _, query := range []url.Values{
+		{"page": {"0"}},
+		{"page": {"-1"}},
+		{"page_size": {"0"}},
+		{"page": {"two"}},
+		{"sort": {"visible"}},
+		{"order": {"sideways"}},
+	} {
+		if code := getReadings(t, m, query, &ReadingsPage{}); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", query.Encode(), code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReadingsPagesWithoutReadings(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	var page ReadingsPage
+	if code := getReadings(t, m, url.Values{}, &page); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	if page.Readings == nil || len(page.Readings) != 0 || page.Total != 0 || page.TotalPages != 0 {
+		t.Errorf("got %+v, want an empty first page", page)
+	}
+}
+
+type cursorPage struct {
+	Readings   []Reading `json:"readings"`
+	NextCursor *string   `json:"next_cursor"`
+}
+
+// Follow next_cursor to the end, returning the lux of every reading and the size of each page
+func followCursor(t *testing.T, m *SLMeter, query url.Values) ([]float64, []int) {
+	t.Helper()
+	var luxes []float64
+	var sizes []int
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("the cursor never reached the last page")
+		}
+		var page cursorPage
+		if code := getReadings(t, m, query, &page); code != http.StatusOK {
+			t.Fatalf("got %d", code)
+		}
+		if page.Readings == nil {
+			t.Fatal("readings should be an empty list, not null")
+		}
+		sizes = append(sizes, len(page.Readings))
+		for _, reading := range page.Readings {
+			luxes = append(luxes, reading.Lux)
+		}
+		if page.NextCursor == nil {
+			return luxes, sizes
+		}
+		query.Set("cursor", *page.NextCursor)
+	}
+}
+
+func TestReadingsCursor(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	seedReadings(t, m, start, 25)
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []int
+	}{
+		{"partial last page", url.Values{"limit": {"10"}}, []int{10, 10, 5}},
+		// The extra row fetched to look ahead means an exact multiple doesn't end on an empty page
+		{"exact multiple", url.Values{"limit": {"5"}}, []int{5, 5, 5, 5, 5}},
+		{"one page", url.Values{"limit": {"25"}}, []int{25}},
+		{"one at a time", url.Values{"limit": {"1"}}, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{"limit is capped", url.Values{"limit": {"1000000"}}, []int{25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			luxes, sizes := followCursor(t, m, tt.query)
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.want) {
+				t.Errorf("got pages of %v, want %v", sizes, tt.want)
+			}
+			// Every reading once, oldest first, even where readings share a time across a page boundary
+			for i, lux := range luxes {
+				if lux != float64(i+1) {
+					t.Fatalf("got %v, want every reading once in order", luxes)
+				}
+			}
+			if len(luxes) != 25 {
+				t.Errorf("got %d readings, want 25", len(luxes))
+			}
+		})
+	}
+
+	t.Run("range boundaries are inclusive", func(t *testing.T) {
+		// 12:03 to 12:06 holds the readings at 12:03, 12:04 (twice, a tie), and 12:06
+		query := url.Values{"start": {timeToInputDate(start.Add(3 * time.Minute))}, "end": {timeToInputDate(start.Add(6 * time.Minute))}, "limit": {"2"}}
+		luxes, _ := followCursor(t, m, query)
+		if fmt.Sprint(luxes) != fmt.Sprint([]float64{4, 5, 6, 7}) {
+			t.Errorf("got %v, want the readings from 12:03 to 12:06", luxes)
+		}
+	})
+	t.Run("empty range", func(t *testing.T) {
+		query := url.Values{"start": {timeToInputDate(start.Add(-48 * time.Hour))}, "end": {timeToInputDate(start.Add(-24 * time.Hour))}}
+		luxes, sizes := followCursor(t, m, query)
+		if len(luxes) != 0 || fmt.Sprint(sizes) != "[0]" {
+			t.Errorf("got %v over pages %v, want one empty page", luxes, sizes)
+		}
+	})
+	t.Run("unknown job", func(t *testing.T) {
+		luxes, _ := followCursor(t, m, url.Values{"job_id": {"no-such-job"}})
+		if len(luxes) != 0 {
+			t.Errorf("got %v, want nothing", luxes)
+		}
+	})
+	t.Run("cursor past the end", func(t *testing.T) {
+		after := readingsCursor{CreatedAt: "2099-01-01 00:00:00", ID: 1}.String()
+		luxes, _ := followCursor(t, m, url.Values{"cursor": {after}})
+		if len(luxes) != 0 {
+			t.Errorf("got %v, want nothing", luxes)
+		}
+	})
+
+	for _, query := range []url.Values{
+		{"limit": {"0"}},
+		{"cursor": {"not base64!"}},
+		{"cursor": {readingsCursor{CreatedAt: "2024-06-21 12:00:00"}.String() + "x"}},
+		{"start": {timeToInputDate(start)}},
+		{"start": {timeToInputDate(start)}, "end": {timeToInputDate(start.Add(-time.Hour))}},
+		// The numbered pages' parameters would be ignored, and answered in a different order than asked
+		{"job_id": {"job-1"}, "sort": {"lux"}},
+		{"limit": {"10"}, "page": {"2"}},
+		{"range": {"24h"}, "order": {"asc"}},
+		{"cursor": {readingsCursor{CreatedAt: "2024-06-21 12:00:00", ID: 1}.String()}, "page_size": {"10"}},
+		{"start": {timeToInputDate(start)}, "end": {timeToInputDate(start.Add(time.Hour))}, "sort": {"created_at"}},
+	} {
+		if code := getReadings(t, m, query, &cursorPage{}); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", query.Encode(), code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReadingsCursorFailsWhole(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	start := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	seedReadings(t, m, start, 5)
+	// lux is stored as text, so a row can hold a value that doesn't scan as a number
+	if _, err := m.ResultsDB.Exec("INSERT INTO sunlight (job_id, lux, full_spectrum, visible, infrared, created_at) VALUES ('job-1', 'bright', 0, 0, 0, ?)", start.Add(10*time.Minute).Format("2006-01-02 15:04:05")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bad row is after the good ones, so readings had been read before the failure
+	w := serveAPIRequest(m.ServeReadings(), http.MethodGet, "/readings?limit=10")
+	assertErrorCode(t, w, http.StatusInternalServerError, tools.ERR_DB_ERROR)
+
+	// A page that stops before it is still served
+	var page cursorPage
+	if code := getReadings(t, m, url.Values{"limit": {"5"}}, &page); code != http.StatusOK || len(page.Readings) != 5 || page.NextCursor == nil {
+		t.Errorf("got %d with %d readings, want the first 5 and a cursor", code, len(page.Readings))
+	}
+}
+
+func TestReadingsRange(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	now := time.Now().UTC()
+	seedReadings(t, m, now.Add(-48*time.Hour), 1)
+	seedReadings(t, m, now.Add(-2*time.Hour), 1)
+
+	// A range picks the readings by cursor, only those in it
+	var page cursorPage
+	if code := getReadings(t, m, url.Values{"range": {"24h"}}, &page); code != http.StatusOK {
+		t.Fatalf("got %d", code)
+	}
+	if len(page.Readings) != 1 {
+		t.Errorf("got %d readings in the last 24h, want 1", len(page.Readings))
+	}
+	if code := getReadings(t, m, url.Values{"range": {"3d"}}, &page); code != http.StatusOK || len(page.Readings) != 2 {
+		t.Errorf("got %d with %d readings in the last 3 days, want 2", code, len(page.Readings))
+	}
+}
@@ -0,0 +1,197 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	DEFAULT_RELAY_ON_BELOW  = 1000
+	DEFAULT_RELAY_OFF_ABOVE = 3000
+)
+
+// In auto, the readings switch the relay. On and off hold it there until it's put back in auto.
+const (
+	RELAY_MODE_AUTO = "auto"
+	RELAY_MODE_ON   = "on"
+	RELAY_MODE_OFF  = "off"
+)
+
+// Switches a relay on a GPIO pin, e.g. for a grow light, from the primary sensor's readings
+type RelayConfig struct {
+	Pin       int  // BCM numbering
+	ActiveLow bool // The relay switches on when the pin is low, as most relay boards do
+	// The gap between the thresholds is the hysteresis, so a passing cloud doesn't flick the light
+	OnBelow  float64
+	OffAbove float64
+	// The hours the relay may be on, as offsets from midnight in the summary timezone.
+	// The window wraps past midnight when From is after To, and it's the whole day when they're equal.
+	From time.Duration
+	To   time.Duration
+}
+
+type Relay struct {
+	RelayConfig
+	pin tools.GPIOOutput
+
+	mu        sync.Mutex
+	mode      string
+	on        bool
+	changedAt time.Time
+}
+
+type RelayStatus struct {
+	Pin       int    `json:"pin"`
+	Mode      string `json:"mode"`
+	On        bool   `json:"on"`
+	ChangedAt string `json:"changedAt,omitempty"` // UTC
+}
+
+// Open the relay's pin, switched off, in auto
+func NewRelay(config RelayConfig) (*Relay, error) {
+	if config.OnBelow >= config.OffAbove {
+		return nil, fmt.Errorf("the relay's on threshold must be below its off threshold")
+	}
+	pin, err := tools.OpenGPIOOutput(config.Pin, config.ActiveLow)
+	if err != nil {
+		return nil, err
+	}
+	return &Relay{RelayConfig: config, pin: pin, mode: RELAY_MODE_AUTO}, nil
+}
+
+func (r *Relay) Close() error {
+	return r.pin.Close()
+}
+
+func (r *Relay) Status() RelayStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := RelayStatus{Pin: r.Pin, Mode: r.mode, On: r.on}
+	if !r.changedAt.IsZero() {
+		status.ChangedAt = r.changedAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	return status
+}
+
+// Whether the time is inside the hours the relay may be on
+func (r *Relay) inWindow(at time.Time) bool {
+	if r.From == r.To {
+		return true
+	}
+	offset := at.Sub(time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location()))
+	if r.From < r.To {
+		return offset >= r.From && offset < r.To
+	}
+	return offset >= r.From || offset < r.To
+}
+
+// Switch the relay, the caller holds the lock
+func (r *Relay) switchTo(on bool) error {
+	if err := r.pin.Set(on != r.ActiveLow); err != nil {
+		return err
+	}
+	r.on = on
+	r.changedAt = time.Now()
+	return nil
+}
+
+// Switch the relay for a reading, when it's in auto. Returns why it switched, or "" when it didn't.
+func (r *Relay) evaluate(lux float64, at time.Time) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mode != RELAY_MODE_AUTO {
+		return "", nil
+	}
+	var reason string
+	switch {
+	case r.on && !r.inWindow(at):
+		reason = "Relay off, outside its hours"
+	case r.on && lux >= r.OffAbove:
+		reason = fmt.Sprintf("Relay off, lux is above %.0f: %.0f", r.OffAbove, lux)
+	case !r.on && lux <= r.OnBelow && r.inWindow(at):
+		reason = fmt.Sprintf("Relay on, lux is below %.0f: %.0f", r.OnBelow, lux)
+	default:
+		return "", nil
+	}
+	return reason, r.switchTo(!r.on)
+}
+
+// Put the relay in a mode, switching it now for on and off. Auto leaves it as it is until the next reading.
+func (r *Relay) setMode(mode string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mode = mode
+	if mode == RELAY_MODE_AUTO || r.on == (mode == RELAY_MODE_ON) {
+		return false, nil
+	}
+	return true, r.switchTo(mode == RELAY_MODE_ON)
+}
+
+// Extra sensors share the primary meter's relay
+func (m *SLMeter) relay() *Relay {
+	if m.primary != nil {
+		return m.primary.Relay
+	}
+	return m.Relay
+}
+
+// Switch the relay for a recorded reading. Only the primary sensor's readings switch it, so sensors in the shade can't fight over it.
+func (m *SLMeter) updateRelay(result LuxResults) {
+	if m.Relay == nil || m.primary != nil {
+		return
+	}
+	reason, err := m.Relay.evaluate(result.Lux, time.Now().In(m.summaryLocation()))
+	if err != nil {
+		log.Println(fmt.Sprintf("Failed to switch the relay on GPIO %d: %s", m.Relay.Pin, err.Error()))
+		m.logEvent(SENSOR_EVENT_RELAY, result.JobID, fmt.Sprintf("Failed to switch the relay: %s", err.Error()))
+	} else if reason != "" {
+		log.Println(fmt.Sprintf("- JobID: %s, %s", result.JobID, reason))
+		m.logEvent(SENSOR_EVENT_RELAY, result.JobID, reason)
+	}
+}
+
+// Serve the relay's state
+func (m *SLMeter) ServeRelay() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		relay := m.relay()
+		if relay == nil {
+			ServeError(w, r, tools.ERR_RELAY_NOT_CONFIGURED, "The relay is not configured, start with -relay-pin", http.StatusServiceUnavailable)
+			return
+		}
+		serveJSON(w, http.StatusOK, relay.Status())
+	}
+}
+
+// Override the relay with on or off, or hand it back to the readings with auto
+func (m *SLMeter) SetRelayMode() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		relay := m.relay()
+		if relay == nil {
+			ServeError(w, r, tools.ERR_RELAY_NOT_CONFIGURED, "The relay is not configured, start with -relay-pin", http.StatusServiceUnavailable)
+			return
+		}
+		mode := chi.URLParam(r, "mode")
+		if mode != RELAY_MODE_AUTO && mode != RELAY_MODE_ON && mode != RELAY_MODE_OFF {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid relay mode, expected on, off or auto", http.StatusBadRequest)
+			return
+		}
+		switched, err := relay.setMode(mode)
+		if err != nil {
+			tools.RequestLog(r).WithError(err).Error(fmt.Sprintf("Failed to switch the relay on GPIO %d", relay.Pin))
+			ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if switched {
+			m.logEvent(SENSOR_EVENT_RELAY, m.JobID(), fmt.Sprintf("Relay %s, switched manually", mode))
+		} else if mode == RELAY_MODE_AUTO {
+			m.logEvent(SENSOR_EVENT_RELAY, m.JobID(), "Relay back in auto")
+		}
+		serveJSON(w, http.StatusOK, relay.Status())
+	}
+}
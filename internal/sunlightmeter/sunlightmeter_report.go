@@ -0,0 +1,117 @@
+package sunlightmeter
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// A digest of one day's light, in the summary timezone, to drop into an email or a chat message
+type Report struct {
+	Date           string  `json:"date"`
+	Timezone       string  `json:"timezone"`
+	Samples        int     `json:"samples"`
+	RecordedHours  float64 `json:"recordedHours"`
+	PeakLux        float64 `json:"peakLux"`
+	PeakAt         string  `json:"peakAt,omitempty"` // UTC
+	FullSunHours   float64 `json:"fullSunHours"`
+	LightCondition string  `json:"lightCondition"`
+	DLI            float64 `json:"dli"` // mol/m²/day, estimated from lux with the PPFD setting
+	// The sun's times, when the location is set, and the weather, when it's fetched
+	Sun     *SunDay       `json:"sun,omitempty"`
+	Weather *WeatherStats `json:"weather,omitempty"`
+	Units   string        `json:"units,omitempty"`
+}
+
+// Serve the report of a date in the summary timezone, yesterday by default.
+// It's JSON, or an HTML fragment with inline styles, for an email body, with format=html.
+// Today's report covers the day so far.
+func (m *SLMeter) ServeReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := r.FormValue("format")
+		if format != "" && format != "json" && format != "html" {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid format, expected json or html", http.StatusBadRequest)
+			return
+		}
+		loc := m.summaryLocation()
+		now := time.Now().In(loc)
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -1)
+		if date := r.FormValue("date"); date != "" {
+			if day, err = time.ParseInLocation("2006-01-02", date, loc); err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+
+		report, err := m.getReport(day, scopeFromRequest(r))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		report = report.inUnits(units)
+		if format != "html" {
+			serveJSON(w, http.StatusOK, report)
+			return
+		}
+
+		tmpl, err := getTemplate("report.gohtml")
+		if err != nil {
+			ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, struct {
+			Report
+			UnitsLabel string
+		}{report, unitsLabel(units)}); err != nil {
+			tools.RequestLog(r).Error(err)
+		}
+	}
+}
+
+// The report of the day starting at day, from its readings rather than its summary, so it works for today too
+func (m *SLMeter) getReport(day time.Time, scope readingScope) (Report, error) {
+	report := Report{Date: day.Format("2006-01-02"), Timezone: day.Location().String()}
+	// BETWEEN includes the end, which is the next day's first second
+	startDate := day.UTC().Format("2006-01-02 15:04:05")
+	endDate := day.AddDate(0, 0, 1).Add(-time.Second).UTC().Format("2006-01-02 15:04:05")
+
+	conditions, err := m.getHistoricalConditions(Conditions{}, startDate, endDate, scope)
+	if err != nil {
+		return report, err
+	}
+	report.RecordedHours = conditions.RecordedHoursInRange
+	report.FullSunHours = conditions.FullSunlightInRange
+	report.LightCondition = conditions.LightConditionInRange
+	if report.PeakLux, report.PeakAt, err = m.getPeak(startDate, endDate, scope); err != nil {
+		return report, err
+	}
+
+	// The same estimate as the daily summary, each reading's PPFD over RECORD_INTERVAL
+	filter, filterArgs := m.readingFilter(scope)
+	var totalLux float64
+	err = tools.RetryBusy(func() error {
+		return m.ResultsDB.QueryRow(`
+    SELECT COUNT(*), COALESCE(SUM(CAST(lux AS REAL)), 0)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter, append([]interface{}{startDate, endDate}, filterArgs...)...).Scan(&report.Samples, &totalLux)
+	})
+	if err != nil {
+		return report, err
+	}
+	report.DLI = totalLux * m.ppfdPerLux() * RECORD_INTERVAL.Seconds() / 1000000
+
+	if m.Location != nil {
+		sun := sunDayFor(day, *m.Location)
+		report.Sun = &sun
+	}
+	report.Weather, err = m.getWeatherStats(startDate, endDate)
+	return report, err
+}
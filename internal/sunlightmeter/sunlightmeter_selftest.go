@@ -0,0 +1,167 @@
+package sunlightmeter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+// Time for the sensor to complete a fresh integration cycle after the gain changes
+const SELFTEST_SETTLE = 250 * time.Millisecond
+
+type SelfTestReading struct {
+	Gain string   `json:"gain"`
+	Ch0  uint16   `json:"ch0"`
+	Ch1  uint16   `json:"ch1"`
+	Lux  *float64 `json:"lux"` // null when a channel is saturated
+}
+
+type SelfTestStep struct {
+	Name    string           `json:"name"`
+	Passed  bool             `json:"passed"`
+	Detail  string           `json:"detail"`
+	Reading *SelfTestReading `json:"reading,omitempty"`
+}
+
+type SelfTestReport struct {
+	Passed bool           `json:"passed"`
+	Steps  []SelfTestStep `json:"steps"`
+}
+
+// Check the sensor end-to-end: it answers with the right device ID, every gain level can be read,
+// and the channels respond to the gain changing. The configured gain/timing are restored afterwards.
+// A bus error on the first step points to the wiring, a sensor that answers but reads nonsense is likely dead.
+func (m *SLMeter) SelfTest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.TSL2591 == nil {
+			ServeError(w, r, tools.ERR_SENSOR_NOT_CONNECTED, "The sensor is not connected", http.StatusServiceUnavailable)
+			return
+		} else if m.JobID() != "" {
+			ServeError(w, r, tools.ERR_JOB_RUNNING, "Stop the running job before testing the sensor", http.StatusConflict)
+			return
+		}
+
+		var report SelfTestReport
+		err := m.withSensor(func() error {
+			report = m.runSelfTest()
+			return nil
+		})
+		m.logSensorRequest(r, "selftest", "", err)
+		if errors.Is(err, ErrSensorBusy) {
+			ServeError(w, r, tools.ERR_SENSOR_BUSY, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			// The sensor couldn't be powered on, so nothing else will work
+			report = SelfTestReport{Steps: []SelfTestStep{{Name: "enable", Detail: err.Error()}}}
+		}
+		if !report.Passed {
+			log.Println(fmt.Sprintf("The sensor failed its self-test: %+v", report.Steps))
+		}
+		serveJSON(w, http.StatusOK, report)
+	}
+}
+
+func (m *SLMeter) runSelfTest() SelfTestReport {
+	report := SelfTestReport{}
+	step := func(s SelfTestStep) {
+		report.Steps = append(report.Steps, s)
+	}
+
+	m.Lock()
+	info, err := m.DeviceInfo()
+	m.Unlock()
+	if err != nil {
+		step(SelfTestStep{Name: "device_id", Detail: fmt.Sprintf("Failed to read from the I2C bus, check the wiring: %s", err.Error())})
+		return report
+	} else if info.DeviceID != tsl2591.TSL2591_DEVICE_ID {
+		step(SelfTestStep{Name: "device_id", Detail: fmt.Sprintf("Expected device ID %#x, read %#x", tsl2591.TSL2591_DEVICE_ID, info.DeviceID)})
+		return report
+	}
+	step(SelfTestStep{Name: "device_id", Passed: true, Detail: fmt.Sprintf("Device ID %#x, Package ID %#x", info.DeviceID, info.PackageID)})
+
+	// Put the configured gain/timing back, whatever happens
+	gain, timing := m.Gain, m.Timing
+	defer func() {
+		if err := m.SetGainAndTiming(gain, timing); err != nil {
+			log.Println(fmt.Sprintf("Failed to restore the gain/timing after the self-test: %s", err.Error()))
+		}
+	}()
+
+	readings := []SelfTestReading{}
+	for _, g := range []byte{tsl2591.TSL2591_GAIN_LOW, tsl2591.TSL2591_GAIN_MED, tsl2591.TSL2591_GAIN_HIGH, tsl2591.TSL2591_GAIN_MAX} {
+		name := fmt.Sprintf("read_gain_%s", tsl2591.GainToString(g))
+		reading, err := m.selfTestRead(g)
+		if err != nil {
+			step(SelfTestStep{Name: name, Detail: err.Error()})
+			continue
+		}
+		readings = append(readings, reading)
+
+		switch {
+		case reading.Ch1 > reading.Ch0:
+			// Channel 1 sees a subset of what channel 0 does, so it can't be brighter
+			step(SelfTestStep{Name: name, Reading: &reading, Detail: "Infrared channel reads higher than full spectrum"})
+		case reading.Lux == nil:
+			step(SelfTestStep{Name: name, Passed: true, Reading: &reading, Detail: "Saturated, expected at high gain in bright light"})
+		default:
+			step(SelfTestStep{Name: name, Passed: true, Reading: &reading, Detail: "OK"})
+		}
+	}
+
+	// Identical counts at every gain mean the channels aren't updating
+	responds := SelfTestStep{Name: "channels_respond", Passed: true, Detail: "Channel counts change with the gain"}
+	if len(readings) < 2 {
+		responds = SelfTestStep{Name: "channels_respond", Detail: "Not enough successful reads to compare"}
+	} else {
+		stuck := true
+		for _, reading := range readings[1:] {
+			if reading.Ch0 != readings[0].Ch0 || reading.Ch1 != readings[0].Ch1 {
+				stuck = false
+			}
+		}
+		if stuck && readings[0].Ch0 != 0 {
+			responds = SelfTestStep{Name: "channels_respond", Detail: fmt.Sprintf("Channels read %d/%d at every gain", readings[0].Ch0, readings[0].Ch1)}
+		} else if stuck {
+			responds = SelfTestStep{Name: "channels_respond", Passed: true, Detail: "Channels read 0 at every gain, the sensor may be in the dark"}
+		}
+	}
+	step(responds)
+
+	report.Passed = true
+	for _, s := range report.Steps {
+		report.Passed = report.Passed && s.Passed
+	}
+	return report
+}
+
+// Read the raw channels at a gain, with the shortest integration time
+func (m *SLMeter) selfTestRead(gain byte) (SelfTestReading, error) {
+	reading := SelfTestReading{Gain: tsl2591.GainToString(gain)}
+	if err := m.SetGainAndTiming(gain, tsl2591.TSL2591_INTEGRATIONTIME_100MS); err != nil {
+		return reading, fmt.Errorf("Failed to set the gain: %w", err)
+	}
+	time.Sleep(SELFTEST_SETTLE)
+
+	m.Lock()
+	defer m.Unlock()
+	ch0, ch1, err := m.GetFullLuminosity()
+	if err != nil {
+		return reading, fmt.Errorf("Failed to read the channels: %w", err)
+	}
+	reading.Ch0, reading.Ch1 = ch0, ch1
+	if ch0 == 0 {
+		// No light at all, CalculateLux would divide by zero
+		lux := 0.0
+		reading.Lux = &lux
+	} else if lux, err := m.CalculateLux(ch0, ch1); err == nil {
+		reading.Lux = &lux
+	} else if !errors.Is(err, tsl2591.ErrOverflow) {
+		return reading, err
+	}
+	return reading, nil
+}
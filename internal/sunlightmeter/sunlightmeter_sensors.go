@@ -0,0 +1,146 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+const DEFAULT_SENSOR_ID = "main"
+
+// Which readings a query covers, beyond the date range. Empty fields match everything.
+type readingScope struct {
+	Device string
+	Sensor string
+	JobID  string
+}
+
+func scopeFromRequest(r *http.Request) readingScope {
+	return readingScope{Device: r.FormValue("device"), Sensor: r.FormValue("sensor")}
+}
+
+// Limit a query to the scope, as extra WHERE conditions
+func (m *SLMeter) scopeFilter(scope readingScope) (string, []interface{}) {
+	filter, args := m.deviceFilter(scope.Device)
+	if scope.Sensor != "" {
+		filter += " AND sensor_id = ?"
+		args = append(args, scope.Sensor)
+	}
+	if scope.JobID != "" {
+		filter += " AND job_id = ?"
+		args = append(args, scope.JobID)
+	}
+	return filter, args
+}
+
+// Limit a query on sunlight to the scope, leaving out saturated readings.
+// Saturated readings are kept, but their lux is meaningless, so they're left out of every average, peak and classification.
+func (m *SLMeter) readingFilter(scope readingScope) (string, []interface{}) {
+	filter, args := m.scopeFilter(scope)
+	return filter + " AND saturated = 0", args
+}
+
+// Add a sensor on another I2C bus. It runs its own jobs, and records through this meter.
+func (m *SLMeter) AddSensor(sensorID string, device *tsl2591.TSL2591) (*SLMeter, error) {
+	if sensorID == "" {
+		return nil, fmt.Errorf("A sensor ID is required")
+	} else if _, ok := m.Sensor(sensorID); ok {
+		return nil, fmt.Errorf("Sensor %s already exists", sensorID)
+	}
+	sensor := &SLMeter{
+		TSL2591:        device,
+		SensorID:       sensorID,
+		LuxResultsChan: m.LuxResultsChan,
+		ResultsDB:      m.ResultsDB,
+		DBPath:         m.DBPath,
+		Pid:            m.Pid,
+		NetInterface:   m.NetInterface,
+		RecordTemp:     m.RecordTemp,
+		PPFDPerLux:     m.PPFDPerLux,
+		Webhooks:       m.Webhooks,
+		DeviceID:       m.DeviceID,
+		Version:        m.Version,
+		Commit:         m.Commit,
+		primary:        m,
+	}
+	m.Sensors = append(m.Sensors, sensor)
+	return sensor, nil
+}
+
+// The meter for a sensor, this one when the ID is empty
+func (m *SLMeter) Sensor(sensorID string) (*SLMeter, bool) {
+	if sensorID == "" || sensorID == m.SensorID {
+		return m, true
+	}
+	for _, sensor := range m.Sensors {
+		if sensor.SensorID == sensorID {
+			return sensor, true
+		}
+	}
+	return nil, false
+}
+
+// Route the request to the meter for the sensor param, this one when it isn't set
+func (m *SLMeter) ForSensor(handler func(*SLMeter) http.HandlerFunc) http.HandlerFunc {
+	handlers := map[*SLMeter]http.HandlerFunc{m: handler(m)}
+	for _, sensor := range m.Sensors {
+		handlers[sensor] = handler(sensor)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		sensor, ok := m.Sensor(r.FormValue("sensor"))
+		if !ok {
+			ServeError(w, r, tools.ERR_NOT_FOUND, fmt.Sprintf("Unknown sensor %q", r.FormValue("sensor")), http.StatusNotFound)
+			return
+		}
+		handlers[sensor](w, r)
+	}
+}
+
+// Readings recorded before sensor IDs existed came from this sensor
+func (m *SLMeter) BackfillSensorID() error {
+	_, err := tools.ExecRetry(m.ResultsDB, "UPDATE sunlight SET sensor_id = ? WHERE sensor_id IS NULL AND device_id = ?", m.SensorID, m.DeviceID)
+	return err
+}
+
+// Settings are kept per sensor, the primary sensor uses the unprefixed key
+func (m *SLMeter) sensorSetting(key string) string {
+	if m.primary == nil {
+		return key
+	}
+	return fmt.Sprintf("%s:%s", m.SensorID, key)
+}
+
+type SensorStatus struct {
+	SensorID  string `json:"sensorID"`
+	Connected bool   `json:"connected"`
+	JobID     string `json:"jobID,omitempty"`
+	Paused    bool   `json:"paused,omitempty"`
+}
+
+// Serve each sensor, and the job it's running
+func (m *SLMeter) ServeSensors() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sensors := []SensorStatus{}
+		for _, sensor := range append([]*SLMeter{m}, m.Sensors...) {
+			sensors = append(sensors, SensorStatus{
+				SensorID:  sensor.SensorID,
+				Connected: sensor.TSL2591 != nil,
+				JobID:     sensor.JobID(),
+				Paused:    sensor.Paused(),
+			})
+		}
+		serveJSON(w, http.StatusOK, sensors)
+	}
+}
+
+// Resume the job each sensor was logging when the process last stopped
+func (m *SLMeter) ResumeAllLogging() {
+	for _, sensor := range append([]*SLMeter{m}, m.Sensors...) {
+		if err := sensor.ResumeLogging(); err != nil {
+			log.Println(fmt.Sprintf("Failed to resume logging for sensor %s: %s", sensor.SensorID, err.Error()))
+		}
+	}
+}
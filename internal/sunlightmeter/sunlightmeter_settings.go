@@ -0,0 +1,68 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const (
+	SETTING_LOGGING_ACTIVE = "logging_active"
+	SETTING_LOGGING_JOB_ID = "logging_job_id"
+)
+
+// Read a value from the settings store, ok is false if it has never been set
+func (m *SLMeter) getSetting(key string) (string, bool, error) {
+	var value string
+	err := m.ResultsDB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (m *SLMeter) setSetting(key string, value string) error {
+	_, err := m.ResultsDB.Exec(`
+    INSERT INTO settings (key, value, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`, key, value)
+	return err
+}
+
+// Remember whether a job is logging, so it can be resumed after a restart
+func (m *SLMeter) persistLoggingState(active bool, jobID string) {
+	err := m.setSetting(m.sensorSetting(SETTING_LOGGING_ACTIVE), fmt.Sprintf("%t", active))
+	if err == nil && active {
+		err = m.setSetting(m.sensorSetting(SETTING_LOGGING_JOB_ID), jobID)
+	}
+	if err != nil {
+		log.Println(fmt.Sprintf("Failed to persist the logging state: %s", err.Error()))
+	}
+}
+
+// Resume the job that was logging when the process last stopped
+func (m *SLMeter) ResumeLogging() error {
+	if m.TSL2591 == nil {
+		return errors.New("The sensor is not connected")
+	}
+	active, _, err := m.getSetting(m.sensorSetting(SETTING_LOGGING_ACTIVE))
+	if err != nil {
+		return err
+	} else if active != "true" {
+		return nil
+	}
+	jobID, ok, err := m.getSetting(m.sensorSetting(SETTING_LOGGING_JOB_ID))
+	if err != nil {
+		return err
+	} else if !ok || jobID == "" {
+		return errors.New("no job to resume")
+	}
+
+	if err := m.startJobWithID(jobID); err != nil {
+		return err
+	}
+	log.Println(fmt.Sprintf("Auto-resumed logging for job %s", jobID))
+	return nil
+}
@@ -0,0 +1,239 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+type ChannelStats struct {
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+}
+
+// The stats multiplied by a factor, for values derived from them
+func (c ChannelStats) scaled(factor float64) ChannelStats {
+	return ChannelStats{
+		Min:    c.Min * factor,
+		Max:    c.Max * factor,
+		Mean:   c.Mean * factor,
+		Median: c.Median * factor,
+		P95:    c.P95 * factor,
+	}
+}
+
+type Stats struct {
+	DateRange string       `json:"dateRange"`
+	Samples   int          `json:"samples"`
+	Coverage  float64      `json:"coverage"`
+	PeakAt    string       `json:"peakAt,omitempty"`
+	Lux       ChannelStats `json:"lux"`
+	Visible   ChannelStats `json:"visible"`
+	Infrared  ChannelStats `json:"infrared"`
+	// Estimated from lux with the PPFD setting, in µmol/m²/s
+	PPFD       ChannelStats `json:"ppfd"`
+	PPFDSource string       `json:"ppfdSource"`
+	// How many readings were classified as each light source
+	LightSources map[string]int `json:"lightSources"`
+	// The weather observed over the range, when it's fetched
+	Weather *WeatherStats `json:"weather,omitempty"`
+	Units   string        `json:"units,omitempty"`
+}
+
+// Serve the lux, visible, and infrared statistics for the date range, with lux in the units
+func (m *SLMeter) ServeStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := m.getStats(startDate, endDate, scopeFromRequest(r))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, stats.inUnits(units))
+	}
+}
+
+// Percentiles are picked by sqlite, one row at a time, so a huge range is never loaded into memory
+func (m *SLMeter) getStats(startDate string, endDate string, scope readingScope) (Stats, error) {
+	setting := m.PPFDSetting()
+	stats := Stats{DateRange: fmt.Sprintf("%s - %s UTC", startDate, endDate), PPFDSource: setting.Source}
+	filter, filterArgs := m.readingFilter(scope)
+	args := append([]interface{}{startDate, endDate}, filterArgs...)
+
+	err := tools.RetryBusy(func() error {
+		row := m.ResultsDB.QueryRow(`
+    SELECT COUNT(*),
+        COALESCE(MIN(CAST(lux AS REAL)), 0), COALESCE(MAX(CAST(lux AS REAL)), 0), COALESCE(AVG(CAST(lux AS REAL)), 0),
+        COALESCE(MIN(CAST(visible AS REAL)), 0), COALESCE(MAX(CAST(visible AS REAL)), 0), COALESCE(AVG(CAST(visible AS REAL)), 0),
+        COALESCE(MIN(CAST(infrared AS REAL)), 0), COALESCE(MAX(CAST(infrared AS REAL)), 0), COALESCE(AVG(CAST(infrared AS REAL)), 0)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter, args...)
+		return row.Scan(&stats.Samples,
+			&stats.Lux.Min, &stats.Lux.Max, &stats.Lux.Mean,
+			&stats.Visible.Min, &stats.Visible.Max, &stats.Visible.Mean,
+			&stats.Infrared.Min, &stats.Infrared.Max, &stats.Infrared.Mean,
+		)
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.LightSources, err = m.getLightSourceCounts(startDate, endDate, scope)
+	if err != nil {
+		return stats, err
+	}
+	stats.Weather, err = m.getWeatherStats(startDate, endDate)
+	if err != nil || stats.Samples == 0 {
+		return stats, err
+	}
+
+	// The share of the range that was recorded, assuming a sample every RECORD_INTERVAL
+	start, end, err := startAndEndDateToTime(startDate, endDate)
+	if err != nil {
+		return stats, err
+	}
+	if wallTime := end.Sub(start); wallTime > 0 {
+		stats.Coverage = math.Min(1, float64(stats.Samples)*RECORD_INTERVAL.Seconds()/wallTime.Seconds())
+	}
+
+	_, stats.PeakAt, err = m.getPeak(startDate, endDate, scope)
+	if err != nil {
+		return stats, err
+	}
+
+	channels := []struct {
+		column string
+		stats  *ChannelStats
+	}{
+		{"lux", &stats.Lux},
+		{"visible", &stats.Visible},
+		{"infrared", &stats.Infrared},
+	}
+	for _, channel := range channels {
+		if channel.stats.Median, err = m.getPercentile(channel.column, 0.5, stats.Samples, filter, args); err != nil {
+			return stats, err
+		}
+		if channel.stats.P95, err = m.getPercentile(channel.column, 0.95, stats.Samples, filter, args); err != nil {
+			return stats, err
+		}
+	}
+	stats.PPFD = stats.Lux.scaled(setting.PPFDPerLux)
+	return stats, nil
+}
+
+// The nearest-rank percentile of a column, out of count samples.
+// The column is from a fixed set, everything else is a parameter.
+func (m *SLMeter) getPercentile(column string, percentile float64, count int, filter string, args []interface{}) (float64, error) {
+	rank := int(math.Ceil(percentile*float64(count))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	var value float64
+	err := tools.RetryBusy(func() error {
+		row := m.ResultsDB.QueryRow(fmt.Sprintf(`
+    SELECT CAST(%s AS REAL)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    ORDER BY CAST(%s AS REAL)
+    LIMIT 1 OFFSET ?`, column, column), append(append([]interface{}{}, args...), rank)...)
+		return row.Scan(&value)
+	})
+	return value, err
+}
+
+// The highest lux in the date range, and when it was recorded.
+// The earliest reading wins a tie, and nothing is returned for an empty range.
+func (m *SLMeter) getPeak(startDate string, endDate string, scope readingScope) (float64, string, error) {
+	filter, filterArgs := m.readingFilter(scope)
+	var lux float64
+	var createdAt string
+	err := tools.RetryBusy(func() error {
+		row := m.ResultsDB.QueryRow(`
+    SELECT CAST(lux AS REAL), CAST(created_at AS TEXT)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    ORDER BY CAST(lux AS REAL) DESC, created_at
+    LIMIT 1`, append([]interface{}{startDate, endDate}, filterArgs...)...)
+		return row.Scan(&lux, &createdAt)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", nil
+	}
+	return lux, createdAt, err
+}
+
+type HourStats struct {
+	Hour       int     `json:"hour"`
+	AverageLux float64 `json:"averageLux"`
+	MaxLux     float64 `json:"maxLux"`
+	Samples    int     `json:"samples"`
+}
+
+// Serve the average and max lux for each hour of the day (UTC) across the date range.
+// Hours without readings are included, with zeros.
+func (m *SLMeter) ServeHistogram() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hours, err := m.getHourlyHistogram(startDate, endDate, scopeFromRequest(r))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		serveJSON(w, http.StatusOK, struct {
+			DateRange string      `json:"dateRange"`
+			Hours     []HourStats `json:"hours"`
+		}{
+			DateRange: fmt.Sprintf("%s - %s UTC", startDate, endDate),
+			Hours:     hours,
+		})
+	}
+}
+
+func (m *SLMeter) getHourlyHistogram(startDate string, endDate string, scope readingScope) ([]HourStats, error) {
+	hours := make([]HourStats, 24)
+	for hour := range hours {
+		hours[hour].Hour = hour
+	}
+
+	filter, filterArgs := m.readingFilter(scope)
+	rows, err := tools.QueryRetry(m.ResultsDB, `
+    SELECT CAST(strftime('%H', created_at) AS INTEGER), AVG(CAST(lux AS REAL)), MAX(CAST(lux AS REAL)), COUNT(*)
+    FROM sunlight
+    WHERE created_at BETWEEN ? AND ?`+filter+`
+    GROUP BY strftime('%H', created_at)`, append([]interface{}{startDate, endDate}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hour HourStats
+		if err := rows.Scan(&hour.Hour, &hour.AverageLux, &hour.MaxLux, &hour.Samples); err != nil {
+			return nil, err
+		}
+		if hour.Hour >= 0 && hour.Hour < 24 {
+			hours[hour.Hour] = hour
+		}
+	}
+	return hours, rows.Err()
+}
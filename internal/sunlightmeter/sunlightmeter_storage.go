@@ -0,0 +1,58 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	DEFAULT_MIN_FREE_DISK       = 200 << 20 // 200MB
+	DEFAULT_DISK_CHECK_INTERVAL = time.Minute
+)
+
+// Check the free space on the db's filesystem every interval.
+// Recording pauses while it's below MinFreeDisk, and resumes once space is freed.
+func (m *SLMeter) MonitorDiskSpace(interval time.Duration) {
+	if m.MinFreeDisk == 0 {
+		return
+	} else if interval <= 0 {
+		interval = DEFAULT_DISK_CHECK_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.checkDiskSpace()
+		<-ticker.C
+	}
+}
+
+func (m *SLMeter) checkDiskSpace() {
+	diskUsage := m.DiskUsage
+	if diskUsage == nil {
+		diskUsage = tools.ReadDiskUsage
+	}
+	usage, err := diskUsage(m.DBPath)
+	if err != nil {
+		log.Println(fmt.Sprintf("Failed to check free disk space: %s", err.Error()))
+		return
+	}
+
+	full := usage.Free < m.MinFreeDisk
+	if wasFull := m.storageFull.Swap(full); full && !wasFull {
+		log.Println(fmt.Sprintf("!!! STORAGE FULL: %d MB free, below the %d MB minimum. Recording is paused until space is freed !!!",
+			usage.Free>>20, m.MinFreeDisk>>20))
+	} else if !full && wasFull {
+		log.Println(fmt.Sprintf("Storage available again: %d MB free, recording resumed", usage.Free>>20))
+	}
+}
+
+// Whether recording is paused, because the db's filesystem is almost full
+func (m *SLMeter) StorageFull() bool {
+	if m.primary != nil {
+		return m.primary.StorageFull()
+	}
+	return m.storageFull.Load()
+}
@@ -0,0 +1,121 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+func TestDiskSpaceGuard(t *testing.T) {
+	var free atomic.Uint64
+	var usageErr atomic.Bool
+	var checkedPath atomic.Value
+	m := &SLMeter{
+		ResultsDB:      newTestDB(t),
+		LuxResultsChan: make(chan LuxResults, RESULTS_BUFFER),
+		SensorID:       DEFAULT_SENSOR_ID,
+		DBPath:         "/data/sunlightmeter.db",
+		MinFreeDisk:    DEFAULT_MIN_FREE_DISK,
+		DiskUsage: func(path string) (tools.DiskUsage, error) {
+			checkedPath.Store(path)
+			if usageErr.Load() {
+				return tools.DiskUsage{}, errors.New("statfs failed")
+			}
+			return tools.DiskUsage{Total: 8 << 30, Free: free.Load()}, nil
+		},
+	}
+	startRecorder(m)
+
+	// Record one reading, and one failed read, then report how many of each were written
+	record := func() (int, int) {
+		t.Helper()
+		m.LuxResultsChan <- LuxResults{Lux: 100, JobID: "job-1"}
+		m.LuxResultsChan <- LuxResults{Failed: true, Error: "i2c timeout", JobID: "job-1"}
+		m.FlushResults(5 * time.Second)
+		return countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight"), countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM read_failures")
+	}
+	systemStorageFull := func() bool {
+		t.Helper()
+		w := serveAPIRequest(m.ServeSystemInfo(), http.MethodGet, "/system")
+		var body struct {
+			StorageFull bool `json:"storage_full"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.StorageFull
+	}
+
+	free.Store(DEFAULT_MIN_FREE_DISK + 100<<20)
+	m.checkDiskSpace()
+	if path, _ := checkedPath.Load().(string); path != m.DBPath {
+		t.Errorf("checked %q, want the db's path", path)
+	}
+	if m.StorageFull() || systemStorageFull() {
+		t.Fatal("storage is full with space to spare")
+	}
+	if readings, failures := record(); readings != 1 || failures != 1 {
+		t.Fatalf("got %d readings and %d failures, want 1 of each", readings, failures)
+	}
+
+	// Just below the minimum, nothing more is written
+	free.Store(DEFAULT_MIN_FREE_DISK - 1)
+	m.checkDiskSpace()
+	if !m.StorageFull() || !systemStorageFull() {
+		t.Fatal("storage isn't full below the minimum")
+	}
+	if readings, failures := record(); readings != 1 || failures != 1 {
+		t.Errorf("got %d readings and %d failures while full, want nothing new", readings, failures)
+	}
+
+	// A failed check keeps the last state, rather than resuming on a disk that's still full
+	usageErr.Store(true)
+	m.checkDiskSpace()
+	if !m.StorageFull() {
+		t.Error("a failed check resumed recording")
+	}
+	usageErr.Store(false)
+
+	// Exactly at the minimum is enough to resume
+	free.Store(DEFAULT_MIN_FREE_DISK)
+	m.checkDiskSpace()
+	if m.StorageFull() || systemStorageFull() {
+		t.Fatal("recording didn't resume once space was freed")
+	}
+	if readings, failures := record(); readings != 2 || failures != 2 {
+		t.Errorf("got %d readings and %d failures after resuming, want 2 of each", readings, failures)
+	}
+}
+
+func TestDiskSpaceGuardSharedBySensors(t *testing.T) {
+	primary := &SLMeter{SensorID: DEFAULT_SENSOR_ID, MinFreeDisk: DEFAULT_MIN_FREE_DISK, DiskUsage: func(string) (tools.DiskUsage, error) {
+		return tools.DiskUsage{Free: 0}, nil
+	}}
+	extra := &SLMeter{SensorID: "shade", primary: primary}
+	primary.checkDiskSpace()
+	if !extra.StorageFull() {
+		t.Error("an added sensor kept recording while the primary's storage is full")
+	}
+}
+
+func TestMonitorDiskSpaceDisabled(t *testing.T) {
+	m := &SLMeter{DiskUsage: func(string) (tools.DiskUsage, error) {
+		t.Error("the disk was checked with the guard disabled")
+		return tools.DiskUsage{}, nil
+	}}
+	done := make(chan struct{})
+	go func() {
+		m.MonitorDiskSpace(time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("MonitorDiskSpace kept running with MinFreeDisk at 0")
+	}
+}
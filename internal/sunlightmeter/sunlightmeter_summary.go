@@ -0,0 +1,249 @@
+package sunlightmeter
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	FULL_SUN_LUX             = 10000
+	DAYLIGHT_LUX             = 10     // First and last light are the first and last readings above this
+	SUNLIGHT_PPFD_PER_LUX    = 0.0185 // µmol/m²/s of photosynthetic light per lux of sunlight
+	SUMMARY_INTERVAL         = time.Hour
+	SUMMARY_LOOKBACK_DAYS    = 2
+	MAX_SUMMARY_BACKFILL     = 366 * 24 * time.Hour
+	SUMMARY_GRAPH_AFTER_DAYS = 31
+)
+
+type DaySummary struct {
+	Date       string  `json:"date"`
+	DeviceID   string  `json:"deviceID"`
+	Samples    int     `json:"samples"`
+	AverageLux float64 `json:"averageLux"`
+	MinLux     float64 `json:"minLux"`
+	MaxLux     float64 `json:"maxLux"`
+	SunHours   float64 `json:"sunHours"`
+	DLI        float64 `json:"dli"`
+	FirstLight *string `json:"firstLight"`
+	LastLight  *string `json:"lastLight"`
+	// The day's average cloud cover in percent, and its precipitation in mm, when the weather is fetched
+	CloudCover    *float64 `json:"cloudCover"`
+	Precipitation *float64 `json:"precipitation"`
+	Units         string   `json:"units,omitempty"`
+	// The sun's times on the day, when the location is set
+	Sun *SunDay `json:"sun,omitempty"`
+}
+
+// Tracks the oldest day that needs summarizing again, after readings arrive late
+type summaryState struct {
+	mu    sync.Mutex
+	stale time.Time
+}
+
+// Summarize each completed day every SUMMARY_INTERVAL, in SummaryLocation.
+// The last SUMMARY_LOOKBACK_DAYS are always summarized again, along with any day that imported or ingested readings landed in.
+func (m *SLMeter) RunSummarizer() {
+	ticker := time.NewTicker(SUMMARY_INTERVAL)
+	defer ticker.Stop()
+	for {
+		if err := m.summarizeCompletedDays(); err != nil {
+			log.Println(fmt.Sprintf("Failed to summarize days: %s", err.Error()))
+		}
+		<-ticker.C
+	}
+}
+
+// Mark the day holding createdAt, and every day after it, to be summarized again
+func (m *SLMeter) markSummaryStale(createdAt string) {
+	t, err := time.Parse("2006-01-02 15:04:05", createdAt)
+	if err != nil {
+		return
+	}
+	m.summary.mu.Lock()
+	defer m.summary.mu.Unlock()
+	if m.summary.stale.IsZero() || t.Before(m.summary.stale) {
+		m.summary.stale = t
+	}
+}
+
+func (m *SLMeter) summaryLocation() *time.Location {
+	if m.SummaryLocation == nil {
+		return time.UTC
+	}
+	return m.SummaryLocation
+}
+
+func (m *SLMeter) summarizeCompletedDays() error {
+	loc := m.summaryLocation()
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	// Start after the last summarized day, less the lookback, or from the first reading
+	from := today.AddDate(0, 0, -SUMMARY_LOOKBACK_DAYS)
+	var lastDate sql.NullString
+	err := tools.RetryBusy(func() error {
+		return m.ResultsDB.QueryRow("SELECT MAX(date) FROM daily_summary").Scan(&lastDate)
+	})
+	if err != nil {
+		return err
+	}
+	if lastDate.Valid {
+		if last, err := time.ParseInLocation("2006-01-02", lastDate.String, loc); err == nil && last.Before(from) {
+			from = last
+		}
+	} else {
+		var first sql.NullString
+		err := tools.RetryBusy(func() error {
+			return m.ResultsDB.QueryRow("SELECT CAST(MIN(created_at) AS TEXT) FROM sunlight").Scan(&first)
+		})
+		if err != nil {
+			return err
+		} else if first.Valid {
+			m.markSummaryStale(first.String)
+		}
+	}
+
+	m.summary.mu.Lock()
+	stale := m.summary.stale
+	m.summary.stale = time.Time{}
+	m.summary.mu.Unlock()
+	if !stale.IsZero() && stale.Before(from) {
+		from = stale.In(loc)
+	}
+	if oldest := today.Add(-MAX_SUMMARY_BACKFILL); from.Before(oldest) {
+		from = oldest
+	}
+
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	for day := from; day.Before(today); day = day.AddDate(0, 0, 1) {
+		if err := m.summarizeDay(day); err != nil {
+			// Try again on the next run
+			m.markSummaryStale(day.UTC().Format("2006-01-02 15:04:05"))
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the summary of each device's readings on the day, replacing any earlier summary of it
+func (m *SLMeter) summarizeDay(day time.Time) error {
+	date := day.Format("2006-01-02")
+	start := day.UTC().Format("2006-01-02 15:04:05")
+	end := day.AddDate(0, 0, 1).UTC().Format("2006-01-02 15:04:05")
+
+	return tools.RetryBusy(func() error {
+		tx, err := m.ResultsDB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Devices can drop out between runs, so the day is rebuilt from scratch
+		if _, err := tx.Exec("DELETE FROM daily_summary WHERE date = ?", date); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+    INSERT INTO daily_summary (date, device_id, samples, avg_lux, min_lux, max_lux, sun_hours, dli, first_light, last_light, cloud_cover, precipitation)
+    SELECT ?, device, COUNT(*), AVG(lux), MIN(lux), MAX(lux),
+        (SELECT COUNT(*) FROM (
+            SELECT AVG(CAST(s.lux AS REAL)) AS avg_lux
+            FROM sunlight s
+            WHERE s.created_at >= ? AND s.created_at < ? AND s.saturated = 0 AND COALESCE(s.device_id, ?) = device
+            GROUP BY strftime('%H:%M', s.created_at)
+        ) WHERE avg_lux > ?) / 60.0,
+        AVG(lux) * ? * COUNT(*) * ? / 1000000.0,
+        MIN(CASE WHEN lux > ? THEN created_at END),
+        MAX(CASE WHEN lux > ? THEN created_at END),
+        (SELECT AVG(cloud_cover) FROM weather WHERE observed_at >= ? AND observed_at < ?),
+        (SELECT SUM(precipitation) FROM weather WHERE observed_at >= ? AND observed_at < ?)
+    FROM (
+        SELECT COALESCE(device_id, ?) AS device, CAST(lux AS REAL) AS lux, CAST(created_at AS TEXT) AS created_at
+        FROM sunlight
+        WHERE created_at >= ? AND created_at < ? AND saturated = 0
+    )
+    GROUP BY device`,
+			date,
+			start, end, m.DeviceID, FULL_SUN_LUX,
+			m.ppfdPerLux(), RECORD_INTERVAL.Seconds(),
+			DAYLIGHT_LUX, DAYLIGHT_LUX,
+			start, end, start, end,
+			m.DeviceID, start, end,
+		)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// Serve the daily summaries in the date range
+func (m *SLMeter) ServeDays() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startDate, endDate, err := parseStartAndEndDate(r)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_DATE_RANGE, err.Error(), http.StatusBadRequest)
+			return
+		}
+		units, err := parseUnits(r.FormValue("units"))
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, err.Error(), http.StatusBadRequest)
+			return
+		}
+		days, err := m.getDaySummaries(startDate, endDate, r.FormValue("device"))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range days {
+			days[i] = days[i].inUnits(units)
+		}
+		serveJSON(w, http.StatusOK, days)
+	}
+}
+
+// The summaries of the days the date range touches, oldest first
+func (m *SLMeter) getDaySummaries(startDate string, endDate string, device string) ([]DaySummary, error) {
+	start, end, err := startAndEndDateToTime(startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	loc := m.summaryLocation()
+	args := []interface{}{start.In(loc).Format("2006-01-02"), end.In(loc).Format("2006-01-02")}
+	query := `
+    SELECT date, device_id, samples, avg_lux, min_lux, max_lux, sun_hours, dli, CAST(first_light AS TEXT), CAST(last_light AS TEXT), cloud_cover, precipitation
+    FROM daily_summary
+    WHERE date BETWEEN ? AND ?`
+	if device != "" {
+		query += " AND device_id = ?"
+		args = append(args, device)
+	}
+	rows, err := tools.QueryRetry(m.ResultsDB, query+" ORDER BY date, device_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []DaySummary{}
+	for rows.Next() {
+		var day DaySummary
+		err := rows.Scan(&day.Date, &day.DeviceID, &day.Samples, &day.AverageLux, &day.MinLux, &day.MaxLux, &day.SunHours, &day.DLI, &day.FirstLight, &day.LastLight, &day.CloudCover, &day.Precipitation)
+		if err != nil {
+			return nil, err
+		}
+		if m.Location != nil {
+			if date, err := time.ParseInLocation("2006-01-02", day.Date, loc); err == nil {
+				sun := sunDayFor(date, *m.Location)
+				day.Sun = &sun
+			}
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
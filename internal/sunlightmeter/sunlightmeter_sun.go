@@ -0,0 +1,93 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/ztkent/sunlight-meter/internal/solar"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// When the sun rose and set on a day, at the configured location.
+// Times are UTC, like the readings, and sunrise and sunset are null while the sun stays up, or down, all day.
+type SunDay struct {
+	Date      string  `json:"date"`
+	Sunrise   *string `json:"sunrise"`
+	Sunset    *string `json:"sunset"`
+	SolarNoon string  `json:"solarNoon"`
+	DayLength float64 `json:"dayLength"` // In hours
+}
+
+func sunDayFor(day time.Time, location solar.Location) SunDay {
+	sun := solar.SunTimes(day, location)
+	sunDay := SunDay{
+		Date:      day.Format("2006-01-02"),
+		SolarNoon: sun.SolarNoon.UTC().Format("2006-01-02 15:04:05"),
+		DayLength: math.Round(sun.Length.Hours()*100) / 100,
+	}
+	if !sun.Sunrise.IsZero() {
+		sunrise, sunset := sun.Sunrise.UTC().Format("2006-01-02 15:04:05"), sun.Sunset.UTC().Format("2006-01-02 15:04:05")
+		sunDay.Sunrise, sunDay.Sunset = &sunrise, &sunset
+	}
+	return sunDay
+}
+
+// Serve the sunrise, sunset, solar noon, and day length of a date in the summary timezone, today by default
+func (m *SLMeter) ServeSun() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.Location == nil {
+			ServeError(w, r, tools.ERR_LOCATION_NOT_SET, ErrLocationNotSet.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		loc := m.summaryLocation()
+		day := time.Now().In(loc)
+		if date := r.FormValue("date"); date != "" {
+			var err error
+			if day, err = time.ParseInLocation("2006-01-02", date, loc); err != nil {
+				ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+		}
+		serveJSON(w, http.StatusOK, sunDayFor(day, *m.Location))
+	}
+}
+
+// The hours the sun was up between two readings, or 0 when the location isn't set
+func (m *SLMeter) daylightHours(from time.Time, to time.Time) float64 {
+	if m.Location == nil {
+		return 0
+	}
+	return solar.DaylightBetween(from, to, *m.Location).Hours()
+}
+
+// Marker lines at each sunrise and sunset the graph's readings span, labeled in the summary timezone
+func (m *SLMeter) sunMarkers(startDate string, endDate string, timeValues []string) []opts.MarkLineNameXAxisItem {
+	markers := []opts.MarkLineNameXAxisItem{}
+	start, end, err := startAndEndDateToTime(startDate, endDate)
+	if m.Location == nil || err != nil || len(timeValues) == 0 {
+		return markers
+	}
+	loc := m.summaryLocation()
+	first, last := timeValues[0], timeValues[len(timeValues)-1]
+	start, end = start.In(loc), end.In(loc)
+	for day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc); !day.After(end); day = day.AddDate(0, 0, 1) {
+		sun := solar.SunTimes(day, *m.Location)
+		for _, event := range []struct {
+			name string
+			at   time.Time
+		}{{"Sunrise", sun.Sunrise}, {"Sunset", sun.Sunset}} {
+			at := event.at.UTC().Format("2006-01-02 15:04:05")
+			// The x axis only spans the readings, so a sunrise or sunset past either end can't be drawn
+			if event.at.IsZero() || at < first || at > last {
+				continue
+			}
+			if marker, ok := markerAt(at, fmt.Sprintf("%s %s", event.name, event.at.In(loc).Format("15:04")), timeValues); ok {
+				markers = append(markers, marker)
+			}
+		}
+	}
+	return markers
+}
@@ -0,0 +1,26 @@
+package sunlightmeter
+
+import (
+	"net/http"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// Serve the device diagnostics, to correlate with drifting readings
+func (m *SLMeter) ServeSystemInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveJSON(w, http.StatusOK, struct {
+			tools.SystemInfo
+			WatchdogStats
+			RecorderStats
+			DroppedReadings int64 `json:"dropped_readings"`
+			StorageFull     bool  `json:"storage_full"`
+		}{
+			SystemInfo:      tools.ReadSystemInfo(m.DBPath),
+			WatchdogStats:   m.WatchdogStats(),
+			RecorderStats:   m.RecorderStats(),
+			DroppedReadings: m.DroppedReadings(),
+			StorageFull:     m.StorageFull(),
+		})
+	}
+}
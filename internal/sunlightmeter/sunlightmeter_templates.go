@@ -0,0 +1,113 @@
+package sunlightmeter
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The dashboard templates, parsed once from the embedded html.
+// With a template dir set, they're re-read from disk on every request instead, to work on them without rebuilding.
+var (
+	templatesMu sync.RWMutex
+	templates   *template.Template
+	templateDir string
+)
+
+// Parse every template up front, so a broken one is reported at startup rather than by a request
+func LoadTemplates(dir string) error {
+	set, err := parseTemplates(dir)
+	if err != nil {
+		return err
+	}
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	templates, templateDir = set, dir
+	return nil
+}
+
+func parseTemplates(dir string) (*template.Template, error) {
+	if dir != "" {
+		return template.ParseGlob(filepath.Join(dir, "*.gohtml"))
+	}
+	return template.ParseFS(templateFiles, "html/*.gohtml")
+}
+
+// Get a template by its file name, e.g. controls.gohtml
+func getTemplate(name string) (*template.Template, error) {
+	templatesMu.RLock()
+	set, dir := templates, templateDir
+	templatesMu.RUnlock()
+
+	var err error
+	if dir != "" {
+		set, err = parseTemplates(dir)
+	} else if set == nil {
+		// LoadTemplates wasn't called, load them now
+		err = LoadTemplates("")
+		set = templates
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse templates: %v", err)
+	}
+	tmpl := set.Lookup(name)
+	if tmpl == nil {
+		return nil, fmt.Errorf("unknown template %s", name)
+	}
+	return tmpl, nil
+}
+
+// The static assets for the file server, embedded unless a directory is given to serve them from disk
+func StaticFiles(dir string) http.FileSystem {
+	if dir != "" {
+		return http.Dir(dir)
+	}
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		// Only possible if the embed directive is broken
+		panic(err)
+	}
+	return http.FS(static)
+}
+
+var (
+	staticVersion     string
+	staticVersionOnce sync.Once
+)
+
+// A hash of every embedded static asset. Pages link assets with it as ?v=, so they can be cached until the binary changes.
+func StaticVersion() string {
+	staticVersionOnce.Do(func() {
+		hash := sha256.New()
+		fs.WalkDir(staticFiles, "static", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			content, err := staticFiles.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			hash.Write([]byte(path))
+			hash.Write(content)
+			return nil
+		})
+		staticVersion = fmt.Sprintf("%x", hash.Sum(nil)[:8])
+	})
+	return staticVersion
+}
+
+// Read a static html file, from the template dir when it's set
+func readHTMLFile(name string) ([]byte, error) {
+	templatesMu.RLock()
+	dir := templateDir
+	templatesMu.RUnlock()
+	if dir != "" {
+		return os.ReadFile(filepath.Join(dir, name))
+	}
+	return templateFiles.ReadFile("html/" + name)
+}
@@ -0,0 +1,160 @@
+package sunlightmeter
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Point the template cache at dir for the test, and back at the embedded templates after it
+func useTemplateDir(t testing.TB, dir string) {
+	t.Helper()
+	if err := LoadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := LoadTemplates(""); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// Copy the embedded templates to a temporary dir, to edit them
+func copyTemplates(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	entries, err := templateFiles.ReadDir("html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		content, err := templateFiles.ReadFile("html/" + entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func serveDashboardResponse(message string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ServeResponse(w, httptest.NewRequest(http.MethodPost, "/sunlightmeter/start", nil), message, http.StatusOK)
+	return w
+}
+
+func TestLoadTemplates(t *testing.T) {
+	useTemplateDir(t, "")
+	for _, name := range []string{"dashboard.gohtml", "controls.gohtml", "status.gohtml", "results.gohtml", "response.gohtml", "report.gohtml"} {
+		if _, err := getTemplate(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := getTemplate("missing.gohtml"); err == nil {
+		t.Error("got a template that doesn't exist")
+	}
+
+	// A broken template is an error to report, and the templates already loaded are kept
+	dir := copyTemplates(t)
+	if err := os.WriteFile(filepath.Join(dir, "response.gohtml"), []byte("{{ .Broken "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadTemplates(dir); err == nil {
+		t.Fatal("a broken template loaded")
+	}
+	if w := serveDashboardResponse("Sensor Enabled"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Sensor Enabled") {
+		t.Errorf("got %d, %s after a failed load", w.Code, w.Body.String())
+	}
+}
+
+func TestTemplateDirIsReadEachRequest(t *testing.T) {
+	dir := copyTemplates(t)
+	useTemplateDir(t, dir)
+
+	if w := serveDashboardResponse("Sensor Enabled"); !strings.Contains(w.Body.String(), "Sensor Enabled") {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	// An edit shows up on the next request, without loading them again
+	if err := os.WriteFile(filepath.Join(dir, "response.gohtml"), []byte(`<p class="edited">{{ . }}</p>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveDashboardResponse("Sensor Enabled"); w.Body.String() != `<p class="edited">Sensor Enabled</p>` {
+		t.Errorf("got %s, want the edited template", w.Body.String())
+	}
+	// A mistake while editing fails the request, not the process
+	if err := os.WriteFile(filepath.Join(dir, "response.gohtml"), []byte("{{ .Broken "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveDashboardResponse("Sensor Enabled"); w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestCachedTemplateLookupDoesNotAllocate(t *testing.T) {
+	useTemplateDir(t, "")
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := getTemplate("results.gohtml"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("a cached lookup made %v allocations", allocs)
+	}
+}
+
+// The cost of the cached lookup, against parsing the template per request as it used to be done.
+// Run with -benchmem to compare the allocations.
+func BenchmarkTemplateCached(b *testing.B) {
+	useTemplateDir(b, "")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tmpl, err := getTemplate("results.gohtml")
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = tmpl
+	}
+}
+
+func BenchmarkTemplateParsedPerRequest(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tmpl, err := template.ParseFS(templateFiles, "html/results.gohtml")
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = tmpl
+	}
+}
+
+// Serving a whole response, which includes executing the template
+func BenchmarkServeResponseCached(b *testing.B) {
+	useTemplateDir(b, "")
+	benchmarkServeResponse(b)
+}
+
+func BenchmarkServeResponseFromDir(b *testing.B) {
+	useTemplateDir(b, copyTemplates(b))
+	benchmarkServeResponse(b)
+}
+
+func benchmarkServeResponse(b *testing.B) {
+	req := httptest.NewRequest(http.MethodPost, "/sunlightmeter/start", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ServeResponse(discardResponse{}, req, "Sensor Enabled", http.StatusOK)
+	}
+}
+
+type discardResponse struct{}
+
+func (discardResponse) Header() http.Header         { return http.Header{} }
+func (discardResponse) Write(p []byte) (int, error) { return io.Discard.Write(p) }
+func (discardResponse) WriteHeader(int)             {}
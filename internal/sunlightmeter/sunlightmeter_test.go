@@ -0,0 +1,647 @@
+package sunlightmeter
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+)
+
+// A fresh in-memory db with the migrations applied, closed when the test ends
+func newTestDB(t testing.TB) *sql.DB {
+	t.Helper()
+	return newNamedTestDB(t, t.Name())
+}
+
+// A db apart from the test's own, for a second meter in the same test
+func newNamedTestDB(t testing.TB, name string) *sql.DB {
+	t.Helper()
+	name = strings.NewReplacer("/", "_", " ", "_", "#", "_").Replace(name)
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared&_busy_timeout=5000", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := tools.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Midday, when the simulated sun is at its brightest
+func simulatedNoon() time.Time {
+	return time.Date(2024, 6, 21, 12, 0, 0, 0, time.Local)
+}
+
+// A meter on a simulated sensor and an in-memory db, at low gain and 100ms so reads don't wait
+func newTestMeter(t testing.TB, sim *tsl2591.Simulator) *SLMeter {
+	t.Helper()
+	if sim.Now == nil {
+		sim.Now = simulatedNoon
+	}
+	device, err := tsl2591.NewTSL2591(tsl2591.TSL2591_GAIN_LOW, tsl2591.TSL2591_INTEGRATIONTIME_100MS, "/dev/i2c-test", tsl2591.WithOpener(sim))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SLMeter{
+		TSL2591:        device,
+		ResultsDB:      newTestDB(t),
+		LuxResultsChan: make(chan LuxResults, RESULTS_BUFFER),
+		SensorID:       DEFAULT_SENSOR_ID,
+		DeviceID:       "test-device",
+	}
+}
+
+func TestRunJobStopsWhileSaturated(t *testing.T) {
+	// Bright enough to saturate at the lowest gain and integration time, so every read overflows
+	m := newTestMeter(t, &tsl2591.Simulator{PeakLux: 1e9})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		m.runJob(ctx, "saturated-job")
+		close(done)
+	}()
+
+	select {
+	case result := <-m.LuxResultsChan:
+		if !result.Saturated {
+			t.Fatalf("expected a saturated sample, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job never recorded the overflow")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the job kept running after it was cancelled")
+	}
+	if m.Enabled {
+		t.Error("the sensor was left enabled after the job ended")
+	}
+	select {
+	case result := <-m.LuxResultsChan:
+		t.Errorf("the job sent a sample after it was cancelled: %+v", result)
+	default:
+	}
+}
+
+func TestSlowRecorderDropsWithoutSlowingTheSampler(t *testing.T) {
+	const interval = 10 * time.Millisecond
+	const consumerDelay = 300 * time.Millisecond
+	for _, policy := range []string{DROP_NEWEST, DROP_OLDEST} {
+		t.Run(policy, func(t *testing.T) {
+			m := newTestMeter(t, &tsl2591.Simulator{})
+			m.LuxResultsChan = make(chan LuxResults, 2)
+			m.DropPolicy = policy
+			m.recordInterval = interval
+
+			// A recorder stuck on slow writes, taking a sample every consumerDelay
+			type received struct {
+				result LuxResults
+				at     time.Time
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			consumed := make(chan []received)
+			go func() {
+				var got []received
+				for {
+					select {
+					case result := <-m.LuxResultsChan:
+						got = append(got, received{result, time.Now()})
+					case <-ctx.Done():
+						consumed <- got
+						return
+					}
+					select {
+					case <-time.After(consumerDelay):
+					case <-ctx.Done():
+						consumed <- got
+						return
+					}
+				}
+			}()
+
+			done := make(chan struct{})
+			started := time.Now()
+			go func() {
+				m.runJob(ctx, "job-1")
+				close(done)
+			}()
+			time.Sleep(3 * consumerDelay)
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("the job is stuck sending to the recorder")
+			}
+			elapsed := time.Since(started)
+			got := <-consumed
+
+			// The sampler kept to its interval, rather than waiting on the recorder
+			stats := m.RecorderStats()
+			if want := int64(elapsed / interval / 2); stats.Produced < want {
+				t.Errorf("took %d samples in %s, want at least %d at one every %s", stats.Produced, elapsed, want, interval)
+			}
+			if len(got) > 5 {
+				t.Errorf("the recorder took %d samples, it should only keep up with a few", len(got))
+			}
+			// Every sample is either recorded, waiting, or counted as dropped
+			if stats.Dropped == 0 || stats.Produced != int64(len(got))+stats.Dropped+int64(len(m.LuxResultsChan)) {
+				t.Errorf("produced %d, dropped %d, with %d received and %d waiting", stats.Produced, stats.Dropped, len(got), len(m.LuxResultsChan))
+			}
+
+			// Dropping the newest keeps the samples taken just after the last write,
+			// dropping the oldest keeps the samples taken just before the next one
+			if len(got) < 2 {
+				t.Fatalf("the recorder only took %d samples", len(got))
+			}
+			age := got[1].at.Sub(got[1].result.CreatedAt)
+			if policy == DROP_NEWEST && age < consumerDelay/2 {
+				t.Errorf("the sample was %s old, want one left waiting since the last write", age)
+			} else if policy == DROP_OLDEST && age > consumerDelay/2 {
+				t.Errorf("the sample was %s old, want a recent one", age)
+			}
+		})
+	}
+}
+
+// Serve a request to the handler, as an API request so errors come back as JSON
+func serveAPIRequest(h http.Handler, method string, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1"+path, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// Wait for a stopped job's goroutine to power the sensor off, so it's done with the db before the test ends
+func waitUntilDisabled(t testing.TB, m *SLMeter) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		m.Lock()
+		enabled := m.Enabled
+		m.Unlock()
+		if !enabled {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the sensor was never disabled")
+}
+
+func TestStartStopTransitions(t *testing.T) {
+	m := newTestMeter(t, &tsl2591.Simulator{PeakLux: 1000})
+	start, stop := m.Start(), m.Stop()
+
+	assertJob := func(t *testing.T, w *httptest.ResponseRecorder, status int, code string, jobID string) {
+		t.Helper()
+		if w.Code != status {
+			t.Fatalf("got %d, want %d: %s", w.Code, status, w.Body.String())
+		}
+		var body struct {
+			JobResponse
+			Error *struct {
+				tools.APIError
+				JobID string `json:"jobID"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("the response isn't JSON: %s", w.Body.String())
+		}
+		gotCode, gotJobID := "", body.JobID
+		if body.Error != nil {
+			gotCode, gotJobID = body.Error.Code, body.Error.JobID
+		}
+		if gotCode != code {
+			t.Errorf("got code %q, want %q", gotCode, code)
+		}
+		if gotJobID != jobID {
+			t.Errorf("got job %q, want %q", gotJobID, jobID)
+		}
+	}
+
+	// Stopping before anything has run has no job to refer to
+	assertJob(t, serveAPIRequest(stop, http.MethodPost, "/stop"), http.StatusConflict, tools.ERR_JOB_NOT_RUNNING, "")
+
+	w := serveAPIRequest(start, http.MethodPost, "/start")
+	if w.Code != http.StatusOK {
+		t.Fatalf("starting: got %d: %s", w.Code, w.Body.String())
+	}
+	jobID := m.JobID()
+	if jobID == "" {
+		t.Fatal("starting didn't set a job ID")
+	}
+	assertJob(t, w, http.StatusOK, "", jobID)
+
+	// Starting again refers to the job that's already running
+	assertJob(t, serveAPIRequest(start, http.MethodPost, "/start"), http.StatusConflict, tools.ERR_JOB_RUNNING, jobID)
+	if m.JobID() != jobID {
+		t.Fatalf("a second start replaced the running job")
+	}
+
+	assertJob(t, serveAPIRequest(stop, http.MethodPost, "/stop"), http.StatusOK, "", jobID)
+	waitUntilDisabled(t, m)
+
+	// Stopping again refers to the job that was just stopped
+	assertJob(t, serveAPIRequest(stop, http.MethodPost, "/stop"), http.StatusConflict, tools.ERR_JOB_NOT_RUNNING, jobID)
+
+	// A new job gets a new ID
+	w = serveAPIRequest(start, http.MethodPost, "/start")
+	next := m.JobID()
+	assertJob(t, w, http.StatusOK, "", next)
+	if next == jobID {
+		t.Error("the new job reused the old job's ID")
+	}
+	assertJob(t, serveAPIRequest(stop, http.MethodPost, "/stop"), http.StatusOK, "", next)
+	waitUntilDisabled(t, m)
+
+	// A single reading holds the sensor, so a job can't start until it's done
+	m.jobMu.Lock()
+	m.onDemand = true
+	m.jobMu.Unlock()
+	assertJob(t, serveAPIRequest(start, http.MethodPost, "/start"), http.StatusConflict, tools.ERR_SENSOR_BUSY, "")
+	m.jobMu.Lock()
+	m.onDemand = false
+	m.jobMu.Unlock()
+}
+
+func TestStartStopWithoutSensor(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	for _, tt := range []struct {
+		path    string
+		handler http.Handler
+	}{
+		{"/start", m.Start()},
+		{"/stop", m.Stop()},
+	} {
+		w := serveAPIRequest(tt.handler, http.MethodPost, tt.path)
+		assertErrorCode(t, w, http.StatusServiceUnavailable, tools.ERR_SENSOR_NOT_CONNECTED)
+	}
+}
+
+// Run the recorder for the rest of the test, FlushResults waits for it to write what's been sent
+func startRecorder(m *SLMeter) {
+	go m.MonitorAndRecordResults()
+}
+
+// The timeout covers the whole flush, whether the recorder never takes the request or never finishes it
+func TestFlushResultsTimesOut(t *testing.T) {
+	for _, takesRequest := range []bool{false, true} {
+		m := &SLMeter{}
+		if takesRequest {
+			// A recorder stuck in the write it was asked for
+			go func() { <-m.flushRequests() }()
+		}
+		start := time.Now()
+		m.FlushResults(50 * time.Millisecond)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("takes the request %t: flushing took %s, want about 50ms", takesRequest, elapsed)
+		}
+	}
+}
+
+func countRows(t testing.TB, db *sql.DB, query string, args ...interface{}) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestRecorderRejectsNonFiniteReadings(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t), LuxResultsChan: make(chan LuxResults, RESULTS_BUFFER), SensorID: DEFAULT_SENSOR_ID}
+	startRecorder(m)
+
+	invalid := []float64{math.NaN(), math.Inf(1), math.Inf(-1)}
+	fields := []func(*LuxResults, float64){
+		func(r *LuxResults, v float64) { r.Lux = v },
+		func(r *LuxResults, v float64) { r.FullSpectrum = v },
+		func(r *LuxResults, v float64) { r.Visible = v },
+		func(r *LuxResults, v float64) { r.Infrared = v },
+	}
+	for _, set := range fields {
+		for _, value := range invalid {
+			result := LuxResults{Lux: 100, FullSpectrum: 0.5, Visible: 0.4, Infrared: 0.1, JobID: "job-1"}
+			set(&result, value)
+			m.LuxResultsChan <- result
+		}
+	}
+	m.LuxResultsChan <- LuxResults{Lux: 100, FullSpectrum: 0.5, Visible: 0.4, Infrared: 0.1, JobID: "job-1"}
+	m.FlushResults(5 * time.Second)
+
+	if rows := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight"); rows != 1 {
+		t.Errorf("got %d rows, want only the valid reading", rows)
+	}
+	if rows := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight WHERE lux IN ('NaN', '+Inf', '-Inf', 'Inf')"); rows != 0 {
+		t.Errorf("%d non-finite values were written", rows)
+	}
+	if dropped := m.DroppedReadings(); dropped != int64(len(fields)*len(invalid)) {
+		t.Errorf("got %d dropped readings, want %d", dropped, len(fields)*len(invalid))
+	}
+}
+
+func TestPrepareResult(t *testing.T) {
+	tests := []struct {
+		name        string
+		result      LuxResults
+		paused      bool
+		storageFull bool
+		want        bool
+		wantLux     float64
+		wantDropped int64
+		wantFailure bool
+		wantEvent   bool
+	}{
+		{name: "valid", result: LuxResults{Lux: 250, FullSpectrum: 0.5, Visible: 0.4, Infrared: 0.1}, want: true, wantLux: 250},
+		{name: "zero lux is kept", result: LuxResults{}, want: true, wantLux: 0},
+		{name: "negative lux is recorded as 0", result: LuxResults{Lux: -3.5, Infrared: 0.2}, want: true, wantLux: 0, wantEvent: true},
+		{name: "NaN lux", result: LuxResults{Lux: math.NaN()}, wantDropped: 1, wantEvent: true},
+		{name: "infinite infrared", result: LuxResults{Lux: 10, Infrared: math.Inf(1)}, wantDropped: 1, wantEvent: true},
+		{name: "saturated skips validation", result: LuxResults{Lux: math.Inf(1), Saturated: true}, want: true, wantLux: math.Inf(1)},
+		{name: "failed read", result: LuxResults{Failed: true, Error: "i2c timeout"}, wantFailure: true},
+		{name: "paused job", result: LuxResults{Lux: 250}, paused: true},
+		{name: "storage full", result: LuxResults{Lux: 250}, storageFull: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &SLMeter{ResultsDB: newTestDB(t), SensorID: DEFAULT_SENSOR_ID}
+			m.storageFull.Store(tt.storageFull)
+			m.jobID, m.paused = "job-1", tt.paused
+			tt.result.JobID = "job-1"
+
+			got, ok := m.prepareResult(tt.result)
+			if ok != tt.want {
+				t.Fatalf("got ok %t, want %t", ok, tt.want)
+			}
+			if got.CreatedAt.IsZero() {
+				t.Error("the result wasn't stamped with a time")
+			}
+			if ok && got.Lux != tt.wantLux {
+				t.Errorf("got lux %v, want %v", got.Lux, tt.wantLux)
+			}
+			if dropped := m.DroppedReadings(); dropped != tt.wantDropped {
+				t.Errorf("got %d dropped, want %d", dropped, tt.wantDropped)
+			}
+			failures := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM read_failures")
+			if tt.wantFailure != (failures == 1) {
+				t.Errorf("got %d read failures recorded", failures)
+			}
+			select {
+			case event := <-m.eventQueue():
+				if !tt.wantEvent || event.Type != SENSOR_EVENT_INVALID_LUX {
+					t.Errorf("got a %s event: %s", event.Type, event.Detail)
+				}
+			default:
+				if tt.wantEvent {
+					t.Errorf("no %s event was logged", SENSOR_EVENT_INVALID_LUX)
+				}
+			}
+		})
+	}
+
+	// A time set when the sensor was read is kept
+	readAt := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	if got, _ := m.prepareResult(LuxResults{Lux: 1, CreatedAt: readAt}); !got.CreatedAt.Equal(readAt) {
+		t.Errorf("got %s, want %s", got.CreatedAt, readAt)
+	}
+}
+
+func TestValidateResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  LuxResults
+		wantErr string
+	}{
+		{"zero", LuxResults{}, ""},
+		{"negative zero", LuxResults{Lux: math.Copysign(0, -1)}, ""},
+		// Negative lux is a number, the recorder clamps it rather than dropping it
+		{"negative lux", LuxResults{Lux: -0.001, Infrared: 0.5}, ""},
+		{"largest finite lux", LuxResults{Lux: math.MaxFloat64}, ""},
+		{"NaN lux", LuxResults{Lux: math.NaN()}, "invalid lux"},
+		{"infinite lux", LuxResults{Lux: math.Inf(1)}, "invalid lux"},
+		{"negative infinite lux", LuxResults{Lux: math.Inf(-1)}, "invalid lux"},
+		{"NaN full spectrum", LuxResults{Lux: 1, FullSpectrum: math.NaN()}, "invalid full spectrum"},
+		{"infinite visible", LuxResults{Lux: 1, Visible: math.Inf(1)}, "invalid visible"},
+		{"negative infinite infrared", LuxResults{Lux: 1, Infrared: math.Inf(-1)}, "invalid infrared"},
+		{"NaN everywhere reports lux first", LuxResults{Lux: math.NaN(), FullSpectrum: math.NaN(), Infrared: math.NaN()}, "invalid lux"},
+	}
+	for _, tt := range tests {
+		err := validateResult(tt.result)
+		if got := fmt.Sprint(err); (tt.wantErr == "" && err != nil) || (tt.wantErr != "" && got != tt.wantErr) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSaturatedReadingsAreFlagged(t *testing.T) {
+	m := newTestMeter(t, &tsl2591.Simulator{})
+	startRecorder(m)
+	readAt := time.Date(2024, 6, 21, 16, 0, 0, 0, time.UTC)
+	m.LuxResultsChan <- LuxResults{Lux: 500, JobID: "job-1", SensorID: DEFAULT_SENSOR_ID, CreatedAt: readAt}
+	m.LuxResultsChan <- LuxResults{Saturated: true, JobID: "job-1", SensorID: DEFAULT_SENSOR_ID, CreatedAt: readAt.Add(time.Minute)}
+	m.FlushResults(5 * time.Second)
+
+	// Stored with the flag and no lux, rather than skipped or stored as a bogus number
+	if n := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight WHERE saturated = 1 AND CAST(lux AS REAL) = 0"); n != 1 {
+		t.Fatalf("got %d flagged rows, want 1", n)
+	}
+
+	// The latest conditions and the stats skip over it
+	conditions, recordedAt, err := m.getLatestConditions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conditions.Lux != 500 || recordedAt != "2024-06-21 16:00:00" {
+		t.Errorf("got %v lux at %s, want the reading before the saturated one", conditions.Lux, recordedAt)
+	}
+	stats, err := m.getStats("2024-06-21 00:00:00", "2024-06-22 00:00:00", readingScope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Samples != 1 || stats.Lux.Min != 500 {
+		t.Errorf("got %d samples with a minimum of %v, want only the reading", stats.Samples, stats.Lux.Min)
+	}
+
+	// The readings list it, marked
+	var page ReadingsPage
+	w := serveAPIRequest(m.ServeReadings(), http.MethodGet, "/readings?order=asc")
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Readings) != 2 || page.Readings[0].Saturated || !page.Readings[1].Saturated {
+		t.Errorf("got %+v, want the reading then the saturated one", page.Readings)
+	}
+}
+
+func TestShutdownFlushesEveryResult(t *testing.T) {
+	m := &SLMeter{
+		ResultsDB:      newTestDB(t),
+		LuxResultsChan: make(chan LuxResults, RESULTS_BUFFER),
+		SensorID:       DEFAULT_SENSOR_ID,
+		// Part of what's sent is left in a batch that isn't full, and isn't due to be written for an hour
+		BatchSize:     RESULTS_BUFFER*2/3 + 1,
+		BatchInterval: time.Hour,
+	}
+
+	// Fill the channel before the recorder starts, so shutdown has a full buffer to drain
+	readAt := time.Date(2024, 6, 21, 16, 0, 0, 0, time.UTC)
+	for i := 0; i < RESULTS_BUFFER; i++ {
+		m.sendResult(LuxResults{Lux: float64(i + 1), JobID: "job-1", SensorID: DEFAULT_SENSOR_ID, CreatedAt: readAt.Add(time.Duration(i) * time.Second)})
+	}
+	if len(m.LuxResultsChan) != RESULTS_BUFFER {
+		t.Fatalf("got %d results waiting, want a full buffer of %d", len(m.LuxResultsChan), RESULTS_BUFFER)
+	}
+	startRecorder(m)
+	m.Shutdown(5 * time.Second)
+
+	if n := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight"); n != RESULTS_BUFFER {
+		t.Fatalf("got %d rows after shutdown, want all %d", n, RESULTS_BUFFER)
+	}
+	if n := countRows(t, m.ResultsDB, "SELECT COUNT(DISTINCT CAST(lux AS REAL)) FROM sunlight WHERE CAST(lux AS REAL) BETWEEN 1 AND ?", RESULTS_BUFFER); n != RESULTS_BUFFER {
+		t.Errorf("got %d distinct results, want each one once", n)
+	}
+	if stats := m.RecorderStats(); stats.Produced != RESULTS_BUFFER || stats.Recorded != RESULTS_BUFFER || stats.Dropped != 0 {
+		t.Errorf("got %+v, want every result recorded", stats)
+	}
+
+	// Nothing sent after shutdown is accepted
+	m.sendResult(LuxResults{Lux: 1000, JobID: "job-1", SensorID: DEFAULT_SENSOR_ID, CreatedAt: readAt})
+	m.FlushResults(5 * time.Second)
+	if n := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight"); n != RESULTS_BUFFER {
+		t.Errorf("got %d rows, a result sent after shutdown was recorded", n)
+	}
+	if stats := m.RecorderStats(); stats.Produced != RESULTS_BUFFER {
+		t.Errorf("a result sent after shutdown was counted: %+v", stats)
+	}
+}
+
+// A batch that can't be written is counted as lost, rather than only logged
+func TestWriteResultsCountsLostRows(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t), SensorID: DEFAULT_SENSOR_ID, DeviceID: "test-device"}
+	if _, err := m.ResultsDB.Exec("CREATE TRIGGER fail_writes BEFORE INSERT ON sunlight BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END"); err != nil {
+		t.Fatal(err)
+	}
+	readAt := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	m.writeResults([]LuxResults{{Lux: 1, JobID: "job-1", CreatedAt: readAt}, {Lux: 2, JobID: "job-1", CreatedAt: readAt.Add(time.Second)}, {Lux: 3, JobID: "job-1", CreatedAt: readAt.Add(2 * time.Second)}})
+	if stats := m.RecorderStats(); stats.Lost != 3 || stats.Recorded != 0 {
+		t.Errorf("got %+v, want 3 lost", stats)
+	}
+
+	if _, err := m.ResultsDB.Exec("DROP TRIGGER fail_writes"); err != nil {
+		t.Fatal(err)
+	}
+	m.writeResults([]LuxResults{{Lux: 4, JobID: "job-1", CreatedAt: readAt.Add(3 * time.Second)}})
+	if stats := m.RecorderStats(); stats.Lost != 3 || stats.Recorded != 1 {
+		t.Errorf("got %+v, want 3 lost and 1 recorded", stats)
+	}
+}
+
+func TestInsertResultsWritesTheBatchTogether(t *testing.T) {
+	m := &SLMeter{ResultsDB: newTestDB(t), SensorID: DEFAULT_SENSOR_ID, DeviceID: "test-device"}
+	readAt := time.Date(2024, 6, 21, 12, 0, 0, 0, time.UTC)
+	batch := func(luxes ...float64) []LuxResults {
+		results := make([]LuxResults, len(luxes))
+		for i, lux := range luxes {
+			results[i] = LuxResults{Lux: lux, JobID: "job-1", CreatedAt: readAt.Add(time.Duration(i) * time.Second)}
+		}
+		return results
+	}
+
+	if err := m.insertResults(batch(1, 2, 3, 4, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if rows := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight WHERE device_id = ? AND job_id = ?", "test-device", "job-1"); rows != 5 {
+		t.Fatalf("got %d rows, want the batch of 5", rows)
+	}
+	var createdAt time.Time
+	if err := m.ResultsDB.QueryRow("SELECT created_at FROM sunlight WHERE CAST(lux AS REAL) = 3").Scan(&createdAt); err != nil {
+		t.Fatal(err)
+	}
+	if !createdAt.Equal(readAt.Add(2 * time.Second)) {
+		t.Errorf("got created_at %s, want the time it was read", createdAt)
+	}
+
+	// A row that fails takes the rest of its batch with it, so a retry can't write any of them twice
+	_, err := m.ResultsDB.Exec(`CREATE TRIGGER reject_bad_lux BEFORE INSERT ON sunlight WHEN CAST(NEW.lux AS REAL) = 666
+		BEGIN SELECT RAISE(ABORT, 'rejected'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.insertResults(batch(10, 666, 30)); err == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	if rows := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM sunlight"); rows != 5 {
+		t.Errorf("got %d rows, a failed batch was partly written", rows)
+	}
+
+	// Queued for the forwarder in the same transaction, without the saturated results
+	m.ForwardURL = "http://central.local"
+	results := batch(7, 8)
+	results = append(results, LuxResults{Saturated: true, JobID: "job-1", CreatedAt: readAt})
+	if err := m.insertResults(results); err != nil {
+		t.Fatal(err)
+	}
+	if queued := countRows(t, m.ResultsDB, "SELECT COUNT(*) FROM forward_queue"); queued != 2 {
+		t.Errorf("got %d queued for the forwarder, want 2", queued)
+	}
+}
+
+func TestCurrentConditionsJSON(t *testing.T) {
+	m := newTestMeter(t, &tsl2591.Simulator{})
+	recordedAt := time.Date(2024, 6, 21, 16, 0, 0, 0, time.UTC)
+	if err := m.insertResults([]LuxResults{{Lux: 1076.4, FullSpectrum: 4000, Visible: 3000, Infrared: 1000, JobID: "job-1", SensorID: DEFAULT_SENSOR_ID, CreatedAt: recordedAt}}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveAPIRequest(m.CurrentConditions(), http.MethodGet, "/current-conditions?units=fc")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("got %d with %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	// The fields are the body itself, not a JSON string inside a message
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("the response isn't a JSON object: %s", w.Body.String())
+	}
+	if _, ok := fields["message"]; ok {
+		t.Fatalf("the conditions are wrapped in a message: %s", w.Body.String())
+	}
+	for _, field := range []string{"jobID", "lux", "fullSpectrum", "visible", "infrared", "ppfd", "units", "live", "recordedAt"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("%s is missing from %s", field, w.Body.String())
+		}
+	}
+
+	var conditions LiveConditions
+	if err := json.Unmarshal(w.Body.Bytes(), &conditions); err != nil {
+		t.Fatal(err)
+	}
+	if conditions.JobID != "job-1" || conditions.Units != UNITS_FOOT_CANDLES || math.Abs(conditions.Lux-100) > 0.01 || conditions.Visible != 3000 {
+		t.Errorf("got %+v, want the reading in foot-candles", conditions.Conditions)
+	}
+	if conditions.Live || conditions.RecordedAt != "2024-06-21 16:00:00" {
+		t.Errorf("got live %t at %q, want the last reading from a stopped job", conditions.Live, conditions.RecordedAt)
+	}
+
+	// The dashboard still gets an HTML fragment
+	req := httptest.NewRequest(http.MethodGet, "/sunlightmeter/current-conditions", nil)
+	w = httptest.NewRecorder()
+	m.CurrentConditions().ServeHTTP(w, req)
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") || strings.Contains(w.Body.String(), `"lux"`) {
+		t.Errorf("the dashboard got JSON: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,167 @@
+package sunlightmeter
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+// Create a new API token, the plaintext token is only returned once
+func (m *SLMeter) CreateToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_INTERNAL, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token := hex.EncodeToString(buf)
+		name := r.FormValue("name")
+
+		result, err := m.ResultsDB.Exec("INSERT INTO api_tokens (name, token_hash) VALUES (?, ?)", name, hashToken(token))
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Println(fmt.Sprintf("Created API token %d", id))
+		serveJSON(w, http.StatusCreated, struct {
+			ID    int64  `json:"id"`
+			Name  string `json:"name"`
+			Token string `json:"token"`
+		}{
+			ID:    id,
+			Name:  name,
+			Token: token,
+		})
+	}
+}
+
+// Revoke an API token, it will no longer be accepted
+func (m *SLMeter) RevokeToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			ServeError(w, r, tools.ERR_BAD_REQUEST, "Invalid token id", http.StatusBadRequest)
+			return
+		}
+
+		result, err := m.ResultsDB.Exec("UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+		if err != nil {
+			tools.RequestLog(r).Error(err)
+			ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if count, err := result.RowsAffected(); err != nil || count == 0 {
+			ServeError(w, r, tools.ERR_NOT_FOUND, "Token not found", http.StatusNotFound)
+			return
+		}
+
+		log.Println(fmt.Sprintf("Revoked API token %d", id))
+		ServeResponse(w, r, "Token Revoked", http.StatusOK)
+	}
+}
+
+// Require a valid bearer token once at least one token has been created, or valid basic auth credentials.
+// Until then the API is as open as the dashboard: behind basic auth when a password is set, and open when it isn't.
+func (m *SLMeter) RequireAPIToken(basicAuth *tools.BasicAuth) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withoutTokens := basicAuth.RequireAuth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var activeTokens int
+			err := m.ResultsDB.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE revoked_at IS NULL").Scan(&activeTokens)
+			if err != nil {
+				tools.RequestLog(r).Error(err)
+				ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+				return
+			} else if activeTokens == 0 {
+				withoutTokens.ServeHTTP(w, r)
+				return
+			} else if basicAuth.Valid(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token, ok := bearerToken(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				ServeError(w, r, tools.ERR_UNAUTHORIZED, "Missing API token", http.StatusUnauthorized)
+				return
+			}
+
+			valid, err := m.isValidToken(token)
+			if err != nil {
+				tools.RequestLog(r).Error(err)
+				ServeError(w, r, tools.ERR_DB_ERROR, err.Error(), http.StatusInternalServerError)
+				return
+			} else if !valid {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				ServeError(w, r, tools.ERR_UNAUTHORIZED, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Check if the request carries a valid, unrevoked API token
+func (m *SLMeter) ValidAPIToken(r *http.Request) bool {
+	token, ok := bearerToken(r)
+	if !ok {
+		return false
+	}
+	valid, err := m.isValidToken(token)
+	if err != nil {
+		tools.RequestLog(r).Error(err)
+		return false
+	}
+	return valid
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+// Compare the token against every active token hash, in constant time
+func (m *SLMeter) isValidToken(token string) (bool, error) {
+	rows, err := m.ResultsDB.Query("SELECT token_hash FROM api_tokens WHERE revoked_at IS NULL")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	hash := []byte(hashToken(token))
+	valid := false
+	for rows.Next() {
+		var storedHash string
+		if err := rows.Scan(&storedHash); err != nil {
+			return false, err
+		}
+		if subtle.ConstantTimeCompare(hash, []byte(storedHash)) == 1 {
+			valid = true
+		}
+	}
+	return valid, rows.Err()
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
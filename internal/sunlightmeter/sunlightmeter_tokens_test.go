@@ -0,0 +1,157 @@
+package sunlightmeter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A router with the token routes, and a route behind RequireAPIToken.
+// Basic auth is only configured when password is set.
+func newTokenTestRouter(t *testing.T, password string) http.Handler {
+	t.Helper()
+	m := &SLMeter{ResultsDB: newTestDB(t)}
+	hash := ""
+	if password != "" {
+		encoded, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash = string(encoded)
+	}
+	basicAuth := tools.NewBasicAuth("admin", hash, nil)
+	basicAuth.Alternative = m.ValidAPIToken
+
+	r := chi.NewRouter()
+	r.Post("/tokens", m.CreateToken())
+	r.Delete("/tokens/{id}", m.RevokeToken())
+	r.With(m.RequireAPIToken(basicAuth)).Get("/protected", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return r
+}
+
+func serveTokenRequest(r http.Handler, method string, path string, auth func(*http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Accept", "application/json")
+	if auth != nil {
+		auth(req)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func bearer(token string) func(*http.Request) {
+	return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+}
+
+func basic(user string, password string) func(*http.Request) {
+	return func(r *http.Request) { r.SetBasicAuth(user, password) }
+}
+
+func createToken(t *testing.T, r http.Handler) (int64, string) {
+	t.Helper()
+	w := serveTokenRequest(r, http.MethodPost, "/tokens", nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating a token: %d %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID    int64  `json:"id"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	return created.ID, created.Token
+}
+
+func assertErrorCode(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	var body struct {
+		Error tools.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("the error isn't JSON: %s", w.Body.String())
+	}
+	if body.Error.Code != code {
+		t.Errorf("got code %s, want %s", body.Error.Code, code)
+	}
+}
+
+func TestRequireAPIToken(t *testing.T) {
+	r := newTokenTestRouter(t, "")
+
+	// Without any tokens, and without a password, the API is open
+	if w := serveTokenRequest(r, http.MethodGet, "/protected", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("before any token exists: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	id, token := createToken(t, r)
+	_, other := createToken(t, r)
+
+	t.Run("missing token", func(t *testing.T) {
+		w := serveTokenRequest(r, http.MethodGet, "/protected", nil)
+		assertErrorCode(t, w, http.StatusUnauthorized, tools.ERR_UNAUTHORIZED)
+		if w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("missing the WWW-Authenticate challenge")
+		}
+	})
+	t.Run("invalid token", func(t *testing.T) {
+		w := serveTokenRequest(r, http.MethodGet, "/protected", bearer(token+"0"))
+		assertErrorCode(t, w, http.StatusUnauthorized, tools.ERR_UNAUTHORIZED)
+	})
+	t.Run("valid token", func(t *testing.T) {
+		if w := serveTokenRequest(r, http.MethodGet, "/protected", bearer(token)); w.Code != http.StatusNoContent {
+			t.Errorf("got %d, want %d", w.Code, http.StatusNoContent)
+		}
+	})
+	t.Run("revoked token", func(t *testing.T) {
+		if w := serveTokenRequest(r, http.MethodDelete, "/tokens/"+strconv.FormatInt(id, 10), nil); w.Code != http.StatusOK {
+			t.Fatalf("revoking: got %d: %s", w.Code, w.Body.String())
+		}
+		w := serveTokenRequest(r, http.MethodGet, "/protected", bearer(token))
+		assertErrorCode(t, w, http.StatusUnauthorized, tools.ERR_UNAUTHORIZED)
+		// The other token still works
+		if w := serveTokenRequest(r, http.MethodGet, "/protected", bearer(other)); w.Code != http.StatusNoContent {
+			t.Errorf("the other token: got %d, want %d", w.Code, http.StatusNoContent)
+		}
+	})
+	t.Run("revoked twice", func(t *testing.T) {
+		w := serveTokenRequest(r, http.MethodDelete, "/tokens/"+strconv.FormatInt(id, 10), nil)
+		assertErrorCode(t, w, http.StatusNotFound, tools.ERR_NOT_FOUND)
+	})
+}
+
+func TestRequireAPITokenFallsBackToBasicAuth(t *testing.T) {
+	r := newTokenTestRouter(t, "secret")
+
+	// Without any tokens, the API takes the dashboard credentials rather than being open
+	w := serveTokenRequest(r, http.MethodGet, "/protected", nil)
+	assertErrorCode(t, w, http.StatusUnauthorized, tools.ERR_UNAUTHORIZED)
+	w = serveTokenRequest(r, http.MethodGet, "/protected", basic("admin", "wrong"))
+	assertErrorCode(t, w, http.StatusUnauthorized, tools.ERR_UNAUTHORIZED)
+	if w := serveTokenRequest(r, http.MethodGet, "/protected", basic("admin", "secret")); w.Code != http.StatusNoContent {
+		t.Fatalf("basic auth without tokens: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+
+	// Once a token exists, either credential works
+	_, token := createToken(t, r)
+	if w := serveTokenRequest(r, http.MethodGet, "/protected", basic("admin", "secret")); w.Code != http.StatusNoContent {
+		t.Errorf("basic auth with tokens: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := serveTokenRequest(r, http.MethodGet, "/protected", bearer(token)); w.Code != http.StatusNoContent {
+		t.Errorf("token: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	w = serveTokenRequest(r, http.MethodGet, "/protected", nil)
+	assertErrorCode(t, w, http.StatusUnauthorized, tools.ERR_UNAUTHORIZED)
+}
@@ -0,0 +1,128 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"math"
+)
+
+// Readings are always stored in lux, foot-candles and W/m² are only converted to when they're presented
+const (
+	UNITS_LUX            = "lux"
+	UNITS_FOOT_CANDLES   = "fc"
+	UNITS_WATTS          = "wm2"
+	LUX_PER_FOOT_CANDLE  = 10.764
+	SUNLIGHT_WM2_PER_LUX = 0.0079 // Sunlight's luminous efficacy is about 126 lm/W
+)
+
+// W/m² of irradiance per lux, set with SetWattsPerLux for light sources other than the sun
+var wattsPerLux = SUNLIGHT_WM2_PER_LUX
+
+// Set the W/m² per lux that readings are converted with. Only call it at startup, it isn't guarded.
+func SetWattsPerLux(factor float64) {
+	wattsPerLux = factor
+}
+
+func parseUnits(value string) (string, error) {
+	switch value {
+	case "":
+		return UNITS_LUX, nil
+	case UNITS_LUX, UNITS_FOOT_CANDLES, UNITS_WATTS:
+		return value, nil
+	}
+	return "", fmt.Errorf("Invalid units, expected one of %s, %s, %s", UNITS_LUX, UNITS_FOOT_CANDLES, UNITS_WATTS)
+}
+
+// Convert a value in lux to the units.
+// W/m² is only an estimate, the TSL2591 can't see most of the infrared that carries the sun's energy.
+func convertLux(lux float64, units string) float64 {
+	switch units {
+	case UNITS_FOOT_CANDLES:
+		return lux / LUX_PER_FOOT_CANDLE
+	case UNITS_WATTS:
+		return lux * wattsPerLux
+	}
+	return lux
+}
+
+// The name of the units, for axes and labels
+func unitsLabel(units string) string {
+	switch units {
+	case UNITS_FOOT_CANDLES:
+		return "Foot-candles"
+	case UNITS_WATTS:
+		return "W/m²"
+	}
+	return "Lux"
+}
+
+// The short name of the units
+func unitsAbbreviation(units string) string {
+	switch units {
+	case UNITS_FOOT_CANDLES:
+		return "fc"
+	case UNITS_WATTS:
+		return "W/m2"
+	}
+	return "lux"
+}
+
+// Round a converted axis maximum up, to keep the axis ticks readable
+func roundAxisMax(value float64) int {
+	step := 5000.0
+	if value < 1000 {
+		step = 100
+	} else if value < 5000 {
+		step = 500
+	}
+	return int(math.Ceil(value/step) * step)
+}
+
+func (m *SLMeter) ppfdPerLux() float64 {
+	return m.PPFDSetting().PPFDPerLux
+}
+
+// The conditions with their PPFD in µmol/m²/s estimated from lux, before lux is converted to other units.
+// The TSL2591 isn't a quantum sensor, so this only holds for the light source in the PPFD setting.
+func (m *SLMeter) withPPFD(c Conditions) Conditions {
+	setting := m.PPFDSetting()
+	c.PPFD = c.Lux * setting.PPFDPerLux
+	c.PPFDSource = setting.Source
+	return c
+}
+
+// The conditions with lux converted to the units. The raw channel counts aren't lux, so they're left alone.
+func (c Conditions) inUnits(units string) Conditions {
+	c.Lux = convertLux(c.Lux, units)
+	c.AverageLuxInRange = convertLux(c.AverageLuxInRange, units)
+	c.Units = units
+	return c
+}
+
+// The stats with lux converted to the units, the raw channel counts are left alone
+func (s Stats) inUnits(units string) Stats {
+	s.Lux = ChannelStats{
+		Min:    convertLux(s.Lux.Min, units),
+		Max:    convertLux(s.Lux.Max, units),
+		Mean:   convertLux(s.Lux.Mean, units),
+		Median: convertLux(s.Lux.Median, units),
+		P95:    convertLux(s.Lux.P95, units),
+	}
+	s.Units = units
+	return s
+}
+
+// The summary with lux converted to the units
+func (d DaySummary) inUnits(units string) DaySummary {
+	d.AverageLux = convertLux(d.AverageLux, units)
+	d.MinLux = convertLux(d.MinLux, units)
+	d.MaxLux = convertLux(d.MaxLux, units)
+	d.Units = units
+	return d
+}
+
+// The report with its peak converted to the units
+func (r Report) inUnits(units string) Report {
+	r.PeakLux = convertLux(r.PeakLux, units)
+	r.Units = units
+	return r
+}
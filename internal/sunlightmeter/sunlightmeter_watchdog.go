@@ -0,0 +1,87 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	DEFAULT_STALE_AFTER   = 5 * time.Minute
+	MIN_WATCHDOG_INTERVAL = 10 * time.Second
+)
+
+type WatchdogStats struct {
+	Stalled          bool  `json:"stalled"`
+	Recoveries       int64 `json:"watchdog_recoveries"`
+	RecoveryFailures int64 `json:"watchdog_recovery_failures"`
+}
+
+// While a job is running, make sure readings are still being recorded.
+// If nothing has been written for staleAfter, restart the job with a freshly reconnected sensor.
+func (m *SLMeter) RunWatchdog(staleAfter time.Duration) {
+	if staleAfter <= 0 {
+		return
+	}
+	interval := staleAfter / 4
+	if interval < MIN_WATCHDOG_INTERVAL {
+		interval = MIN_WATCHDOG_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.jobMu.Lock()
+		jobID, startedAt := m.jobID, m.jobStartedAt
+		m.jobMu.Unlock()
+		if jobID == "" || m.TSL2591 == nil || m.StorageFull() {
+			continue
+		}
+
+		// A job that just started hasn't had a chance to write anything yet
+		lastActivity := startedAt
+		if lastWrite := time.Unix(0, m.lastWrite.Load()); lastWrite.After(lastActivity) {
+			lastActivity = lastWrite
+		}
+		if stale := time.Since(lastActivity); stale > staleAfter {
+			m.recoverStalledJob(jobID, stale)
+		}
+	}
+}
+
+// Cancel the stalled job, reconnect the sensor, and start a new job in its place
+func (m *SLMeter) recoverStalledJob(jobID string, stale time.Duration) {
+	m.stalled.Store(true)
+	message := fmt.Sprintf("No readings recorded for %s, restarting job %s", stale.Round(time.Second), jobID)
+	log.Println(fmt.Sprintf("!!! WATCHDOG: %s !!!", message))
+	m.Webhooks.Notify(tools.EVENT_JOB_STALLED, jobID, nil, message)
+
+	if _, err := m.stopJob(); err != nil {
+		log.Println(fmt.Sprintf("Watchdog failed to stop job %s: %s", jobID, err.Error()))
+	}
+	if err := m.Reconnect(); err != nil {
+		m.recoveryFailures.Add(1)
+		log.Println(fmt.Sprintf("Watchdog failed to reconnect the sensor: %s", err.Error()))
+		m.Webhooks.Notify(tools.EVENT_JOB_ERROR, jobID, nil, fmt.Sprintf("Watchdog failed to reconnect the sensor: %s", err.Error()))
+		return
+	}
+	newJobID, err := m.startJob()
+	if err != nil {
+		m.recoveryFailures.Add(1)
+		log.Println(fmt.Sprintf("Watchdog failed to restart the job: %s", err.Error()))
+		m.Webhooks.Notify(tools.EVENT_JOB_ERROR, jobID, nil, fmt.Sprintf("Watchdog failed to restart the job: %s", err.Error()))
+		return
+	}
+	recoveries := m.recoveries.Add(1)
+	log.Println(fmt.Sprintf("Watchdog restarted job %s as %s (%d recoveries)", jobID, newJobID, recoveries))
+}
+
+// Whether the watchdog found the running job stalled, and how its recoveries have gone
+func (m *SLMeter) WatchdogStats() WatchdogStats {
+	return WatchdogStats{
+		Stalled:          m.stalled.Load(),
+		Recoveries:       m.recoveries.Load(),
+		RecoveryFailures: m.recoveryFailures.Load(),
+	}
+}
@@ -0,0 +1,85 @@
+package sunlightmeter
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	DEFAULT_WEATHER_INTERVAL = 15 * time.Minute
+	WEATHER_MAX_BACKOFF      = time.Hour
+	WEATHER_PUBLISH_DELAY    = time.Minute // Providers publish an interval's conditions a little after it starts
+)
+
+// The weather of the observation at or before a reading, within the hour before it.
+// It's joined on sunlight.created_at, so the query must select from sunlight unaliased.
+const weatherForReading = `
+        (SELECT cloud_cover FROM weather WHERE observed_at <= sunlight.created_at AND observed_at > datetime(sunlight.created_at, '-1 hour') ORDER BY observed_at DESC LIMIT 1),
+        (SELECT precipitation FROM weather WHERE observed_at <= sunlight.created_at AND observed_at > datetime(sunlight.created_at, '-1 hour') ORDER BY observed_at DESC LIMIT 1)`
+
+// The weather observed over a date range
+type WeatherStats struct {
+	Observations      int     `json:"observations"`
+	AverageCloudCover float64 `json:"averageCloudCover"` // In percent
+	Precipitation     float64 `json:"precipitation"`     // In mm, the total over the observed intervals
+}
+
+// Fetch the current weather at the meter's location every interval, and keep it in the weather table.
+// A provider that updates less often isn't asked again until its next update is out, and a failure only delays the next fetch,
+// recording never waits on the weather.
+func (m *SLMeter) RunWeather(interval time.Duration) {
+	if m.Weather == nil || m.Location == nil {
+		return
+	} else if interval <= 0 {
+		interval = DEFAULT_WEATHER_INTERVAL
+	}
+	backoff := interval
+	for {
+		observation, err := m.Weather.Current(m.Location.Latitude, m.Location.Longitude)
+		if err == nil {
+			err = m.recordWeather(observation)
+		}
+		if err != nil {
+			log.Println(fmt.Sprintf("Failed to record the weather from %s, retrying in %s: %s", m.Weather.Name(), backoff, err.Error()))
+			time.Sleep(backoff)
+			backoff = min(backoff*2, WEATHER_MAX_BACKOFF)
+			continue
+		}
+		backoff = interval
+
+		wait := interval
+		if observation.Interval > 0 {
+			wait = max(wait, time.Until(observation.ObservedAt.Add(observation.Interval+WEATHER_PUBLISH_DELAY)))
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Keep an observation, replacing any earlier fetch of the same interval
+func (m *SLMeter) recordWeather(observation tools.WeatherObservation) error {
+	return tools.RetryBusy(func() error {
+		_, err := m.ResultsDB.Exec(`
+    INSERT OR REPLACE INTO weather (observed_at, provider, cloud_cover, precipitation)
+    VALUES (?, ?, ?, ?)`,
+			observation.ObservedAt.UTC().Format("2006-01-02 15:04:05"), m.Weather.Name(), observation.CloudCover, observation.Precipitation)
+		return err
+	})
+}
+
+// The weather observed over the date range, nil when nothing was observed
+func (m *SLMeter) getWeatherStats(startDate string, endDate string) (*WeatherStats, error) {
+	var stats WeatherStats
+	err := tools.RetryBusy(func() error {
+		return m.ResultsDB.QueryRow(`
+    SELECT COUNT(*), COALESCE(AVG(cloud_cover), 0), COALESCE(SUM(precipitation), 0)
+    FROM weather
+    WHERE observed_at BETWEEN ? AND ?`, startDate, endDate).Scan(&stats.Observations, &stats.AverageCloudCover, &stats.Precipitation)
+	})
+	if err != nil || stats.Observations == 0 {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,40 @@
+package sunlightmeter
+
+import (
+	"fmt"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+)
+
+const (
+	DEFAULT_LUX_ABOVE = 10000
+	DEFAULT_LUX_BELOW = 500
+)
+
+const (
+	lightUnknown = iota
+	lightBelow
+	lightAbove
+)
+
+// Notify when the lux crosses LuxAbove or LuxBelow.
+// The gap between them is the hysteresis, so a passing cloud doesn't re-trigger the event.
+// Only called from the recorder, so the light state needs no lock.
+func (m *SLMeter) checkThresholds(result LuxResults) {
+	if m.Webhooks == nil {
+		return
+	}
+	lux := result.Lux
+	switch {
+	case m.lightState == lightUnknown && lux >= m.LuxAbove:
+		m.lightState = lightAbove
+	case m.lightState == lightUnknown:
+		m.lightState = lightBelow
+	case m.lightState == lightBelow && lux >= m.LuxAbove:
+		m.lightState = lightAbove
+		m.Webhooks.Notify(tools.EVENT_LUX_ABOVE, result.JobID, &lux, fmt.Sprintf("Lux is above %.0f: %.0f", m.LuxAbove, lux))
+	case m.lightState == lightAbove && lux <= m.LuxBelow:
+		m.lightState = lightBelow
+		m.Webhooks.Notify(tools.EVENT_LUX_BELOW, result.JobID, &lux, fmt.Sprintf("Lux is below %.0f: %.0f", m.LuxBelow, lux))
+	}
+}
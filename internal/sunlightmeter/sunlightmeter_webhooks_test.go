@@ -0,0 +1,151 @@
+package sunlightmeter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"github.com/ztkent/sunlight-meter/tsl2591"
+	"golang.org/x/exp/io/i2c/driver"
+)
+
+// A notifier for every event, delivering to a receiver that passes them on in order
+func newWebhookReceiver(t *testing.T) (*tools.WebhookNotifier, chan tools.WebhookEvent) {
+	t.Helper()
+	received := make(chan tools.WebhookEvent, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event tools.WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		received <- event
+	}))
+	t.Cleanup(srv.Close)
+	return tools.NewWebhookNotifier([]string{srv.URL}, nil, ""), received
+}
+
+// The events delivered before this point. The notifier delivers in order, so a marker sent now arrives after them.
+func deliveredEvents(t *testing.T, n *tools.WebhookNotifier, received chan tools.WebhookEvent) []string {
+	t.Helper()
+	n.Notify("marker", "", nil, "")
+	var events []string
+	for {
+		select {
+		case event := <-received:
+			if event.Event == "marker" {
+				return events
+			}
+			events = append(events, event.Event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the marker wasn't delivered, got %v", events)
+		}
+	}
+}
+
+func TestCheckThresholds(t *testing.T) {
+	tests := []struct {
+		name   string
+		lux    []float64
+		events []string
+	}{
+		// The first reading only sets where the light is, it hasn't crossed anything
+		{"starting dark", []float64{600}, nil},
+		{"starting bright", []float64{20000}, nil},
+		{"sunrise", []float64{100, 5000, 12000}, []string{tools.EVENT_LUX_ABOVE}},
+		{"sunset", []float64{12000, 5000, 400}, []string{tools.EVENT_LUX_BELOW}},
+		// Between the thresholds nothing is sent, however often the lux goes back and forth
+		{"a passing cloud", []float64{12000, 9000, 11000, 600, 10500}, nil},
+		{"clouds at dusk", []float64{100, 9999, 501, 9999}, nil},
+		{"on the thresholds", []float64{100, 10000, 500, 10000}, []string{tools.EVENT_LUX_ABOVE, tools.EVENT_LUX_BELOW, tools.EVENT_LUX_ABOVE}},
+		{"a day", []float64{0, 3000, 15000, 30000, 8000, 15000, 450, 0}, []string{tools.EVENT_LUX_ABOVE, tools.EVENT_LUX_BELOW}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier, received := newWebhookReceiver(t)
+			m := &SLMeter{Webhooks: notifier, LuxAbove: DEFAULT_LUX_ABOVE, LuxBelow: DEFAULT_LUX_BELOW}
+			for _, lux := range tt.lux {
+				m.checkThresholds(LuxResults{JobID: "job-1", Lux: lux})
+			}
+			if events := deliveredEvents(t, notifier, received); !slices.Equal(events, tt.events) {
+				t.Errorf("got %v, want %v", events, tt.events)
+			}
+		})
+	}
+}
+
+// A simulated bus whose writes fail once broken
+type breakableBus struct {
+	*tsl2591.Simulator
+	broken atomic.Bool
+}
+
+func (b *breakableBus) Open(addr int, tenbit bool) (driver.Conn, error) {
+	conn, err := b.Simulator.Open(addr, tenbit)
+	if err != nil {
+		return nil, err
+	}
+	return &breakableConn{Conn: conn, bus: b}, nil
+}
+
+type breakableConn struct {
+	driver.Conn
+	bus *breakableBus
+}
+
+func (c *breakableConn) Tx(w, r []byte) error {
+	if c.bus.broken.Load() {
+		return errors.New("remote I/O error")
+	}
+	return c.Conn.Tx(w, r)
+}
+
+// A job whose sensor won't enable reports the error, and ends without starting
+func TestRunJobSensorFailsToEnable(t *testing.T) {
+	bus := &breakableBus{Simulator: &tsl2591.Simulator{Now: simulatedNoon}}
+	device, err := tsl2591.NewTSL2591(tsl2591.TSL2591_GAIN_LOW, tsl2591.TSL2591_INTEGRATIONTIME_100MS, "/dev/i2c-test", tsl2591.WithOpener(bus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, received := newWebhookReceiver(t)
+	m := &SLMeter{
+		TSL2591:        device,
+		ResultsDB:      newTestDB(t),
+		LuxResultsChan: make(chan LuxResults, RESULTS_BUFFER),
+		SensorID:       DEFAULT_SENSOR_ID,
+		Webhooks:       notifier,
+	}
+	bus.broken.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.jobID, m.cancel = "broken-job", cancel
+	done := make(chan struct{})
+	go func() {
+		m.runJob(ctx, "broken-job")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job kept running without a sensor")
+	}
+
+	if events := deliveredEvents(t, notifier, received); !slices.Equal(events, []string{tools.EVENT_JOB_ERROR}) {
+		t.Errorf("got %v, want only %s", events, tools.EVENT_JOB_ERROR)
+	}
+	m.jobMu.Lock()
+	jobID, lastJobID := m.jobID, m.lastJobID
+	m.jobMu.Unlock()
+	if jobID != "" || lastJobID != "broken-job" || ctx.Err() == nil {
+		t.Errorf("got job %q, last job %q, context %v, want the job cleared", jobID, lastJobID, ctx.Err())
+	}
+	if len(m.LuxResultsChan) != 0 {
+		t.Errorf("got %d results from a sensor that never enabled", len(m.LuxResultsChan))
+	}
+}
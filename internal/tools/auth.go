@@ -0,0 +1,156 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MAX_AUTH_FAILURES   = 5
+	AUTH_FAILURE_WINDOW = 15 * time.Minute
+)
+
+// Optional HTTP basic auth, checked against a bcrypt password hash.
+// Repeated failures from the same address are locked out for a while to slow brute forcing.
+type BasicAuth struct {
+	Username     string
+	PasswordHash []byte
+	// Another credential that is also accepted, like an API token
+	Alternative func(r *http.Request) bool
+	ClientIP    func(r *http.Request) net.IP
+
+	mu       sync.Mutex
+	verified [32]byte
+	failures map[string]*authFailures
+}
+
+type authFailures struct {
+	count int
+	first time.Time
+}
+
+func NewBasicAuth(username string, passwordHash string, clientIP func(r *http.Request) net.IP) *BasicAuth {
+	return &BasicAuth{
+		Username:     username,
+		PasswordHash: []byte(passwordHash),
+		ClientIP:     clientIP,
+		failures:     make(map[string]*authFailures),
+	}
+}
+
+// Auth is only enforced once a password hash is configured
+func (a *BasicAuth) Enabled() bool {
+	return len(a.PasswordHash) > 0
+}
+
+// Require basic auth, or the alternative credential
+func (a *BasicAuth) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled() || (a.Alternative != nil && a.Alternative(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := a.clientKey(r)
+		if retryAfter := a.lockedOut(client); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			WriteError(w, r, ERR_RATE_LIMITED, "Too many failed login attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		if _, _, ok := r.BasicAuth(); ok && a.Valid(r) {
+			next.ServeHTTP(w, r)
+			return
+		} else if ok {
+			a.recordFailure(client)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="Sunlight Meter", charset="UTF-8"`)
+		WriteError(w, r, ERR_UNAUTHORIZED, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// Check the request's basic auth credentials
+func (a *BasicAuth) Valid(r *http.Request) bool {
+	if !a.Enabled() {
+		return false
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// bcrypt is slow on a Pi, so remember the last credentials that passed
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	a.mu.Lock()
+	verified := a.verified
+	a.mu.Unlock()
+	if subtle.ConstantTimeCompare(sum[:], verified[:]) == 1 {
+		return true
+	}
+
+	if subtle.ConstantTimeCompare([]byte(username), []byte(a.Username)) != 1 {
+		return false
+	}
+	if bcrypt.CompareHashAndPassword(a.PasswordHash, []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	a.verified = sum
+	a.mu.Unlock()
+	return true
+}
+
+func (a *BasicAuth) clientKey(r *http.Request) string {
+	if a.ClientIP != nil {
+		if ip := a.ClientIP(r); ip != nil {
+			return ip.String()
+		}
+	}
+	return r.RemoteAddr
+}
+
+// How long the client is locked out for, or 0 if it isn't
+func (a *BasicAuth) lockedOut(client string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	failure, ok := a.failures[client]
+	if !ok {
+		return 0
+	}
+	remaining := AUTH_FAILURE_WINDOW - time.Since(failure.first)
+	if remaining <= 0 {
+		delete(a.failures, client)
+		return 0
+	} else if failure.count < MAX_AUTH_FAILURES {
+		return 0
+	}
+	return remaining
+}
+
+func (a *BasicAuth) recordFailure(client string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Drop any expired entries, so the map doesn't grow forever
+	for key, failure := range a.failures {
+		if time.Since(failure.first) > AUTH_FAILURE_WINDOW {
+			delete(a.failures, key)
+		}
+	}
+
+	failure, ok := a.failures[client]
+	if !ok {
+		failure = &authFailures{first: time.Now()}
+		a.failures[client] = failure
+	}
+	failure.count++
+	log.Printf("Failed login attempt from %s (%d/%d)", client, failure.count, MAX_AUTH_FAILURES)
+}
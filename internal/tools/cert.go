@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+type CertOptions struct {
+	Hosts       []string      // DNS names and IP addresses added as SANs
+	KeyType     string        // "rsa" or "ecdsa"
+	Validity    time.Duration // How long a generated certificate is valid
+	RenewBefore time.Duration // Regenerate when the certificate expires within this window
+}
+
+// Make sure a usable self-signed certificate exists at certPath/keyPath.
+// A new certificate is generated if none exists, or if the current one is close to expiring.
+func EnsureSelfSignedCertificate(certPath string, keyPath string, opts CertOptions) error {
+	if len(opts.Hosts) == 0 {
+		opts.Hosts = DefaultCertHosts()
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err == nil {
+		block, _ := pem.Decode(certPEM)
+		if block != nil {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err == nil && time.Now().Add(opts.RenewBefore).Before(cert.NotAfter) {
+				return nil
+			}
+		}
+		log.Println("Self-signed certificate is invalid or close to expiry, regenerating")
+	}
+	return generateSelfSignedCertificate(certPath, keyPath, opts)
+}
+
+func generateSelfSignedCertificate(certPath string, keyPath string, opts CertOptions) error {
+	var privateKey crypto.Signer
+	var err error
+	switch opts.KeyType {
+	case "ecdsa":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "rsa", "":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return fmt.Errorf("unsupported key type: %s", opts.KeyType)
+	}
+	if err != nil {
+		return err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	notBefore := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{"Sunlight Meter"}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(opts.Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if _, ok := privateKey.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, host := range opts.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, privateKey.Public(), privateKey)
+	if err != nil {
+		return err
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	certOut, err := os.Create(certPath)
+	if err != nil {
+		return err
+	}
+	defer certOut.Close()
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
+		return err
+	}
+
+	keyOut, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer keyOut.Close()
+	if err := pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}); err != nil {
+		return err
+	}
+
+	log.Printf("Generated self-signed %s certificate for %v, valid until %s", opts.KeyType, opts.Hosts, template.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// The hostname, localhost, and every non-loopback interface address
+func DefaultCertHosts() []string {
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		hosts = append(hosts, ipNet.IP.String())
+	}
+	return hosts
+}
@@ -0,0 +1,194 @@
+package tools
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Generate a certificate in a temp dir, and parse both PEM files back
+func generateTestCertificate(t *testing.T, opts CertOptions) (*x509.Certificate, any) {
+	t.Helper()
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := EnsureSelfSignedCertificate(certPath, keyPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	return readTestCertificate(t, certPath), readTestKey(t, keyPath)
+}
+
+func readTestCertificate(t *testing.T, certPath string) *x509.Certificate {
+	t.Helper()
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("no CERTIFICATE block in %s", certPEM)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func readTestKey(t *testing.T, keyPath string) any {
+	t.Helper()
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("the key is readable by others: %v", perm)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		t.Fatalf("no PRIVATE KEY block in the key file")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSelfSignedCertificateSANs(t *testing.T) {
+	cert, _ := generateTestCertificate(t, CertOptions{
+		Hosts:    []string{"localhost", "sunlight.local", "192.168.1.50", "::1"},
+		Validity: 24 * time.Hour,
+	})
+
+	wantDNS := []string{"localhost", "sunlight.local"}
+	if len(cert.DNSNames) != len(wantDNS) {
+		t.Fatalf("got DNS names %v, want %v", cert.DNSNames, wantDNS)
+	}
+	for i, name := range wantDNS {
+		if cert.DNSNames[i] != name {
+			t.Errorf("got DNS names %v, want %v", cert.DNSNames, wantDNS)
+		}
+	}
+	wantIPs := []net.IP{net.ParseIP("192.168.1.50"), net.ParseIP("::1")}
+	if len(cert.IPAddresses) != len(wantIPs) {
+		t.Fatalf("got IP addresses %v, want %v", cert.IPAddresses, wantIPs)
+	}
+	for i, ip := range wantIPs {
+		if !cert.IPAddresses[i].Equal(ip) {
+			t.Errorf("got IP addresses %v, want %v", cert.IPAddresses, wantIPs)
+		}
+	}
+
+	// Each SAN verifies against the certificate, and a name that isn't one doesn't
+	for _, host := range []string{"localhost", "sunlight.local", "192.168.1.50", "::1"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+	if err := cert.VerifyHostname("example.com"); err == nil {
+		t.Error("example.com verified against the certificate")
+	}
+	if cert.NotAfter.Sub(cert.NotBefore) != 24*time.Hour {
+		t.Errorf("got validity %s, want 24h", cert.NotAfter.Sub(cert.NotBefore))
+	}
+}
+
+func TestSelfSignedCertificateKeyType(t *testing.T) {
+	tests := []struct {
+		keyType string
+		check   func(t *testing.T, cert *x509.Certificate, key any)
+	}{
+		{"", checkRSACertificate},
+		{"rsa", checkRSACertificate},
+		{"ecdsa", func(t *testing.T, cert *x509.Certificate, key any) {
+			private, ok := key.(*ecdsa.PrivateKey)
+			if !ok {
+				t.Fatalf("got a %T key, want ECDSA", key)
+			}
+			if private.Curve != elliptic.P256() {
+				t.Errorf("got curve %s, want P-256", private.Curve.Params().Name)
+			}
+			if cert.PublicKeyAlgorithm != x509.ECDSA {
+				t.Errorf("got public key algorithm %s, want ECDSA", cert.PublicKeyAlgorithm)
+			}
+			// Key encipherment only applies to RSA key exchange
+			if cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+				t.Error("an ECDSA certificate has key encipherment set")
+			}
+			if !private.PublicKey.Equal(cert.PublicKey) {
+				t.Error("the key doesn't match the certificate")
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("keytype="+tt.keyType, func(t *testing.T) {
+			cert, key := generateTestCertificate(t, CertOptions{Hosts: []string{"localhost"}, KeyType: tt.keyType, Validity: time.Hour})
+			tt.check(t, cert, key)
+		})
+	}
+}
+
+func checkRSACertificate(t *testing.T, cert *x509.Certificate, key any) {
+	private, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("got a %T key, want RSA", key)
+	}
+	if private.N.BitLen() != 2048 {
+		t.Errorf("got a %d bit key, want 2048", private.N.BitLen())
+	}
+	if cert.PublicKeyAlgorithm != x509.RSA {
+		t.Errorf("got public key algorithm %s, want RSA", cert.PublicKeyAlgorithm)
+	}
+	if cert.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+		t.Error("an RSA certificate is missing key encipherment")
+	}
+	if !private.PublicKey.Equal(cert.PublicKey) {
+		t.Error("the key doesn't match the certificate")
+	}
+}
+
+func TestSelfSignedCertificateUnsupportedKeyType(t *testing.T) {
+	dir := t.TempDir()
+	err := EnsureSelfSignedCertificate(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), CertOptions{Hosts: []string{"localhost"}, KeyType: "dsa", Validity: time.Hour})
+	if err == nil {
+		t.Fatal("expected an error for an unsupported key type")
+	}
+}
+
+func TestSelfSignedCertificateRenewal(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	opts := CertOptions{Hosts: []string{"localhost"}, Validity: 48 * time.Hour, RenewBefore: 24 * time.Hour}
+	if err := EnsureSelfSignedCertificate(certPath, keyPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	first := readTestCertificate(t, certPath)
+
+	// Still well within its validity, so it's kept
+	if err := EnsureSelfSignedCertificate(certPath, keyPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	if kept := readTestCertificate(t, certPath); kept.SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Error("a valid certificate was regenerated")
+	}
+
+	// Expiring inside the renewal window, so it's replaced
+	opts.RenewBefore = 72 * time.Hour
+	if err := EnsureSelfSignedCertificate(certPath, keyPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	if renewed := readTestCertificate(t, certPath); renewed.SerialNumber.Cmp(first.SerialNumber) == 0 {
+		t.Error("a certificate inside the renewal window was kept")
+	}
+}
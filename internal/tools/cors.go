@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CORS_ALLOWED_METHODS = "GET, POST, PUT, PATCH, DELETE"
+	CORS_ALLOWED_HEADERS = "Authorization, Content-Type"
+	CORS_EXPOSED_HEADERS = "Content-Disposition, Retry-After"
+	CORS_MAX_AGE         = 10 * time.Minute // How long a browser can cache a preflight
+)
+
+// Lets pages served from other origins call the API.
+// With no allowed origins no headers are added, so browsers keep the API same-origin.
+type CORS struct {
+	AllowedOrigins []string // Origins like https://app.example.com, or * for any origin
+}
+
+func NewCORS(allowedOrigins []string) (*CORS, error) {
+	cors := &CORS{}
+	for _, origin := range allowedOrigins {
+		if origin == "*" {
+			cors.AllowedOrigins = append(cors.AllowedOrigins, origin)
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") || strings.Trim(parsed.Path, "/") != "" {
+			return nil, fmt.Errorf("invalid CORS origin %q, expected a scheme and host like https://app.example.com", origin)
+		}
+		cors.AllowedOrigins = append(cors.AllowedOrigins, parsed.Scheme+"://"+strings.ToLower(parsed.Host))
+	}
+	return cors, nil
+}
+
+// Add the CORS headers for an allowed origin, and answer its preflight requests.
+// Requests from other origins are passed on untouched, and the browser blocks their responses.
+func (c *CORS) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(c.AllowedOrigins) == 0 || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowOrigin, ok := c.allowOrigin(origin)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", CORS_ALLOWED_METHODS)
+			w.Header().Set("Access-Control-Allow-Headers", CORS_ALLOWED_HEADERS)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(CORS_MAX_AGE.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", CORS_EXPOSED_HEADERS)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The Access-Control-Allow-Origin for an origin, if it's allowed
+func (c *CORS) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*", true
+		} else if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
@@ -3,19 +3,57 @@ package tools
 import (
 	"database/sql"
 	"embed"
+	"errors"
 	"io/fs"
 	"log"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	BUSY_RETRIES = 5
+	BUSY_BACKOFF = 50 * time.Millisecond
 )
 
 //go:embed migration/*
 var migrationFiles embed.FS
 
-func ConnectSqlite(filePath string) (*sql.DB, error) {
-	db, err := connectWithBackoff("sqlite3", filePath, 3)
+// Connection settings applied to every pooled connection.
+// WAL lets the dashboard read while the recorder writes, and NORMAL sync is safe with WAL while sparing the SD card.
+type SqliteOptions struct {
+	JournalMode string        // DELETE, TRUNCATE, PERSIST, MEMORY, WAL, or OFF
+	Synchronous string        // OFF, NORMAL, FULL, or EXTRA
+	BusyTimeout time.Duration // How long to wait on a locked db before failing
+}
+
+var DefaultSqliteOptions = SqliteOptions{
+	JournalMode: "WAL",
+	Synchronous: "NORMAL",
+	BusyTimeout: 5 * time.Second,
+}
+
+func ConnectSqlite(filePath string, options SqliteOptions) (*sql.DB, error) {
+	params := url.Values{}
+	if options.JournalMode != "" {
+		params.Set("_journal_mode", options.JournalMode)
+	}
+	if options.Synchronous != "" {
+		params.Set("_synchronous", options.Synchronous)
+	}
+	if options.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(options.BusyTimeout.Milliseconds(), 10))
+	}
+	connStr := filePath
+	if len(params) > 0 {
+		connStr += "?" + params.Encode()
+	}
+
+	db, err := connectWithBackoff("sqlite3", connStr, 3)
 	if err != nil {
 		return nil, err
 	}
@@ -39,6 +77,10 @@ func RunMigrations(db *sql.DB) error {
 			return err
 		}
 		if _, err := db.Exec(string(fileData)); err != nil {
+			// Migrations run on every start, a column that was already added is fine
+			if strings.Contains(err.Error(), "duplicate column name") {
+				continue
+			}
 			return err
 		}
 	}
@@ -66,3 +108,50 @@ func connectWithBackoff(driver string, connStr string, maxRetries int) (*sql.DB,
 	}
 	return nil, err
 }
+
+// Whether the error is sqlite reporting the db is busy or locked by another connection
+func IsBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// Run fn, retrying with exponential backoff while it fails because the db is busy.
+// Any other error is returned straight away.
+func RetryBusy(fn func() error) error {
+	backoff := BUSY_BACKOFF
+	var err error
+	for attempt := 0; attempt <= BUSY_RETRIES; attempt++ {
+		if err = fn(); err == nil || !IsBusyError(err) {
+			return err
+		} else if attempt < BUSY_RETRIES {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// Query, retrying while the db is busy
+func QueryRetry(db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := RetryBusy(func() error {
+		var err error
+		rows, err = db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// Exec, retrying while the db is busy
+func ExecRetry(db *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := RetryBusy(func() error {
+		var err error
+		result, err = db.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
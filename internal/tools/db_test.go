@@ -0,0 +1,223 @@
+package tools
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Wraps the sqlite driver, failing the next calls with SQLITE_BUSY as if another connection held the db
+type busyDriver struct {
+	sqlite3.SQLiteDriver
+	failures atomic.Int32
+	calls    atomic.Int32
+}
+
+var (
+	testBusyDriver         = &busyDriver{}
+	registerBusyDriverOnce sync.Once
+)
+
+func (d *busyDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &busyConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), driver: d}, nil
+}
+
+// Whether the call should fail as busy
+func (d *busyDriver) busy() error {
+	d.calls.Add(1)
+	for {
+		remaining := d.failures.Load()
+		if remaining <= 0 {
+			return nil
+		} else if d.failures.CompareAndSwap(remaining, remaining-1) {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+	}
+}
+
+type busyConn struct {
+	*sqlite3.SQLiteConn
+	driver *busyDriver
+}
+
+func (c *busyConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.driver.busy(); err != nil {
+		return nil, err
+	}
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c *busyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.driver.busy(); err != nil {
+		return nil, err
+	}
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *busyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.driver.busy(); err != nil {
+		return nil, err
+	}
+	return c.SQLiteConn.BeginTx(ctx, opts)
+}
+
+// An in-memory db on the busy driver, with a table to write to
+func newBusyTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	registerBusyDriverOnce.Do(func() {
+		sql.Register("sqlite3_busy_test", testBusyDriver)
+	})
+	testBusyDriver.failures.Store(0)
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := sql.Open("sqlite3_busy_test", fmt.Sprintf("file:%s?mode=memory&cache=shared", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE readings (lux REAL)"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// Fail the next n calls as busy, and count the calls from here
+func failNextCalls(n int32) {
+	testBusyDriver.calls.Store(0)
+	testBusyDriver.failures.Store(n)
+}
+
+func TestIsBusyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"busy", sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{"locked", sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{"wrapped busy", fmt.Errorf("writing results: %w", sqlite3.Error{Code: sqlite3.ErrBusy}), true},
+		{"constraint", sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{"other error", errors.New("database is locked"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsBusyError(tt.err); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBusy(t *testing.T) {
+	t.Run("succeeds once the db is free", func(t *testing.T) {
+		calls := 0
+		err := RetryBusy(func() error {
+			calls++
+			if calls < 3 {
+				return sqlite3.Error{Code: sqlite3.ErrBusy}
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("got %v after %d calls, want success after 3", err, calls)
+		}
+	})
+	t.Run("other errors aren't retried", func(t *testing.T) {
+		calls := 0
+		constraint := sqlite3.Error{Code: sqlite3.ErrConstraint}
+		err := RetryBusy(func() error {
+			calls++
+			return constraint
+		})
+		if !errors.Is(err, constraint) || calls != 1 {
+			t.Errorf("got %v after %d calls, want the error after 1", err, calls)
+		}
+	})
+	t.Run("gives up while the db stays busy", func(t *testing.T) {
+		calls := 0
+		err := RetryBusy(func() error {
+			calls++
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		})
+		if !IsBusyError(err) || calls != BUSY_RETRIES+1 {
+			t.Errorf("got %v after %d calls, want the busy error after %d", err, calls, BUSY_RETRIES+1)
+		}
+	})
+}
+
+func TestExecAndQueryRetry(t *testing.T) {
+	db := newBusyTestDB(t)
+
+	failNextCalls(2)
+	if _, err := ExecRetry(db, "INSERT INTO readings (lux) VALUES (?)", 250.0); err != nil {
+		t.Fatalf("ExecRetry: %v", err)
+	}
+	if calls := testBusyDriver.calls.Load(); calls != 3 {
+		t.Errorf("ExecRetry made %d calls, want 3", calls)
+	}
+
+	failNextCalls(2)
+	rows, err := QueryRetry(db, "SELECT lux FROM readings")
+	if err != nil {
+		t.Fatalf("QueryRetry: %v", err)
+	}
+	var luxes []float64
+	for rows.Next() {
+		var lux float64
+		if err := rows.Scan(&lux); err != nil {
+			t.Fatal(err)
+		}
+		luxes = append(luxes, lux)
+	}
+	rows.Close()
+	if len(luxes) != 1 || luxes[0] != 250 {
+		t.Errorf("got %v, want the one reading", luxes)
+	}
+
+	// Without the retry, the busy error reaches the caller
+	failNextCalls(1)
+	if _, err := db.Exec("INSERT INTO readings (lux) VALUES (?)", 1.0); !IsBusyError(err) {
+		t.Errorf("got %v, want a busy error", err)
+	}
+}
+
+func TestRetryBusyTransaction(t *testing.T) {
+	db := newBusyTestDB(t)
+
+	// The recorder retries the whole transaction, so a busy Begin or insert never loses part of a batch
+	insertBatch := func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		for _, lux := range []float64{1, 2, 3} {
+			if _, err := tx.Exec("INSERT INTO readings (lux) VALUES (?)", lux); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
+	failNextCalls(1)
+	if err := RetryBusy(insertBatch); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM readings").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got %d rows, want the batch of 3 once", count)
+	}
+}
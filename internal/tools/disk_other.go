@@ -0,0 +1,14 @@
+//go:build !unix
+
+package tools
+
+import "errors"
+
+type DiskUsage struct {
+	Total uint64
+	Free  uint64
+}
+
+func ReadDiskUsage(path string) (DiskUsage, error) {
+	return DiskUsage{}, errors.New("disk usage is not supported on this platform")
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package tools
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+type DiskUsage struct {
+	Total uint64
+	Free  uint64
+}
+
+// Usage of the filesystem holding path, free is the space available to this process
+func ReadDiskUsage(path string) (DiskUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Dir(path), &stat); err != nil {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{
+		Total: uint64(stat.Blocks) * uint64(stat.Bsize),
+		Free:  uint64(stat.Bavail) * uint64(stat.Bsize),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package tools
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error codes returned in API error responses
+const (
+	ERR_BAD_REQUEST          = "BAD_REQUEST"
+	ERR_BAD_DATE_RANGE       = "BAD_DATE_RANGE"
+	ERR_UNAUTHORIZED         = "UNAUTHORIZED"
+	ERR_FORBIDDEN            = "FORBIDDEN"
+	ERR_NOT_FOUND            = "NOT_FOUND"
+	ERR_CONFLICT             = "CONFLICT"
+	ERR_RATE_LIMITED         = "RATE_LIMITED"
+	ERR_SENSOR_NOT_CONNECTED = "SENSOR_NOT_CONNECTED"
+	ERR_JOB_RUNNING          = "JOB_RUNNING"
+	ERR_JOB_NOT_RUNNING      = "JOB_NOT_RUNNING"
+	ERR_SENSOR_BUSY          = "SENSOR_BUSY"
+	ERR_SENSOR_ERROR         = "SENSOR_ERROR"
+	ERR_NETWORK_UNAVAILABLE  = "NETWORK_UNAVAILABLE"
+	ERR_LOCATION_NOT_SET     = "LOCATION_NOT_SET"
+	ERR_RELAY_NOT_CONFIGURED = "RELAY_NOT_CONFIGURED"
+	ERR_DB_ERROR             = "DB_ERROR"
+	ERR_INTERNAL             = "INTERNAL_ERROR"
+)
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Requests under /api/v1 get JSON responses, everything else is for the dashboard
+func IsAPIRequest(r *http.Request) bool {
+	return strings.Contains(r.URL.Path, "/api/v1/")
+}
+
+// API requests, and any request whose Accept header asks for JSON, get JSON responses
+func WantsJSON(r *http.Request) bool {
+	return IsAPIRequest(r) || AcceptsJSON(r.Header.Get("Accept"))
+}
+
+// Whether the Accept header prefers JSON over HTML. Wildcards don't count, so browsers and htmx still get HTML.
+func AcceptsJSON(accept string) bool {
+	jsonQ, htmlQ := 0.0, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ >= htmlQ
+}
+
+// Reply with the error envelope for JSON requests, or plain text otherwise
+func WriteError(w http.ResponseWriter, r *http.Request, code string, message string, status int) {
+	if !WantsJSON(r) {
+		http.Error(w, message, status)
+		return
+	}
+	WriteAPIError(w, code, message, status)
+}
+
+// Reply with {"error": {"code": "...", "message": "..."}}
+func WriteAPIError(w http.ResponseWriter, code string, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error APIError `json:"error"`
+	}{
+		Error: APIError{Code: code, Message: message},
+	})
+}
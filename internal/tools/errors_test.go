@@ -0,0 +1,81 @@
+package tools
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"text/html", false},
+		{"text/html, application/json", true},
+		{"text/html, application/json;q=0.9", false},
+		{"text/html;q=0.5, application/json", true},
+		{"application/json;q=0", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+	}
+	for _, tt := range tests {
+		if got := AcceptsJSON(tt.accept); got != tt.want {
+			t.Errorf("AcceptsJSON(%q) = %t, want %t", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		wantJSON bool
+	}{
+		{"API path", "/api/v1/readings", "", true},
+		{"dashboard path", "/sunlightmeter/readings", "", false},
+		{"dashboard path asking for JSON", "/sunlightmeter/readings", "application/json", true},
+		{"API path from a browser", "/api/v1/readings", "text/html", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			WriteError(w, req, ERR_SENSOR_BUSY, "The sensor is busy", http.StatusConflict)
+
+			if w.Code != http.StatusConflict {
+				t.Errorf("got %d, want %d", w.Code, http.StatusConflict)
+			}
+			contentType := w.Header().Get("Content-Type")
+			if !tt.wantJSON {
+				if strings.HasPrefix(contentType, "application/json") {
+					t.Errorf("got a JSON error for a dashboard request: %s", w.Body.String())
+				}
+				if strings.TrimSpace(w.Body.String()) != "The sensor is busy" {
+					t.Errorf("got body %q", w.Body.String())
+				}
+				return
+			}
+			if contentType != "application/json" {
+				t.Errorf("got content type %q", contentType)
+			}
+			var body struct {
+				Error APIError `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("the error isn't JSON: %s", w.Body.String())
+			}
+			if body.Error != (APIError{Code: ERR_SENSOR_BUSY, Message: "The sensor is busy"}) {
+				t.Errorf("got %+v", body.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+package tools
+
+// A GPIO pin driven as an output, like a relay's input
+type GPIOOutput interface {
+	Set(high bool) error
+	Close() error
+}
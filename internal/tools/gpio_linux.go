@@ -0,0 +1,97 @@
+//go:build linux
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GPIO_SYSFS = "/sys/class/gpio"
+	// How long to wait for udev to hand an exported pin's files to the gpio group
+	GPIO_EXPORT_WAIT = time.Second
+)
+
+// A pin driven through the sysfs GPIO interface
+type sysfsGPIO struct {
+	number int
+	value  *os.File
+}
+
+// Export the pin with the BCM number as an output, starting at the level.
+// Newer Raspberry Pi kernels number the pins from the SoC chip's base, rather than 0, so it's added on.
+func OpenGPIOOutput(bcm int, high bool) (GPIOOutput, error) {
+	number := gpioBase() + bcm
+	dir := filepath.Join(GPIO_SYSFS, fmt.Sprintf("gpio%d", number))
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(filepath.Join(GPIO_SYSFS, "export"), []byte(strconv.Itoa(number)), 0); err != nil {
+			return nil, fmt.Errorf("failed to export GPIO %d: %w", bcm, err)
+		}
+	}
+
+	// The direction sets the starting level too, so the pin never glitches to the other one
+	direction := "low"
+	if high {
+		direction = "high"
+	}
+	deadline := time.Now().Add(GPIO_EXPORT_WAIT)
+	for {
+		err := os.WriteFile(filepath.Join(dir, "direction"), []byte(direction), 0)
+		if err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to set GPIO %d as an output: %w", bcm, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	value, err := os.OpenFile(filepath.Join(dir, "value"), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GPIO %d: %w", bcm, err)
+	}
+	return &sysfsGPIO{number: number, value: value}, nil
+}
+
+func (g *sysfsGPIO) Set(high bool) error {
+	level := []byte("0")
+	if high {
+		level = []byte("1")
+	}
+	_, err := g.value.WriteAt(level, 0)
+	return err
+}
+
+// Close the pin and unexport it, which leaves it as an input
+func (g *sysfsGPIO) Close() error {
+	err := g.value.Close()
+	if unexportErr := os.WriteFile(filepath.Join(GPIO_SYSFS, "unexport"), []byte(strconv.Itoa(g.number)), 0); err == nil {
+		err = unexportErr
+	}
+	return err
+}
+
+// The base of the Raspberry Pi's GPIO chip, or 0 when it isn't found
+func gpioBase() int {
+	chips, _ := filepath.Glob(filepath.Join(GPIO_SYSFS, "gpiochip*"))
+	for _, chip := range chips {
+		label, err := os.ReadFile(filepath.Join(chip, "label"))
+		if err != nil {
+			continue
+		}
+		if name := strings.TrimSpace(string(label)); strings.HasPrefix(name, "pinctrl-bcm") || name == "pinctrl-rp1" {
+			base, err := os.ReadFile(filepath.Join(chip, "base"))
+			if err != nil {
+				continue
+			}
+			if n, err := strconv.Atoi(strings.TrimSpace(string(base))); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
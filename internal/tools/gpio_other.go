@@ -0,0 +1,18 @@
+//go:build !linux
+
+package tools
+
+// GPIO is only driven through linux's sysfs, elsewhere the pin does nothing so the rest of the meter still runs
+type noopGPIO struct{}
+
+func OpenGPIOOutput(bcm int, high bool) (GPIOOutput, error) {
+	return noopGPIO{}, nil
+}
+
+func (noopGPIO) Set(high bool) error {
+	return nil
+}
+
+func (noopGPIO) Close() error {
+	return nil
+}
@@ -0,0 +1,193 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_INFLUX_INTERVAL = 30 * time.Second
+	DEFAULT_INFLUX_BUFFER   = 10000
+	INFLUX_MAX_BACKOFF      = 5 * time.Minute
+	INFLUX_TIMEOUT          = 10 * time.Second
+)
+
+// A single point in InfluxDB line protocol
+type InfluxPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Time        time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// Format the point as a line of line protocol, with a nanosecond timestamp.
+// Tags and fields are sorted, and empty tags are left out.
+func (p InfluxPoint) String() string {
+	var line strings.Builder
+	line.WriteString(measurementEscaper.Replace(p.Measurement))
+	for _, key := range sortedKeys(p.Tags) {
+		if p.Tags[key] == "" {
+			continue
+		}
+		line.WriteString("," + tagEscaper.Replace(key) + "=" + tagEscaper.Replace(p.Tags[key]))
+	}
+	for i, key := range sortedKeys(p.Fields) {
+		if i == 0 {
+			line.WriteString(" ")
+		} else {
+			line.WriteString(",")
+		}
+		line.WriteString(tagEscaper.Replace(key) + "=" + strconv.FormatFloat(p.Fields[key], 'f', -1, 64))
+	}
+	line.WriteString(" " + strconv.FormatInt(p.Time.UnixNano(), 10))
+	return line.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Writes points to an InfluxDB v2 bucket in batches, in the background.
+// While the server is unreachable, points are kept in a bounded buffer and the oldest are dropped once it's full.
+type InfluxWriter struct {
+	URL       string
+	Org       string
+	Bucket    string
+	Token     string
+	MaxBuffer int
+	Client    *http.Client
+
+	mu      sync.Mutex
+	buffer  []string
+	dropped int
+	flushMu sync.Mutex
+}
+
+func NewInfluxWriter(serverURL string, org string, bucket string, token string, interval time.Duration, maxBuffer int) *InfluxWriter {
+	if interval <= 0 {
+		interval = DEFAULT_INFLUX_INTERVAL
+	}
+	if maxBuffer < 1 {
+		maxBuffer = DEFAULT_INFLUX_BUFFER
+	}
+	w := &InfluxWriter{
+		URL:       strings.TrimSuffix(serverURL, "/"),
+		Org:       org,
+		Bucket:    bucket,
+		Token:     token,
+		MaxBuffer: maxBuffer,
+		Client:    &http.Client{Timeout: INFLUX_TIMEOUT},
+	}
+	go w.writeEvery(interval)
+	return w
+}
+
+// Buffer the points for the next write, this never blocks on the network.
+// A nil writer drops everything.
+func (w *InfluxWriter) Write(points ...InfluxPoint) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, point := range points {
+		w.buffer = append(w.buffer, point.String())
+	}
+	w.trim()
+}
+
+// Drop the oldest lines beyond MaxBuffer, must be called with mu held
+func (w *InfluxWriter) trim() {
+	if over := len(w.buffer) - w.MaxBuffer; over > 0 {
+		w.buffer = w.buffer[over:]
+		w.dropped += over
+		log.Printf("Influx buffer is full, dropped %d points", w.dropped)
+	}
+}
+
+// Write the buffered points now, they're kept for the next attempt if the write fails
+func (w *InfluxWriter) Flush() error {
+	if w == nil {
+		return nil
+	}
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	lines := w.buffer
+	w.buffer = nil
+	w.mu.Unlock()
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if err := w.post(lines); err != nil {
+		// Put them back ahead of anything written meanwhile
+		w.mu.Lock()
+		w.buffer = append(lines, w.buffer...)
+		w.trim()
+		w.mu.Unlock()
+		return err
+	}
+	w.mu.Lock()
+	w.dropped = 0
+	w.mu.Unlock()
+	return nil
+}
+
+// Flush every interval, backing off while the server is unreachable
+func (w *InfluxWriter) writeEvery(interval time.Duration) {
+	wait := interval
+	for {
+		time.Sleep(wait)
+		if err := w.Flush(); err != nil {
+			wait = min(wait*2, INFLUX_MAX_BACKOFF)
+			log.Printf("Failed to write to InfluxDB, retrying in %s: %v", wait, err)
+			continue
+		}
+		wait = interval
+	}
+}
+
+func (w *InfluxWriter) post(lines []string) error {
+	params := url.Values{}
+	params.Set("org", w.Org)
+	params.Set("bucket", w.Bucket)
+	params.Set("precision", "ns")
+	body := strings.Join(lines, "\n")
+	req, err := http.NewRequest(http.MethodPost, w.URL+"/api/v2/write?"+params.Encode(), bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.Token)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ func init() {
 	}
 	multi := io.MultiWriter(logFile, os.Stdout)
 	log.SetOutput(multi)
+	requestLog.SetOutput(multi)
 }
 
 func (t *MultiWriter) Write(p []byte) (n int, err error) {
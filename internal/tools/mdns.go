@@ -0,0 +1,427 @@
+package tools
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MDNS_ADDR                  = "224.0.0.251:5353"
+	MDNS_PORT                  = 5353
+	MDNS_SERVICE_SUNLIGHTMETER = "_sunlightmeter._tcp"
+	MDNS_SERVICE_HTTP          = "_http._tcp"
+	MDNS_TTL                   = 120 // Seconds, as recommended for records that name a host
+	MDNS_LEGACY_TTL            = 10  // Seconds, for one-shot queries that didn't come from port 5353
+	// Announcements are sent twice, a second apart, so one lost packet doesn't hide the change
+	MDNS_ANNOUNCE_DELAY = time.Second
+)
+
+const (
+	dnsTypeA   uint16 = 1
+	dnsTypePTR uint16 = 12
+	dnsTypeTXT uint16 = 16
+	dnsTypeSRV uint16 = 33
+	dnsTypeANY uint16 = 255
+
+	dnsClassIN         uint16 = 1
+	dnsClassCacheFlush uint16 = 0x8000 // Set on records only this host answers for
+
+	dnsServicesName = "_services._dns-sd._udp.local."
+)
+
+// Answers mDNS queries for an HTTP service on this host, so DNS-SD browsers like avahi-browse can find it.
+// It only speaks IPv4, and answers from the interface the multicast group was joined on.
+type MDNSResponder struct {
+	instance string
+	host     string
+	port     int
+	services []string
+	conn     *net.UDPConn
+	// The host's addresses, net.InterfaceAddrs unless a test replaces them
+	interfaceAddrs func() ([]net.Addr, error)
+
+	mu  sync.Mutex
+	txt []string
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+}
+
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	data  []byte
+}
+
+// Join the mDNS group, and advertise the port as an instance of each service, like _http._tcp, with the TXT records
+func NewMDNSResponder(instance string, port int, services []string, txt []string) (*MDNSResponder, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	group, err := net.ResolveUDPAddr("udp4", MDNS_ADDR)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join the mDNS group: %w", err)
+	}
+	// The instance is a single DNS label, dots and all, and a label is at most 63 bytes
+	if len(instance) > 63 {
+		instance = instance[:63]
+	}
+	r := &MDNSResponder{
+		instance:       instance,
+		host:           strings.SplitN(hostname, ".", 2)[0] + ".local.",
+		port:           port,
+		services:       services,
+		conn:           conn,
+		interfaceAddrs: net.InterfaceAddrs,
+		txt:            txt,
+		done:           make(chan struct{}),
+	}
+	go r.serve()
+	go r.announce(MDNS_TTL)
+	return r, nil
+}
+
+// Replace the TXT records, and announce them so browsers see the change without asking again
+func (r *MDNSResponder) SetTXT(txt []string) {
+	r.mu.Lock()
+	r.txt = txt
+	r.mu.Unlock()
+	go r.announce(MDNS_TTL)
+}
+
+// Closed once the responder is
+func (r *MDNSResponder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Tell browsers the service is going away, then leave the group
+func (r *MDNSResponder) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		r.send(r.response(r.records(0), nil, false, 0), nil)
+		close(r.done)
+		err = r.conn.Close()
+	})
+	return err
+}
+
+func (r *MDNSResponder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+				return
+			default:
+			}
+			log.Println(fmt.Sprintf("Failed to read an mDNS query: %s", err.Error()))
+			time.Sleep(time.Second)
+			continue
+		}
+		if packet, to := r.reply(buf[:n], from); packet != nil {
+			r.send(packet, to)
+		}
+	}
+}
+
+// The response to a query, and where to send it: nil for the group. The packet is nil when there's nothing to answer.
+func (r *MDNSResponder) reply(query []byte, from *net.UDPAddr) ([]byte, *net.UDPAddr) {
+	id, questions, err := parseDNSQuery(query)
+	if err != nil || len(questions) == 0 {
+		return nil, nil
+	}
+	// Queries from another port are one-shot lookups, like dig, that want a plain unicast DNS reply
+	if from.Port != MDNS_PORT {
+		answers, additionals := r.answer(questions, MDNS_LEGACY_TTL)
+		if len(answers) == 0 {
+			return nil, nil
+		}
+		return r.response(answers, additionals, true, id, questions...), from
+	}
+	answers, additionals := r.answer(questions, MDNS_TTL)
+	if len(answers) == 0 {
+		return nil, nil
+	}
+	return r.response(answers, additionals, false, 0), nil
+}
+
+// Send every record unasked, twice, with the TTL. A TTL of 0 withdraws them.
+func (r *MDNSResponder) announce(ttl uint32) {
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			select {
+			case <-r.done:
+				return
+			case <-time.After(MDNS_ANNOUNCE_DELAY):
+			}
+		}
+		r.send(r.response(r.records(ttl), nil, false, 0), nil)
+	}
+}
+
+// The records answering the questions, and the ones a browser will need next
+func (r *MDNSResponder) answer(questions []dnsQuestion, ttl uint32) ([]dnsRecord, []dnsRecord) {
+	var answers, additionals []dnsRecord
+	for _, question := range questions {
+		name := strings.ToLower(question.name)
+		matches := func(rtype uint16) bool {
+			return question.qtype == rtype || question.qtype == dnsTypeANY
+		}
+		if name == dnsServicesName && matches(dnsTypePTR) {
+			for _, service := range r.services {
+				answers = append(answers, dnsRecord{dnsServicesName, dnsTypePTR, dnsClassIN, ttl, encodeDNSName(service + ".local.")})
+			}
+		}
+		if name == strings.ToLower(r.host) && matches(dnsTypeA) {
+			answers = append(answers, r.addressRecords(ttl)...)
+		}
+		for _, service := range r.services {
+			switch name {
+			case strings.ToLower(service + ".local."):
+				if matches(dnsTypePTR) {
+					answers = append(answers, r.serviceRecords(service, ttl)[0])
+					additionals = append(additionals, r.serviceRecords(service, ttl)[1:]...)
+					additionals = append(additionals, r.addressRecords(ttl)...)
+				}
+			case strings.ToLower(r.instanceName(service)):
+				for _, record := range r.serviceRecords(service, ttl)[1:] {
+					if matches(record.rtype) {
+						answers = append(answers, record)
+					}
+				}
+				if len(answers) > 0 {
+					additionals = append(additionals, r.addressRecords(ttl)...)
+				}
+			}
+		}
+	}
+	return answers, additionals
+}
+
+// Every record the responder answers for
+func (r *MDNSResponder) records(ttl uint32) []dnsRecord {
+	var records []dnsRecord
+	for _, service := range r.services {
+		records = append(records, r.serviceRecords(service, ttl)...)
+	}
+	return append(records, r.addressRecords(ttl)...)
+}
+
+func (r *MDNSResponder) instanceName(service string) string {
+	return escapeDNSLabel(r.instance) + "." + service + ".local."
+}
+
+// The service's PTR, then the instance's SRV and TXT
+func (r *MDNSResponder) serviceRecords(service string, ttl uint32) []dnsRecord {
+	instance := r.instanceName(service)
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:], uint16(r.port)) // Priority and weight are 0
+	srv = append(srv, encodeDNSName(r.host)...)
+
+	r.mu.Lock()
+	var txt []byte
+	for _, entry := range r.txt {
+		if len(entry) > 255 {
+			entry = entry[:255]
+		}
+		txt = append(txt, byte(len(entry)))
+		txt = append(txt, entry...)
+	}
+	r.mu.Unlock()
+	if len(txt) == 0 {
+		// A TXT record can't be empty, it holds a single empty string instead
+		txt = []byte{0}
+	}
+	return []dnsRecord{
+		{service + ".local.", dnsTypePTR, dnsClassIN, ttl, encodeDNSName(instance)},
+		{instance, dnsTypeSRV, dnsClassIN | dnsClassCacheFlush, ttl, srv},
+		{instance, dnsTypeTXT, dnsClassIN | dnsClassCacheFlush, ttl, txt},
+	}
+}
+
+// An A record for each of the host's IPv4 addresses, other than loopback
+func (r *MDNSResponder) addressRecords(ttl uint32) []dnsRecord {
+	interfaceAddrs := r.interfaceAddrs
+	if interfaceAddrs == nil {
+		interfaceAddrs = net.InterfaceAddrs
+	}
+	addrs, err := interfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var records []dnsRecord
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		records = append(records, dnsRecord{r.host, dnsTypeA, dnsClassIN | dnsClassCacheFlush, ttl, ipNet.IP.To4()})
+	}
+	return records
+}
+
+// A response for the group, or for a legacy querier with its ID and questions echoed back
+func (r *MDNSResponder) response(answers []dnsRecord, additionals []dnsRecord, legacy bool, id uint16, questions ...dnsQuestion) []byte {
+	packet := make([]byte, 12)
+	binary.BigEndian.PutUint16(packet[0:], id)
+	binary.BigEndian.PutUint16(packet[2:], 0x8400) // A response, and authoritative
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(questions)))
+	binary.BigEndian.PutUint16(packet[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(packet[10:], uint16(len(additionals)))
+	for _, question := range questions {
+		packet = append(packet, encodeDNSName(question.name)...)
+		packet = binary.BigEndian.AppendUint16(packet, question.qtype)
+		packet = binary.BigEndian.AppendUint16(packet, dnsClassIN)
+	}
+	for _, record := range append(answers, additionals...) {
+		class := record.class
+		if legacy {
+			// Legacy queriers don't know the cache flush bit
+			class &^= dnsClassCacheFlush
+		}
+		packet = append(packet, encodeDNSName(record.name)...)
+		packet = binary.BigEndian.AppendUint16(packet, record.rtype)
+		packet = binary.BigEndian.AppendUint16(packet, class)
+		packet = binary.BigEndian.AppendUint32(packet, record.ttl)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(len(record.data)))
+		packet = append(packet, record.data...)
+	}
+	return packet
+}
+
+// Send a packet to the querier, or to the group when that's nil
+func (r *MDNSResponder) send(packet []byte, to *net.UDPAddr) {
+	if to == nil {
+		to = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: MDNS_PORT}
+	}
+	if _, err := r.conn.WriteToUDP(packet, to); err != nil {
+		select {
+		case <-r.done:
+		default:
+			log.Println(fmt.Sprintf("Failed to send an mDNS response: %s", err.Error()))
+		}
+	}
+}
+
+// A name in DNS wire format, uncompressed. Names are written as text, where "\." is a dot inside a label.
+func encodeDNSName(name string) []byte {
+	var encoded []byte
+	for _, label := range splitDNSName(name) {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0)
+}
+
+// Split a name into its labels at the dots that aren't escaped, and unescape them
+func splitDNSName(name string) []string {
+	var labels []string
+	if name == "." {
+		// The root has no labels
+		return nil
+	}
+	var label strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '\\' && i+1 < len(name):
+			i++
+			label.WriteByte(name[i])
+		case name[i] == '.':
+			labels = append(labels, label.String())
+			label.Reset()
+		default:
+			label.WriteByte(name[i])
+		}
+	}
+	if label.Len() > 0 {
+		labels = append(labels, label.String())
+	}
+	return labels
+}
+
+// Escape the dots and backslashes in a label, so it stays one label when it's joined into a name
+func escapeDNSLabel(label string) string {
+	return strings.NewReplacer(`\`, `\\`, ".", `\.`).Replace(label)
+}
+
+// The ID and questions of a DNS query, responses are skipped
+func parseDNSQuery(packet []byte) (uint16, []dnsQuestion, error) {
+	if len(packet) < 12 {
+		return 0, nil, errors.New("short DNS message")
+	}
+	id := binary.BigEndian.Uint16(packet[0:])
+	if packet[2]&0x80 != 0 {
+		return id, nil, nil
+	}
+	count := int(binary.BigEndian.Uint16(packet[4:]))
+	offset := 12
+	questions := make([]dnsQuestion, 0, count)
+	for i := 0; i < count; i++ {
+		name, next, err := readDNSName(packet, offset)
+		if err != nil {
+			return id, nil, err
+		}
+		if next+4 > len(packet) {
+			return id, nil, errors.New("short DNS question")
+		}
+		questions = append(questions, dnsQuestion{name: name, qtype: binary.BigEndian.Uint16(packet[next:])})
+		offset = next + 4
+	}
+	return id, questions, nil
+}
+
+// Read the name at the offset, following compression pointers, and return it with the offset after it
+func readDNSName(packet []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(packet) {
+			return "", 0, errors.New("short DNS name")
+		}
+		length := int(packet[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(packet) {
+				return "", 0, errors.New("short DNS name pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("DNS name pointer loop")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(packet[offset:]) & 0x3FFF)
+		default:
+			if offset+1+length > len(packet) {
+				return "", 0, errors.New("short DNS label")
+			}
+			labels = append(labels, escapeDNSLabel(string(packet[offset+1:offset+1+length])))
+			offset += 1 + length
+		}
+	}
+}
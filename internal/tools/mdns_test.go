@@ -0,0 +1,212 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// A responder that never touches the network, on a host with one LAN address
+func newTestResponder(instance string) *MDNSResponder {
+	return &MDNSResponder{
+		instance: instance,
+		host:     "pi.local.",
+		port:     8080,
+		services: []string{MDNS_SERVICE_SUNLIGHTMETER, MDNS_SERVICE_HTTP},
+		txt:      []string{"path=/", "job=idle"},
+		interfaceAddrs: func() ([]net.Addr, error) {
+			return []net.Addr{
+				&net.IPNet{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(8, 32)},
+				&net.IPNet{IP: net.IPv4(192, 168, 1, 20), Mask: net.CIDRMask(24, 32)},
+				&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+			}, nil
+		},
+	}
+}
+
+// A name in wire format, from its labels
+func wireName(labels ...string) []byte {
+	var name []byte
+	for _, label := range labels {
+		name = append(name, byte(len(label)))
+		name = append(name, label...)
+	}
+	return append(name, 0)
+}
+
+// A query with one question of the class IN
+func wireQuery(id uint16, qtype uint16, labels ...string) []byte {
+	packet := []byte{byte(id >> 8), byte(id), 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	packet = append(packet, wireName(labels...)...)
+	return append(packet, byte(qtype>>8), byte(qtype), 0x00, 0x01)
+}
+
+func TestParseDNSQuery(t *testing.T) {
+	// What avahi-browse sends: a PTR question for _http._tcp.local., then an A question for pi.local. that points back at
+	// "local" in the first, asking for a unicast response
+	browse := []byte{
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x05, '_', 'h', 't', 't', 'p', 0x04, '_', 't', 'c', 'p', 0x05, 'l', 'o', 'c', 'a', 'l', 0x00, 0x00, 0x0c, 0x00, 0x01,
+		0x02, 'p', 'i', 0xc0, 0x17, 0x00, 0x01, 0x80, 0x01,
+	}
+	id, questions, err := parseDNSQuery(browse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dnsQuestion{{"_http._tcp.local.", dnsTypePTR}, {"pi.local.", dnsTypeA}}
+	if id != 0 || len(questions) != len(want) || questions[0] != want[0] || questions[1] != want[1] {
+		t.Errorf("got %d, %v, want 0, %v", id, questions, want)
+	}
+
+	// A label with a dot in it stays one label
+	_, questions, err = parseDNSQuery(wireQuery(7, dnsTypeSRV, "Meter on pi.lan", "_http", "_tcp", "local"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(questions) != 1 || questions[0].name != `Meter on pi\.lan._http._tcp.local.` {
+		t.Errorf("got %v", questions)
+	}
+
+	// Other responders' answers aren't questions
+	response := wireQuery(0, dnsTypePTR, "_http", "_tcp", "local")
+	response[2] = 0x84
+	if _, questions, err := parseDNSQuery(response); err != nil || len(questions) != 0 {
+		t.Errorf("got %v, %v for a response, want no questions", questions, err)
+	}
+
+	for name, packet := range map[string][]byte{
+		"a short header":      {0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+		"a missing question":  {0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		"a short label":       {0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 'l', 'o', 'c'},
+		"a name without type": {0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 'p', 'i', 0x00, 0x00, 0x01},
+		"a short pointer":     {0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0},
+		"a pointer loop":      {0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01},
+	} {
+		if _, questions, err := parseDNSQuery(packet); err == nil {
+			t.Errorf("%s: got %v, want an error", name, questions)
+		}
+	}
+}
+
+func TestEncodeDNSName(t *testing.T) {
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"pi.local.", wireName("pi", "local")},
+		{"pi.local", wireName("pi", "local")},
+		{".", wireName()},
+		{`Meter on pi\.lan._http._tcp.local.`, wireName("Meter on pi.lan", "_http", "_tcp", "local")},
+		{`back\\slash.local.`, wireName(`back\slash`, "local")},
+	}
+	for _, tt := range tests {
+		if got := encodeDNSName(tt.name); !bytes.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+		// Reading it back gives the same name
+		if name, _, err := readDNSName(encodeDNSName(tt.name), 0); err != nil || !bytes.Equal(encodeDNSName(name), tt.want) {
+			t.Errorf("%q: read back %q, %v", tt.name, name, err)
+		}
+	}
+}
+
+func TestMDNSAnswer(t *testing.T) {
+	r := newTestResponder("Sunlight Meter on pi.lan")
+	instance := wireName("Sunlight Meter on pi.lan", "_sunlightmeter", "_tcp", "local")
+	srv := append([]byte{0x00, 0x00, 0x00, 0x00, 0x1f, 0x90}, wireName("pi", "local")...)
+	txt := []byte{6, 'p', 'a', 't', 'h', '=', '/', 8, 'j', 'o', 'b', '=', 'i', 'd', 'l', 'e'}
+	address := []byte{192, 168, 1, 20}
+
+	type record struct {
+		rtype uint16
+		data  []byte
+	}
+	tests := []struct {
+		name            string
+		question        dnsQuestion
+		wantAnswers     []record
+		wantAdditionals []record
+	}{
+		{"the services", dnsQuestion{dnsServicesName, dnsTypePTR},
+			[]record{{dnsTypePTR, wireName("_sunlightmeter", "_tcp", "local")}, {dnsTypePTR, wireName("_http", "_tcp", "local")}}, nil},
+		{"browsing, in another case", dnsQuestion{"_SunlightMeter._tcp.local.", dnsTypePTR},
+			[]record{{dnsTypePTR, instance}}, []record{{dnsTypeSRV, srv}, {dnsTypeTXT, txt}, {dnsTypeA, address}}},
+		{"resolving the instance", dnsQuestion{`Sunlight Meter on pi\.lan._sunlightmeter._tcp.local.`, dnsTypeSRV},
+			[]record{{dnsTypeSRV, srv}}, []record{{dnsTypeA, address}}},
+		{"everything about the instance", dnsQuestion{`Sunlight Meter on pi\.lan._sunlightmeter._tcp.local.`, dnsTypeANY},
+			[]record{{dnsTypeSRV, srv}, {dnsTypeTXT, txt}}, []record{{dnsTypeA, address}}},
+		{"the host", dnsQuestion{"pi.local.", dnsTypeA}, []record{{dnsTypeA, address}}, nil},
+		// The instance split at its dot is another name
+		{"a split instance", dnsQuestion{"Sunlight Meter on pi.lan._sunlightmeter._tcp.local.", dnsTypeSRV}, nil, nil},
+		{"a type the host hasn't got", dnsQuestion{"pi.local.", dnsTypeTXT}, nil, nil},
+		{"another host", dnsQuestion{"printer.local.", dnsTypeA}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answers, additionals := r.answer([]dnsQuestion{tt.question}, MDNS_TTL)
+			for _, got := range []struct {
+				section string
+				records []dnsRecord
+				want    []record
+			}{{"answers", answers, tt.wantAnswers}, {"additionals", additionals, tt.wantAdditionals}} {
+				if len(got.records) != len(got.want) {
+					t.Fatalf("got %d %s, want %d", len(got.records), got.section, len(got.want))
+				}
+				for i, record := range got.records {
+					if record.rtype != got.want[i].rtype || !bytes.Equal(record.data, got.want[i].data) || record.ttl != MDNS_TTL {
+						t.Errorf("%s[%d]: got type %d, %q, TTL %d, want type %d, %q", got.section, i, record.rtype, record.data, record.ttl, got.want[i].rtype, got.want[i].data)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMDNSReply(t *testing.T) {
+	r := newTestResponder("Sunlight Meter on pi")
+
+	// dig -p 5353 @224.0.0.251 pi.local. gets a plain DNS reply: its ID and question back, a short TTL, and no cache flush bit
+	legacy := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 30), Port: 54321}
+	packet, to := r.reply(wireQuery(0xbeef, dnsTypeA, "pi", "local"), legacy)
+	want := []byte{
+		0xbe, 0xef, 0x84, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x02, 'p', 'i', 0x05, 'l', 'o', 'c', 'a', 'l', 0x00, 0x00, 0x01, 0x00, 0x01,
+		0x02, 'p', 'i', 0x05, 'l', 'o', 'c', 'a', 'l', 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x04, 192, 168, 1, 20,
+	}
+	if !bytes.Equal(packet, want) {
+		t.Errorf("got\n% x\nwant\n% x", packet, want)
+	}
+	if to != legacy {
+		t.Errorf("got a reply to %v, want %v", to, legacy)
+	}
+
+	// The same question from port 5353 is answered to the group, without the question, and with the cache flush bit
+	packet, to = r.reply(wireQuery(0xbeef, dnsTypeA, "pi", "local"), &net.UDPAddr{IP: net.IPv4(192, 168, 1, 30), Port: MDNS_PORT})
+	want = []byte{
+		0x00, 0x00, 0x84, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x02, 'p', 'i', 0x05, 'l', 'o', 'c', 'a', 'l', 0x00, 0x00, 0x01, 0x80, 0x01, 0x00, 0x00, 0x00, 0x78, 0x00, 0x04, 192, 168, 1, 20,
+	}
+	if !bytes.Equal(packet, want) {
+		t.Errorf("got\n% x\nwant\n% x", packet, want)
+	}
+	if to != nil {
+		t.Errorf("got a reply to %v, want the group", to)
+	}
+
+	// A legacy browse gets the PTR as its answer, and the SRV, TXT and A as additionals
+	packet, _ = r.reply(wireQuery(1, dnsTypePTR, "_http", "_tcp", "local"), legacy)
+	if len(packet) < 12 || binary.BigEndian.Uint16(packet[6:]) != 1 || binary.BigEndian.Uint16(packet[10:]) != 3 {
+		t.Errorf("got\n% x\nwant 1 answer and 3 additionals", packet)
+	}
+
+	// Nothing is sent for questions about other names, or for packets that aren't queries
+	for name, query := range map[string][]byte{
+		"another host": wireQuery(1, dnsTypeA, "printer", "local"),
+		"garbage":      {0x01, 0x02, 0x03},
+	} {
+		if packet, _ := r.reply(query, legacy); packet != nil {
+			t.Errorf("%s: got\n% x\nwant no reply", name, packet)
+		}
+	}
+}
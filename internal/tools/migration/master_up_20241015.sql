@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "api_tokens" (
+    "id" INTEGER PRIMARY KEY,
+    "name" varchar(255) NOT NULL DEFAULT '',
+    "token_hash" varchar(64) NOT NULL UNIQUE,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    "revoked_at" timestamp
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS "settings" (
+    "key" varchar(255) PRIMARY KEY,
+    "value" text NOT NULL,
+    "updated_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "interrupt_events" (
+    "id" INTEGER PRIMARY KEY,
+    "job_id" varchar(255) NOT NULL,
+    "ch0" INTEGER NOT NULL,
+    "ch1" INTEGER NOT NULL,
+    "lux" REAL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
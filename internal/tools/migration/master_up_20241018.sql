@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS "annotations" (
+    "id" INTEGER PRIMARY KEY,
+    "note" text NOT NULL,
+    "temperature" REAL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE "sunlight" ADD COLUMN "cpu_temp" REAL;
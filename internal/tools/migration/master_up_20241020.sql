@@ -0,0 +1 @@
+ALTER TABLE "sunlight" ADD COLUMN "device_id" varchar(255);
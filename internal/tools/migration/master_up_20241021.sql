@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "forward_queue" (
+    "id" INTEGER PRIMARY KEY,
+    "job_id" varchar(255) NOT NULL,
+    "lux" REAL NOT NULL,
+    "full_spectrum" REAL NOT NULL,
+    "visible" REAL NOT NULL,
+    "infrared" REAL NOT NULL,
+    "cpu_temp" REAL,
+    "created_at" timestamp NOT NULL
+);
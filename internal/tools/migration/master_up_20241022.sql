@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS "daily_summary" (
+    "date" varchar(10) NOT NULL,
+    "device_id" varchar(255) NOT NULL,
+    "samples" INTEGER NOT NULL,
+    "avg_lux" REAL NOT NULL,
+    "min_lux" REAL NOT NULL,
+    "max_lux" REAL NOT NULL,
+    "sun_hours" REAL NOT NULL,
+    "dli" REAL NOT NULL,
+    "first_light" timestamp,
+    "last_light" timestamp,
+    "updated_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("date", "device_id")
+);
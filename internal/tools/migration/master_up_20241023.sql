@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "jobs" (
+    "job_id" varchar(255) PRIMARY KEY,
+    "name" varchar(255),
+    "location" varchar(255),
+    "tags" TEXT,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "jobs_name" ON "jobs" ("name");
@@ -0,0 +1 @@
+ALTER TABLE "sunlight" ADD COLUMN "sensor_id" varchar(255);
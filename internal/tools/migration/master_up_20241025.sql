@@ -0,0 +1 @@
+ALTER TABLE "annotations" ADD COLUMN "job_id" varchar(255);
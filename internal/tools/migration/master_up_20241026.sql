@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "read_failures" (
+    "id" INTEGER PRIMARY KEY,
+    "device_id" varchar(255),
+    "sensor_id" varchar(255),
+    "job_id" varchar(255) NOT NULL,
+    "error" text NOT NULL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "read_failures_created_at" ON "read_failures" ("created_at");
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "events" (
+    "id" INTEGER PRIMARY KEY,
+    "device_id" varchar(255),
+    "sensor_id" varchar(255),
+    "job_id" varchar(255),
+    "type" varchar(255) NOT NULL,
+    "detail" text,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "events_created_at" ON "events" ("created_at");
@@ -0,0 +1 @@
+ALTER TABLE "sunlight" ADD COLUMN "saturated" INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS "plants" (
+    "id" INTEGER PRIMARY KEY,
+    "name" varchar(255) NOT NULL UNIQUE COLLATE NOCASE,
+    "min_sun_hours" REAL NOT NULL DEFAULT 0,
+    "min_dli" REAL NOT NULL DEFAULT 0,
+    "classification" varchar(255) NOT NULL DEFAULT '',
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
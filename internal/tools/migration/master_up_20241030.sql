@@ -0,0 +1 @@
+ALTER TABLE "sunlight" ADD COLUMN "light_source" varchar(255);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "weather" (
+    "observed_at" timestamp PRIMARY KEY,
+    "provider" varchar(255) NOT NULL,
+    "cloud_cover" REAL,
+    "precipitation" REAL,
+    "created_at" timestamp DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE "daily_summary" ADD COLUMN "cloud_cover" REAL;
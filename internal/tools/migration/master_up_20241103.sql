@@ -0,0 +1 @@
+ALTER TABLE "forward_queue" ADD COLUMN "sensor_id" varchar(255);
@@ -0,0 +1 @@
+ALTER TABLE "forward_queue" ADD COLUMN "light_source" varchar(255);
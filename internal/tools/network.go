@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Decides which callers are considered part of the local network.
+// Requests from a trusted proxy are checked against the X-Forwarded-For client instead of the proxy.
+type NetworkFilter struct {
+	TrustedProxies  []*net.IPNet
+	AllowedNetworks []*net.IPNet
+}
+
+func NewNetworkFilter(trustedProxies []string, allowedNetworks []string) (*NetworkFilter, error) {
+	proxies, err := ParseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := ParseCIDRs(allowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkFilter{TrustedProxies: proxies, AllowedNetworks: allowed}, nil
+}
+
+// Only allow requests from loopback, link-local, private, or explicitly allowed addresses
+func (f *NetworkFilter) CheckInNetwork(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.IsAllowed(f.ClientIP(r)) {
+			WriteError(w, r, ERR_FORBIDDEN, "Access is restricted to the local network", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *NetworkFilter) IsAllowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() {
+		return true
+	}
+	return containsIP(f.AllowedNetworks, ip)
+}
+
+// The address of the caller. When the peer is a trusted proxy, this is the
+// right-most X-Forwarded-For entry that isn't also a trusted proxy.
+func (f *NetworkFilter) ClientIP(r *http.Request) net.IP {
+	peer := parseHostIP(r.RemoteAddr)
+	if peer == nil || !containsIP(f.TrustedProxies, peer) {
+		return peer
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	client := peer
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		client = net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if client == nil || !containsIP(f.TrustedProxies, client) {
+			return client
+		}
+	}
+	return client
+}
+
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range list {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHostIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNetworkFilterForwardedFor(t *testing.T) {
+	filter, err := NewNetworkFilter([]string{"10.0.0.1/32", "10.0.0.2/32"}, []string{"198.51.100.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filter.CheckInNetwork(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		wantIP    string
+		wantCode  int
+	}{
+		// Untrusted peers are judged by their own address, whatever they claim to forward for
+		{"public peer", "203.0.113.9:51000", nil, "203.0.113.9", http.StatusForbidden},
+		{"public peer spoofing a LAN client", "203.0.113.9:51000", []string{"192.168.1.20"}, "203.0.113.9", http.StatusForbidden},
+		{"public peer spoofing loopback", "203.0.113.9:51000", []string{"127.0.0.1"}, "203.0.113.9", http.StatusForbidden},
+		{"LAN peer forwarding for a public client", "192.168.1.20:51000", []string{"203.0.113.9"}, "192.168.1.20", http.StatusNoContent},
+		{"LAN peer", "192.168.1.20:51000", nil, "192.168.1.20", http.StatusNoContent},
+		{"explicitly allowed peer", "198.51.100.7:51000", nil, "198.51.100.7", http.StatusNoContent},
+
+		// Trusted proxies are judged by the client they forward for
+		{"proxy for a LAN client", "10.0.0.1:443", []string{"192.168.1.20"}, "192.168.1.20", http.StatusNoContent},
+		{"proxy for a public client", "10.0.0.1:443", []string{"203.0.113.9"}, "203.0.113.9", http.StatusForbidden},
+		{"proxy for a client spoofing a LAN entry", "10.0.0.1:443", []string{"192.168.1.20, 203.0.113.9"}, "203.0.113.9", http.StatusForbidden},
+		{"proxy for a client spoofing a LAN header", "10.0.0.1:443", []string{"192.168.1.20", "203.0.113.9"}, "203.0.113.9", http.StatusForbidden},
+		{"chained proxies", "10.0.0.1:443", []string{"203.0.113.9, 10.0.0.2"}, "203.0.113.9", http.StatusForbidden},
+		{"chained proxies for a LAN client", "10.0.0.1:443", []string{"192.168.1.20,10.0.0.2"}, "192.168.1.20", http.StatusNoContent},
+		{"proxy with a garbled entry", "10.0.0.1:443", []string{"192.168.1.20, not-an-ip"}, "", http.StatusForbidden},
+		{"proxy without a header", "10.0.0.1:443", nil, "10.0.0.1", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for _, header := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+
+			got := filter.ClientIP(req)
+			if tt.wantIP == "" {
+				if got != nil {
+					t.Errorf("got client %s, want none", got)
+				}
+			} else if !got.Equal(net.ParseIP(tt.wantIP)) {
+				t.Errorf("got client %s, want %s", got, tt.wantIP)
+			}
+
+			w := httptest.NewRecorder()
+			allowed.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("got %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNewNetworkFilterInvalidCIDR(t *testing.T) {
+	if _, err := NewNetworkFilter([]string{"10.0.0.1"}, nil); err == nil {
+		t.Error("expected an error for a trusted proxy without a prefix length")
+	}
+	if _, err := NewNetworkFilter(nil, []string{"not-a-network"}); err == nil {
+		t.Error("expected an error for an invalid allowed network")
+	}
+}
@@ -0,0 +1,103 @@
+package tools
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const RATE_LIMIT_CLEANUP_INTERVAL = 5 * time.Minute
+
+// A per-client token bucket rate limiter
+type RateLimiter struct {
+	Rate     float64 // Tokens added per second
+	Burst    int     // Maximum tokens a client can hold
+	ClientIP func(r *http.Request) net.IP
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// A rate of 0 disables limiting
+func NewRateLimiter(perMinute float64, burst int, clientIP func(r *http.Request) net.IP) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := &RateLimiter{
+		Rate:     perMinute / 60,
+		Burst:    burst,
+		ClientIP: clientIP,
+		buckets:  make(map[string]*bucket),
+	}
+	if limiter.Rate > 0 {
+		go limiter.cleanup()
+	}
+	return limiter
+}
+
+// Reject requests from clients that have used up their tokens
+func (l *RateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.RemoteAddr
+		if l.ClientIP != nil {
+			if ip := l.ClientIP(r); ip != nil {
+				key = ip.String()
+			}
+		}
+		if ok, retryAfter := l.allow(key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			WriteError(w, r, ERR_RATE_LIMITED, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Take a token for the client, or report how long until one is available
+func (l *RateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Forget clients whose bucket would have refilled completely
+func (l *RateLimiter) cleanup() {
+	ticker := time.NewTicker(RATE_LIMIT_CLEANUP_INTERVAL)
+	for range ticker.C {
+		refill := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if time.Since(b.last) > refill {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,87 @@
+package tools
+
+import (
+	"net"
+	"net/http"
+	"time"
+	"unicode"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// Each request is tagged with an ID, the client's own when it sends one, and it's returned on the response.
+// It's on the access log line and on anything the request's handler logs, so a request can be followed through the log.
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// Longer IDs from a client are replaced with our own
+const maxRequestIDLength = 128
+
+// Logs JSON lines, its output is set alongside the standard logger's
+var requestLog = newRequestLogger()
+
+func newRequestLogger() *logrus.Logger {
+	l := logrus.New()
+	l.Formatter = &logrus.JSONFormatter{}
+	return l
+}
+
+// Tag the request with an ID, keeping the client's X-Request-ID if it sent a sensible one
+func RequestID(next http.Handler) http.Handler {
+	tagged := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(REQUEST_ID_HEADER, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(REQUEST_ID_HEADER); id != "" && !validRequestID(id) {
+			r.Header.Del(REQUEST_ID_HEADER)
+		}
+		tagged.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c > unicode.MaxASCII || !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// The logger for a request's handler, its lines carry the request's ID
+func RequestLog(r *http.Request) *logrus.Entry {
+	return requestLog.WithField("request_id", middleware.GetReqID(r.Context()))
+}
+
+// Log a line for each request once it's served, with its status, size and duration.
+// The remote IP is the client's, from clientIP, rather than a trusted proxy's.
+func AccessLog(clientIP func(r *http.Request) net.IP) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			start := time.Now()
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				fields := logrus.Fields{
+					"method":      r.Method,
+					"path":        r.URL.RequestURI(),
+					"status":      status,
+					"bytes":       ww.BytesWritten(),
+					"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+				}
+				if ip := clientIP(r); ip != nil {
+					fields["remote_ip"] = ip.String()
+				}
+				RequestLog(r).WithFields(fields).Info("request")
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
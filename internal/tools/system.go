@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const THERMAL_ZONE_TEMP = "/sys/class/thermal/thermal_zone0/temp"
+
+var processStart = time.Now()
+
+// Health of the device running the meter.
+// Anything that can't be read on this platform is left out.
+type SystemInfo struct {
+	CPUTemperature       *float64  `json:"cpu_temperature_c,omitempty"`
+	SystemUptimeSeconds  *float64  `json:"system_uptime_seconds,omitempty"`
+	ProcessUptimeSeconds float64   `json:"process_uptime_seconds"`
+	LoadAverage          []float64 `json:"load_average,omitempty"`
+	MemoryTotalBytes     *uint64   `json:"memory_total_bytes,omitempty"`
+	MemoryAvailableBytes *uint64   `json:"memory_available_bytes,omitempty"`
+	DiskTotalBytes       *uint64   `json:"disk_total_bytes,omitempty"`
+	DiskFreeBytes        *uint64   `json:"disk_free_bytes,omitempty"`
+	DBSizeBytes          *int64    `json:"db_size_bytes,omitempty"`
+}
+
+// Collect the system info, disk usage is for the filesystem holding dbPath
+func ReadSystemInfo(dbPath string) SystemInfo {
+	info := SystemInfo{
+		ProcessUptimeSeconds: time.Since(processStart).Seconds(),
+	}
+	if temp, err := ReadCPUTemperature(); err == nil {
+		info.CPUTemperature = &temp
+	}
+	if uptime, err := readProcFloats("/proc/uptime"); err == nil && len(uptime) > 0 {
+		info.SystemUptimeSeconds = &uptime[0]
+	}
+	if load, err := readProcFloats("/proc/loadavg"); err == nil && len(load) >= 3 {
+		info.LoadAverage = load[:3]
+	}
+	if total, available, err := readMemInfo(); err == nil {
+		info.MemoryTotalBytes = &total
+		info.MemoryAvailableBytes = &available
+	}
+	if usage, err := ReadDiskUsage(dbPath); err == nil {
+		info.DiskTotalBytes = &usage.Total
+		info.DiskFreeBytes = &usage.Free
+	}
+	if stat, err := os.Stat(dbPath); err == nil {
+		size := stat.Size()
+		info.DBSizeBytes = &size
+	}
+	return info
+}
+
+// The CPU temperature in °C, the kernel reports it in millidegrees
+func ReadCPUTemperature() (float64, error) {
+	data, err := os.ReadFile(THERMAL_ZONE_TEMP)
+	if err != nil {
+		return 0, err
+	}
+	milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return milli / 1000, nil
+}
+
+// Parse the leading numeric fields of a /proc file, like /proc/uptime or /proc/loadavg
+func readProcFloats(path string) ([]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values []float64
+	for _, field := range strings.Fields(string(data)) {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			break
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Total and available memory in bytes, /proc/meminfo reports kB
+func readMemInfo() (uint64, uint64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, 0, os.ErrNotExist
+	}
+	return total, values["MemAvailable"], nil
+}
@@ -0,0 +1,91 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WEATHER_PROVIDER_OPEN_METEO = "open-meteo"
+	OPEN_METEO_URL              = "https://api.open-meteo.com/v1/forecast"
+	WEATHER_TIMEOUT             = 10 * time.Second
+)
+
+// The conditions a weather provider reported for an interval
+type WeatherObservation struct {
+	ObservedAt    time.Time
+	CloudCover    float64       // In percent
+	Precipitation float64       // In mm, over the interval
+	Interval      time.Duration // How often the provider updates, 0 when it doesn't say
+}
+
+// A source of current weather conditions at a place
+type WeatherProvider interface {
+	Name() string
+	Current(latitude float64, longitude float64) (WeatherObservation, error)
+}
+
+// The weather provider with the name, only open-meteo for now
+func NewWeatherProvider(name string) (WeatherProvider, error) {
+	switch strings.ToLower(name) {
+	case WEATHER_PROVIDER_OPEN_METEO:
+		return &OpenMeteo{URL: OPEN_METEO_URL, Client: &http.Client{Timeout: WEATHER_TIMEOUT}}, nil
+	}
+	return nil, fmt.Errorf("unknown weather provider %q, expected %s", name, WEATHER_PROVIDER_OPEN_METEO)
+}
+
+// Open-Meteo's forecast API, which needs no key. Its current conditions update every 15 minutes.
+type OpenMeteo struct {
+	URL    string
+	Client *http.Client
+}
+
+func (o *OpenMeteo) Name() string {
+	return WEATHER_PROVIDER_OPEN_METEO
+}
+
+func (o *OpenMeteo) Current(latitude float64, longitude float64) (WeatherObservation, error) {
+	query := url.Values{}
+	query.Set("latitude", strconv.FormatFloat(latitude, 'f', -1, 64))
+	query.Set("longitude", strconv.FormatFloat(longitude, 'f', -1, 64))
+	query.Set("current", "cloud_cover,precipitation")
+	query.Set("timezone", "GMT")
+	resp, err := o.Client.Get(o.URL + "?" + query.Encode())
+	if err != nil {
+		return WeatherObservation{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return WeatherObservation{}, fmt.Errorf("open-meteo returned %s", resp.Status)
+	}
+
+	var body struct {
+		Current struct {
+			Time          string   `json:"time"`
+			Interval      int      `json:"interval"`
+			CloudCover    *float64 `json:"cloud_cover"`
+			Precipitation *float64 `json:"precipitation"`
+		} `json:"current"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return WeatherObservation{}, err
+	}
+	if body.Current.CloudCover == nil || body.Current.Precipitation == nil {
+		return WeatherObservation{}, fmt.Errorf("open-meteo returned no current conditions")
+	}
+	observedAt, err := time.Parse("2006-01-02T15:04", body.Current.Time)
+	if err != nil {
+		return WeatherObservation{}, fmt.Errorf("open-meteo returned an invalid time %q", body.Current.Time)
+	}
+	return WeatherObservation{
+		ObservedAt:    observedAt,
+		CloudCover:    *body.Current.CloudCover,
+		Precipitation: *body.Current.Precipitation,
+		Interval:      time.Duration(body.Current.Interval) * time.Second,
+	}, nil
+}
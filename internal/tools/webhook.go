@@ -0,0 +1,134 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	EVENT_JOB_STARTED = "job_started"
+	EVENT_JOB_STOPPED = "job_stopped"
+	EVENT_JOB_ERROR   = "job_error"
+	EVENT_JOB_STALLED = "job_stalled"
+	EVENT_LUX_ABOVE   = "lux_above"
+	EVENT_LUX_BELOW   = "lux_below"
+)
+
+const (
+	WEBHOOK_QUEUE_SIZE       = 64
+	WEBHOOK_ATTEMPTS         = 4
+	WEBHOOK_BACKOFF          = 2 * time.Second
+	WEBHOOK_TIMEOUT          = 10 * time.Second
+	WEBHOOK_SIGNATURE_HEADER = "X-SLM-Signature"
+)
+
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	JobID     string    `json:"jobID,omitempty"`
+	Lux       *float64  `json:"lux,omitempty"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Posts events to webhook URLs, like an ntfy topic, in the background.
+// Bodies are signed with HMAC-SHA256 when a secret is set, so receivers can verify them.
+type WebhookNotifier struct {
+	URLs   []string
+	Events map[string]bool // Only these events are sent, every event when empty
+	Secret []byte
+	Client *http.Client
+
+	queue chan WebhookEvent
+}
+
+func NewWebhookNotifier(urls []string, events []string, secret string) *WebhookNotifier {
+	n := &WebhookNotifier{
+		URLs:   urls,
+		Events: map[string]bool{},
+		Secret: []byte(secret),
+		Client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		queue:  make(chan WebhookEvent, WEBHOOK_QUEUE_SIZE),
+	}
+	for _, event := range events {
+		n.Events[event] = true
+	}
+	go n.deliverQueued()
+	return n
+}
+
+// Queue the event for delivery, this never blocks.
+// A nil notifier, or one without URLs, drops everything.
+func (n *WebhookNotifier) Notify(event string, jobID string, lux *float64, message string) {
+	if n == nil || len(n.URLs) == 0 || (len(n.Events) > 0 && !n.Events[event]) {
+		return
+	}
+	select {
+	case n.queue <- WebhookEvent{Event: event, JobID: jobID, Lux: lux, Message: message, Timestamp: time.Now().UTC()}:
+	default:
+		log.Printf("Webhook queue is full, dropping %s event", event)
+	}
+}
+
+func (n *WebhookNotifier) deliverQueued() {
+	for event := range n.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to encode %s webhook: %v", event.Event, err)
+			continue
+		}
+		for _, url := range n.URLs {
+			if err := n.deliver(url, body); err != nil {
+				log.Printf("Failed to deliver %s webhook to %s: %v", event.Event, url, err)
+			}
+		}
+	}
+}
+
+// Post the body, retrying with exponential backoff
+func (n *WebhookNotifier) deliver(url string, body []byte) error {
+	var err error
+	backoff := WEBHOOK_BACKOFF
+	for attempt := 1; attempt <= WEBHOOK_ATTEMPTS; attempt++ {
+		if err = n.post(url, body); err == nil {
+			return nil
+		} else if attempt < WEBHOOK_ATTEMPTS {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("%d attempts: %w", WEBHOOK_ATTEMPTS, err)
+}
+
+func (n *WebhookNotifier) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.Secret) > 0 {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, "sha256="+SignWebhook(n.Secret, body))
+	}
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// The hex encoded HMAC-SHA256 of the body
+func SignWebhook(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
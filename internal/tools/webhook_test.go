@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type receivedWebhook struct {
+	event     WebhookEvent
+	signature string
+	valid     bool
+}
+
+// A receiver that checks each body against its signature, as the docs tell receivers to
+func newWebhookReceiver(t *testing.T, secret string) (*httptest.Server, chan receivedWebhook) {
+	t.Helper()
+	received := make(chan receivedWebhook, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got a %s of %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		var webhook receivedWebhook
+		if err := json.Unmarshal(body, &webhook.event); err != nil {
+			t.Error(err)
+		}
+		webhook.signature = r.Header.Get(WEBHOOK_SIGNATURE_HEADER)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		webhook.valid = hmac.Equal([]byte(webhook.signature), []byte("sha256="+hex.EncodeToString(mac.Sum(nil))))
+		received <- webhook
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func receiveWebhook(t *testing.T, received chan receivedWebhook) receivedWebhook {
+	t.Helper()
+	select {
+	case webhook := <-received:
+		return webhook
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook was delivered")
+		return receivedWebhook{}
+	}
+}
+
+func TestWebhookNotifierSigns(t *testing.T) {
+	srv, received := newWebhookReceiver(t, "s3cret")
+	n := NewWebhookNotifier([]string{srv.URL}, []string{EVENT_LUX_ABOVE, EVENT_JOB_ERROR}, "s3cret")
+
+	// Events that weren't asked for aren't sent, the rest arrive in order
+	lux := 12000.0
+	n.Notify(EVENT_JOB_STARTED, "job-1", nil, "Sunlight Reading Started")
+	n.Notify(EVENT_LUX_ABOVE, "job-1", &lux, "Lux is above 10000: 12000")
+	n.Notify(EVENT_JOB_ERROR, "job-1", nil, "The sensor failed to enable")
+
+	webhook := receiveWebhook(t, received)
+	if !webhook.valid {
+		t.Errorf("the signature %q doesn't match the body", webhook.signature)
+	}
+	if webhook.event.Event != EVENT_LUX_ABOVE || webhook.event.JobID != "job-1" || webhook.event.Lux == nil || *webhook.event.Lux != lux || webhook.event.Timestamp.IsZero() {
+		t.Errorf("got %+v", webhook.event)
+	}
+	if webhook := receiveWebhook(t, received); webhook.event.Event != EVENT_JOB_ERROR || webhook.event.Lux != nil || !webhook.valid {
+		t.Errorf("got %+v, signed %t", webhook.event, webhook.valid)
+	}
+
+	// Without a secret the body isn't signed
+	srv, received = newWebhookReceiver(t, "")
+	NewWebhookNotifier([]string{srv.URL}, nil, "").Notify(EVENT_JOB_STARTED, "job-2", nil, "Sunlight Reading Started")
+	if webhook := receiveWebhook(t, received); webhook.signature != "" || webhook.event.Event != EVENT_JOB_STARTED {
+		t.Errorf("got %+v, signature %q", webhook.event, webhook.signature)
+	}
+}
@@ -0,0 +1,148 @@
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const PROC_NET_WIRELESS = "/proc/net/wireless"
+
+const (
+	LINK_WIFI     = "wifi"
+	LINK_ETHERNET = "ethernet"
+	LINK_NONE     = "none"
+)
+
+// The state of the device's network link
+type LinkInfo struct {
+	Interface      string `json:"interface"`
+	Connected      bool   `json:"connected"`
+	SSID           string `json:"ssid,omitempty"`
+	SignalDBM      *int   `json:"signal_dbm,omitempty"`
+	QualityPercent *int   `json:"quality_percent,omitempty"`
+	LinkType       string `json:"link_type"`
+}
+
+// Report on the named interface, or pick one when the name is empty.
+// Wireless interfaces are preferred, then any other interface that is up.
+func ReadLinkInfo(name string) (LinkInfo, error) {
+	wireless, err := readProcNetWireless(PROC_NET_WIRELESS)
+	if err != nil && !os.IsNotExist(err) {
+		return LinkInfo{}, err
+	}
+
+	if name == "" {
+		name = pickInterface(wireless)
+		if name == "" {
+			return LinkInfo{LinkType: LINK_NONE}, nil
+		}
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return LinkInfo{}, fmt.Errorf("unknown network interface %q: %w", name, err)
+	}
+
+	info := LinkInfo{Interface: name}
+	status, isWireless := wireless[name]
+	if !isWireless && !isWirelessInterface(name) {
+		info.LinkType = LINK_ETHERNET
+		info.Connected = iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0
+		return info, nil
+	}
+
+	info.LinkType = LINK_WIFI
+	info.Connected = isWireless && status.link > 0 && iface.Flags&net.FlagRunning != 0
+	if !info.Connected {
+		return info, nil
+	}
+	signal := status.level
+	quality := signalQuality(signal)
+	info.SignalDBM = &signal
+	info.QualityPercent = &quality
+	info.SSID, _ = readSSID(iface.Index)
+	return info, nil
+}
+
+// Convert the signal to a strength value, then scale it to a percentage
+// https://git.openwrt.org/?p=project/iwinfo.git;a=blob;f=iwinfo_nl80211.c;hb=HEAD#l2885
+func signalQuality(signal int) int {
+	if signal < -110 {
+		signal = -110
+	} else if signal > -40 {
+		signal = -40
+	}
+	return (signal + 110) * 100 / 70
+}
+
+type wirelessStatus struct {
+	link  int
+	level int
+}
+
+// Parse the link quality and signal level for each interface.
+// Lines look like: " wlan0: 0000   70.  -40.  -256        0      0      0      0      0        0"
+func readProcNetWireless(path string) (map[string]wirelessStatus, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	statuses := map[string]wirelessStatus{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, values, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(values)
+		if len(fields) < 3 {
+			continue
+		}
+		link, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "."), 64)
+		if err != nil {
+			continue
+		}
+		level, err := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		if err != nil {
+			continue
+		}
+		statuses[strings.TrimSpace(name)] = wirelessStatus{link: int(link), level: int(level)}
+	}
+	return statuses, scanner.Err()
+}
+
+func isWirelessInterface(name string) bool {
+	_, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/wireless", name))
+	return err == nil
+}
+
+// Prefer a connected wireless interface, then any running interface, then any wireless interface
+func pickInterface(wireless map[string]wirelessStatus) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	running, idle := "", ""
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		status, ok := wireless[iface.Name]
+		if ok && status.link > 0 {
+			return iface.Name
+		} else if running == "" && iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0 {
+			running = iface.Name
+		} else if idle == "" && (ok || isWirelessInterface(iface.Name)) {
+			idle = iface.Name
+		}
+	}
+	if running != "" {
+		return running
+	}
+	return idle
+}
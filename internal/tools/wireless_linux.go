@@ -0,0 +1,120 @@
+//go:build linux
+
+package tools
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+// Generic netlink and nl80211 constants, from linux/genetlink.h and linux/nl80211.h
+const (
+	NETLINK_GENERIC           = 16
+	GENL_ID_CTRL              = 0x10
+	CTRL_CMD_GETFAMILY        = 3
+	CTRL_ATTR_FAMILY_ID       = 1
+	CTRL_ATTR_FAMILY_NAME     = 2
+	NL80211_CMD_GET_INTERFACE = 5
+	NL80211_ATTR_IFINDEX      = 3
+	NL80211_ATTR_SSID         = 52
+)
+
+// Ask nl80211 for the SSID the interface is associated with
+func readSSID(ifindex int) (string, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, NETLINK_GENERIC)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return "", err
+	}
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return "", err
+	}
+
+	attrs, err := genlRequest(fd, GENL_ID_CTRL, CTRL_CMD_GETFAMILY, netlinkAttr(CTRL_ATTR_FAMILY_NAME, []byte("nl80211\x00")))
+	if err != nil {
+		return "", err
+	}
+	family, ok := attrs[CTRL_ATTR_FAMILY_ID]
+	if !ok || len(family) < 2 {
+		return "", fmt.Errorf("nl80211 is not available")
+	}
+
+	index := make([]byte, 4)
+	binary.NativeEndian.PutUint32(index, uint32(ifindex))
+	attrs, err = genlRequest(fd, binary.NativeEndian.Uint16(family), NL80211_CMD_GET_INTERFACE, netlinkAttr(NL80211_ATTR_IFINDEX, index))
+	if err != nil {
+		return "", err
+	}
+	return string(attrs[NL80211_ATTR_SSID]), nil
+}
+
+// Send a generic netlink request, and return the attributes of the reply
+func genlRequest(fd int, family uint16, cmd uint8, attrs []byte) (map[uint16][]byte, error) {
+	msg := make([]byte, syscall.NLMSG_HDRLEN+4, syscall.NLMSG_HDRLEN+4+len(attrs))
+	msg = append(msg, attrs...)
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	binary.NativeEndian.PutUint16(msg[4:6], family)
+	binary.NativeEndian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(msg[8:12], 1)
+	msg[syscall.NLMSG_HDRLEN] = cmd
+	msg[syscall.NLMSG_HDRLEN+1] = 1
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	for _, reply := range replies {
+		if reply.Header.Type == syscall.NLMSG_ERROR {
+			if len(reply.Data) >= 4 {
+				if errno := int32(binary.NativeEndian.Uint32(reply.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+			}
+			continue
+		} else if len(reply.Data) < 4 {
+			continue
+		}
+		return parseNetlinkAttrs(reply.Data[4:]), nil
+	}
+	return nil, fmt.Errorf("no reply from netlink")
+}
+
+func netlinkAttr(attrType uint16, data []byte) []byte {
+	length := syscall.SizeofRtAttr + len(data)
+	attr := make([]byte, (length+syscall.NLA_ALIGNTO-1) & ^(syscall.NLA_ALIGNTO-1))
+	binary.NativeEndian.PutUint16(attr[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[4:], data)
+	return attr
+}
+
+func parseNetlinkAttrs(data []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(data) >= syscall.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(data[0:2]))
+		attrType := binary.NativeEndian.Uint16(data[2:4])
+		if length < syscall.SizeofRtAttr || length > len(data) {
+			break
+		}
+		attrs[attrType&0x3fff] = data[4:length]
+		aligned := (length + syscall.NLA_ALIGNTO - 1) & ^(syscall.NLA_ALIGNTO - 1)
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+	return attrs
+}
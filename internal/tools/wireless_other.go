@@ -0,0 +1,10 @@
+//go:build !linux
+
+package tools
+
+import "errors"
+
+// nl80211 is linux only
+func readSSID(ifindex int) (string, error) {
+	return "", errors.New("reading the SSID is not supported on this platform")
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,110 +24,539 @@ import (
 	"github.com/ztkent/sunlight-meter/tsl2591"
 )
 
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
+// Static files are reused for an hour, then revalidated with their ETag.
+// Links carrying the current version never change, so those are kept for a year.
+const (
+	STATIC_CACHE_CONTROL           = "private, max-age=3600"
+	STATIC_VERSIONED_CACHE_CONTROL = "private, max-age=31536000, immutable"
+)
+
+var embeddedETags sync.Map
+
+// Set at build time with: -ldflags "-X main.Version=v1.2.0 -X main.Commit=$(git rev-parse --short HEAD)"
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
 /*
 	This is going to be the primary entry point for the Sunlight Meter application.
 	It should be running at startup, on a Raspberry Pi, with the TSL2591 sensor connected.
 */
 
 func main() {
+	// The client subcommand drives a running meter's API, rather than being one
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(runClient(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	pid := os.Getpid()
 	log.Println("SunlightMeter [" + fmt.Sprintf("%d", pid) + "]")
+	cfg := loadConfig()
+	cfg.log()
 
 	// Connect to the lux sensor
-	device, err := tsl2591.NewTSL2591(
-		tsl2591.TSL2591_GAIN_LOW,
-		tsl2591.TSL2591_INTEGRATIONTIME_300MS,
-		"/dev/i2c-1",
-	)
+	i2cAddr, err := parseI2CAddr(cfg.I2CAddr)
 	if err != nil {
-		log.Printf("Failed to connect to the TSL2591 sensor: %v", err)
+		log.Fatalf("Invalid -i2c-addr: %v", err)
 	}
+	if cfg.Saturation <= 0 || cfg.Saturation > 1 {
+		log.Fatalf("-saturation must be above 0, and at most 1")
+	}
+	device := connectSensor(cfg.I2CDev, i2cAddr, cfg.ReadRetries, cfg.Saturation, cfg.Simulate)
 
 	// Connect to the sqlite database
-	slmDB, err := tools.ConnectSqlite(slm.DB_PATH)
+	slmDB, err := tools.ConnectSqlite(cfg.DBPath, tools.SqliteOptions{
+		JournalMode: cfg.DBJournalMode,
+		Synchronous: cfg.DBSynchronous,
+		BusyTimeout: cfg.DBBusyTimeout,
+	})
 	if err != nil {
 		log.Fatalf("Failed to configure the sqlite database: %v", err)
 	}
 
+	// Restrict the dashboard to the local network, and any extra allowed networks
+	netFilter, err := tools.NewNetworkFilter(splitList(cfg.TrustedProxies), splitList(cfg.AllowedCIDRs))
+	if err != nil {
+		log.Fatalf("Failed to configure the network filter: %v", err)
+	}
+
+	// Let browser apps on the allowed origins call the API
+	cors, err := tools.NewCORS(splitList(cfg.CORSOrigins))
+	if err != nil {
+		log.Fatalf("Failed to configure CORS: %v", err)
+	}
+
+	// Optional basic auth for the dashboard
+	basicAuth := tools.NewBasicAuth(cfg.AuthUser, cfg.AuthPasswordHash, netFilter.ClientIP)
+
+	// Limit how often each client can start/stop/reset the sensor
+	controlLimiter := tools.NewRateLimiter(cfg.ControlRatePerMin, cfg.ControlBurst, netFilter.ClientIP)
+
+	// Parse the dashboard templates once, rather than on every request
+	if err := slm.LoadTemplates(cfg.TemplateDir); err != nil {
+		log.Fatalf("Failed to load the dashboard templates: %v", err)
+	}
+
+	if cfg.PPFDPerLux <= 0 {
+		log.Fatalf("Invalid -ppfd-per-lux %g, expected more than 0", cfg.PPFDPerLux)
+	}
+	if cfg.WattsPerLux <= 0 {
+		log.Fatalf("Invalid -wm2-per-lux %g, expected more than 0", cfg.WattsPerLux)
+	}
+	slm.SetWattsPerLux(cfg.WattsPerLux)
+	if cfg.ResultsBuffer < 0 {
+		log.Fatalf("Invalid -results-buffer %d, expected 0 or more", cfg.ResultsBuffer)
+	}
+	if !slm.ValidDropPolicy(cfg.DropPolicy) {
+		log.Fatalf("Invalid -drop-policy %q, expected %s or %s", cfg.DropPolicy, slm.DROP_NEWEST, slm.DROP_OLDEST)
+	}
+
 	// Initialize router
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	// The request ID is on each access log line, the response's X-Request-ID, and anything a handler logs
+	r.Use(tools.RequestID)
+	r.Use(tools.AccessLog(netFilter.ClientIP))
 	r.Use(handleServerPanic)
-	defineRoutes(r, &slm.SLMeter{
+
+	// Compress the graph page and JSON for clients that accept it.
+	// The .db export isn't a compressible type, and the NDJSON export sets its own encoding, so both are sent as-is.
+	if cfg.GzipLevel < 0 || cfg.GzipLevel > 9 {
+		log.Fatalf("Invalid -gzip-level %d, expected 0 to 9", cfg.GzipLevel)
+	} else if cfg.GzipLevel > 0 {
+		r.Use(middleware.Compress(cfg.GzipLevel))
+	}
+	meter := &slm.SLMeter{
 		TSL2591:        device,
 		ResultsDB:      slmDB,
-		LuxResultsChan: make(chan slm.LuxResults),
+		DBPath:         cfg.DBPath,
+		LuxResultsChan: make(chan slm.LuxResults, cfg.ResultsBuffer),
 		Pid:            pid,
-	})
+		NetInterface:   cfg.NetInterface,
+		RecordTemp:     cfg.RecordTemp,
+		PPFDPerLux:     cfg.PPFDPerLux,
+		MinFreeDisk:    uint64(cfg.MinFreeDiskMB) << 20,
+		BatchSize:      cfg.BatchSize,
+		BatchInterval:  cfg.BatchInterval,
+		DropPolicy:     cfg.DropPolicy,
+		DeviceID:       cfg.DeviceID,
+		SensorID:       cfg.SensorID,
+		Version:        Version,
+		Commit:         buildCommit(),
+		ForwardURL:     cfg.ForwardURL,
+		ForwardToken:   cfg.ForwardToken,
+		EChartsCDN:     cfg.EChartsCDN,
+	}
+
+	// The graph's JavaScript is served locally, a build without it would draw a blank graph offline
+	if !meter.EChartsCDN && !slm.EChartsAssetsAvailable(slm.StaticFiles(cfg.StaticDir)) {
+		log.Fatalf("The echarts assets are missing from the static files, run go generate ./... before building, or start with -echarts-cdn to load them from the CDN")
+	}
 
-	// Start server
-	app_port := "80"
-	log.Printf("Starting HTTP server on port %s", app_port)
-	err = http.ListenAndServe("0.0.0.0:"+app_port, r)
+	// Post job events and lux threshold crossings to any configured webhooks
+	if cfg.WebhookURLs != "" {
+		if cfg.LuxBelow >= cfg.LuxAbove {
+			log.Fatalf("-lux-below must be less than -lux-above")
+		}
+		meter.Webhooks = tools.NewWebhookNotifier(splitList(cfg.WebhookURLs), splitList(cfg.WebhookEvents), cfg.WebhookSecret)
+		meter.LuxAbove = cfg.LuxAbove
+		meter.LuxBelow = cfg.LuxBelow
+	}
+	// Write readings to InfluxDB too, if it's configured
+	if cfg.InfluxURL != "" {
+		meter.Influx = tools.NewInfluxWriter(cfg.InfluxURL, cfg.InfluxOrg, cfg.InfluxBucket, cfg.InfluxToken, cfg.InfluxInterval, cfg.InfluxBuffer)
+	}
+
+	summaryLocation, err := time.LoadLocation(cfg.SummaryTimezone)
+	if err != nil {
+		log.Fatalf("Invalid -summary-timezone: %v", err)
+	}
+	meter.SummaryLocation = summaryLocation
+	if meter.Location, err = parseLocation(cfg.Latitude, cfg.Longitude); err != nil {
+		log.Fatalf("Invalid location: %v", err)
+	}
+	if cfg.WeatherProvider != "" {
+		if meter.Location == nil {
+			log.Fatalf("-weather-provider needs -latitude and -longitude")
+		}
+		if meter.Weather, err = tools.NewWeatherProvider(cfg.WeatherProvider); err != nil {
+			log.Fatalf("Invalid -weather-provider: %v", err)
+		}
+	}
+
+	// Switch a relay by the lux, if one is wired up
+	if cfg.RelayPin >= 0 {
+		from, to, err := parseRelayHours(cfg.RelayHours)
+		if err != nil {
+			log.Fatalf("Invalid -relay-hours: %v", err)
+		}
+		meter.Relay, err = slm.NewRelay(slm.RelayConfig{
+			Pin:       cfg.RelayPin,
+			ActiveLow: cfg.RelayActiveLow,
+			OnBelow:   cfg.RelayOnBelow,
+			OffAbove:  cfg.RelayOffAbove,
+			From:      from,
+			To:        to,
+		})
+		if err != nil {
+			log.Fatalf("Failed to open the relay: %v", err)
+		}
+	}
+
+	if err := meter.LoadDeviceID(); err != nil {
+		log.Fatalf("Failed to load the device ID: %v", err)
+	}
+	log.Printf("Device ID: %s", meter.DeviceID)
+	if err := meter.LoadPPFDSetting(); err != nil {
+		log.Fatalf("Failed to load the PPFD setting: %v", err)
+	}
+	if err := meter.LoadLightSourceBands(); err != nil {
+		log.Fatalf("Failed to load the light source bands: %v", err)
+	}
+	if err := meter.SeedPlants(); err != nil {
+		log.Fatalf("Failed to add the default plants: %v", err)
+	}
+	if err := meter.BackfillSensorID(); err != nil {
+		log.Fatalf("Failed to backfill the sensor ID: %v", err)
+	}
+
+	// Each extra sensor gets its own meter, recording through this one
+	for _, pair := range splitList(cfg.Sensors) {
+		sensorID, dev, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Fatalf("Invalid -sensors entry %q, expected id=bus", pair)
+		}
+		addr := tsl2591.TSL2591_ADDR
+		if bus, addrValue, ok := strings.Cut(dev, "@"); ok {
+			if addr, err = parseI2CAddr(addrValue); err != nil {
+				log.Fatalf("Invalid -sensors entry %q: %v", pair, err)
+			}
+			dev = bus
+		}
+		if _, err := meter.AddSensor(strings.TrimSpace(sensorID), connectSensor(strings.TrimSpace(dev), addr, cfg.ReadRetries, cfg.Saturation, cfg.Simulate)); err != nil {
+			log.Fatalf("Failed to add sensor %s: %v", sensorID, err)
+		}
+	}
+	defineRoutes(r, netFilter, basicAuth, controlLimiter, cors, meter, cfg.StaticDir)
+
+	// Pause recording before the db fills the disk
+	go meter.MonitorDiskSpace(cfg.DiskCheckInterval)
+
+	// Restart any job that stops recording
+	go meter.RunWatchdog(cfg.StaleAfter)
+
+	// Post readings to a central instance, if one is configured
+	go meter.RunForwarder(cfg.ForwardInterval)
+
+	// Summarize each day once it's over
+	go meter.RunSummarizer()
+
+	// Keep the weather alongside the readings, if a provider is configured
+	go meter.RunWeather(cfg.WeatherInterval)
+
+	// Pick up where we left off, if a job was logging when the process stopped
+	if cfg.AutoResume {
+		meter.ResumeAllLogging()
+	}
+
+	// Start server, listening first so the meter is only advertised once it can be reached
+	addr := cfg.ListenAddr + ":" + cfg.Port
+	server := &http.Server{Addr: addr, Handler: r}
+	serverErr := make(chan error, 1)
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
-	return
+	if cfg.TLS {
+		err = tools.EnsureSelfSignedCertificate(cfg.TLSCert, cfg.TLSKey, tools.CertOptions{
+			Hosts:       splitList(cfg.TLSHosts),
+			KeyType:     cfg.TLSKeyType,
+			Validity:    time.Duration(cfg.TLSValidityDays) * 24 * time.Hour,
+			RenewBefore: time.Duration(cfg.TLSRenewDays) * 24 * time.Hour,
+		})
+		if err != nil {
+			log.Fatalf("Failed to prepare the TLS certificate: %v", err)
+		}
+		log.Printf("Starting HTTPS server on %s", addr)
+		go func() { serverErr <- server.ServeTLS(listener, cfg.TLSCert, cfg.TLSKey) }()
+	} else {
+		log.Printf("Starting HTTP server on %s", addr)
+		go func() { serverErr <- server.Serve(listener) }()
+	}
+
+	// Advertise the meter on the LAN, a failure only means it has to be found by address
+	var mdns *tools.MDNSResponder
+	if cfg.MDNS {
+		port := listener.Addr().(*net.TCPAddr).Port
+		if mdns, err = meter.Advertise(port); err != nil {
+			log.Printf("Failed to advertise over mDNS: %v", err)
+		} else {
+			log.Printf("Advertising %s and %s over mDNS on port %d", tools.MDNS_SERVICE_SUNLIGHTMETER, tools.MDNS_SERVICE_HTTP, port)
+		}
+	}
+
+	// Run until the server fails, or we're asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-serverErr:
+		log.Fatalf("Failed to start HTTP server: %v", err)
+	case <-ctx.Done():
+	}
+
+	// Finish in-flight requests, then stop taking results and write every one already sent before closing the db.
+	// Running jobs are left as-is, so auto-resume can pick them back up.
+	log.Println("Shutting down...")
+	if mdns != nil {
+		if err := mdns.Close(); err != nil {
+			log.Printf("Failed to stop advertising over mDNS: %v", err)
+		}
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down the HTTP server: %v", err)
+	}
+	meter.Shutdown(SHUTDOWN_TIMEOUT)
+	if err := meter.Influx.Flush(); err != nil {
+		log.Printf("Failed to write buffered readings to InfluxDB: %v", err)
+	}
+	if meter.Relay != nil {
+		if err := meter.Relay.Close(); err != nil {
+			log.Printf("Failed to release the relay's GPIO pin: %v", err)
+		}
+	}
+	slmDB.Close()
 }
 
-func defineRoutes(r *chi.Mux, meter *slm.SLMeter) {
+func defineRoutes(r *chi.Mux, netFilter *tools.NetworkFilter, basicAuth *tools.BasicAuth, controlLimiter *tools.RateLimiter, cors *tools.CORS, meter *slm.SLMeter, staticDir string) {
 	// Listen for any result messages from our jobs, record them in sqlite
 	go meter.MonitorAndRecordResults()
 
-	// Sunlight Meter Dashboard Controls
-	r.Get("/", meter.ServeDashboard())
-	r.Route("/sunlightmeter", func(r chi.Router) {
-		r.Get("/start", meter.Start())
-		r.Get("/stop", meter.Stop())
-		r.Get("/signal-strength", meter.SignalStrength())
-		r.Get("/current-conditions", meter.CurrentConditions())
-		r.Get("/export", meter.ServeResultsDB())
-		r.Post("/graph", meter.ServeResultsGraph())
-		r.Get("/controls", meter.ServeSunlightControls())
-		r.Get("/status", meter.ServeSensorStatus())
-		r.Post("/results", meter.ServeResultsTab())
-		r.Get("/clear", meter.Clear())
+	// Record overflows, gain changes, and bus trouble alongside the readings
+	go meter.RecordEvents()
+
+	// A valid API token also works on the dashboard, and dashboard credentials work on the API
+	basicAuth.Alternative = meter.ValidAPIToken
+
+	// Sunlight Meter Dashboard Controls, only available from the local network
+	r.Group(func(r chi.Router) {
+		r.Use(netFilter.CheckInNetwork)
+		r.Use(basicAuth.RequireAuth)
+		r.Get("/", meter.ServeDashboard())
+		r.Route("/sunlightmeter", func(r chi.Router) {
+			r.With(controlLimiter.Limit).Post("/start", meter.ForSensor((*slm.SLMeter).Start))
+			r.With(controlLimiter.Limit).Post("/stop", meter.ForSensor((*slm.SLMeter).Stop))
+			r.With(controlLimiter.Limit).Post("/pause", meter.ForSensor((*slm.SLMeter).Pause))
+			r.With(controlLimiter.Limit).Post("/resume", meter.ForSensor((*slm.SLMeter).Resume))
+			r.With(controlLimiter.Limit).Get("/reset", meter.ForSensor((*slm.SLMeter).ResetSensor))
+			r.Get("/read", meter.ForSensor((*slm.SLMeter).ReadOnce))
+			r.Get("/selftest", meter.ForSensor((*slm.SLMeter).SelfTest))
+			r.With(controlLimiter.Limit).Post("/config", meter.ForSensor((*slm.SLMeter).SetSensorConfig))
+			r.Get("/signal-strength", meter.SignalStrength())
+			r.Get("/current-conditions", meter.ForSensor((*slm.SLMeter).CurrentConditions))
+			r.Get("/export", meter.ServeResultsDB())
+			r.Get("/backup", meter.ServeBackup())
+			r.Post("/import", meter.ImportResultsDB())
+			r.Post("/graph", meter.ServeResultsGraph())
+			r.Get("/graph.png", meter.ServeResultsGraphPNG())
+			r.Get("/graph.svg", meter.ServeResultsGraphSVG())
+			r.Get("/graph/data", meter.ServeGraphData())
+			r.Get("/controls", meter.ServeSunlightControls())
+			r.Get("/status", meter.ForSensor((*slm.SLMeter).ServeSensorStatus))
+			r.Post("/results", meter.ServeResultsTab())
+			r.Get("/devices", meter.ServeDeviceSelect())
+			r.Get("/jobs", meter.ServeJobSelect())
+			r.Get("/jobs/list", meter.ServeJobList())
+			r.With(controlLimiter.Limit).Delete("/jobs/{id}", meter.DeleteJob())
+			r.Get("/clear", meter.Clear())
+		})
 	})
 
 	// Sunlight Meter API, these serve a JSON response
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Get("/start", meter.Start())
-		r.Get("/stop", meter.Stop())
-		r.Get("/signal-strength", meter.SignalStrength())
-		r.Get("/current-conditions", meter.CurrentConditions())
-		r.Get("/export", meter.ServeResultsDB())
+		// Preflight requests are answered here, before they'd need a token
+		r.Use(cors.Handle)
+		r.Get("/openapi.json", meter.ServeOpenAPISpec())
+		r.Get("/docs", meter.ServeAPIDocs())
+
+		// Token management is only available from the local network, and with basic auth or a valid token when a password is set
+		r.Group(func(r chi.Router) {
+			r.Use(netFilter.CheckInNetwork)
+			r.Use(basicAuth.RequireAuth)
+			r.Post("/tokens", meter.CreateToken())
+			r.Delete("/tokens/{id}", meter.RevokeToken())
+		})
+
+		// Once a token exists, every other API route requires one, or the dashboard credentials
+		r.Group(func(r chi.Router) {
+			r.Use(meter.RequireAPIToken(basicAuth))
+			r.With(controlLimiter.Limit).Post("/start", meter.ForSensor((*slm.SLMeter).Start))
+			r.With(controlLimiter.Limit).Post("/stop", meter.ForSensor((*slm.SLMeter).Stop))
+			r.With(controlLimiter.Limit).Post("/pause", meter.ForSensor((*slm.SLMeter).Pause))
+			r.With(controlLimiter.Limit).Post("/resume", meter.ForSensor((*slm.SLMeter).Resume))
+			r.With(controlLimiter.Limit).Get("/reset", meter.ForSensor((*slm.SLMeter).ResetSensor))
+			r.Get("/read", meter.ForSensor((*slm.SLMeter).ReadOnce))
+			r.Get("/signal-strength", meter.SignalStrength())
+			r.Get("/current-conditions", meter.ForSensor((*slm.SLMeter).CurrentConditions))
+			r.Get("/export", meter.ServeResultsDB())
+			r.Get("/backup", meter.ServeBackup())
+			r.Get("/export.lp", meter.ServeLineProtocol())
+			r.Get("/export.ndjson", meter.ServeNDJSON())
+			r.Get("/readings", meter.ServeReadings())
+			r.Get("/raw", meter.ForSensor((*slm.SLMeter).RawChannels))
+			r.Get("/selftest", meter.ForSensor((*slm.SLMeter).SelfTest))
+			r.With(controlLimiter.Limit).Post("/config", meter.ForSensor((*slm.SLMeter).SetSensorConfig))
+			r.Get("/sensors", meter.ServeSensors())
+			r.With(controlLimiter.Limit).Post("/interrupts", meter.EnableInterrupts())
+			r.With(controlLimiter.Limit).Delete("/interrupts", meter.DisableInterrupts())
+			r.Get("/interrupts/events", meter.ServeInterruptEvents())
+			r.Post("/annotate", meter.Annotate())
+			r.Post("/annotations", meter.Annotate())
+			r.Get("/annotations", meter.ServeAnnotations())
+			r.Delete("/annotations/{id}", meter.DeleteAnnotation())
+			r.Get("/system", meter.ServeSystemInfo())
+			r.Get("/classify", meter.Classify())
+			r.Get("/stats", meter.ServeStats())
+			r.Get("/histogram", meter.ServeHistogram())
+			r.Get("/cloudiness", meter.ServeCloudiness())
+			r.Get("/sun", meter.ServeSun())
+			r.Get("/relay", meter.ServeRelay())
+			r.With(controlLimiter.Limit).Post("/relay/{mode}", meter.SetRelayMode())
+			r.Get("/report", meter.ServeReport())
+			r.Get("/days", meter.ServeDays())
+			r.Get("/gaps", meter.ServeGaps())
+			r.Get("/events", meter.ServeEvents())
+			r.Get("/jobs", meter.ServeJobs())
+			r.Patch("/jobs/{id}", meter.UpdateJob())
+			r.With(controlLimiter.Limit).Delete("/jobs/{id}", meter.DeleteJob())
+			r.Post("/ingest", meter.Ingest())
+			r.Get("/device", meter.ServeDeviceInfo())
+			r.Put("/device", meter.UpdateDeviceInfo())
+			r.Get("/plants", meter.ServePlants())
+			r.Post("/plants", meter.CreatePlant())
+			r.Put("/plants/{id}", meter.UpdatePlant())
+			r.Delete("/plants/{id}", meter.DeletePlant())
+			r.Get("/adequacy", meter.ServeAdequacy())
+			r.Get("/settings/ppfd", meter.ServePPFDSetting())
+			r.Put("/settings/ppfd", meter.UpdatePPFDSetting())
+			r.Get("/settings/light-source", meter.ServeLightSourceBands())
+			r.Put("/settings/light-source", meter.UpdateLightSourceBands())
+		})
 	})
 
 	// Service Information
-	r.Get("/id", func(w http.ResponseWriter, r *http.Request) {
-		response := struct {
-			ServiceName string `json:"service_name"`
-		}{
-			ServiceName: "Sunlight Meter",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
-	})
+	r.Get("/id", meter.ServeDeviceInfo())
 
-	workDir, _ := os.Getwd()
-	filesDir := filepath.Join(workDir, "internal", "sunlightmeter")
-	FileServer(r, "/", http.Dir(filesDir))
+	// Static assets are embedded in the binary, or read from -static-dir while working on them.
+	// Nothing else is served from the filesystem.
+	version := slm.StaticVersion()
+	if staticDir != "" {
+		version = ""
+	}
+	r.Group(func(r chi.Router) {
+		r.Use(netFilter.CheckInNetwork)
+		r.Use(basicAuth.RequireAuth)
+		FileServer(r, "/static/", slm.StaticFiles(staticDir), version)
+	})
 }
 
-func FileServer(r chi.Router, path string, root http.FileSystem) {
+// Serve the files under root with caching headers, requests carrying the version as ?v= are cached for good.
+// Directories aren't listed. http.FileServer answers If-None-Match and If-Modified-Since with a 304, once the ETag is set.
+func FileServer(r chi.Router, path string, root http.FileSystem, version string) {
+	files := http.StripPrefix(path, http.FileServer(root))
 	r.Get(path+"*", func(w http.ResponseWriter, r *http.Request) {
-		http.StripPrefix(path, http.FileServer(root)).ServeHTTP(w, r)
+		etag, ok := fileETag(root, strings.TrimPrefix(r.URL.Path, path))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		if version != "" && r.URL.Query().Get("v") == version {
+			w.Header().Set("Cache-Control", STATIC_VERSIONED_CACHE_CONTROL)
+		} else {
+			w.Header().Set("Cache-Control", STATIC_CACHE_CONTROL)
+		}
+		files.ServeHTTP(w, r)
 	})
 }
 
+// An ETag from the file's modification time and size, like nginx's. Directories and missing files don't get one.
+// Embedded files have no modification time, so theirs is a hash of the content, which can't change while running.
+func fileETag(root http.FileSystem, name string) (string, bool) {
+	file, err := root.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), true
+	}
+	if etag, ok := embeddedETags.Load(name); ok {
+		return etag.(string), true
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", false
+	}
+	etag := fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+	embeddedETags.Store(name, etag)
+	return etag, true
+}
+
+// Recover from a handler's panic with a 500, logging the stack with the request's ID
 func handleServerPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				sunlightmeter.ServeResponse(w, r, (fmt.Sprintf("%v", err)), http.StatusInternalServerError)
+				tools.RequestLog(r).WithField("stack", string(debug.Stack())).Error(fmt.Sprintf("Recovered from panic: %v", err))
+				sunlightmeter.ServeError(w, r, tools.ERR_INTERNAL, fmt.Sprintf("%v", err), http.StatusInternalServerError)
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Connect to a TSL2591 on the bus, a nil sensor is returned if it can't be reached.
+// A simulated sensor stands in for it when simulate is set, each with its own synthetic day.
+func connectSensor(dev string, addr uint16, readRetries int, saturation float64, simulate bool) *tsl2591.TSL2591 {
+	opts := []tsl2591.Option{tsl2591.WithAddress(addr)}
+	if simulate {
+		log.Printf("Simulating the TSL2591 sensor on %s at %#x", dev, addr)
+		opts = append(opts, tsl2591.WithOpener(&tsl2591.Simulator{}))
+	}
+	device, err := tsl2591.NewTSL2591(
+		tsl2591.TSL2591_GAIN_LOW,
+		tsl2591.TSL2591_INTEGRATIONTIME_300MS,
+		dev,
+		opts...,
+	)
+	if err != nil {
+		log.Printf("Failed to connect to the TSL2591 sensor on %s at %#x: %v", dev, addr, err)
+		return nil
+	}
+	device.ReadRetries = readRetries
+	device.Saturation = saturation
+	return device
+}
+
+// The commit set at build time, or the one recorded by the go toolchain
+func buildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
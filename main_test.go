@@ -0,0 +1,502 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-chi/chi/v5"
+	slm "github.com/ztkent/sunlight-meter/internal/sunlightmeter"
+	"github.com/ztkent/sunlight-meter/internal/tools"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testUser     = "admin"
+	testPassword = "correct horse"
+	testLANAddr  = "192.168.1.20:51000"
+)
+
+// The routes as main serves them, on an in-memory db without a sensor.
+// Basic auth is only configured when password is set.
+func newTestRouter(t *testing.T, password string) (*chi.Mux, *slm.SLMeter) {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared&_busy_timeout=5000", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := tools.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := slm.LoadTemplates(""); err != nil {
+		t.Fatal(err)
+	}
+
+	netFilter, err := tools.NewNetworkFilter(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cors, err := tools.NewCORS(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := ""
+	if password != "" {
+		encoded, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash = string(encoded)
+	}
+	basicAuth := tools.NewBasicAuth(testUser, hash, netFilter.ClientIP)
+	controlLimiter := tools.NewRateLimiter(600, 100, netFilter.ClientIP)
+	meter := &slm.SLMeter{
+		ResultsDB:      db,
+		LuxResultsChan: make(chan slm.LuxResults, slm.RESULTS_BUFFER),
+		SensorID:       slm.DEFAULT_SENSOR_ID,
+		DeviceID:       "test-device",
+	}
+
+	r := chi.NewRouter()
+	r.Use(tools.RequestID)
+	r.Use(handleServerPanic)
+	defineRoutes(r, netFilter, basicAuth, controlLimiter, cors, meter, "")
+	return r, meter
+}
+
+type testRequest struct {
+	method   string
+	path     string
+	remote   string
+	user     string
+	password string
+	token    string
+	accept   string
+}
+
+func (tr testRequest) serve(r http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(tr.method, tr.path, nil)
+	req.RemoteAddr = testLANAddr
+	if tr.remote != "" {
+		req.RemoteAddr = tr.remote
+	}
+	if tr.user != "" {
+		req.SetBasicAuth(tr.user, tr.password)
+	}
+	if tr.token != "" {
+		req.Header.Set("Authorization", "Bearer "+tr.token)
+	}
+	if tr.accept != "" {
+		req.Header.Set("Accept", tr.accept)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// Create a token through the API, with the dashboard credentials
+func createTestToken(t *testing.T, r http.Handler) (int64, string) {
+	t.Helper()
+	w := testRequest{method: http.MethodPost, path: "/api/v1/tokens", user: testUser, password: testPassword}.serve(r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating a token: %d %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		ID    int64  `json:"id"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	return created.ID, created.Token
+}
+
+func TestTokenRoutesRequireBasicAuth(t *testing.T) {
+	r, _ := newTestRouter(t, testPassword)
+	_, token := createTestToken(t, r)
+
+	tests := []struct {
+		name string
+		req  testRequest
+		want int
+	}{
+		{"no credentials", testRequest{method: http.MethodPost, path: "/api/v1/tokens"}, http.StatusUnauthorized},
+		{"wrong password", testRequest{method: http.MethodPost, path: "/api/v1/tokens", user: testUser, password: "wrong"}, http.StatusUnauthorized},
+		{"invalid token", testRequest{method: http.MethodPost, path: "/api/v1/tokens", token: "not-a-token"}, http.StatusUnauthorized},
+		{"basic auth", testRequest{method: http.MethodPost, path: "/api/v1/tokens", user: testUser, password: testPassword}, http.StatusCreated},
+		{"existing token", testRequest{method: http.MethodPost, path: "/api/v1/tokens", token: token}, http.StatusCreated},
+		{"revoke without credentials", testRequest{method: http.MethodDelete, path: "/api/v1/tokens/1"}, http.StatusUnauthorized},
+		{"outside the local network", testRequest{method: http.MethodPost, path: "/api/v1/tokens", remote: "203.0.113.9:51000", user: testUser, password: testPassword}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := tt.req.serve(r); w.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenRoutesOpenWithoutPassword(t *testing.T) {
+	r, _ := newTestRouter(t, "")
+	if w := (testRequest{method: http.MethodPost, path: "/api/v1/tokens"}).serve(r); w.Code != http.StatusCreated {
+		t.Errorf("got %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+}
+
+func TestAPIRoutesNeedBasicAuthUntilATokenExists(t *testing.T) {
+	r, _ := newTestRouter(t, testPassword)
+	stop := testRequest{method: http.MethodPost, path: "/api/v1/stop"}
+	if w := stop.serve(r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	stop.user, stop.password = testUser, testPassword
+	// There's no sensor, so getting past the auth ends at the sensor check
+	if w := stop.serve(r); w.Code == http.StatusUnauthorized {
+		t.Fatalf("with basic auth: got %d: %s", w.Code, w.Body.String())
+	}
+
+	_, token := createTestToken(t, r)
+	if w := (testRequest{method: http.MethodPost, path: "/api/v1/stop", token: token}).serve(r); w.Code == http.StatusUnauthorized {
+		t.Errorf("with a token: got %d: %s", w.Code, w.Body.String())
+	}
+	if w := (testRequest{method: http.MethodPost, path: "/api/v1/stop"}).serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials once a token exists: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// Decode the {"error": {...}} envelope, failing if the response isn't one
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) tools.APIError {
+	t.Helper()
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf("got content type %q: %s", contentType, w.Body.String())
+	}
+	var body struct {
+		Error tools.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("the error isn't JSON: %s", w.Body.String())
+	}
+	return body.Error
+}
+
+func TestAPIErrorCodes(t *testing.T) {
+	r, meter := newTestRouter(t, "")
+
+	tests := []struct {
+		name     string
+		req      testRequest
+		wantCode int
+		wantErr  string
+	}{
+		{"no sensor to start", testRequest{method: http.MethodPost, path: "/api/v1/start"}, http.StatusServiceUnavailable, tools.ERR_SENSOR_NOT_CONNECTED},
+		{"no sensor to stop", testRequest{method: http.MethodPost, path: "/api/v1/stop"}, http.StatusServiceUnavailable, tools.ERR_SENSOR_NOT_CONNECTED},
+		{"half a date range", testRequest{method: http.MethodGet, path: "/api/v1/gaps?start=2024-06-21T00:00"}, http.StatusBadRequest, tools.ERR_BAD_DATE_RANGE},
+		{"malformed date", testRequest{method: http.MethodGet, path: "/api/v1/gaps?start=yesterday&end=2024-06-21T00:00"}, http.StatusBadRequest, tools.ERR_BAD_DATE_RANGE},
+		{"backwards date range", testRequest{method: http.MethodGet, path: "/api/v1/gaps?start=2024-06-22T00:00&end=2024-06-21T00:00"}, http.StatusBadRequest, tools.ERR_BAD_DATE_RANGE},
+		{"bad parameter", testRequest{method: http.MethodGet, path: "/api/v1/gaps?min_gap=soon"}, http.StatusBadRequest, tools.ERR_BAD_REQUEST},
+		{"no location", testRequest{method: http.MethodGet, path: "/api/v1/cloudiness"}, http.StatusServiceUnavailable, tools.ERR_LOCATION_NOT_SET},
+		{"no relay", testRequest{method: http.MethodGet, path: "/api/v1/relay"}, http.StatusServiceUnavailable, tools.ERR_RELAY_NOT_CONFIGURED},
+		{"missing annotation", testRequest{method: http.MethodDelete, path: "/api/v1/annotations/9999"}, http.StatusNotFound, tools.ERR_NOT_FOUND},
+		{"token from outside the local network", testRequest{method: http.MethodPost, path: "/api/v1/tokens", remote: "203.0.113.9:51000"}, http.StatusForbidden, tools.ERR_FORBIDDEN},
+		{"API path from a browser", testRequest{method: http.MethodPost, path: "/api/v1/stop", accept: "text/html"}, http.StatusServiceUnavailable, tools.ERR_SENSOR_NOT_CONNECTED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.req.serve(r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if got := decodeAPIError(t, w); got.Code != tt.wantErr || got.Message == "" {
+				t.Errorf("got %+v, want code %s with a message", got, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("db error", func(t *testing.T) {
+		meter.ResultsDB.Close()
+		w := testRequest{method: http.MethodGet, path: "/api/v1/gaps"}.serve(r)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("got %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body.String())
+		}
+		if got := decodeAPIError(t, w); got.Code != tools.ERR_DB_ERROR {
+			t.Errorf("got code %s, want %s", got.Code, tools.ERR_DB_ERROR)
+		}
+	})
+}
+
+func TestDashboardErrorsStayHTML(t *testing.T) {
+	r, _ := newTestRouter(t, "")
+	w := testRequest{method: http.MethodPost, path: "/sunlightmeter/stop"}.serve(r)
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("the dashboard got a JSON error: %s", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "The sensor is not connected") {
+		t.Errorf("the fragment is missing the message: %s", w.Body.String())
+	}
+
+	// Unless the request asks for JSON
+	w = testRequest{method: http.MethodPost, path: "/sunlightmeter/stop", accept: "application/json"}.serve(r)
+	if got := decodeAPIError(t, w); got.Code != tools.ERR_SENSOR_NOT_CONNECTED {
+		t.Errorf("got code %s, want %s", got.Code, tools.ERR_SENSOR_NOT_CONNECTED)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	if err := slm.LoadTemplates(""); err != nil {
+		t.Fatal(err)
+	}
+	h := tools.RequestID(handleServerPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("the handler broke")
+	})))
+
+	t.Run("API path", func(t *testing.T) {
+		w := testRequest{method: http.MethodGet, path: "/api/v1/readings"}.serve(h)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("got %d, want %d", w.Code, http.StatusInternalServerError)
+		}
+		if got := decodeAPIError(t, w); got != (tools.APIError{Code: tools.ERR_INTERNAL, Message: "the handler broke"}) {
+			t.Errorf("got %+v", got)
+		}
+	})
+	t.Run("dashboard path", func(t *testing.T) {
+		w := testRequest{method: http.MethodGet, path: "/sunlightmeter/controls"}.serve(h)
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Fatalf("the dashboard got a JSON error: %s", w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), "the handler broke") {
+			t.Errorf("the fragment is missing the message: %s", w.Body.String())
+		}
+	})
+}
+
+// The OpenAPI document, decoded generically so any part of it can be checked
+func loadOpenAPISpec(t *testing.T) map[string]interface{} {
+	t.Helper()
+	encoded, err := os.ReadFile("internal/sunlightmeter/api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(encoded, &spec); err != nil {
+		t.Fatalf("the OpenAPI document isn't valid JSON: %v", err)
+	}
+	return spec
+}
+
+// Follow a local reference like #/components/schemas/Reading
+func resolveRef(spec map[string]interface{}, ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	var node interface{} = spec
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// Call fn with every $ref in the document, and where it was found
+func eachRef(node interface{}, at string, fn func(at string, ref string)) {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				fn(at, ref)
+				continue
+			}
+			eachRef(child, at+"/"+key, fn)
+		}
+	case []interface{}:
+		for _, child := range v {
+			eachRef(child, at, fn)
+		}
+	}
+}
+
+var openAPIMethods = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+
+func TestOpenAPISpecIsValid(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+
+	if version, _ := spec["openapi"].(string); !strings.HasPrefix(version, "3.0.") {
+		t.Errorf("got openapi version %q, want 3.0.x", spec["openapi"])
+	}
+	info, _ := spec["info"].(map[string]interface{})
+	if info["title"] == nil || info["version"] == nil {
+		t.Errorf("info needs a title and version, got %v", info)
+	}
+
+	eachRef(spec, "", func(at string, ref string) {
+		if _, ok := resolveRef(spec, ref); !ok {
+			t.Errorf("%s: %s doesn't resolve", at, ref)
+		}
+	})
+
+	paths, _ := spec["paths"].(map[string]interface{})
+	if len(paths) == 0 {
+		t.Fatal("the document has no paths")
+	}
+	templateParams := regexp.MustCompile(`\{([^}]+)\}`)
+	for path, item := range paths {
+		operations, _ := item.(map[string]interface{})
+		for method, value := range operations {
+			if method == "parameters" {
+				continue
+			} else if !openAPIMethods[method] {
+				t.Errorf("%s: unknown method %q", path, method)
+				continue
+			}
+			operation, _ := value.(map[string]interface{})
+			if operation["summary"] == nil {
+				t.Errorf("%s %s has no summary", method, path)
+			}
+			responses, _ := operation["responses"].(map[string]interface{})
+			if len(responses) == 0 {
+				t.Errorf("%s %s has no responses", method, path)
+			}
+			for status, response := range responses {
+				response, _ := response.(map[string]interface{})
+				if response["$ref"] == nil && response["description"] == nil {
+					t.Errorf("%s %s: the %s response has no description", method, path, status)
+				}
+			}
+
+			// Every {param} in the path is declared as a required path parameter
+			declared := map[string]bool{}
+			var parameters []interface{}
+			if shared, ok := operations["parameters"].([]interface{}); ok {
+				parameters = append(parameters, shared...)
+			}
+			if own, ok := operation["parameters"].([]interface{}); ok {
+				parameters = append(parameters, own...)
+			}
+			for _, parameter := range parameters {
+				parameter, _ := parameter.(map[string]interface{})
+				if ref, ok := parameter["$ref"].(string); ok {
+					resolved, _ := resolveRef(spec, ref)
+					parameter, _ = resolved.(map[string]interface{})
+				}
+				if parameter["in"] == "path" && parameter["required"] == true {
+					declared[parameter["name"].(string)] = true
+				}
+			}
+			for _, match := range templateParams.FindAllStringSubmatch(path, -1) {
+				if !declared[match[1]] {
+					t.Errorf("%s %s doesn't declare the path parameter %s", method, path, match[1])
+				}
+			}
+		}
+	}
+}
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	r, _ := newTestRouter(t, "")
+
+	// The routes under /api/v1, other than the document and its docs page
+	registered := map[string]bool{}
+	err := chi.Walk(r, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		path, ok := strings.CutPrefix(route, "/api/v1")
+		if !ok || path == "/openapi.json" || path == "/docs" {
+			return nil
+		}
+		registered[strings.ToLower(method)+" "+path] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) == 0 {
+		t.Fatal("no /api/v1 routes were registered")
+	}
+
+	documented := map[string]bool{}
+	paths, _ := spec["paths"].(map[string]interface{})
+	for path, item := range paths {
+		operations, _ := item.(map[string]interface{})
+		for method := range operations {
+			if openAPIMethods[method] {
+				documented[method+" "+path] = true
+			}
+		}
+	}
+
+	var undocumented, unregistered []string
+	for route := range registered {
+		if !documented[route] {
+			undocumented = append(undocumented, route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			unregistered = append(unregistered, route)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(unregistered)
+	if len(undocumented) > 0 {
+		t.Errorf("routes missing from the OpenAPI document: %v", undocumented)
+	}
+	if len(unregistered) > 0 {
+		t.Errorf("documented routes that aren't registered: %v", unregistered)
+	}
+}
+
+func TestEChartsAssetsAreCachedForGood(t *testing.T) {
+	// The static files as they are once go generate has fetched the assets
+	static := fstest.MapFS{
+		"dashboard.js":            {Data: []byte("// dashboard")},
+		"echarts/echarts.min.js":  {Data: []byte("// echarts")},
+		"echarts/themes/chalk.js": {Data: []byte("// chalk")},
+	}
+	const version = "0123456789abcdef"
+	r := chi.NewRouter()
+	FileServer(r, "/static/", http.FS(static), version)
+
+	tests := []struct {
+		path         string
+		code         int
+		cacheControl string
+	}{
+		{"/static/echarts/echarts.min.js?v=" + version, http.StatusOK, STATIC_VERSIONED_CACHE_CONTROL},
+		{"/static/echarts/themes/chalk.js?v=" + version, http.StatusOK, STATIC_VERSIONED_CACHE_CONTROL},
+		// A link from an older binary is revalidated rather than kept
+		{"/static/echarts/echarts.min.js?v=fedcba9876543210", http.StatusOK, STATIC_CACHE_CONTROL},
+		{"/static/echarts/echarts.min.js", http.StatusOK, STATIC_CACHE_CONTROL},
+		{"/static/echarts/themes/", http.StatusNotFound, ""},
+		{"/static/echarts/themes/missing.js?v=" + version, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := testRequest{method: http.MethodGet, path: tt.path}.serve(r)
+		if w.Code != tt.code || w.Header().Get("Cache-Control") != tt.cacheControl {
+			t.Errorf("%s: got %d with Cache-Control %q, want %d with %q", tt.path, w.Code, w.Header().Get("Cache-Control"), tt.code, tt.cacheControl)
+		}
+		if tt.code == http.StatusOK && (w.Header().Get("ETag") == "" || !strings.Contains(w.Header().Get("Content-Type"), "javascript")) {
+			t.Errorf("%s: got ETag %q and Content-Type %q", tt.path, w.Header().Get("ETag"), w.Header().Get("Content-Type"))
+		}
+	}
+
+	// The embedded files are served the same way, under the binary's version
+	router, _ := newTestRouter(t, "")
+	w := testRequest{method: http.MethodGet, path: "/static/dashboard.js?v=" + slm.StaticVersion()}.serve(router)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != STATIC_VERSIONED_CACHE_CONTROL {
+		t.Errorf("got %d with Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
@@ -1,11 +1,17 @@
 package tsl2591
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	TSL2591_VISIBLE      byte = 2 ///< channel 0 - channel 1
 	TSL2591_INFRARED     byte = 1 ///< channel 1
 	TSL2591_FULLSPECTRUM byte = 0 ///< channel 0
 
-	TSL2591_ADDR        uint16 = 0x29 ///< Default I2C address
+	TSL2591_ADDR        uint16 = 0x29 ///< Default I2C address, see WithAddress
+	TSL2591_DEVICE_ID   byte   = 0x50 ///< Expected value of the device ID register
 	TSL2591_COMMAND_BIT byte   = 0xA0 ///< 1010 0000: bits 7 and 5 for 'command normal'
 
 	TSL2591_WORD_BIT  byte = 0x20 ///< 1 = read/write word rather than byte
@@ -17,6 +23,12 @@ const (
 	TSL2591_ENABLE_AIEN     byte = 0x10 ///< ALS Interrupt Enable. When asserted permits ALS interrupts to be generated, subject to the persist filter.
 	TSL2591_ENABLE_NPIEN    byte = 0x80 ///< No Persist Interrupt Enable. When asserted NP Threshold conditions will generate an interrupt, bypassing the persist filter
 
+	TSL2591_CONTROL_SRESET byte = 0x80 ///< System reset. Writing a one to the control register resets the device to its power-on state
+
+	TSL2591_MAX_COUNT_100MS uint16 = 0x8FFF ///< The most either channel can count in a 100ms integration
+	TSL2591_MAX_COUNT       uint16 = 0xFFFF ///< The most either channel can count in a longer integration
+	TSL2591_MIN_COUNT       uint16 = 0x0290 ///< Below ~1% of the range, channel 0 is too dim to resolve well
+
 	TSL2591_LUX_DF    float64 = 408.0 ///< Lux cooefficient
 	TSL2591_LUX_COEFB float64 = 1.64  ///< CH0 coefficient
 	TSL2591_LUX_COEFC float64 = 0.59  ///< CH1 coefficient A
@@ -45,6 +57,39 @@ const (
 	TSL2591_REGISTER_CHAN1_HIGH        byte = 0x17 // Channel 1 data, high byte
 )
 
+// Special function commands, written with the command bit
+const (
+	TSL2591_SPECIAL_FUNCTION byte = 0x60 // Special function select, bits 6:5
+	TSL2591_CLEAR_INT        byte = 0x07 // Clear ALS and no persist ALS interrupts
+)
+
+// Status register flags
+const (
+	TSL2591_STATUS_AVALID byte = 0x01 // ALS valid, an integration cycle has completed
+	TSL2591_STATUS_AINT   byte = 0x10 // ALS interrupt, the persist filter has been met
+	TSL2591_STATUS_NPINTR byte = 0x20 // No persist interrupt
+)
+
+// Constants for the interrupt persistence filter, consecutive out-of-range cycles before an interrupt
+const (
+	TSL2591_PERSIST_EVERY byte = 0x00 // Every ALS cycle generates an interrupt
+	TSL2591_PERSIST_ANY   byte = 0x01 // Any value outside of threshold range
+	TSL2591_PERSIST_2     byte = 0x02 // 2 consecutive values out of range
+	TSL2591_PERSIST_3     byte = 0x03 // 3 consecutive values out of range
+	TSL2591_PERSIST_5     byte = 0x04 // 5 consecutive values out of range
+	TSL2591_PERSIST_10    byte = 0x05 // 10 consecutive values out of range
+	TSL2591_PERSIST_15    byte = 0x06 // 15 consecutive values out of range
+	TSL2591_PERSIST_20    byte = 0x07 // 20 consecutive values out of range
+	TSL2591_PERSIST_25    byte = 0x08 // 25 consecutive values out of range
+	TSL2591_PERSIST_30    byte = 0x09 // 30 consecutive values out of range
+	TSL2591_PERSIST_35    byte = 0x0A // 35 consecutive values out of range
+	TSL2591_PERSIST_40    byte = 0x0B // 40 consecutive values out of range
+	TSL2591_PERSIST_45    byte = 0x0C // 45 consecutive values out of range
+	TSL2591_PERSIST_50    byte = 0x0D // 50 consecutive values out of range
+	TSL2591_PERSIST_55    byte = 0x0E // 55 consecutive values out of range
+	TSL2591_PERSIST_60    byte = 0x0F // 60 consecutive values out of range
+)
+
 // Constants for adjusting the sensor integration timing
 const (
 	TSL2591_INTEGRATIONTIME_100MS byte = 0x00 // 100 millis
@@ -96,3 +141,27 @@ func GainToString(value byte) string {
 		return "Unknown"
 	}
 }
+
+// The integration time with the name IntegrationTimeToString gives it, like 300ms
+func ParseIntegrationTime(value string) (byte, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, timing := range []byte{TSL2591_INTEGRATIONTIME_100MS, TSL2591_INTEGRATIONTIME_200MS, TSL2591_INTEGRATIONTIME_300MS, TSL2591_INTEGRATIONTIME_400MS, TSL2591_INTEGRATIONTIME_500MS, TSL2591_INTEGRATIONTIME_600MS} {
+		if value == IntegrationTimeToString(timing) {
+			return timing, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid integration time %q, expected 100ms, 200ms, 300ms, 400ms, 500ms or 600ms", value)
+}
+
+// The gain with the name GainToString gives it, by its first word, like med or medium, or its multiplier, like 25x
+func ParseGain(value string) (byte, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, gain := range []byte{TSL2591_GAIN_LOW, TSL2591_GAIN_MED, TSL2591_GAIN_HIGH, TSL2591_GAIN_MAX} {
+		name := strings.ToLower(GainToString(gain))
+		word, _, _ := strings.Cut(name, " ")
+		if value != "" && (value == word || value == word[:3] || value == name || strings.Contains(name, "("+value+")")) {
+			return gain, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid gain %q, expected low, med, high or max", value)
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"golang.org/x/exp/io/i2c"
+	"golang.org/x/exp/io/i2c/driver"
 )
 
 var l *logrus.Logger
@@ -44,46 +45,139 @@ func init() {
 }
 
 type TSL2591 struct {
-	Enabled bool
-	Timing  byte
-	Gain    byte
-	Device  *i2c.Device
+	Enabled     bool
+	Timing      byte
+	Gain        byte
+	Device      *i2c.Device
+	ReadRetries int
+	// The share of a channel's full scale it's treated as saturated from, the sensor stops being linear well before it tops out
+	Saturation float64
+	// Called after each attempt to reopen the I2C device, with the error if it failed
+	OnReconnect func(err error)
+	path        string
+	addr        uint16
+	opener      driver.Opener
+	sleep       func(time.Duration) // time.Sleep when nil, tests replace it so reads don't wait out the integration time
 	*sync.Mutex
 }
 
+const (
+	DEFAULT_READ_RETRIES = 3
+	DEFAULT_SATURATION   = float64(0xE000) / float64(TSL2591_MAX_COUNT) // ~88%
+)
+
+// Changes how NewTSL2591 connects
+type Option func(*TSL2591) error
+
+// Connect at another I2C address, for boards with an address jumper
+func WithAddress(addr uint16) Option {
+	return func(tsl *TSL2591) error {
+		if err := ValidateAddress(addr); err != nil {
+			return err
+		}
+		tsl.addr = addr
+		return nil
+	}
+}
+
+// Talk to the sensor through another I2C driver, like a Simulator, rather than the bus at path
+func WithOpener(opener driver.Opener) Option {
+	return func(tsl *TSL2591) error {
+		tsl.opener = opener
+		return nil
+	}
+}
+
+// Check the address is a 7-bit I2C address, outside the ranges reserved by the I2C spec
+func ValidateAddress(addr uint16) error {
+	if addr < 0x08 || addr > 0x77 {
+		return fmt.Errorf("Invalid I2C address %#x, expected 0x08-0x77", addr)
+	}
+	return nil
+}
+
 // Connect to a TSL2591 via I2C protocol & set gain/timing
-func NewTSL2591(gain byte, timing byte, path string) (*TSL2591, error) {
+func NewTSL2591(gain byte, timing byte, path string, opts ...Option) (*TSL2591, error) {
 	if path == "" {
 		// i2c-1 is the default I2C bus for the Raspberry Pi
 		path = "/dev/i2c-1"
 	}
-	device, err := i2c.Open(&i2c.Devfs{Dev: path}, int(TSL2591_ADDR))
+	tsl := &TSL2591{
+		ReadRetries: DEFAULT_READ_RETRIES,
+		Saturation:  DEFAULT_SATURATION,
+		path:        path,
+		addr:        TSL2591_ADDR,
+		opener:      &i2c.Devfs{Dev: path},
+		Mutex:       &sync.Mutex{},
+	}
+	for _, opt := range opts {
+		if err := opt(tsl); err != nil {
+			return nil, err
+		}
+	}
+	device, err := i2c.Open(tsl.opener, int(tsl.addr))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to open: %w", err)
 	}
-	tsl := &TSL2591{
-		Device:  device,
-		Mutex:   &sync.Mutex{},
-		Enabled: true,
-	}
+	tsl.Device = device
 
 	// Read the device ID from the TSL2591
-	buf := make([]byte, 1)
-	err = tsl.Device.ReadReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_DEVICE_ID, buf)
+	info, err := tsl.DeviceInfo()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to read ref: %w", err)
 	}
-	if buf[0] != 0x50 {
-		return nil, errors.New("Can't find a TSL2591 on I2C bus /dev/i2c-1")
+	if info.DeviceID != TSL2591_DEVICE_ID {
+		return nil, fmt.Errorf("Can't find a TSL2591 on I2C bus %s at %#x", path, tsl.addr)
 	}
+	l.Infof("Found TSL2591 on %s at %#x - Device ID: %#x, Package ID: %#x", path, tsl.addr, info.DeviceID, info.PackageID)
 
-	tsl.SetTiming(timing)
-	tsl.SetGain(gain)
-
-	tsl.Disable()
+	// Power on the device so the initial gain/timing writes take effect
+	if err := tsl.Enable(); err != nil {
+		return nil, fmt.Errorf("Failed to enable: %w", err)
+	}
+	if err := tsl.SetGainAndTiming(gain, timing); err != nil {
+		return nil, fmt.Errorf("Failed to set gain/timing: %w", err)
+	}
+	if err := tsl.Disable(); err != nil {
+		return nil, fmt.Errorf("Failed to disable: %w", err)
+	}
 	return tsl, nil
 }
 
+// Wait out the integration time, or a retry's backoff
+func (tsl *TSL2591) wait(d time.Duration) {
+	if tsl.sleep != nil {
+		tsl.sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// Returned by CalculateLux when either channel is past the saturation threshold
+var ErrOverflow = errors.New("channel overflow")
+
+type DeviceInfo struct {
+	DeviceID  byte `json:"deviceID"`
+	PackageID byte `json:"packageID"`
+}
+
+// Read the device and package identification registers
+func (tsl *TSL2591) DeviceInfo() (DeviceInfo, error) {
+	buf := make([]byte, 1)
+	err := tsl.Device.ReadReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_DEVICE_ID, buf)
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	info := DeviceInfo{DeviceID: buf[0]}
+
+	err = tsl.Device.ReadReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_PACKAGE_PID, buf)
+	if err != nil {
+		return DeviceInfo{}, err
+	}
+	info.PackageID = buf[0]
+	return info, nil
+}
+
 // Read from the light sensor's channels
 func (tsl *TSL2591) GetFullLuminosity() (uint16, uint16, error) {
 	if !tsl.Enabled {
@@ -91,17 +185,31 @@ func (tsl *TSL2591) GetFullLuminosity() (uint16, uint16, error) {
 	}
 
 	for d := byte(0); d < tsl.Timing; d++ {
-		time.Sleep(200 * time.Millisecond)
+		tsl.wait(200 * time.Millisecond)
 	}
 
 	// Reading from TSL2591_REGISTER_CHAN0_LOW, and TSL2591_REGISTER_CHAN1_LOW
 	// They are 2 bytes each, so we read 4 bytes in total
+	// Transient bus errors are retried with a short backoff, reconnecting before each retry
 	bytes := make([]byte, 4)
-	err := tsl.Device.ReadReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_CHAN0_LOW, bytes)
+	var err error
+	for attempt := 0; attempt <= tsl.ReadRetries; attempt++ {
+		if attempt > 0 {
+			tsl.wait(time.Duration(attempt) * 50 * time.Millisecond)
+			l.Debugf("Retrying read, attempt %d of %d", attempt, tsl.ReadRetries)
+			if err = tsl.reconnect(); err != nil {
+				l.Errorf("Failed to reconnect: %v", err)
+				continue
+			}
+		}
+		err = tsl.Device.ReadReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_CHAN0_LOW, bytes)
+		if err == nil {
+			break
+		}
+		l.Errorf("Error reading from register: %v", err)
+	}
 	if err != nil {
-
-		fmt.Printf("Error reading from register: %v\n", err)
-		return 0, 0, err
+		return 0, 0, fmt.Errorf("read failed after %d retries: %w", tsl.ReadRetries, err)
 	}
 	l.Debugf("Bytes read: %v\n", bytes)
 
@@ -112,14 +220,96 @@ func (tsl *TSL2591) GetFullLuminosity() (uint16, uint16, error) {
 	return channel0, channel1, nil
 }
 
+type Reading struct {
+	Lux          float64
+	Visible      float64
+	Infrared     float64
+	FullSpectrum float64
+	ReadAt       time.Time // When the channels were read, in UTC
+}
+
+// Read the sensor and calculate the lux
+func (tsl *TSL2591) GetLux() (float64, error) {
+	reading, err := tsl.GetReading()
+	if err != nil {
+		return 0, err
+	}
+	return reading.Lux, nil
+}
+
+// Read the sensor, and return the lux along with the normalized output of each spectrum
+func (tsl *TSL2591) GetReading() (Reading, error) {
+	tsl.Lock()
+	defer tsl.Unlock()
+
+	ch0, ch1, err := tsl.GetFullLuminosity()
+	if err != nil {
+		return Reading{}, err
+	}
+	readAt := time.Now().UTC()
+	lux, err := tsl.CalculateLux(ch0, ch1)
+	if err != nil {
+		return Reading{}, err
+	}
+	visible, infrared, fullSpectrum := NormalizeAll(ch0, ch1)
+	return Reading{
+		Lux:          lux,
+		Visible:      visible,
+		Infrared:     infrared,
+		FullSpectrum: fullSpectrum,
+		ReadAt:       readAt,
+	}, nil
+}
+
+// Reopen the I2C device, the sensor keeps its register state
+func (tsl *TSL2591) reconnect() error {
+	if tsl.path == "" {
+		return errors.New("unknown I2C bus path")
+	}
+	tsl.Device.Close()
+	device, err := i2c.Open(tsl.opener, int(tsl.addr))
+	if tsl.OnReconnect != nil {
+		tsl.OnReconnect(err)
+	}
+	if err != nil {
+		return err
+	}
+	tsl.Device = device
+	return nil
+}
+
+// Reopen the I2C device and reset the sensor, to recover one that has stopped responding
+func (tsl *TSL2591) Reconnect() error {
+	tsl.Lock()
+	err := tsl.reconnect()
+	tsl.Unlock()
+	if err != nil {
+		return err
+	}
+	return tsl.Reset()
+}
+
 func (tsl *TSL2591) CalculateLux(ch0, ch1 uint16) (float64, error) {
-	// Check for channel overflow
-	if ch0 == 0xFFFF || ch1 == 0xFFFF {
-		return 0, fmt.Errorf("Overflow: Channel 0: %v, Channel 1: %v\n", ch0, ch1)
+	// Check for channel overflow, or counts too close to it to be linear
+	if saturated(ch0, ch1, tsl.Timing, tsl.Saturation) {
+		return 0, fmt.Errorf("%w: Channel 0: %v, Channel 1: %v", ErrOverflow, ch0, ch1)
+	}
+
+	// No light on either channel, the formula below would divide by zero
+	if ch0 == 0 {
+		return 0, nil
 	}
 
+	// Based on the formula provided in the datasheet of the TSL2591 sensor
+	cpl := countsPerLux(tsl.Gain, tsl.Timing)
+	lux := (float64(ch0) - float64(ch1)) * (1.0 - (float64(ch1) / float64(ch0))) / cpl
+	return lux, nil
+}
+
+// The counts per lux at a gain and integration time
+func countsPerLux(gain byte, timing byte) float64 {
 	var int_time float64
-	switch tsl.Timing {
+	switch timing {
 	case TSL2591_INTEGRATIONTIME_100MS:
 		int_time = 100.0
 	case TSL2591_INTEGRATIONTIME_200MS:
@@ -137,7 +327,7 @@ func (tsl *TSL2591) CalculateLux(ch0, ch1 uint16) (float64, error) {
 	}
 
 	var adj_gain float64
-	switch tsl.Gain {
+	switch gain {
 	case TSL2591_GAIN_LOW:
 		adj_gain = 1.0
 	case TSL2591_GAIN_MED:
@@ -149,43 +339,90 @@ func (tsl *TSL2591) CalculateLux(ch0, ch1 uint16) (float64, error) {
 	default:
 		adj_gain = 1.0
 	}
+	return (int_time * adj_gain) / TSL2591_LUX_DF
+}
 
-	// Based on the formula provided in the datasheet of the TSL2591 sensor
-	cpl := (int_time * adj_gain) / TSL2591_LUX_DF
-	lux := (float64(ch0) - float64(ch1)) * (1.0 - (float64(ch1) / float64(ch0))) / cpl
-	return lux, nil
+// Every gain and integration time, from least to most sensitive.
+// Each gain is more than 10x the last, so the steps never overlap.
+var sensitivitySteps = func() [][2]byte {
+	gains := []byte{TSL2591_GAIN_LOW, TSL2591_GAIN_MED, TSL2591_GAIN_HIGH, TSL2591_GAIN_MAX}
+	timings := []byte{TSL2591_INTEGRATIONTIME_100MS, TSL2591_INTEGRATIONTIME_200MS, TSL2591_INTEGRATIONTIME_300MS, TSL2591_INTEGRATIONTIME_400MS, TSL2591_INTEGRATIONTIME_500MS, TSL2591_INTEGRATIONTIME_600MS}
+	steps := make([][2]byte, 0, len(gains)*len(timings))
+	for _, gain := range gains {
+		for _, timing := range timings {
+			steps = append(steps, [2]byte{gain, timing})
+		}
+	}
+	return steps
+}()
+
+// Check if either channel has reached the share of the most it can count at the timing.
+// A share outside (0, 1] only counts a channel at full scale as saturated.
+func saturated(ch0, ch1 uint16, timing byte, saturation float64) bool {
+	limit := TSL2591_MAX_COUNT
+	if timing == TSL2591_INTEGRATIONTIME_100MS {
+		limit = TSL2591_MAX_COUNT_100MS
+	}
+	if saturation > 0 && saturation < 1 {
+		limit = uint16(float64(limit) * saturation)
+	}
+	return ch0 >= limit || ch1 >= limit
+}
+
+// Pick the sensitivity step to try after reading ch0 and ch1 at step, or return done to keep it.
+// A saturated read steps down, a dim one steps up, but never back up once it has stepped down,
+// so a reading near the edge of two steps can't bounce between them.
+func nextSensitivityStep(step int, ch0, ch1 uint16, steppedDown bool, saturation float64) (int, bool) {
+	timing := sensitivitySteps[step][1]
+	switch {
+	case saturated(ch0, ch1, timing, saturation):
+		if step == 0 {
+			return step, true
+		}
+		return step - 1, false
+	case ch0 < TSL2591_MIN_COUNT && !steppedDown && step < len(sensitivitySteps)-1:
+		return step + 1, false
+	}
+	return step, true
+}
+
+func sensitivityStepOf(gain byte, timing byte) int {
+	for i, step := range sensitivitySteps {
+		if step[0] == gain && step[1] == timing {
+			return i
+		}
+	}
+	return 0
 }
 
+// Find a gain and integration time that reads the current light without saturating, starting from the current settings.
+// Reads past the saturation threshold step the sensitivity down, shorter timings first, and reads that are too dim step it up.
+// If the sensor is saturated at low gain and 100ms it's left there, and ErrOverflow is returned.
 func (tsl *TSL2591) SetOptimalGain() error {
-	// Try each gain option and see if the sensor is saturated
-	gainOptions := []byte{TSL2591_GAIN_MAX, TSL2591_GAIN_HIGH, TSL2591_GAIN_MED, TSL2591_GAIN_LOW}
-	integrationOptions := []byte{TSL2591_INTEGRATIONTIME_600MS, TSL2591_INTEGRATIONTIME_500MS, TSL2591_INTEGRATIONTIME_400MS, TSL2591_INTEGRATIONTIME_300MS, TSL2591_INTEGRATIONTIME_200MS, TSL2591_INTEGRATIONTIME_100MS}
-	for _, gain := range gainOptions {
-		tsl.SetGain(gain)
-		for _, time := range integrationOptions {
-			tsl.SetTiming(time)
-			l.Debugf("Attempting - Gain: %v, Integration Time: %v", GainToString(gain), IntegrationTimeToString(time))
-			ch0, ch1, err := tsl.GetFullLuminosity()
-			if err != nil {
-				continue
-			}
-			if ch0 == 0xFFFF || ch1 == 0xFFFF {
-				continue
-			}
-			lux, err := tsl.CalculateLux(ch0, ch1)
-			if err != nil {
-				continue
-			} else if lux == 0 {
-				continue
+	step := sensitivityStepOf(tsl.Gain, tsl.Timing)
+	steppedDown := false
+	for range sensitivitySteps {
+		gain, timing := sensitivitySteps[step][0], sensitivitySteps[step][1]
+		if err := tsl.SetGainAndTiming(gain, timing); err != nil {
+			return err
+		}
+		l.Debugf("Attempting - Gain: %v, Integration Time: %v", GainToString(gain), IntegrationTimeToString(timing))
+		ch0, ch1, err := tsl.GetFullLuminosity()
+		if err != nil {
+			return err
+		}
+		next, done := nextSensitivityStep(step, ch0, ch1, steppedDown, tsl.Saturation)
+		if done {
+			if saturated(ch0, ch1, timing, tsl.Saturation) {
+				return fmt.Errorf("%w: saturated at the lowest gain and integration time", ErrOverflow)
 			}
-			l.Debugf("Set - Gain: %v, Integration Time: %v", GainToString(gain), IntegrationTimeToString(time))
+			l.Debugf("Set - Gain: %v, Integration Time: %v", GainToString(gain), IntegrationTimeToString(timing))
 			return nil
 		}
+		steppedDown = steppedDown || next < step
+		step = next
 	}
-	// Use default options
-	tsl.SetGain(TSL2591_GAIN_LOW)
-	tsl.SetTiming(TSL2591_INTEGRATIONTIME_600MS)
-	return errors.New("All gain options are saturated")
+	return nil
 }
 
 // Returns the normalized output for a given spectrum type
@@ -206,11 +443,22 @@ func GetNormalizedOutput(spectrumType byte, ch0, ch1 uint16) float64 {
 	}
 }
 
+// Returns the normalized output for every spectrum type, from one pair of channel reads.
+// Visible is clamped to 0 when the infrared channel reads higher than the full spectrum channel.
+func NormalizeAll(ch0, ch1 uint16) (visible, infrared, fullSpectrum float64) {
+	return GetNormalizedOutput(TSL2591_VISIBLE, ch0, ch1),
+		GetNormalizedOutput(TSL2591_INFRARED, ch0, ch1),
+		GetNormalizedOutput(TSL2591_FULLSPECTRUM, ch0, ch1)
+}
+
 // Enable the sensor
 func (tsl *TSL2591) Enable() error {
 	tsl.Lock()
 	defer tsl.Unlock()
+	return tsl.enable()
+}
 
+func (tsl *TSL2591) enable() error {
 	if tsl.Enabled {
 		return nil
 	}
@@ -228,7 +476,10 @@ func (tsl *TSL2591) Enable() error {
 func (tsl *TSL2591) Disable() error {
 	tsl.Lock()
 	defer tsl.Unlock()
+	return tsl.disable()
+}
 
+func (tsl *TSL2591) disable() error {
 	if !tsl.Enabled {
 		return nil
 	}
@@ -274,3 +525,132 @@ func (tsl *TSL2591) SetTiming(timing byte) error {
 	tsl.Timing = timing
 	return nil
 }
+
+// Set the gain and integration timing together, in a single write of the control register.
+// Setting them one at a time leaves the sensor briefly configured with a mix of old and new values.
+func (tsl *TSL2591) SetGainAndTiming(gain byte, timing byte) error {
+	tsl.Lock()
+	defer tsl.Unlock()
+	return tsl.setGainAndTiming(gain, timing)
+}
+
+func (tsl *TSL2591) setGainAndTiming(gain byte, timing byte) error {
+	if !tsl.Enabled {
+		return errors.New("sensor must be enabled")
+	}
+
+	write := []byte{
+		timing | gain,
+	}
+	if err := tsl.Device.WriteReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_CONTROL, write); err != nil {
+		return err
+	}
+	tsl.Gain = gain
+	tsl.Timing = timing
+	return nil
+}
+
+// Reset the sensor to its power-on state, then re-apply the configured gain/timing.
+// The lock is held throughout, so no read sees the sensor mid-reset.
+func (tsl *TSL2591) Reset() error {
+	tsl.Lock()
+	defer tsl.Unlock()
+	wasEnabled := tsl.Enabled
+	gain, timing := tsl.Gain, tsl.Timing
+
+	// The device resets mid-transaction, so the write may not be acknowledged
+	err := tsl.Device.WriteReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_CONTROL, []byte{TSL2591_CONTROL_SRESET})
+	if err != nil {
+		l.Debugf("Reset write was not acknowledged: %v", err)
+	}
+	tsl.Enabled = false
+
+	// Give the device time to come back
+	tsl.wait(100 * time.Millisecond)
+	buf := make([]byte, 1)
+	if err := tsl.Device.ReadReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_DEVICE_ID, buf); err != nil {
+		return fmt.Errorf("Failed to read device after reset: %w", err)
+	} else if buf[0] != TSL2591_DEVICE_ID {
+		return fmt.Errorf("Unexpected device ID after reset: %#x", buf[0])
+	}
+
+	if err := tsl.enable(); err != nil {
+		return err
+	}
+	if err := tsl.setGainAndTiming(gain, timing); err != nil {
+		return err
+	}
+	if !wasEnabled {
+		return tsl.disable()
+	}
+	return nil
+}
+
+// Set the ALS interrupt thresholds and persist filter, and enable interrupts.
+// Thresholds are compared against the full spectrum channel, an interrupt fires when
+// channel 0 stays outside of [low, high] for the persist filter's number of cycles.
+func (tsl *TSL2591) EnableInterruptMode(low uint16, high uint16, persist byte) error {
+	if persist > TSL2591_PERSIST_60 {
+		return fmt.Errorf("invalid persist filter: %#x", persist)
+	} else if low > high {
+		return fmt.Errorf("low threshold %d is above high threshold %d", low, high)
+	}
+
+	tsl.Lock()
+	defer tsl.Unlock()
+	if !tsl.Enabled {
+		return errors.New("sensor must be enabled")
+	}
+	thresholds := []struct {
+		register byte
+		value    byte
+	}{
+		{TSL2591_REGISTER_THRESHOLD_AILTL, byte(low)},
+		{TSL2591_REGISTER_THRESHOLD_AILTH, byte(low >> 8)},
+		{TSL2591_REGISTER_THRESHOLD_AIHTL, byte(high)},
+		{TSL2591_REGISTER_THRESHOLD_AIHTH, byte(high >> 8)},
+		{TSL2591_REGISTER_PERSIST_FILTER, persist},
+	}
+	for _, threshold := range thresholds {
+		if err := tsl.Device.WriteReg(TSL2591_COMMAND_BIT|threshold.register, []byte{threshold.value}); err != nil {
+			return err
+		}
+	}
+	if err := tsl.clearInterrupt(); err != nil {
+		return err
+	}
+
+	write := []byte{TSL2591_ENABLE_POWERON | TSL2591_ENABLE_AEN | TSL2591_ENABLE_AIEN}
+	return tsl.Device.WriteReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_ENABLE, write)
+}
+
+// Stop generating ALS interrupts, the sensor keeps reading
+func (tsl *TSL2591) DisableInterruptMode() error {
+	tsl.Lock()
+	defer tsl.Unlock()
+	if !tsl.Enabled {
+		return nil
+	}
+	write := []byte{TSL2591_ENABLE_POWERON | TSL2591_ENABLE_AEN}
+	return tsl.Device.WriteReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_ENABLE, write)
+}
+
+// Check if the persist filtered ALS interrupt has fired
+func (tsl *TSL2591) InterruptFired() (bool, error) {
+	buf := make([]byte, 1)
+	if err := tsl.Device.ReadReg(TSL2591_COMMAND_BIT|TSL2591_REGISTER_DEVICE_STATUS, buf); err != nil {
+		return false, err
+	}
+	return buf[0]&TSL2591_STATUS_AINT != 0, nil
+}
+
+// Clear any pending interrupts, so the next threshold crossing can be detected
+func (tsl *TSL2591) ClearInterrupt() error {
+	tsl.Lock()
+	defer tsl.Unlock()
+	return tsl.clearInterrupt()
+}
+
+func (tsl *TSL2591) clearInterrupt() error {
+	return tsl.Device.Write([]byte{TSL2591_COMMAND_BIT | TSL2591_SPECIAL_FUNCTION | TSL2591_CLEAR_INT})
+}
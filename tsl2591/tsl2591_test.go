@@ -0,0 +1,495 @@
+package tsl2591
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/io/i2c/driver"
+)
+
+// A bus that records every register write, and answers from a Simulator
+type recordingBus struct {
+	sim    *Simulator
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (b *recordingBus) Open(addr int, tenbit bool) (driver.Conn, error) {
+	conn, err := b.sim.Open(addr, tenbit)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: conn, bus: b}, nil
+}
+
+// The writes since the last call
+func (b *recordingBus) takeWrites() [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	writes := b.writes
+	b.writes = nil
+	return writes
+}
+
+type recordingConn struct {
+	driver.Conn
+	bus *recordingBus
+}
+
+func (c *recordingConn) Tx(w, r []byte) error {
+	// A write with nothing to read back sets a register, reads only write the register to read from
+	if len(w) > 0 && len(r) == 0 {
+		c.bus.mu.Lock()
+		c.bus.writes = append(c.bus.writes, append([]byte(nil), w...))
+		c.bus.mu.Unlock()
+	}
+	return c.Conn.Tx(w, r)
+}
+
+func noon() time.Time {
+	return time.Date(2024, 6, 21, 12, 0, 0, 0, time.Local)
+}
+
+// A sensor on a recording bus, with the writes NewTSL2591 made
+func newRecordedSensor(t *testing.T, gain byte, timing byte, sim *Simulator) (*TSL2591, *recordingBus, [][]byte) {
+	t.Helper()
+	if sim.Now == nil {
+		sim.Now = noon
+	}
+	bus := &recordingBus{sim: sim}
+	tsl, err := NewTSL2591(gain, timing, "/dev/i2c-test", WithOpener(bus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tsl, bus, bus.takeWrites()
+}
+
+func assertWrites(t *testing.T, got [][]byte, want [][]byte) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d writes %s, want %d %s", len(got), formatWrites(got), len(want), formatWrites(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("got writes %s, want %s", formatWrites(got), formatWrites(want))
+		}
+	}
+}
+
+func formatWrites(writes [][]byte) string {
+	return fmt.Sprintf("%#x", writes)
+}
+
+func TestNewTSL2591WriteSequence(t *testing.T) {
+	tsl, bus, writes := newRecordedSensor(t, TSL2591_GAIN_MED, TSL2591_INTEGRATIONTIME_300MS, &Simulator{})
+
+	// Powered on before the control register is written, so the gain and timing stick, then powered off again
+	assertWrites(t, writes, [][]byte{
+		{TSL2591_COMMAND_BIT | TSL2591_REGISTER_ENABLE, TSL2591_ENABLE_POWERON | TSL2591_ENABLE_AEN | TSL2591_ENABLE_AIEN | TSL2591_ENABLE_NPIEN},
+		{TSL2591_COMMAND_BIT | TSL2591_REGISTER_CONTROL, TSL2591_INTEGRATIONTIME_300MS | TSL2591_GAIN_MED},
+		{TSL2591_COMMAND_BIT | TSL2591_REGISTER_ENABLE, TSL2591_ENABLE_POWEROFF},
+	})
+	if tsl.Enabled {
+		t.Error("Enabled should be false once the sensor is powered off")
+	}
+	if tsl.Gain != TSL2591_GAIN_MED || tsl.Timing != TSL2591_INTEGRATIONTIME_300MS {
+		t.Errorf("got gain %#x timing %#x", tsl.Gain, tsl.Timing)
+	}
+	if control := bus.sim.registers[TSL2591_REGISTER_CONTROL]; control != TSL2591_INTEGRATIONTIME_300MS|TSL2591_GAIN_MED {
+		t.Errorf("the control register holds %#x", control)
+	}
+
+	// Enabling writes the enable register once, and tracks the state
+	if err := tsl.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tsl.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	assertWrites(t, bus.takeWrites(), [][]byte{
+		{TSL2591_COMMAND_BIT | TSL2591_REGISTER_ENABLE, TSL2591_ENABLE_POWERON | TSL2591_ENABLE_AEN | TSL2591_ENABLE_AIEN | TSL2591_ENABLE_NPIEN},
+	})
+	if !tsl.Enabled {
+		t.Error("Enabled should be true once the sensor is powered on")
+	}
+}
+
+func TestNormalizeAll(t *testing.T) {
+	tests := []struct {
+		name                            string
+		ch0, ch1                        uint16
+		visible, infrared, fullSpectrum float64
+	}{
+		{"dark", 0, 0, 0, 0, 0},
+		{"mixed", 0x8000, 0x2000, float64(0x6000) / 0xFFFF, float64(0x2000) / 0xFFFF, float64(0x8000) / 0xFFFF},
+		{"full scale", 0xFFFF, 0, 1, 0, 1},
+		{"equal channels", 0x1000, 0x1000, 0, float64(0x1000) / 0xFFFF, float64(0x1000) / 0xFFFF},
+		// Infrared reading higher than full spectrum leaves no visible light, rather than a negative amount
+		{"infrared above full spectrum", 0x1000, 0x3000, 0, float64(0x3000) / 0xFFFF, float64(0x1000) / 0xFFFF},
+		{"only infrared", 0, 0xFFFF, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visible, infrared, fullSpectrum := NormalizeAll(tt.ch0, tt.ch1)
+			if visible != tt.visible || infrared != tt.infrared || fullSpectrum != tt.fullSpectrum {
+				t.Errorf("got visible %v infrared %v full spectrum %v, want %v %v %v",
+					visible, infrared, fullSpectrum, tt.visible, tt.infrared, tt.fullSpectrum)
+			}
+			// The same as asking for each one at a time
+			if visible != GetNormalizedOutput(TSL2591_VISIBLE, tt.ch0, tt.ch1) ||
+				infrared != GetNormalizedOutput(TSL2591_INFRARED, tt.ch0, tt.ch1) ||
+				fullSpectrum != GetNormalizedOutput(TSL2591_FULLSPECTRUM, tt.ch0, tt.ch1) {
+				t.Error("NormalizeAll disagrees with GetNormalizedOutput")
+			}
+		})
+	}
+}
+
+func TestCalculateLux(t *testing.T) {
+	tests := []struct {
+		name     string
+		gain     byte
+		timing   byte
+		ch0, ch1 uint16
+		want     float64
+	}{
+		// (ch0 - ch1) * (1 - ch1/ch0) / ((time * gain) / 408)
+		{"low gain", TSL2591_GAIN_LOW, TSL2591_INTEGRATIONTIME_100MS, 1000, 200, 800 * 0.8 / (100.0 / 408)},
+		{"medium gain", TSL2591_GAIN_MED, TSL2591_INTEGRATIONTIME_300MS, 20000, 5000, 15000 * 0.75 / (300.0 * 25 / 408)},
+		{"max gain", TSL2591_GAIN_MAX, TSL2591_INTEGRATIONTIME_600MS, 4000, 1000, 3000 * 0.75 / (600.0 * 9876 / 408)},
+		{"no infrared", TSL2591_GAIN_LOW, TSL2591_INTEGRATIONTIME_200MS, 5000, 0, 5000 / (200.0 / 408)},
+		{"dark", TSL2591_GAIN_HIGH, TSL2591_INTEGRATIONTIME_600MS, 0, 0, 0},
+		{"only infrared", TSL2591_GAIN_HIGH, TSL2591_INTEGRATIONTIME_600MS, 0, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsl := &TSL2591{Gain: tt.gain, Timing: tt.timing, Saturation: DEFAULT_SATURATION}
+			got, err := tsl.CalculateLux(tt.ch0, tt.ch1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.IsNaN(got) || math.Abs(got-tt.want) > 1e-9*math.Max(1, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Counts past the saturation share overflow, rather than returning a lux that reads too low
+	tsl := &TSL2591{Gain: TSL2591_GAIN_LOW, Timing: TSL2591_INTEGRATIONTIME_300MS, Saturation: DEFAULT_SATURATION}
+	if _, err := tsl.CalculateLux(TSL2591_MAX_COUNT, 0); !errors.Is(err, ErrOverflow) {
+		t.Errorf("got %v, want ErrOverflow", err)
+	}
+}
+
+func TestSaturated(t *testing.T) {
+	// The default share puts the threshold at 0xE000 for the longer timings, and 88% of 0x8FFF at 100ms
+	const limit, limit100 = 0xE000, 32255
+	longer := []byte{TSL2591_INTEGRATIONTIME_200MS, TSL2591_INTEGRATIONTIME_300MS, TSL2591_INTEGRATIONTIME_400MS, TSL2591_INTEGRATIONTIME_500MS, TSL2591_INTEGRATIONTIME_600MS}
+
+	tests := []struct {
+		name       string
+		timings    []byte
+		ch0, ch1   uint16
+		saturation float64
+		want       bool
+	}{
+		{"just below the threshold", longer, limit - 1, limit - 1, DEFAULT_SATURATION, false},
+		{"at the threshold", longer, limit, 0, DEFAULT_SATURATION, true},
+		{"infrared at the threshold", longer, 100, limit, DEFAULT_SATURATION, true},
+		{"100ms just below its threshold", []byte{TSL2591_INTEGRATIONTIME_100MS}, limit100 - 1, limit100 - 1, DEFAULT_SATURATION, false},
+		{"100ms at its threshold", []byte{TSL2591_INTEGRATIONTIME_100MS}, limit100, 0, DEFAULT_SATURATION, true},
+		{"100ms infrared at its threshold", []byte{TSL2591_INTEGRATIONTIME_100MS}, 100, limit100, DEFAULT_SATURATION, true},
+		{"half scale", longer, TSL2591_MAX_COUNT / 2, 0, 0.5, true},
+		{"just below half scale", longer, TSL2591_MAX_COUNT/2 - 1, 0, 0.5, false},
+
+		// A share outside (0, 1) is full scale, so only a channel that has topped out counts
+		{"full share below full scale", longer, TSL2591_MAX_COUNT - 1, 0, 1, false},
+		{"full share at full scale", longer, TSL2591_MAX_COUNT, 0, 1, true},
+		{"no share at full scale", longer, TSL2591_MAX_COUNT, 0, 0, true},
+		{"no share below full scale", longer, TSL2591_MAX_COUNT - 1, 0, 0, false},
+		{"negative share below full scale", longer, TSL2591_MAX_COUNT - 1, 0, -0.5, false},
+		{"share above one below full scale", longer, TSL2591_MAX_COUNT - 1, 0, 1.5, false},
+		{"100ms full share below full scale", []byte{TSL2591_INTEGRATIONTIME_100MS}, TSL2591_MAX_COUNT_100MS - 1, 0, 1, false},
+		{"100ms full share at full scale", []byte{TSL2591_INTEGRATIONTIME_100MS}, TSL2591_MAX_COUNT_100MS, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, timing := range tt.timings {
+				if got := saturated(tt.ch0, tt.ch1, timing, tt.saturation); got != tt.want {
+					t.Errorf("timing %#x: got %t for %d, %d at %v, want %t", timing, got, tt.ch0, tt.ch1, tt.saturation, tt.want)
+				}
+			}
+		})
+	}
+
+	// CalculateLux overflows from exactly the same count, and reads a lux just below it
+	tsl := &TSL2591{Gain: TSL2591_GAIN_LOW, Timing: TSL2591_INTEGRATIONTIME_300MS, Saturation: DEFAULT_SATURATION}
+	if lux, err := tsl.CalculateLux(limit-1, 0); err != nil || lux <= 0 {
+		t.Errorf("just below the threshold: got %v, %v", lux, err)
+	}
+	if _, err := tsl.CalculateLux(limit, 0); !errors.Is(err, ErrOverflow) {
+		t.Errorf("at the threshold: got %v, want ErrOverflow", err)
+	}
+	if _, err := tsl.CalculateLux(limit, limit); !errors.Is(err, ErrOverflow) {
+		t.Errorf("both channels at the threshold: got %v, want ErrOverflow", err)
+	}
+}
+
+func TestSetGainAndTimingWritesOnce(t *testing.T) {
+	tsl, bus, _ := newRecordedSensor(t, TSL2591_GAIN_LOW, TSL2591_INTEGRATIONTIME_100MS, &Simulator{})
+
+	// Disabled, nothing is written and the settings are kept
+	if err := tsl.SetGainAndTiming(TSL2591_GAIN_HIGH, TSL2591_INTEGRATIONTIME_400MS); err == nil {
+		t.Error("expected an error while the sensor is disabled")
+	}
+	assertWrites(t, bus.takeWrites(), nil)
+	if tsl.Gain != TSL2591_GAIN_LOW || tsl.Timing != TSL2591_INTEGRATIONTIME_100MS {
+		t.Errorf("a failed set changed gain %#x timing %#x", tsl.Gain, tsl.Timing)
+	}
+
+	if err := tsl.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	bus.takeWrites()
+	if err := tsl.SetGainAndTiming(TSL2591_GAIN_HIGH, TSL2591_INTEGRATIONTIME_400MS); err != nil {
+		t.Fatal(err)
+	}
+	// One write of the combined byte, so there's never a moment with only one of them changed
+	assertWrites(t, bus.takeWrites(), [][]byte{
+		{TSL2591_COMMAND_BIT | TSL2591_REGISTER_CONTROL, TSL2591_INTEGRATIONTIME_400MS | TSL2591_GAIN_HIGH},
+	})
+	if tsl.Gain != TSL2591_GAIN_HIGH || tsl.Timing != TSL2591_INTEGRATIONTIME_400MS {
+		t.Errorf("got gain %#x timing %#x", tsl.Gain, tsl.Timing)
+	}
+	if control := bus.sim.registers[TSL2591_REGISTER_CONTROL]; control != TSL2591_INTEGRATIONTIME_400MS|TSL2591_GAIN_HIGH {
+		t.Errorf("the control register holds %#x", control)
+	}
+}
+
+func TestResetSequence(t *testing.T) {
+	enable := []byte{TSL2591_COMMAND_BIT | TSL2591_REGISTER_ENABLE, TSL2591_ENABLE_POWERON | TSL2591_ENABLE_AEN | TSL2591_ENABLE_AIEN | TSL2591_ENABLE_NPIEN}
+	control := []byte{TSL2591_COMMAND_BIT | TSL2591_REGISTER_CONTROL, TSL2591_INTEGRATIONTIME_400MS | TSL2591_GAIN_HIGH}
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled %t", enabled), func(t *testing.T) {
+			tsl, bus := newInstantSensor(t, TSL2591_GAIN_HIGH, TSL2591_INTEGRATIONTIME_400MS, &Simulator{})
+			if !enabled {
+				if err := tsl.Disable(); err != nil {
+					t.Fatal(err)
+				}
+				bus.takeWrites()
+			}
+			// Nothing else can use the sensor while it comes back
+			var slept []time.Duration
+			tsl.sleep = func(d time.Duration) {
+				if tsl.TryLock() {
+					tsl.Unlock()
+					t.Error("the lock was released while the sensor reset")
+				}
+				slept = append(slept, d)
+			}
+
+			if err := tsl.Reset(); err != nil {
+				t.Fatal(err)
+			}
+			// The soft reset, then the settings it cleared are written again, and the sensor is left as it was
+			want := [][]byte{{TSL2591_COMMAND_BIT | TSL2591_REGISTER_CONTROL, TSL2591_CONTROL_SRESET}, enable, control}
+			if !enabled {
+				want = append(want, []byte{TSL2591_COMMAND_BIT | TSL2591_REGISTER_ENABLE, TSL2591_ENABLE_POWEROFF})
+			}
+			assertWrites(t, bus.takeWrites(), want)
+			if fmt.Sprint(slept) != fmt.Sprint([]time.Duration{100 * time.Millisecond}) {
+				t.Errorf("slept %v, want 100ms", slept)
+			}
+			if tsl.Enabled != enabled || tsl.Gain != TSL2591_GAIN_HIGH || tsl.Timing != TSL2591_INTEGRATIONTIME_400MS {
+				t.Errorf("got enabled %t, gain %#x, timing %#x", tsl.Enabled, tsl.Gain, tsl.Timing)
+			}
+			if got := bus.sim.registers[TSL2591_REGISTER_CONTROL]; got != TSL2591_INTEGRATIONTIME_400MS|TSL2591_GAIN_HIGH {
+				t.Errorf("the control register holds %#x", got)
+			}
+		})
+	}
+}
+
+func TestEnableInterruptModeChecksEnabledUnderTheLock(t *testing.T) {
+	tsl, bus, _ := newRecordedSensor(t, TSL2591_GAIN_LOW, TSL2591_INTEGRATIONTIME_100MS, &Simulator{})
+	if err := tsl.EnableInterruptMode(100, 1000, TSL2591_PERSIST_ANY); err == nil {
+		t.Error("expected an error while the sensor is disabled")
+	}
+	assertWrites(t, bus.takeWrites(), nil)
+
+	// The sensor is enabled while the call waits on the lock, so it sees the sensor as it is once it has it
+	tsl.Lock()
+	result := make(chan error, 1)
+	go func() { result <- tsl.EnableInterruptMode(100, 1000, TSL2591_PERSIST_ANY) }()
+	time.Sleep(50 * time.Millisecond)
+	tsl.Enabled = true
+	tsl.Unlock()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	writes := bus.takeWrites()
+	if len(writes) == 0 || !bytes.Equal(writes[len(writes)-1], []byte{TSL2591_COMMAND_BIT | TSL2591_REGISTER_ENABLE, TSL2591_ENABLE_POWERON | TSL2591_ENABLE_AEN | TSL2591_ENABLE_AIEN}) {
+		t.Errorf("got writes %s, want interrupts enabled last", formatWrites(writes))
+	}
+
+	// Disabled under the lock too
+	tsl.Lock()
+	go func() { result <- tsl.DisableInterruptMode() }()
+	time.Sleep(50 * time.Millisecond)
+	tsl.Enabled = false
+	tsl.Unlock()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	assertWrites(t, bus.takeWrites(), nil)
+}
+
+func midnight() time.Time {
+	return time.Date(2024, 6, 21, 0, 0, 0, 0, time.Local)
+}
+
+// An enabled sensor that doesn't wait out the integration time, so stepping through every setting is quick
+func newInstantSensor(t *testing.T, gain byte, timing byte, sim *Simulator) (*TSL2591, *recordingBus) {
+	t.Helper()
+	tsl, bus, _ := newRecordedSensor(t, gain, timing, sim)
+	tsl.sleep = func(time.Duration) {}
+	if err := tsl.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	bus.takeWrites()
+	return tsl, bus
+}
+
+// The sensitivity steps each control register write set, in order
+func controlSteps(writes [][]byte) []int {
+	var steps []int
+	for _, w := range writes {
+		if w[0] == TSL2591_COMMAND_BIT|TSL2591_REGISTER_CONTROL {
+			steps = append(steps, sensitivityStepOf(w[1]&0x30, w[1]&0x07))
+		}
+	}
+	return steps
+}
+
+func TestSetOptimalGainAtTheExtremes(t *testing.T) {
+	last := len(sensitivitySteps) - 1
+	stepsBetween := func(from, to int) []int {
+		var steps []int
+		for step := from; ; {
+			steps = append(steps, step)
+			if step == to {
+				return steps
+			} else if from < to {
+				step++
+			} else {
+				step--
+			}
+		}
+	}
+
+	t.Run("saturated at the lowest sensitivity", func(t *testing.T) {
+		tsl, bus := newInstantSensor(t, TSL2591_GAIN_MAX, TSL2591_INTEGRATIONTIME_600MS, &Simulator{PeakLux: 1e9})
+		err := tsl.SetOptimalGain()
+		if !errors.Is(err, ErrOverflow) {
+			t.Fatalf("got %v, want ErrOverflow", err)
+		}
+		// From where it was, every step down is tried once, shorter timings before lower gains, and it's left at the bottom
+		if got, want := controlSteps(bus.takeWrites()), stepsBetween(last, 0); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("stepped through %v, want %v", got, want)
+		}
+		if tsl.Gain != TSL2591_GAIN_LOW || tsl.Timing != TSL2591_INTEGRATIONTIME_100MS {
+			t.Errorf("left at %s, %s, want low gain and 100ms", GainToString(tsl.Gain), IntegrationTimeToString(tsl.Timing))
+		}
+	})
+
+	t.Run("dark at the highest sensitivity", func(t *testing.T) {
+		tsl, bus := newInstantSensor(t, TSL2591_GAIN_LOW, TSL2591_INTEGRATIONTIME_100MS, &Simulator{Now: midnight})
+		if err := tsl.SetOptimalGain(); err != nil {
+			t.Fatalf("got %v, the dark isn't an error", err)
+		}
+		// From where it was, every step up is tried once, and it stays at the top rather than running past it
+		if got, want := controlSteps(bus.takeWrites()), stepsBetween(0, last); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("stepped through %v, want %v", got, want)
+		}
+		if tsl.Gain != TSL2591_GAIN_MAX || tsl.Timing != TSL2591_INTEGRATIONTIME_600MS {
+			t.Errorf("left at %s, %s, want max gain and 600ms", GainToString(tsl.Gain), IntegrationTimeToString(tsl.Timing))
+		}
+	})
+
+	t.Run("bright sun from the highest sensitivity", func(t *testing.T) {
+		tsl, _ := newInstantSensor(t, TSL2591_GAIN_MAX, TSL2591_INTEGRATIONTIME_600MS, &Simulator{})
+		if err := tsl.SetOptimalGain(); err != nil {
+			t.Fatal(err)
+		}
+		// Settled on the most sensitive step that reads without saturating
+		step := sensitivityStepOf(tsl.Gain, tsl.Timing)
+		if step == last {
+			t.Fatal("stayed at the highest sensitivity in full sun")
+		}
+		ch0, ch1, err := tsl.GetFullLuminosity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saturated(ch0, ch1, tsl.Timing, tsl.Saturation) || ch0 < TSL2591_MIN_COUNT {
+			t.Errorf("settled on %s, %s reading %d, %d", GainToString(tsl.Gain), IntegrationTimeToString(tsl.Timing), ch0, ch1)
+		}
+		above := sensitivitySteps[step+1]
+		if err := tsl.SetGainAndTiming(above[0], above[1]); err != nil {
+			t.Fatal(err)
+		}
+		if ch0, ch1, err = tsl.GetFullLuminosity(); err != nil || !saturated(ch0, ch1, above[1], tsl.Saturation) {
+			t.Errorf("the step above reads %d, %d without saturating, it should have been kept", ch0, ch1)
+		}
+	})
+
+	t.Run("already at the right sensitivity", func(t *testing.T) {
+		tsl, bus := newInstantSensor(t, TSL2591_GAIN_LOW, TSL2591_INTEGRATIONTIME_100MS, &Simulator{PeakLux: 20000})
+		if err := tsl.SetOptimalGain(); err != nil {
+			t.Fatal(err)
+		}
+		step := sensitivityStepOf(tsl.Gain, tsl.Timing)
+		bus.takeWrites()
+		// Only the current settings are read, once
+		if err := tsl.SetOptimalGain(); err != nil {
+			t.Fatal(err)
+		}
+		if got := controlSteps(bus.takeWrites()); fmt.Sprint(got) != fmt.Sprint([]int{step}) || sensitivityStepOf(tsl.Gain, tsl.Timing) != step {
+			t.Errorf("moved from step %d through %v", step, got)
+		}
+	})
+}
+
+func TestNextSensitivityStep(t *testing.T) {
+	last := len(sensitivitySteps) - 1
+	full := TSL2591_MAX_COUNT
+	tests := []struct {
+		name        string
+		step        int
+		ch0, ch1    uint16
+		steppedDown bool
+		want        int
+		wantDone    bool
+	}{
+		{"readable", 10, 20000, 5000, false, 10, true},
+		{"saturated steps down", 10, full, 0, false, 9, false},
+		{"saturated infrared steps down", 10, 100, full, false, 9, false},
+		{"saturated at the bottom", 0, TSL2591_MAX_COUNT_100MS, 0, false, 0, true},
+		{"dim steps up", 10, TSL2591_MIN_COUNT - 1, 0, false, 11, false},
+		{"just bright enough", 10, TSL2591_MIN_COUNT, 0, false, 10, true},
+		{"dim at the top", last, 1, 0, false, last, true},
+		// Once it has stepped down it never steps back up, so it can't bounce between two steps
+		{"dim after stepping down", 10, 1, 0, true, 10, true},
+		{"saturated after stepping down", 10, full, 0, true, 9, false},
+	}
+	for _, tt := range tests {
+		got, done := nextSensitivityStep(tt.step, tt.ch0, tt.ch1, tt.steppedDown, DEFAULT_SATURATION)
+		if got != tt.want || done != tt.wantDone {
+			t.Errorf("%s: got step %d done %t, want %d done %t", tt.name, got, done, tt.want, tt.wantDone)
+		}
+	}
+}